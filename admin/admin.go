@@ -0,0 +1,167 @@
+package admin
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/funny/link"
+)
+
+type SessionInfo struct {
+	ID          uint64            `json:"id"`
+	RemoteAddr  string            `json:"remote_addr,omitempty"`
+	Uptime      float64           `json:"uptime"`
+	Idle        float64           `json:"idle"`
+	RecvPackets uint64            `json:"recv_packets"`
+	SendPackets uint64            `json:"send_packets"`
+	BytesIn     uint64            `json:"bytes_in,omitempty"`
+	BytesOut    uint64            `json:"bytes_out,omitempty"`
+	QueueDepth  int               `json:"queue_depth"`
+	Tags        map[string]string `json:"tags,omitempty"`
+}
+
+func NewSessionInfo(session *link.Session) SessionInfo {
+	info := SessionInfo{
+		ID:          session.ID(),
+		Uptime:      time.Since(session.CreatedAt()).Seconds(),
+		Idle:        time.Since(session.LastActive()).Seconds(),
+		RecvPackets: session.RecvPackets(),
+		SendPackets: session.SendPackets(),
+		BytesIn:     session.BytesIn(),
+		BytesOut:    session.BytesOut(),
+		QueueDepth:  session.SendChanLen(),
+		Tags:        session.Tags(),
+	}
+	if addr := session.RemoteAddr(); addr != nil {
+		info.RemoteAddr = addr.String()
+	}
+	return info
+}
+
+// Handler serves the live sessions of manager as JSON.
+//
+//	GET /            list all sessions, sorted by ID
+//	GET /?id=N       get one session
+//	GET /?tag=k=v    list sessions tagged with k=v, or with k when v is omitted
+func Handler(manager *link.Manager) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "GET" {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		query := r.URL.Query()
+		if id := query.Get("id"); id != "" {
+			n, err := strconv.ParseUint(id, 10, 64)
+			if err != nil {
+				http.Error(w, "bad session id", http.StatusBadRequest)
+				return
+			}
+			session := manager.GetSession(n)
+			if session == nil {
+				http.NotFound(w, r)
+				return
+			}
+			writeJSON(w, NewSessionInfo(session))
+			return
+		}
+
+		filter := parseTag(query.Get("tag"))
+		infos := []SessionInfo{}
+		manager.Fetch(func(session *link.Session) {
+			info := NewSessionInfo(session)
+			if filter(info.Tags) {
+				infos = append(infos, info)
+			}
+		})
+		sort.Slice(infos, func(i, j int) bool { return infos[i].ID < infos[j].ID })
+		writeJSON(w, infos)
+	})
+}
+
+func parseTag(tag string) func(map[string]string) bool {
+	if tag == "" {
+		return func(map[string]string) bool { return true }
+	}
+	for i := 0; i < len(tag); i++ {
+		if tag[i] == '=' {
+			key, value := tag[:i], tag[i+1:]
+			return func(tags map[string]string) bool { return tags[key] == value }
+		}
+	}
+	return func(tags map[string]string) bool {
+		_, exists := tags[tag]
+		return exists
+	}
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+// KillHandler closes sessions and bans addresses of server.
+//
+//	POST /?id=N                 close one session
+//	POST /?addr=CIDR            close sessions from an IP or CIDR
+//	POST /?addr=CIDR&ban=TTL    also deny new connections for TTL, e.g. 10m, 0 means forever
+func KillHandler(server *link.Server) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		query := r.URL.Query()
+		if id := query.Get("id"); id != "" {
+			n, err := strconv.ParseUint(id, 10, 64)
+			if err != nil {
+				http.Error(w, "bad session id", http.StatusBadRequest)
+				return
+			}
+			if !server.Kill(n) {
+				http.NotFound(w, r)
+				return
+			}
+			writeJSON(w, map[string]int{"killed": 1})
+			return
+		}
+
+		addr := query.Get("addr")
+		if addr == "" {
+			http.Error(w, "id or addr is required", http.StatusBadRequest)
+			return
+		}
+		var n int
+		var err error
+		if ban := query.Get("ban"); ban != "" {
+			var ttl time.Duration
+			if ttl, err = time.ParseDuration(ban); err != nil {
+				http.Error(w, "bad ban ttl", http.StatusBadRequest)
+				return
+			}
+			n, err = server.Ban(addr, ttl)
+		} else {
+			n, err = server.KillAddr(addr)
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		writeJSON(w, map[string]int{"killed": n})
+	})
+}
+
+// StatsHandler serves the accept stage counters of server as JSON.
+func StatsHandler(server *link.Server) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "GET" {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		writeJSON(w, server.AcceptStats())
+	})
+}
@@ -0,0 +1,66 @@
+package admin
+
+import (
+	"encoding/json"
+	"net"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/funny/link"
+	"github.com/funny/utest"
+)
+
+func Test_Handler(t *testing.T) {
+	manager := link.NewManager()
+	s1 := manager.NewSession(nil, 0)
+	s2 := manager.NewSession(nil, 10)
+	s2.SetTag("user", "alice")
+
+	handler := Handler(manager)
+
+	var infos []SessionInfo
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+	utest.IsNilNow(t, json.Unmarshal(w.Body.Bytes(), &infos))
+	utest.EqualNow(t, len(infos), 2)
+	utest.EqualNow(t, infos[0].ID, s1.ID())
+	utest.EqualNow(t, infos[1].Tags["user"], "alice")
+
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/?tag=user=alice", nil))
+	infos = nil
+	utest.IsNilNow(t, json.Unmarshal(w.Body.Bytes(), &infos))
+	utest.EqualNow(t, len(infos), 1)
+	utest.EqualNow(t, infos[0].ID, s2.ID())
+
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/?tag=admin", nil))
+	infos = nil
+	utest.IsNilNow(t, json.Unmarshal(w.Body.Bytes(), &infos))
+	utest.EqualNow(t, len(infos), 0)
+
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/?id=999999", nil))
+	utest.EqualNow(t, w.Code, 404)
+}
+
+func Test_KillHandler(t *testing.T) {
+	server, err := link.Listen("tcp", "127.0.0.1:0", nil, 0, nil)
+	utest.IsNilNow(t, err)
+	defer server.Stop()
+
+	handler := KillHandler(server)
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("POST", "/?id=999999", nil))
+	utest.EqualNow(t, w.Code, 404)
+
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("POST", "/?addr=bad", nil))
+	utest.EqualNow(t, w.Code, 400)
+
+	w = httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("POST", "/?addr=10.0.0.0/8&ban=1m", nil))
+	utest.EqualNow(t, w.Code, 200)
+	utest.Assert(t, server.BanList().Banned(net.ParseIP("10.1.2.3")))
+}
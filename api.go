@@ -28,43 +28,30 @@ type ClearSendChan interface {
 }
 
 func Listen(network, address string, protocol Protocol, sendChanSize int, handler Handler) (*Server, error) {
-	listener, err := net.Listen(network, address)
-	if err != nil {
-		return nil, err
-	}
-	return NewServer(listener, protocol, sendChanSize, handler), nil
+	return ListenTransport(NetTransport(network), address, protocol, sendChanSize, handler)
 }
 
 func Dial(network, address string, protocol Protocol, sendChanSize int) (*Session, error) {
-	conn, err := net.Dial(network, address)
-	if err != nil {
-		return nil, err
-	}
-	codec, err := protocol.NewCodec(conn)
-	if err != nil {
-		return nil, err
-	}
-	return NewSession(codec, sendChanSize), nil
+	return DialTransport(NetTransport(network), address, 0, protocol, sendChanSize)
 }
 
 func DialTimeout(network, address string, timeout time.Duration, protocol Protocol, sendChanSize int) (*Session, error) {
-	conn, err := net.DialTimeout(network, address, timeout)
-	if err != nil {
-		return nil, err
-	}
-	codec, err := protocol.NewCodec(conn)
-	if err != nil {
-		return nil, err
-	}
-	return NewSession(codec, sendChanSize), nil
+	return DialTransport(NetTransport(network), address, timeout, protocol, sendChanSize)
 }
 
 func Accept(listener net.Listener) (net.Conn, error) {
+	return accept(listener, nil)
+}
+
+func accept(listener net.Listener, onTempError func()) (net.Conn, error) {
 	var tempDelay time.Duration
 	for {
 		conn, err := listener.Accept()
 		if err != nil {
 			if ne, ok := err.(net.Error); ok && ne.Temporary() {
+				if onTempError != nil {
+					onTempError()
+				}
 				if tempDelay == 0 {
 					tempDelay = 5 * time.Millisecond
 				} else {
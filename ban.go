@@ -0,0 +1,297 @@
+package link
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// BanEntry is a ban of an IP network or an application key, like a user ID.
+// A zero ExpireAt means forever.
+type BanEntry struct {
+	IP       string    `json:",omitempty"`
+	Key      string    `json:",omitempty"`
+	ExpireAt time.Time `json:",omitempty"`
+}
+
+func (entry *BanEntry) id() string {
+	if entry.IP != "" {
+		return "ip:" + entry.IP
+	}
+	return "key:" + entry.Key
+}
+
+func (entry *BanEntry) expired(now time.Time) bool {
+	return !entry.ExpireAt.IsZero() && now.After(entry.ExpireAt)
+}
+
+// BanStore persists bans so they survive restarts and can be shared by
+// server instances.
+type BanStore interface {
+	LoadBans() ([]BanEntry, error)
+	SaveBan(BanEntry) error
+	DeleteBan(BanEntry) error
+}
+
+// BanList is a deny list of IP networks and application keys with expiry.
+type BanList struct {
+	mutex   sync.Mutex
+	store   BanStore
+	entries map[string]*banEntry
+}
+
+type banEntry struct {
+	BanEntry
+	ipnet *net.IPNet
+}
+
+func NewBanList() *BanList {
+	return &BanList{
+		entries: make(map[string]*banEntry),
+	}
+}
+
+// LoadBanList returns a ban list that writes through to store,
+// the entries in store are loaded first.
+func LoadBanList(store BanStore) (*BanList, error) {
+	list := NewBanList()
+	list.store = store
+	if err := list.Reload(); err != nil {
+		return nil, err
+	}
+	return list, nil
+}
+
+// Reload replaces the entries with the ones in the store, call it
+// periodically to see bans added by other server instances.
+func (list *BanList) Reload() error {
+	if list.store == nil {
+		return nil
+	}
+	bans, err := list.store.LoadBans()
+	if err != nil {
+		return err
+	}
+	entries := make(map[string]*banEntry, len(bans))
+	now := time.Now()
+	for _, ban := range bans {
+		if ban.expired(now) {
+			continue
+		}
+		entry := &banEntry{BanEntry: ban}
+		if ban.IP != "" {
+			if entry.ipnet, err = ParseCIDR(ban.IP); err != nil {
+				return err
+			}
+		}
+		entries[entry.id()] = entry
+	}
+	list.mutex.Lock()
+	list.entries = entries
+	list.mutex.Unlock()
+	return nil
+}
+
+// ParseCIDR accepts a CIDR or a single IP address.
+func ParseCIDR(s string) (*net.IPNet, error) {
+	if ip := net.ParseIP(s); ip != nil {
+		bits := 128
+		if ip4 := ip.To4(); ip4 != nil {
+			ip, bits = ip4, 32
+		}
+		return &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}, nil
+	}
+	_, ipnet, err := net.ParseCIDR(s)
+	return ipnet, err
+}
+
+// AddrIP returns the IP of a network address, or nil if it has none.
+func AddrIP(addr net.Addr) net.IP {
+	switch a := addr.(type) {
+	case *net.TCPAddr:
+		return a.IP
+	case *net.UDPAddr:
+		return a.IP
+	case nil:
+		return nil
+	}
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return nil
+	}
+	return net.ParseIP(host)
+}
+
+func expireAt(ttl time.Duration) time.Time {
+	if ttl > 0 {
+		return time.Now().Add(ttl)
+	}
+	return time.Time{}
+}
+
+func (list *BanList) add(entry *banEntry) error {
+	list.mutex.Lock()
+	list.entries[entry.id()] = entry
+	list.mutex.Unlock()
+	if list.store != nil {
+		return list.store.SaveBan(entry.BanEntry)
+	}
+	return nil
+}
+
+func (list *BanList) remove(ban BanEntry) error {
+	list.mutex.Lock()
+	delete(list.entries, ban.id())
+	list.mutex.Unlock()
+	if list.store != nil {
+		return list.store.DeleteBan(ban)
+	}
+	return nil
+}
+
+// Ban adds the network to the list, a zero ttl means forever.
+func (list *BanList) Ban(ipnet *net.IPNet, ttl time.Duration) error {
+	return list.add(&banEntry{
+		BanEntry: BanEntry{IP: ipnet.String(), ExpireAt: expireAt(ttl)},
+		ipnet:    ipnet,
+	})
+}
+
+// BanKey adds an application key to the list, a zero ttl means forever.
+func (list *BanList) BanKey(key string, ttl time.Duration) error {
+	return list.add(&banEntry{
+		BanEntry: BanEntry{Key: key, ExpireAt: expireAt(ttl)},
+	})
+}
+
+func (list *BanList) Unban(ipnet *net.IPNet) error {
+	return list.remove(BanEntry{IP: ipnet.String()})
+}
+
+func (list *BanList) UnbanKey(key string) error {
+	return list.remove(BanEntry{Key: key})
+}
+
+func (list *BanList) Banned(ip net.IP) bool {
+	if ip == nil {
+		return false
+	}
+	now := time.Now()
+	list.mutex.Lock()
+	defer list.mutex.Unlock()
+	for id, entry := range list.entries {
+		if entry.expired(now) {
+			delete(list.entries, id)
+			continue
+		}
+		if entry.ipnet != nil && entry.ipnet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func (list *BanList) KeyBanned(key string) bool {
+	id := (&BanEntry{Key: key}).id()
+	list.mutex.Lock()
+	defer list.mutex.Unlock()
+	entry, exists := list.entries[id]
+	if exists && entry.expired(time.Now()) {
+		delete(list.entries, id)
+		return false
+	}
+	return exists
+}
+
+// Entries returns the bans not expired.
+func (list *BanList) Entries() []BanEntry {
+	now := time.Now()
+	list.mutex.Lock()
+	defer list.mutex.Unlock()
+	bans := make([]BanEntry, 0, len(list.entries))
+	for _, entry := range list.entries {
+		if !entry.expired(now) {
+			bans = append(bans, entry.BanEntry)
+		}
+	}
+	return bans
+}
+
+// FileBanStore keeps bans in a JSON file.
+type FileBanStore struct {
+	mutex sync.Mutex
+	path  string
+}
+
+func NewFileBanStore(path string) *FileBanStore {
+	return &FileBanStore{path: path}
+}
+
+func (store *FileBanStore) LoadBans() ([]BanEntry, error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	return store.load()
+}
+
+func (store *FileBanStore) load() ([]BanEntry, error) {
+	data, err := ioutil.ReadFile(store.path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var bans []BanEntry
+	err = json.Unmarshal(data, &bans)
+	return bans, err
+}
+
+func (store *FileBanStore) update(ban BanEntry, keep bool) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	bans, err := store.load()
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	result := bans[:0]
+	for _, b := range bans {
+		if b.id() != ban.id() && !b.expired(now) {
+			result = append(result, b)
+		}
+	}
+	if keep {
+		result = append(result, ban)
+	}
+
+	data, err := json.MarshalIndent(result, "", "\t")
+	if err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(store.path), ".bans-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), store.path)
+}
+
+func (store *FileBanStore) SaveBan(ban BanEntry) error {
+	return store.update(ban, true)
+}
+
+func (store *FileBanStore) DeleteBan(ban BanEntry) error {
+	return store.update(ban, false)
+}
@@ -0,0 +1,100 @@
+package link
+
+import (
+	"sync"
+	"time"
+)
+
+// TokenBucket is a rate limiter, it refills rate tokens per second up to burst.
+// A rate of zero or less means unlimited, a burst of zero means same as rate.
+type TokenBucket struct {
+	mutex  sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func NewTokenBucket(rate, burst int) *TokenBucket {
+	bucket := &TokenBucket{}
+	bucket.SetRate(rate, burst)
+	bucket.tokens = bucket.burst
+	return bucket
+}
+
+// SetRate changes the rate and burst, it can be called at any time.
+func (bucket *TokenBucket) SetRate(rate, burst int) {
+	bucket.mutex.Lock()
+	defer bucket.mutex.Unlock()
+	bucket.refill(time.Now())
+	if burst <= 0 {
+		burst = rate
+	}
+	bucket.rate = float64(rate)
+	bucket.burst = float64(burst)
+	if bucket.tokens > bucket.burst {
+		bucket.tokens = bucket.burst
+	}
+}
+
+func (bucket *TokenBucket) refill(now time.Time) {
+	if !bucket.last.IsZero() {
+		bucket.tokens += now.Sub(bucket.last).Seconds() * bucket.rate
+		if bucket.tokens > bucket.burst {
+			bucket.tokens = bucket.burst
+		}
+	}
+	bucket.last = now
+}
+
+// Allow takes n tokens if they are available now.
+func (bucket *TokenBucket) Allow(n int) bool {
+	bucket.mutex.Lock()
+	defer bucket.mutex.Unlock()
+	if bucket.rate <= 0 {
+		return true
+	}
+	bucket.refill(time.Now())
+	if bucket.tokens < float64(n) {
+		return false
+	}
+	bucket.tokens -= float64(n)
+	return true
+}
+
+// Take takes n tokens, going into debt if needed, and returns how long the
+// caller should wait before the tokens are really available.
+func (bucket *TokenBucket) Take(n int) time.Duration {
+	bucket.mutex.Lock()
+	defer bucket.mutex.Unlock()
+	if bucket.rate <= 0 {
+		return 0
+	}
+	bucket.refill(time.Now())
+	bucket.tokens -= float64(n)
+	if bucket.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-bucket.tokens / bucket.rate * float64(time.Second))
+}
+
+// Wait takes n tokens and sleeps until they are available.
+func (bucket *TokenBucket) Wait(n int) {
+	if d := bucket.Take(n); d > 0 {
+		time.Sleep(d)
+	}
+}
+
+// Delay returns how long until n tokens are available, without taking them.
+func (bucket *TokenBucket) Delay(n int) time.Duration {
+	bucket.mutex.Lock()
+	defer bucket.mutex.Unlock()
+	if bucket.rate <= 0 {
+		return 0
+	}
+	bucket.refill(time.Now())
+	if lack := float64(n) - bucket.tokens; lack > 0 {
+		return time.Duration(lack / bucket.rate * float64(time.Second))
+	}
+	return 0
+}
@@ -0,0 +1,204 @@
+package link
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+)
+
+var ErrMemoryBudget = errors.New("Memory Budget Exceeded")
+
+// BudgetPolicy tells what Send does when a message does not fit the memory
+// budget.
+type BudgetPolicy int
+
+const (
+	// BudgetBlock waits for the send queues to drain, or until the session
+	// is closed.
+	BudgetBlock BudgetPolicy = iota
+	// BudgetReject returns ErrMemoryBudget.
+	BudgetReject
+	// BudgetShed closes the sessions with the most queued bytes for
+	// ErrMemoryBudget until the message fits, Send returns ErrMemoryBudget
+	// when it closes the sending session.
+	BudgetShed
+)
+
+// MemoryBudget limits the bytes of the messages waiting in the send queues
+// of the sessions sharing it, so a few slow clients can't take the memory of
+// the process. The messages of unknown size are not counted, see
+// MessageSize, and a session without send channel queues nothing.
+type MemoryBudget struct {
+	used    int64
+	waiters int32
+	limit   int64
+	policy  BudgetPolicy
+
+	mutex    sync.Mutex
+	freed    chan struct{}
+	sessions map[*Session]struct{}
+}
+
+func NewMemoryBudget(limit int, policy BudgetPolicy) *MemoryBudget {
+	return &MemoryBudget{
+		limit:    int64(limit),
+		policy:   policy,
+		freed:    make(chan struct{}),
+		sessions: make(map[*Session]struct{}),
+	}
+}
+
+// Used returns the bytes queued by the sessions.
+func (budget *MemoryBudget) Used() int {
+	return int(atomic.LoadInt64(&budget.used))
+}
+
+func (budget *MemoryBudget) Limit() int {
+	return int(budget.limit)
+}
+
+func (budget *MemoryBudget) add(session *Session) {
+	budget.mutex.Lock()
+	defer budget.mutex.Unlock()
+	budget.sessions[session] = struct{}{}
+}
+
+func (budget *MemoryBudget) remove(session *Session) {
+	budget.mutex.Lock()
+	defer budget.mutex.Unlock()
+	delete(budget.sessions, session)
+}
+
+// shed takes the session with the most queued bytes out, or nil.
+func (budget *MemoryBudget) shed() *Session {
+	budget.mutex.Lock()
+	defer budget.mutex.Unlock()
+	var victim *Session
+	var most int64
+	for session := range budget.sessions {
+		if queued := atomic.LoadInt64(&session.queuedBytes); queued > most {
+			victim, most = session, queued
+		}
+	}
+	if victim != nil {
+		delete(budget.sessions, victim)
+	}
+	return victim
+}
+
+func (budget *MemoryBudget) reserve(session *Session, n int64) error {
+	if n > budget.limit {
+		return ErrMemoryBudget
+	}
+	for {
+		used := atomic.LoadInt64(&budget.used)
+		if used+n <= budget.limit {
+			if atomic.CompareAndSwapInt64(&budget.used, used, used+n) {
+				return nil
+			}
+			continue
+		}
+		switch budget.policy {
+		case BudgetReject:
+			return ErrMemoryBudget
+		case BudgetShed:
+			victim := budget.shed()
+			if victim == nil {
+				return ErrMemoryBudget
+			}
+			victim.closeWith(ErrMemoryBudget)
+			if victim == session {
+				return ErrMemoryBudget
+			}
+		default:
+			budget.mutex.Lock()
+			atomic.AddInt32(&budget.waiters, 1)
+			freed := budget.freed
+			budget.mutex.Unlock()
+			// Checked again after waiting, so a release in between is
+			// not missed.
+			if atomic.LoadInt64(&budget.used)+n > budget.limit {
+				select {
+				case <-freed:
+				case <-session.closeChan:
+				}
+			}
+			atomic.AddInt32(&budget.waiters, -1)
+			if session.IsClosed() {
+				return SessionClosedError
+			}
+		}
+	}
+}
+
+func (budget *MemoryBudget) release(n int64) {
+	atomic.AddInt64(&budget.used, -n)
+	if atomic.LoadInt32(&budget.waiters) > 0 {
+		budget.mutex.Lock()
+		close(budget.freed)
+		budget.freed = make(chan struct{})
+		budget.mutex.Unlock()
+	}
+}
+
+// queuedSize is the size charged to the budget for a queued message.
+func queuedSize(msg interface{}) int64 {
+	if async, ok := msg.(*asyncSend); ok {
+		msg = async.msg
+	}
+	if timed, ok := msg.(*timedSend); ok {
+		msg = timed.msg
+	}
+	switch msg.(type) {
+	case *codecSwitch, flushSend:
+		return 0
+	}
+	if size := MessageSize(msg); size > 0 {
+		return int64(size)
+	}
+	return 0
+}
+
+// charge reserves the size of msg before it is queued, the size is given
+// back by uncharge if it is not queued.
+func (session *Session) charge(msg interface{}) (int64, error) {
+	if session.budget == nil {
+		return 0, nil
+	}
+	size := queuedSize(msg)
+	if size == 0 {
+		return 0, nil
+	}
+	if err := session.budget.reserve(session, size); err != nil {
+		return 0, err
+	}
+	atomic.AddInt64(&session.queuedBytes, size)
+	return size, nil
+}
+
+func (session *Session) uncharge(size int64) {
+	if size > 0 {
+		atomic.AddInt64(&session.queuedBytes, -size)
+		session.budget.release(size)
+	}
+}
+
+// released gives back the size of a message taken out of the send queues.
+func (session *Session) released(msg interface{}) {
+	if session.budget != nil {
+		session.uncharge(queuedSize(msg))
+	}
+}
+
+// QueuedBytes returns the bytes of the messages waiting in the send queues of
+// the session, it is counted when the session has a memory budget.
+func (session *Session) QueuedBytes() int {
+	return int(atomic.LoadInt64(&session.queuedBytes))
+}
+
+// SetMemoryBudget makes new sessions share budget, nil removes it.
+func (server *Server) SetMemoryBudget(budget *MemoryBudget) {
+	server.configMutex.Lock()
+	defer server.configMutex.Unlock()
+	server.budget = budget
+}
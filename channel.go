@@ -6,9 +6,18 @@ import (
 
 type KEY interface{}
 
+// Relay carries the broadcasts of a channel to the channels of other servers,
+// e.g. through a pub/sub backend like redisbridge.Bridge. It delivers the
+// messages of other servers to its channel with BroadcastLocal.
+type Relay interface {
+	Publish(msg interface{}) error
+}
+
 type Channel struct {
 	mutex    sync.RWMutex
 	sessions map[KEY]*Session
+	encode   func(interface{}) (interface{}, error)
+	relay    Relay
 
 	// channel state
 	State interface{}
@@ -20,6 +29,66 @@ func NewChannel() *Channel {
 	}
 }
 
+// SetEncoder makes Broadcast convert each message with encode once before fan
+// out, e.g. into the []byte sent as is by the codecs of the sessions. The
+// encoded message is shared by all sessions so it must not be changed after.
+func (channel *Channel) SetEncoder(encode func(interface{}) (interface{}, error)) {
+	channel.mutex.Lock()
+	defer channel.mutex.Unlock()
+	channel.encode = encode
+}
+
+// SetRelay makes Broadcast also publish the messages to other servers, nil
+// stops it.
+func (channel *Channel) SetRelay(relay Relay) {
+	channel.mutex.Lock()
+	defer channel.mutex.Unlock()
+	channel.relay = relay
+}
+
+// Broadcast sends msg to all sessions and returns the number of them, then
+// publishes msg by the relay if any. A session fails to send is closed and
+// removed.
+func (channel *Channel) Broadcast(msg interface{}) (int, error) {
+	n, err := channel.BroadcastLocal(msg)
+	if err != nil {
+		return n, err
+	}
+	channel.mutex.RLock()
+	relay := channel.relay
+	channel.mutex.RUnlock()
+	if relay != nil {
+		err = relay.Publish(msg)
+	}
+	return n, err
+}
+
+// BroadcastLocal is Broadcast without the relay.
+func (channel *Channel) BroadcastLocal(msg interface{}) (int, error) {
+	channel.mutex.RLock()
+	encode := channel.encode
+	sessions := make([]*Session, 0, len(channel.sessions))
+	for _, session := range channel.sessions {
+		sessions = append(sessions, session)
+	}
+	channel.mutex.RUnlock()
+
+	if encode != nil {
+		var err error
+		if msg, err = encode(msg); err != nil {
+			return 0, err
+		}
+	}
+
+	n := 0
+	for _, session := range sessions {
+		if session.Send(msg) == nil {
+			n++
+		}
+	}
+	return n, nil
+}
+
 func (channel *Channel) Len() int {
 	channel.mutex.RLock()
 	defer channel.mutex.RUnlock()
@@ -0,0 +1,92 @@
+package link
+
+import (
+	"context"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// defaultCloseTimeout limits CloseWith for sessions without write timeout.
+const defaultCloseTimeout = 5 * time.Second
+
+// CloseFrame is the last message of a session closed by CloseWith. Receive
+// returns it as the error when the peer sent it, and it is the CloseError of
+// both sides, so clients can tell e.g. a kick for a failed authentication
+// from a crashed server.
+type CloseFrame struct {
+	Code   uint16
+	Reason string
+}
+
+func (frame *CloseFrame) Error() string {
+	return "Session Closed With " + strconv.Itoa(int(frame.Code)) + ": " + frame.Reason
+}
+
+// CloseFrames are the messages of close frames in a protocol, like the pings
+// of Heartbeat. Without them the close frames are sent and received as
+// *CloseFrame, for codecs sending messages as they are.
+type CloseFrames struct {
+	// New returns the close frame of code and reason.
+	New func(code uint16, reason string) interface{}
+	// Parse tells the close frames of the peer.
+	Parse func(msg interface{}) (code uint16, reason string, ok bool)
+}
+
+// SetCloseFrames sets the close frames of the session, nil removes them.
+func (session *Session) SetCloseFrames(frames *CloseFrames) {
+	session.closeFrames.Store(frames)
+}
+
+func (session *Session) loadCloseFrames() *CloseFrames {
+	frames, _ := session.closeFrames.Load().(*CloseFrames)
+	return frames
+}
+
+// CloseWith sends a close frame of code and reason after the messages
+// queued, flushes the codec and closes the session with the frame as its
+// CloseError. It waits up to the write timeout of the session, or 5 seconds
+// without one, and closes the session anyway.
+func (session *Session) CloseWith(code uint16, reason string) error {
+	if session.IsClosed() {
+		return SessionClosedError
+	}
+	frame := &CloseFrame{code, reason}
+	var msg interface{} = frame
+	if frames := session.loadCloseFrames(); frames != nil {
+		msg = frames.New(code, reason)
+	}
+	timeout := time.Duration(atomic.LoadInt64(&session.writeTimeout))
+	if timeout <= 0 {
+		timeout = defaultCloseTimeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	err := session.SendContext(ctx, msg)
+	if err == nil {
+		err = session.flushContext(ctx)
+	}
+	if cerr := session.closeWith(frame); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// peerClose tells whether msg is a close frame of the peer.
+func (session *Session) peerClose(msg interface{}) (*CloseFrame, bool) {
+	if frames := session.loadCloseFrames(); frames != nil {
+		if code, reason, ok := frames.Parse(msg); ok {
+			return &CloseFrame{code, reason}, true
+		}
+		return nil, false
+	}
+	frame, ok := msg.(*CloseFrame)
+	return frame, ok
+}
+
+// SetCloseFrames sets the close frames of new sessions, nil removes them.
+func (server *Server) SetCloseFrames(frames *CloseFrames) {
+	server.configMutex.Lock()
+	defer server.configMutex.Unlock()
+	server.frames = frames
+}
@@ -0,0 +1,411 @@
+// Package cluster lets link servers gossip membership and a registry of
+// which node owns which key, like a user ID, and deliver messages to the
+// owner of a key through inter-node link sessions.
+//
+// Each node gossips its full state, so it suits clusters of tens of nodes.
+package cluster
+
+import (
+	"errors"
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/funny/link"
+	"github.com/funny/link/codec"
+)
+
+var ErrNoOwner = errors.New("No Owner")
+
+// peerTimeout limits dialing and writing to a peer, so a stuck peer doesn't
+// stall the gossip of the others.
+const peerTimeout = time.Second
+
+// Member is a node known to be alive.
+type Member struct {
+	ID   string
+	Addr string
+	Keys int
+}
+
+type nodeState struct {
+	Addr      string
+	Heartbeat uint64
+	Version   uint64
+	Keys      []string
+}
+
+type node struct {
+	nodeState
+	updated time.Time
+}
+
+type peer struct {
+	sync.Mutex
+	session *link.Session
+}
+
+type message struct {
+	From    string
+	Nodes   map[string]*nodeState `json:",omitempty"`
+	Key     string                `json:",omitempty"`
+	Payload []byte                `json:",omitempty"`
+}
+
+type Cluster struct {
+	id       string
+	protocol *codec.JsonProtocol
+	server   *link.Server
+
+	mutex       sync.RWMutex
+	self        nodeState
+	local       map[string]struct{}
+	nodes       map[string]*node
+	dead        map[string]uint64
+	owners      map[string]string
+	seeds       []string
+	interval    time.Duration
+	failTimeout time.Duration
+	handler     func(key string, payload []byte)
+
+	peerMutex sync.Mutex
+	peers     map[string]*peer
+
+	closeOnce sync.Once
+	closeChan chan struct{}
+}
+
+// New starts a node listening on address. Nodes gossip every second and are
+// dropped after five seconds without news by default.
+func New(id, address string) (*Cluster, error) {
+	c := &Cluster{
+		id:          id,
+		protocol:    codec.Json(),
+		local:       make(map[string]struct{}),
+		nodes:       make(map[string]*node),
+		dead:        make(map[string]uint64),
+		owners:      make(map[string]string),
+		interval:    time.Second,
+		failTimeout: 5 * time.Second,
+		peers:       make(map[string]*peer),
+		closeChan:   make(chan struct{}),
+	}
+	c.protocol.Register(message{})
+	// Start the counters from the clock, so a restarted node is newer than
+	// its old state still gossiped by others.
+	c.self.Heartbeat = uint64(time.Now().UnixNano())
+	c.self.Version = c.self.Heartbeat
+
+	server, err := link.Listen("tcp", address, c.protocol, 0, link.HandlerFunc(c.handleSession))
+	if err != nil {
+		return nil, err
+	}
+	c.server = server
+	c.self.Addr = server.Listener().Addr().String()
+	go server.Serve()
+	go c.gossipLoop()
+	return c, nil
+}
+
+func (c *Cluster) ID() string {
+	return c.id
+}
+
+// Addr returns the address other nodes use to reach this node.
+func (c *Cluster) Addr() string {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	return c.self.Addr
+}
+
+// SetAdvertiseAddr changes the address told to other nodes, e.g. when
+// listening on 0.0.0.0.
+func (c *Cluster) SetAdvertiseAddr(addr string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.self.Addr = addr
+}
+
+func (c *Cluster) SetGossipInterval(interval time.Duration) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.interval = interval
+}
+
+func (c *Cluster) SetFailTimeout(timeout time.Duration) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.failTimeout = timeout
+}
+
+// SetHandler sets the callback of messages delivered to keys of this node.
+func (c *Cluster) SetHandler(handler func(key string, payload []byte)) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.handler = handler
+}
+
+// Join gossips with the nodes at addrs, they are retried until any node known.
+func (c *Cluster) Join(addrs ...string) {
+	c.mutex.Lock()
+	c.seeds = append(c.seeds, addrs...)
+	c.mutex.Unlock()
+	c.gossip()
+}
+
+func (c *Cluster) Members() []Member {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	members := []Member{{c.id, c.self.Addr, len(c.self.Keys)}}
+	for id, n := range c.nodes {
+		members = append(members, Member{id, n.Addr, len(n.Keys)})
+	}
+	sort.Slice(members, func(i, j int) bool { return members[i].ID < members[j].ID })
+	return members
+}
+
+// Register claims the key for this node. When nodes claim the same key,
+// the one with the smallest ID owns it.
+func (c *Cluster) Register(key string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if _, exists := c.local[key]; !exists {
+		c.local[key] = struct{}{}
+		c.updateKeys()
+	}
+}
+
+func (c *Cluster) Unregister(key string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if _, exists := c.local[key]; exists {
+		delete(c.local, key)
+		c.updateKeys()
+	}
+}
+
+func (c *Cluster) updateKeys() {
+	keys := make([]string, 0, len(c.local))
+	for key := range c.local {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	c.self.Keys = keys
+	c.self.Version++
+	c.rebuildOwners()
+}
+
+func (c *Cluster) rebuildOwners() {
+	owners := make(map[string]string, len(c.owners))
+	claim := func(id string, keys []string) {
+		for _, key := range keys {
+			if owner, exists := owners[key]; !exists || id < owner {
+				owners[key] = id
+			}
+		}
+	}
+	claim(c.id, c.self.Keys)
+	for id, n := range c.nodes {
+		claim(id, n.Keys)
+	}
+	c.owners = owners
+}
+
+// Owner returns the ID and address of the node owning key.
+func (c *Cluster) Owner(key string) (id, addr string, ok bool) {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	id, ok = c.owners[key]
+	if !ok {
+		return "", "", false
+	}
+	if id == c.id {
+		return id, c.self.Addr, true
+	}
+	return id, c.nodes[id].Addr, true
+}
+
+// Send delivers payload to the handler of the node owning key.
+func (c *Cluster) Send(key string, payload []byte) error {
+	id, addr, ok := c.Owner(key)
+	if !ok {
+		return ErrNoOwner
+	}
+	if id == c.id {
+		c.deliver(key, payload)
+		return nil
+	}
+	return c.sendTo(addr, &message{From: c.id, Key: key, Payload: payload})
+}
+
+func (c *Cluster) deliver(key string, payload []byte) {
+	c.mutex.RLock()
+	handler := c.handler
+	c.mutex.RUnlock()
+	if handler != nil {
+		handler(key, payload)
+	}
+}
+
+func (c *Cluster) sendTo(addr string, msg *message) error {
+	c.peerMutex.Lock()
+	p := c.peers[addr]
+	if p == nil {
+		p = &peer{}
+		c.peers[addr] = p
+	}
+	c.peerMutex.Unlock()
+
+	p.Lock()
+	defer p.Unlock()
+	select {
+	case <-c.closeChan:
+		return link.SessionClosedError
+	default:
+	}
+	if p.session == nil || p.session.IsClosed() {
+		session, err := link.DialTimeout("tcp", addr, peerTimeout, c.protocol, 0)
+		if err != nil {
+			return err
+		}
+		session.SetWriteTimeout(peerTimeout)
+		p.session = session
+	}
+	err := p.session.Send(msg)
+	if err != nil {
+		p.session.Close()
+		p.session = nil
+	}
+	return err
+}
+
+func (c *Cluster) handleSession(session *link.Session) {
+	defer session.Close()
+	for {
+		msg, err := session.Receive()
+		if err != nil {
+			return
+		}
+		m, ok := msg.(*message)
+		if !ok {
+			return
+		}
+		if m.Nodes != nil {
+			c.merge(m.Nodes)
+		}
+		if m.Key != "" {
+			c.deliver(m.Key, m.Payload)
+		}
+	}
+}
+
+func (c *Cluster) merge(states map[string]*nodeState) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	now := time.Now()
+	changed := false
+	for id, state := range states {
+		if id == c.id {
+			continue
+		}
+		if heartbeat, exists := c.dead[id]; exists {
+			if state.Heartbeat <= heartbeat {
+				continue
+			}
+			delete(c.dead, id)
+		}
+		n := c.nodes[id]
+		if n == nil {
+			n = &node{}
+			c.nodes[id] = n
+			changed = true
+		}
+		if state.Heartbeat > n.Heartbeat {
+			n.Addr = state.Addr
+			n.Heartbeat = state.Heartbeat
+			n.updated = now
+		}
+		if state.Version > n.Version {
+			n.Version = state.Version
+			n.Keys = state.Keys
+			changed = true
+		}
+	}
+	if changed {
+		c.rebuildOwners()
+	}
+}
+
+func (c *Cluster) gossipLoop() {
+	for {
+		c.mutex.RLock()
+		interval := c.interval
+		c.mutex.RUnlock()
+		select {
+		case <-time.After(interval):
+		case <-c.closeChan:
+			return
+		}
+		c.gossip()
+	}
+}
+
+// gossip sends the state known to up to three random nodes, or to the seeds
+// when no node known.
+func (c *Cluster) gossip() {
+	c.mutex.Lock()
+	now := time.Now()
+	c.self.Heartbeat++
+	removed := false
+	for id, n := range c.nodes {
+		if now.Sub(n.updated) > c.failTimeout {
+			delete(c.nodes, id)
+			c.dead[id] = n.Heartbeat
+			removed = true
+		}
+	}
+	if removed {
+		c.rebuildOwners()
+	}
+
+	states := make(map[string]*nodeState, len(c.nodes)+1)
+	self := c.self
+	states[c.id] = &self
+	addrs := make([]string, 0, len(c.nodes))
+	for id, n := range c.nodes {
+		state := n.nodeState
+		states[id] = &state
+		addrs = append(addrs, n.Addr)
+	}
+	if len(addrs) == 0 {
+		addrs = append(addrs, c.seeds...)
+	}
+	c.mutex.Unlock()
+
+	rand.Shuffle(len(addrs), func(i, j int) { addrs[i], addrs[j] = addrs[j], addrs[i] })
+	if len(addrs) > 3 {
+		addrs = addrs[:3]
+	}
+	msg := &message{From: c.id, Nodes: states}
+	for _, addr := range addrs {
+		c.sendTo(addr, msg)
+	}
+}
+
+func (c *Cluster) Close() {
+	c.closeOnce.Do(func() {
+		close(c.closeChan)
+		c.server.Stop()
+		c.peerMutex.Lock()
+		defer c.peerMutex.Unlock()
+		for addr, p := range c.peers {
+			p.Lock()
+			if p.session != nil {
+				p.session.Close()
+			}
+			p.Unlock()
+			delete(c.peers, addr)
+		}
+	})
+}
@@ -0,0 +1,103 @@
+package cluster
+
+import (
+	"testing"
+	"time"
+
+	"github.com/funny/utest"
+)
+
+func newTestNode(t *testing.T, id string) *Cluster {
+	c, err := New(id, "127.0.0.1:0")
+	utest.IsNilNow(t, err)
+	c.SetGossipInterval(10 * time.Millisecond)
+	c.SetFailTimeout(300 * time.Millisecond)
+	return c
+}
+
+func waitFor(t *testing.T, cond func() bool) {
+	for i := 0; i < 300; i++ {
+		if cond() {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatal("timeout")
+}
+
+func Test_Cluster(t *testing.T) {
+	a := newTestNode(t, "a")
+	defer a.Close()
+	b := newTestNode(t, "b")
+	defer b.Close()
+	c := newTestNode(t, "c")
+
+	b.Join(a.Addr())
+	c.Join(a.Addr())
+	for _, node := range []*Cluster{a, b, c} {
+		node := node
+		waitFor(t, func() bool { return len(node.Members()) == 3 })
+	}
+
+	received := make(chan string, 1)
+	c.SetHandler(func(key string, payload []byte) {
+		received <- key + ":" + string(payload)
+	})
+	c.Register("user1")
+	waitFor(t, func() bool {
+		id, _, _ := a.Owner("user1")
+		return id == "c"
+	})
+	utest.IsNilNow(t, a.Send("user1", []byte("hello")))
+	select {
+	case msg := <-received:
+		utest.EqualNow(t, msg, "user1:hello")
+	case <-time.After(time.Second):
+		t.Fatal("message not delivered")
+	}
+
+	b.Register("user1")
+	waitFor(t, func() bool {
+		id, _, _ := a.Owner("user1")
+		return id == "b"
+	})
+	b.Unregister("user1")
+
+	c.Close()
+	waitFor(t, func() bool { return len(a.Members()) == 2 && len(b.Members()) == 2 })
+	_, _, ok := a.Owner("user1")
+	utest.Assert(t, !ok)
+	utest.EqualNow(t, a.Send("user1", nil), ErrNoOwner)
+}
+
+func Test_ClusterSlowPeer(t *testing.T) {
+	a := newTestNode(t, "a")
+	defer a.Close()
+	b := newTestNode(t, "b")
+	defer b.Close()
+
+	received := make(chan string, 1)
+	b.SetHandler(func(key string, payload []byte) {
+		received <- key
+	})
+
+	// A send to a stuck peer holds only that peer.
+	utest.NotNilNow(t, a.sendTo("127.0.0.1:1", &message{From: "a"}))
+	a.peerMutex.Lock()
+	stuck := a.peers["127.0.0.1:1"]
+	a.peerMutex.Unlock()
+	stuck.Lock()
+	defer stuck.Unlock()
+
+	done := make(chan error, 1)
+	go func() {
+		done <- a.sendTo(b.Addr(), &message{From: "a", Key: "user1"})
+	}()
+	select {
+	case err := <-done:
+		utest.IsNilNow(t, err)
+	case <-time.After(time.Second):
+		t.Fatal("blocked by another peer")
+	}
+	utest.EqualNow(t, <-received, "user1")
+}
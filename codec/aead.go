@@ -0,0 +1,132 @@
+package codec
+
+import (
+	"bytes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"io"
+
+	"github.com/funny/link"
+)
+
+var ErrReplayedPacket = errors.New("Replayed Packet")
+
+type aeadProtocol struct {
+	base link.Protocol
+	aead cipher.AEAD
+}
+
+// Encrypt seals each packet body encoded by base with aead, e.g. AES-GCM or
+// ChaCha20-Poly1305, so it is both encrypted and authenticated. Each packet
+// carries its nonce, a random 96-bit start per codec increased for each
+// packet, so the peers can share the key. Received nonces must keep the
+// start of the first one and increase, so a packet replayed, or sent back to
+// its sender, fails with ErrReplayedPacket. Like Checksum it must be placed
+// under a framing protocol.
+func Encrypt(base link.Protocol, aead cipher.AEAD) link.Protocol {
+	if aead.NonceSize() < 12 {
+		panic("Encrypt: nonce size less than 12 bytes")
+	}
+	return &aeadProtocol{
+		base: base,
+		aead: aead,
+	}
+}
+
+func (p *aeadProtocol) baseProtocol() link.Protocol {
+	return p.base
+}
+
+func (p *aeadProtocol) NewCodec(rw io.ReadWriter) (cc link.Codec, err error) {
+	codec := &aeadCodec{
+		rw:           rw,
+		nonce:        make([]byte, p.aead.NonceSize()),
+		aeadProtocol: p,
+	}
+	if _, err = rand.Read(codec.nonce); err != nil {
+		return
+	}
+	// The counter starts below 2^63, so it never wraps.
+	codec.nonce[len(codec.nonce)-8] &= 0x7f
+	codec.start = append([]byte(nil), codec.nonce...)
+	codec.base, err = p.base.NewCodec(&codec.fixlenReadWriter)
+	if err != nil {
+		return
+	}
+	cc = codec
+	return
+}
+
+type aeadCodec struct {
+	base     link.Codec
+	rw       io.ReadWriter
+	recvData bytes.Buffer
+	nonce    []byte
+	start    []byte // the first nonce, read by Receive
+	peer     []byte // the nonce of the last packet received
+	*aeadProtocol
+	fixlenReadWriter
+}
+
+func (c *aeadCodec) Receive() (interface{}, error) {
+	c.recvData.Reset()
+	if _, err := c.recvData.ReadFrom(c.rw); err != nil {
+		return nil, err
+	}
+	data := c.recvData.Bytes()
+	n := len(c.nonce)
+	if len(data) < n+c.aead.Overhead() {
+		return nil, ErrBadCiphertext
+	}
+	nonce := data[:n]
+	if c.reflected(nonce) || (c.peer != nil && !c.follows(nonce)) {
+		return nil, ErrReplayedPacket
+	}
+	body, err := c.aead.Open(data[n:n], nonce, data[n:], nil)
+	if err != nil {
+		return nil, ErrBadCiphertext
+	}
+	// Kept only once authenticated, so forged nonces can't block the peer.
+	if c.peer == nil {
+		c.peer = make([]byte, n)
+	}
+	copy(c.peer, nonce)
+	c.recvBuf.Reset(body)
+	return c.base.Receive()
+}
+
+// follows tells whether nonce has the start of the peer and a greater counter.
+func (c *aeadCodec) follows(nonce []byte) bool {
+	n := len(nonce)
+	return bytes.Equal(nonce[:n-8], c.peer[:n-8]) &&
+		binary.BigEndian.Uint64(nonce[n-8:]) > binary.BigEndian.Uint64(c.peer[n-8:])
+}
+
+// reflected tells whether nonce may be one sent by this codec.
+func (c *aeadCodec) reflected(nonce []byte) bool {
+	n := len(nonce)
+	return bytes.Equal(nonce[:n-8], c.start[:n-8]) &&
+		binary.BigEndian.Uint64(nonce[n-8:]) > binary.BigEndian.Uint64(c.start[n-8:])
+}
+
+func (c *aeadCodec) Send(msg interface{}) error {
+	n := len(c.nonce)
+	c.sendBuf.Reset()
+	binary.BigEndian.PutUint64(c.nonce[n-8:], binary.BigEndian.Uint64(c.nonce[n-8:])+1)
+	c.sendBuf.Write(c.nonce)
+	if err := c.base.Send(msg); err != nil {
+		return err
+	}
+	// The tag is sealed in place after the body.
+	c.sendBuf.Grow(c.aead.Overhead())
+	data := c.sendBuf.Bytes()
+	sealed := c.aead.Seal(data[n:n], data[:n], data[n:], nil)
+	_, err := c.rw.Write(data[:n+len(sealed)])
+	return err
+}
+
+func (c *aeadCodec) Close() error {
+	return c.base.Close()
+}
@@ -0,0 +1,63 @@
+package codec
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/binary"
+	"testing"
+)
+
+func Test_Encrypt(t *testing.T) {
+	block, _ := aes.NewCipher(make([]byte, 16))
+	aead, _ := cipher.NewGCM(block)
+
+	var stream bytes.Buffer
+	protocol := FixLen(Encrypt(BytesTestProtocol(), aead), 2, binary.BigEndian, 1024, 1024)
+	codec, _ := protocol.NewCodec(&stream)
+	codec.Send([]byte("hello"))
+	codec.Send([]byte("hello"))
+	packet1 := append([]byte(nil), stream.Bytes()[:stream.Len()/2]...)
+	packet2 := stream.Bytes()[stream.Len()/2:]
+	if bytes.Contains(stream.Bytes(), []byte("hello")) || bytes.Equal(packet1, packet2) {
+		t.Fatal("packet not encrypted with a new nonce")
+	}
+
+	// A packet sent back to its sender is not opened.
+	if _, err := codec.Receive(); err != ErrReplayedPacket {
+		t.Fatalf("expected replayed packet, got %v", err)
+	}
+	stream.Reset()
+	codec.Send([]byte("hello"))
+	codec.Send([]byte("world"))
+
+	// A peer with the same key opens the packets.
+	peer, _ := protocol.NewCodec(&stream)
+	msg, err := peer.Receive()
+	if err != nil || string(msg.([]byte)) != "hello" {
+		t.Fatalf("message not match: %v, %v", msg, err)
+	}
+	tampered := append([]byte(nil), stream.Bytes()...)
+	tampered[20] ^= 0x01
+	second := append([]byte(nil), stream.Bytes()...)
+	stream.Reset()
+	stream.Write(tampered)
+	if _, err := peer.Receive(); err != ErrBadCiphertext {
+		t.Fatalf("expected bad ciphertext, got %v", err)
+	}
+	stream.Write(second)
+	msg, err = peer.Receive()
+	if err != nil || string(msg.([]byte)) != "world" {
+		t.Fatalf("message not match: %v, %v", msg, err)
+	}
+
+	// A recorded packet replayed is not opened again.
+	stream.Write(second)
+	if _, err := peer.Receive(); err != ErrReplayedPacket {
+		t.Fatalf("expected replayed packet, got %v", err)
+	}
+	stream.Write(packet1)
+	if _, err := peer.Receive(); err != ErrReplayedPacket {
+		t.Fatalf("expected replayed packet, got %v", err)
+	}
+}
@@ -0,0 +1,75 @@
+package codec
+
+import "unsafe"
+
+const defaultArenaChunk = 4096
+
+// Arena allocates byte slices and strings for decoded messages and frees
+// them all at once by Reset. The raw codec resets the arena of an InBuffer
+// when the buffer is recycled, so data allocated from it must not be kept
+// after the packet is handled. It holds no pointers, objects that contain
+// pointers must be allocated normally.
+type Arena struct {
+	chunkSize int
+	chunks    [][]byte
+	index     int
+	buf       []byte
+}
+
+func NewArena(chunkSize int) *Arena {
+	if chunkSize <= 0 {
+		chunkSize = defaultArenaChunk
+	}
+	return &Arena{chunkSize: chunkSize}
+}
+
+func (a *Arena) Alloc(n int) []byte {
+	if n > a.chunkSize {
+		return make([]byte, n)
+	}
+	if len(a.buf)+n > cap(a.buf) {
+		a.next()
+	}
+	m := len(a.buf)
+	a.buf = a.buf[:m+n]
+	return a.buf[m : m+n : m+n]
+}
+
+func (a *Arena) next() {
+	if a.buf != nil {
+		a.index++
+	}
+	if a.index == len(a.chunks) {
+		a.chunks = append(a.chunks, make([]byte, 0, a.chunkSize))
+	}
+	a.buf = a.chunks[a.index][:0]
+}
+
+// Bytes returns a copy of b allocated in the arena.
+func (a *Arena) Bytes(b []byte) []byte {
+	c := a.Alloc(len(b))
+	copy(c, b)
+	return c
+}
+
+// String returns a string allocated in the arena.
+func (a *Arena) String(b []byte) string {
+	if len(b) == 0 {
+		return ""
+	}
+	c := a.Bytes(b)
+	return *(*string)(unsafe.Pointer(&c))
+}
+
+func (a *Arena) Reset() {
+	if DebugBuffers {
+		for _, chunk := range a.chunks {
+			chunk = chunk[:cap(chunk)]
+			for i := range chunk {
+				chunk[i] = 0xdd
+			}
+		}
+	}
+	a.index = 0
+	a.buf = nil
+}
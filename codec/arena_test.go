@@ -0,0 +1,56 @@
+package codec
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+func Test_Arena(t *testing.T) {
+	arena := NewArena(16)
+
+	a := arena.Bytes([]byte("0123456789"))
+	b := arena.String([]byte("abcdefghij"))
+	c := arena.Alloc(100)
+	if string(a) != "0123456789" || b != "abcdefghij" || len(c) != 100 {
+		t.Fatalf("arena alloc not match: %q, %q, %d", a, b, len(c))
+	}
+	if len(arena.chunks) != 2 {
+		t.Fatalf("chunks not match: %d", len(arena.chunks))
+	}
+	a = append(a, 'x')
+	if b != "abcdefghij" {
+		t.Fatal("arena allocation overlapped")
+	}
+
+	arena.Reset()
+	arena.Bytes([]byte("0123456789"))
+	arena.Bytes([]byte("0123456789"))
+	if len(arena.chunks) != 2 {
+		t.Fatalf("chunks not reused: %d", len(arena.chunks))
+	}
+}
+
+func Test_InBufferArena(t *testing.T) {
+	var stream bytes.Buffer
+
+	codec, _ := FixLen(Raw(), 2, binary.BigEndian, 1024, 1024).NewCodec(&stream)
+	codec.Send([]byte("first"))
+	codec.Send([]byte("second"))
+
+	recv, _ := codec.Receive()
+	in := recv.(*InBuffer)
+	s := in.Arena().String(in.Bytes())
+	if s != "first" {
+		t.Fatalf("arena string not match: %q", s)
+	}
+	in.Detach()
+
+	recv, _ = codec.Receive()
+	if in.Arena() == recv.(*InBuffer).Arena() {
+		t.Fatal("detached arena reused")
+	}
+	if s != "first" {
+		t.Fatalf("detached arena reset: %q", s)
+	}
+}
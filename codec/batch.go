@@ -0,0 +1,144 @@
+package codec
+
+import (
+	"bytes"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/funny/link"
+)
+
+// batchCloseTimeout limits the write of the packets buffered at Close.
+const batchCloseTimeout = time.Second
+
+type batchProtocol struct {
+	base  link.Protocol
+	size  int
+	delay time.Duration
+}
+
+// Batch buffers the packets base sends and writes them at once when size
+// bytes are buffered, delay after the first buffered packet, or at
+// Session.Flush, so many small messages take few writes. A zero delay waits
+// for size or Flush. The writes after delay are outside the write timeout of
+// the session, and their errors are returned by the next Send or Flush.
+// Close writes the packets buffered within a second when the connection has
+// a write deadline, and drops them otherwise, so a peer not reading can't
+// hang it.
+func Batch(base link.Protocol, size int, delay time.Duration) link.Protocol {
+	return &batchProtocol{
+		base:  base,
+		size:  size,
+		delay: delay,
+	}
+}
+
+func (p *batchProtocol) NewCodec(rw io.ReadWriter) (cc link.Codec, err error) {
+	codec := &batchCodec{
+		rw:            rw,
+		batchProtocol: p,
+	}
+	codec.stream.Reader = rw
+	codec.stream.Writer = &codec.buf
+	codec.base, err = p.base.NewCodec(&codec.stream)
+	if err != nil {
+		return
+	}
+	cc = codec
+	return
+}
+
+type batchCodec struct {
+	base   link.Codec
+	rw     io.ReadWriter
+	stream struct {
+		io.Reader
+		io.Writer
+	}
+	*batchProtocol
+
+	mutex sync.Mutex
+	buf   bytes.Buffer
+	timer *time.Timer
+	err   error
+}
+
+func (c *batchCodec) Receive() (interface{}, error) {
+	return c.base.Receive()
+}
+
+func (c *batchCodec) Send(msg interface{}) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.err != nil {
+		return c.err
+	}
+	if err := c.base.Send(msg); err != nil {
+		return err
+	}
+	if c.buf.Len() >= c.size {
+		return c.flush()
+	}
+	if c.delay > 0 && c.timer == nil && c.buf.Len() > 0 {
+		c.timer = time.AfterFunc(c.delay, func() {
+			c.mutex.Lock()
+			defer c.mutex.Unlock()
+			c.timer = nil
+			c.flush()
+		})
+	}
+	return nil
+}
+
+func (c *batchCodec) Flush() error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.flush()
+}
+
+// flush must be called with mutex locked, the first error is kept.
+func (c *batchCodec) flush() error {
+	if c.timer != nil {
+		c.timer.Stop()
+		c.timer = nil
+	}
+	if c.err != nil || c.buf.Len() == 0 {
+		return c.err
+	}
+	if _, err := c.rw.Write(c.buf.Bytes()); err != nil {
+		c.err = err
+	}
+	c.buf.Reset()
+	return c.err
+}
+
+// Release flushes and stops the timer, for a codec replaced by
+// Session.SetProtocol.
+func (c *batchCodec) Release() {
+	c.Flush()
+}
+
+func (c *batchCodec) Close() error {
+	// The deadline also ends a write of the timer in progress.
+	if conn, ok := c.rw.(interface{ SetWriteDeadline(time.Time) error }); ok {
+		conn.SetWriteDeadline(time.Now().Add(batchCloseTimeout))
+		c.Flush()
+	}
+	err1 := c.base.Close()
+	var err2 error
+	if closer, ok := c.rw.(io.Closer); ok {
+		err2 = closer.Close()
+	}
+	c.mutex.Lock()
+	if c.timer != nil {
+		c.timer.Stop()
+		c.timer = nil
+	}
+	c.buf.Reset()
+	c.mutex.Unlock()
+	if err1 != nil {
+		return err1
+	}
+	return err2
+}
@@ -0,0 +1,132 @@
+package codec
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+// writeCounter counts the writes to a buffer.
+type writeCounter struct {
+	sync.Mutex
+	bytes.Buffer
+	writes int
+}
+
+func (w *writeCounter) Write(p []byte) (int, error) {
+	w.Lock()
+	defer w.Unlock()
+	w.writes++
+	return w.Buffer.Write(p)
+}
+
+func (w *writeCounter) count() (int, int) {
+	w.Lock()
+	defer w.Unlock()
+	return w.writes, w.Len()
+}
+
+func Test_Batch(t *testing.T) {
+	JsonTest(t, FixLen(JsonTestProtocol(), 2, binary.BigEndian, 1024, 1024))
+	JsonTest(t, Batch(FixLen(JsonTestProtocol(), 2, binary.BigEndian, 1024, 1024), 1, 0))
+
+	var stream writeCounter
+	codec, _ := Batch(FixLen(Raw(), 2, binary.BigEndian, 1024, 1024), 100, 0).NewCodec(&stream)
+	for i := 0; i < 10; i++ {
+		codec.Send([]byte("12345678"))
+	}
+	if writes, size := stream.count(); writes != 1 || size != 100 {
+		t.Fatalf("expected 1 write of 100 bytes, got %d of %d", writes, size)
+	}
+	codec.Send([]byte("hello"))
+	if writes, _ := stream.count(); writes != 1 {
+		t.Fatalf("expected the packet buffered, got %d writes", writes)
+	}
+	codec.(interface{ Flush() error }).Flush()
+	if writes, size := stream.count(); writes != 2 || size != 107 {
+		t.Fatalf("expected 2 writes of 107 bytes, got %d of %d", writes, size)
+	}
+
+	for i := 0; i < 11; i++ {
+		recv, err := codec.Receive()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if i == 10 && string(recv.(*InBuffer).Bytes()) != "hello" {
+			t.Fatalf("message not match: %q", recv.(*InBuffer).Bytes())
+		}
+	}
+}
+
+func Test_BatchDelay(t *testing.T) {
+	var stream writeCounter
+	codec, _ := Batch(FixLen(Raw(), 2, binary.BigEndian, 1024, 1024), 1024, 10*time.Millisecond).NewCodec(&stream)
+	codec.Send([]byte("hello"))
+	codec.Send([]byte("world"))
+	if writes, _ := stream.count(); writes != 0 {
+		t.Fatalf("expected the packets buffered, got %d writes", writes)
+	}
+	time.Sleep(100 * time.Millisecond)
+	if writes, size := stream.count(); writes != 1 || size != 14 {
+		t.Fatalf("expected 1 write of 14 bytes, got %d of %d", writes, size)
+	}
+
+	// Close drops the packets of a stream without write deadline.
+	codec.Send([]byte("bye"))
+	codec.Close()
+	time.Sleep(30 * time.Millisecond)
+	if writes, size := stream.count(); writes != 1 || size != 14 {
+		t.Fatalf("expected 1 write of 14 bytes, got %d of %d", writes, size)
+	}
+}
+
+func Test_BatchClose(t *testing.T) {
+	// Close flushes to a connection.
+	conn1, conn2 := net.Pipe()
+	codec, _ := Batch(FixLen(Raw(), 2, binary.BigEndian, 1024, 1024), 1024, 0).NewCodec(conn1)
+	codec.Send([]byte("bye"))
+	received := make(chan []byte, 1)
+	go func() {
+		data, _ := ioutil.ReadAll(conn2)
+		received <- data
+	}()
+	codec.Close()
+	if data := <-received; string(data) != "\x00\x03bye" {
+		t.Fatalf("expected the packet flushed, got %q", data)
+	}
+
+	// A peer not reading does not hang Close.
+	conn1, conn2 = net.Pipe()
+	defer conn2.Close()
+	codec, _ = Batch(FixLen(Raw(), 2, binary.BigEndian, 1024, 1024), 1024, 0).NewCodec(conn1)
+	codec.Send([]byte("stuck"))
+	closed := make(chan struct{})
+	go func() {
+		codec.Close()
+		close(closed)
+	}()
+	select {
+	case <-closed:
+	case <-time.After(2 * batchCloseTimeout):
+		t.Fatal("Close hangs on a peer not reading")
+	}
+}
+
+func Test_BatchRelease(t *testing.T) {
+	var stream writeCounter
+	codec, _ := Batch(FixLen(Raw(), 2, binary.BigEndian, 1024, 1024), 1024, time.Hour).NewCodec(&stream)
+	codec.Send([]byte("12345678"))
+
+	// The packets are flushed, and the timer stopped.
+	codec.(*batchCodec).Release()
+	if writes, size := stream.count(); writes != 1 || size != 10 {
+		t.Fatalf("expected 1 write of 10 bytes, got %d of %d", writes, size)
+	}
+	if codec.(*batchCodec).timer != nil {
+		t.Fatal("timer not stopped")
+	}
+}
@@ -0,0 +1,129 @@
+package codec
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/funny/link"
+)
+
+var ErrBadBeanstalkMsg = errors.New("Bad Beanstalk Message")
+
+// BeanstalkMsg is a beanstalkd command or response line.
+// For put, RESERVED, FOUND and OK the trailing byte count is not kept
+// in Args, it is derived from Body.
+type BeanstalkMsg struct {
+	Name string
+	Args []string
+	Body []byte
+}
+
+func beanstalkHasBody(name string) bool {
+	switch name {
+	case "put", "RESERVED", "FOUND", "OK":
+		return true
+	}
+	return false
+}
+
+type BeanstalkProtocol struct {
+	maxLine int
+	maxBody int
+}
+
+func Beanstalk(maxLine, maxBody int) *BeanstalkProtocol {
+	return &BeanstalkProtocol{
+		maxLine: maxLine,
+		maxBody: maxBody,
+	}
+}
+
+func (p *BeanstalkProtocol) NewCodec(rw io.ReadWriter) (link.Codec, error) {
+	codec := &beanstalkCodec{
+		rw:                rw,
+		reader:            bufio.NewReaderSize(rw, p.maxLine),
+		BeanstalkProtocol: p,
+	}
+	return codec, nil
+}
+
+type beanstalkCodec struct {
+	rw      io.ReadWriter
+	reader  *bufio.Reader
+	sendBuf bytes.Buffer
+	*BeanstalkProtocol
+}
+
+func (c *beanstalkCodec) Receive() (interface{}, error) {
+	line, err := readLine(c.reader, []byte("\r\n"))
+	if err != nil {
+		return nil, err
+	}
+	fields := strings.Fields(string(line))
+	if len(fields) == 0 {
+		return nil, ErrBadBeanstalkMsg
+	}
+
+	msg := &BeanstalkMsg{
+		Name: fields[0],
+		Args: fields[1:],
+	}
+	if !beanstalkHasBody(msg.Name) {
+		return msg, nil
+	}
+	if len(msg.Args) == 0 {
+		return nil, ErrBadBeanstalkMsg
+	}
+	size, err := strconv.Atoi(msg.Args[len(msg.Args)-1])
+	if err != nil || size < 0 {
+		return nil, ErrBadBeanstalkMsg
+	}
+	if size > c.maxBody {
+		return nil, ErrTooLargePacket
+	}
+	msg.Args = msg.Args[:len(msg.Args)-1]
+
+	buff := make([]byte, size+2)
+	if _, err := io.ReadFull(c.reader, buff); err != nil {
+		return nil, err
+	}
+	if buff[size] != '\r' || buff[size+1] != '\n' {
+		return nil, ErrBadBeanstalkMsg
+	}
+	msg.Body = buff[:size]
+	return msg, nil
+}
+
+func (c *beanstalkCodec) Send(m interface{}) error {
+	msg := m.(*BeanstalkMsg)
+	if len(msg.Body) > c.maxBody {
+		return ErrTooLargePacket
+	}
+
+	c.sendBuf.Reset()
+	c.sendBuf.WriteString(msg.Name)
+	for _, arg := range msg.Args {
+		c.sendBuf.WriteByte(' ')
+		c.sendBuf.WriteString(arg)
+	}
+	if beanstalkHasBody(msg.Name) {
+		c.sendBuf.WriteByte(' ')
+		c.sendBuf.WriteString(strconv.Itoa(len(msg.Body)))
+		c.sendBuf.WriteString("\r\n")
+		c.sendBuf.Write(msg.Body)
+	}
+	c.sendBuf.WriteString("\r\n")
+	_, err := c.rw.Write(c.sendBuf.Bytes())
+	return err
+}
+
+func (c *beanstalkCodec) Close() error {
+	if closer, ok := c.rw.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
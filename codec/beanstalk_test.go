@@ -0,0 +1,46 @@
+package codec
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func Test_Beanstalk(t *testing.T) {
+	var stream bytes.Buffer
+
+	codec, _ := Beanstalk(224, 1024).NewCodec(&stream)
+
+	msgs := []*BeanstalkMsg{
+		{Name: "use", Args: []string{"jobs"}},
+		{Name: "put", Args: []string{"1024", "0", "60"}, Body: []byte("job\r\nbody")},
+		{Name: "INSERTED", Args: []string{"1"}},
+		{Name: "reserve-with-timeout", Args: []string{"5"}},
+		{Name: "RESERVED", Args: []string{"1"}, Body: []byte("job\r\nbody")},
+		{Name: "OK", Args: []string{}, Body: []byte("---\ncurrent-jobs-ready: 0\n")},
+		{Name: "delete", Args: []string{"1"}},
+	}
+	for _, msg := range msgs {
+		if err := codec.Send(msg); err != nil {
+			t.Fatal(err)
+		}
+		recv, err := codec.Receive()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(msg, recv) {
+			t.Fatalf("message not match: %#v, %#v", msg, recv)
+		}
+	}
+
+	stream.WriteString("put 0 0 60 2048\r\n")
+	if _, err := codec.Receive(); err != ErrTooLargePacket {
+		t.Fatalf("expected too large packet, got %v", err)
+	}
+
+	stream.Reset()
+	stream.WriteString("put 0 0 60 3\r\nabcd\r\n")
+	if _, err := codec.Receive(); err != ErrBadBeanstalkMsg {
+		t.Fatalf("expected bad message, got %v", err)
+	}
+}
@@ -0,0 +1,64 @@
+package codec
+
+import (
+	"io"
+	"net"
+)
+
+// Buffers is a message made of several byte slices. Framing codecs write it
+// out with writev after the packet header instead of concatenating it, so a
+// broadcast payload can be shared by many sessions with per-session headers.
+// The slices must not be modified until every Send returned.
+type Buffers [][]byte
+
+func (b Buffers) Len() int {
+	n := 0
+	for _, s := range b {
+		n += len(s)
+	}
+	return n
+}
+
+func (b Buffers) writeTo(w io.Writer, head []byte) error {
+	// Only the connections of the net package have writev, net.Buffers
+	// writes the slices one by one to others, like a tls.Conn.
+	if !writev(w) {
+		packet := make([]byte, len(head), len(head)+b.Len())
+		copy(packet, head)
+		for _, s := range b {
+			packet = append(packet, s...)
+		}
+		_, err := w.Write(packet)
+		return err
+	}
+	buffers := make(net.Buffers, 0, len(b)+1)
+	buffers = append(buffers, head)
+	for _, s := range b {
+		if len(s) > 0 {
+			buffers = append(buffers, s)
+		}
+	}
+	if bw, ok := w.(buffersWriter); ok {
+		_, err := bw.WriteBuffers(&buffers)
+		return err
+	}
+	_, err := buffers.WriteTo(w)
+	return err
+}
+
+// buffersWriter is a wrapper of a connection, like the byte counter of
+// link.Server, writing net.Buffers to the connection it wraps.
+type buffersWriter interface {
+	WriteBuffers(buffers *net.Buffers) (int64, error)
+	NetConn() net.Conn
+}
+
+func writev(w io.Writer) bool {
+	switch c := w.(type) {
+	case *net.TCPConn, *net.UnixConn:
+		return true
+	case buffersWriter:
+		return writev(c.NetConn())
+	}
+	return false
+}
@@ -0,0 +1,83 @@
+package codec
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
+	"testing"
+)
+
+func Test_Buffers(t *testing.T) {
+	var stream bytes.Buffer
+
+	codec, _ := FixLen(BytesTestProtocol(), 2, binary.BigEndian, 1024, 1024).NewCodec(&stream)
+
+	payload := []byte("shared payload")
+	for i := 0; i < 3; i++ {
+		head := []byte{byte(i)}
+		if err := codec.Send(Buffers{head, payload, nil, []byte("!")}); err != nil {
+			t.Fatal(err)
+		}
+		recv, err := codec.Receive()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if expect := append(append([]byte{byte(i)}, payload...), '!'); !bytes.Equal(expect, recv.([]byte)) {
+			t.Fatalf("message not match: %q", recv)
+		}
+	}
+
+	if err := codec.Send(Buffers{payload, make([]byte, 1024)}); err != ErrTooLargePacket {
+		t.Fatalf("expected too large packet, got %v", err)
+	}
+}
+
+// wrappedConn wraps a connection like the byte counter of a server.
+type wrappedConn struct {
+	net.Conn
+	writev int
+}
+
+func (c *wrappedConn) WriteBuffers(buffers *net.Buffers) (int64, error) {
+	c.writev++
+	return buffers.WriteTo(c.Conn)
+}
+
+func (c *wrappedConn) NetConn() net.Conn {
+	return c.Conn
+}
+
+func Test_BuffersWrapped(t *testing.T) {
+	lsn, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lsn.Close()
+	conn1, err := net.Dial("tcp", lsn.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn1.Close()
+	conn2, err := lsn.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn2.Close()
+
+	conn := &wrappedConn{Conn: conn1}
+	codec, _ := FixLen(BytesTestProtocol(), 2, binary.BigEndian, 1024, 1024).NewCodec(conn)
+	if err := codec.Send(Buffers{[]byte("shared"), []byte(" payload")}); err != nil {
+		t.Fatal(err)
+	}
+	if conn.writev != 1 {
+		t.Fatalf("expected writev through the wrapper, got %d", conn.writev)
+	}
+	packet := make([]byte, 16)
+	if _, err := io.ReadFull(conn2, packet); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(packet, []byte("\x00\x0eshared payload")) {
+		t.Fatalf("packet not match: %q", packet)
+	}
+}
@@ -0,0 +1,99 @@
+package codec
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash"
+	"hash/crc32"
+	"io"
+
+	"github.com/funny/link"
+)
+
+var ErrChecksumMismatch = errors.New("Checksum Mismatch")
+
+var crc32cTable = crc32.MakeTable(crc32.Castagnoli)
+
+func NewCRC32C() hash.Hash32 {
+	return crc32.New(crc32cTable)
+}
+
+type checksumProtocol struct {
+	base    link.Protocol
+	newHash func() hash.Hash32
+}
+
+// Checksum appends a 4 byte big-endian checksum after the body encoded by base
+// and verifies it on receive. It reads each packet to the end, so it must be
+// placed under a framing protocol, e.g. FixLen(Checksum(Json(), NewCRC32C), ...).
+func Checksum(base link.Protocol, newHash func() hash.Hash32) link.Protocol {
+	return &checksumProtocol{
+		base:    base,
+		newHash: newHash,
+	}
+}
+
+func (p *checksumProtocol) baseProtocol() link.Protocol {
+	return p.base
+}
+
+func (p *checksumProtocol) NewCodec(rw io.ReadWriter) (cc link.Codec, err error) {
+	codec := &checksumCodec{
+		rw:       rw,
+		sendHash: p.newHash(),
+		recvHash: p.newHash(),
+	}
+	codec.base, err = p.base.NewCodec(&codec.fixlenReadWriter)
+	if err != nil {
+		return
+	}
+	cc = codec
+	return
+}
+
+type checksumCodec struct {
+	base     link.Codec
+	rw       io.ReadWriter
+	recvData bytes.Buffer
+	sendHash hash.Hash32
+	recvHash hash.Hash32
+	fixlenReadWriter
+}
+
+func (c *checksumCodec) Receive() (interface{}, error) {
+	c.recvData.Reset()
+	if _, err := c.recvData.ReadFrom(c.rw); err != nil {
+		return nil, err
+	}
+	data := c.recvData.Bytes()
+	if len(data) < 4 {
+		return nil, ErrChecksumMismatch
+	}
+	body := data[:len(data)-4]
+	c.recvHash.Reset()
+	c.recvHash.Write(body)
+	if c.recvHash.Sum32() != binary.BigEndian.Uint32(data[len(body):]) {
+		return nil, ErrChecksumMismatch
+	}
+	c.recvBuf.Reset(body)
+	return c.base.Receive()
+}
+
+func (c *checksumCodec) Send(msg interface{}) error {
+	c.sendBuf.Reset()
+	if err := c.base.Send(msg); err != nil {
+		return err
+	}
+	c.sendHash.Reset()
+	c.sendHash.Write(c.sendBuf.Bytes())
+	var sum [4]byte
+	binary.BigEndian.PutUint32(sum[:], c.sendHash.Sum32())
+	c.sendBuf.Write(sum[:])
+	_, err := c.rw.Write(c.sendBuf.Bytes())
+	return err
+}
+
+func (c *checksumCodec) Close() error {
+	return c.base.Close()
+}
@@ -0,0 +1,53 @@
+package codec
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/adler32"
+	"testing"
+)
+
+func Test_Checksum(t *testing.T) {
+	JsonTest(t, FixLen(Checksum(JsonTestProtocol(), NewCRC32C), 2, binary.BigEndian, 1024, 1024))
+	JsonTest(t, FixLen(Checksum(JsonTestProtocol(), adler32.New), 2, binary.BigEndian, 1024, 1024))
+	JsonTest(t, FixLen(Checksum(JsonTestProtocol(), NewXXH32), 2, binary.BigEndian, 1024, 1024))
+}
+
+func Test_XXH32(t *testing.T) {
+	for input, sum := range map[string]uint32{
+		"":    0x02cc5d05,
+		"a":   0x550d7456,
+		"abc": 0x32d153ff,
+		"Nobody inspects the spammish repetition": 0xe2293b2f,
+	} {
+		h := NewXXH32()
+		h.Write([]byte(input))
+		if h.Sum32() != sum {
+			t.Fatalf("sum of %q not match: %08x", input, h.Sum32())
+		}
+		// Written in pieces across the 16 byte stripes.
+		h.Reset()
+		for piece := input; piece != ""; {
+			n := 5
+			if n > len(piece) {
+				n = len(piece)
+			}
+			h.Write([]byte(piece[:n]))
+			piece = piece[n:]
+		}
+		if h.Sum32() != sum {
+			t.Fatalf("sum of %q in pieces not match: %08x", input, h.Sum32())
+		}
+	}
+}
+
+func Test_ChecksumMismatch(t *testing.T) {
+	var stream bytes.Buffer
+
+	codec, _ := FixLen(Checksum(BytesTestProtocol(), NewCRC32C), 2, binary.BigEndian, 1024, 1024).NewCodec(&stream)
+	codec.Send([]byte("hello"))
+	stream.Bytes()[3] ^= 0x01
+	if _, err := codec.Receive(); err != ErrChecksumMismatch {
+		t.Fatalf("expected checksum mismatch, got %v", err)
+	}
+}
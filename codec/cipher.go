@@ -0,0 +1,105 @@
+package codec
+
+import (
+	"bytes"
+	"crypto/cipher"
+	"crypto/rand"
+	"errors"
+	"io"
+
+	"github.com/funny/link"
+)
+
+var ErrBadCiphertext = errors.New("Bad Ciphertext")
+
+// wrapperProtocol is implemented by the protocols transforming the packets
+// of a base protocol, so StreamCipher can find a MAC under them.
+type wrapperProtocol interface {
+	baseProtocol() link.Protocol
+}
+
+type streamCipherProtocol struct {
+	base  link.Protocol
+	block cipher.Block
+}
+
+// StreamCipher encrypts each packet body encoded by base with CTR mode and a
+// random IV. It gives no integrity, use EncryptThenMAC to authenticate it.
+// Like Checksum it must be placed under a framing protocol.
+func StreamCipher(base link.Protocol, block cipher.Block) link.Protocol {
+	for p := base; p != nil; {
+		if _, ok := p.(*macProtocol); ok {
+			panic("StreamCipher: MAC-then-encrypt is not allowed, use EncryptThenMAC")
+		}
+		w, ok := p.(wrapperProtocol)
+		if !ok {
+			break
+		}
+		p = w.baseProtocol()
+	}
+	return &streamCipherProtocol{
+		base:  base,
+		block: block,
+	}
+}
+
+func (p *streamCipherProtocol) baseProtocol() link.Protocol {
+	return p.base
+}
+
+func (p *streamCipherProtocol) NewCodec(rw io.ReadWriter) (cc link.Codec, err error) {
+	codec := &streamCipherCodec{
+		rw:                   rw,
+		streamCipherProtocol: p,
+	}
+	codec.base, err = p.base.NewCodec(&codec.fixlenReadWriter)
+	if err != nil {
+		return
+	}
+	cc = codec
+	return
+}
+
+type streamCipherCodec struct {
+	base     link.Codec
+	rw       io.ReadWriter
+	recvData bytes.Buffer
+	*streamCipherProtocol
+	fixlenReadWriter
+}
+
+func (c *streamCipherCodec) Receive() (interface{}, error) {
+	c.recvData.Reset()
+	if _, err := c.recvData.ReadFrom(c.rw); err != nil {
+		return nil, err
+	}
+	data := c.recvData.Bytes()
+	n := c.block.BlockSize()
+	if len(data) < n {
+		return nil, ErrBadCiphertext
+	}
+	body := data[n:]
+	cipher.NewCTR(c.block, data[:n]).XORKeyStream(body, body)
+	c.recvBuf.Reset(body)
+	return c.base.Receive()
+}
+
+func (c *streamCipherCodec) Send(msg interface{}) error {
+	n := c.block.BlockSize()
+	c.sendBuf.Reset()
+	c.sendBuf.Write(make([]byte, n))
+	if err := c.base.Send(msg); err != nil {
+		return err
+	}
+	data := c.sendBuf.Bytes()
+	if _, err := rand.Read(data[:n]); err != nil {
+		return err
+	}
+	cipher.NewCTR(c.block, data[:n]).XORKeyStream(data[n:], data[n:])
+	_, err := c.rw.Write(data)
+	return err
+}
+
+func (c *streamCipherCodec) Close() error {
+	return c.base.Close()
+}
@@ -0,0 +1,227 @@
+package codec
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"errors"
+	"io"
+
+	"github.com/funny/link"
+)
+
+var ErrBadCompressFlag = errors.New("Bad Compress Flag")
+
+const (
+	compressNone = 0
+	compressed   = 1
+)
+
+// Compressor compresses the bodies of Compress, it is used by one codec at a
+// time so it can keep its state between packets.
+type Compressor interface {
+	Compress(dst *bytes.Buffer, src []byte) error
+	// Decompress fails with ErrTooLargePacket beyond max bytes.
+	Decompress(dst *bytes.Buffer, src []byte, max int) error
+}
+
+type compressProtocol struct {
+	base          link.Protocol
+	newCompressor func() Compressor
+	threshold     int
+	maxRecv       int
+}
+
+// Compress compresses the bodies encoded by base larger than threshold, with
+// a 1 byte flag telling whether a body is compressed. Decompressed bodies are
+// limited to maxRecv. It reads each packet to the end, so it must be placed
+// under a framing protocol, e.g. FixLen(Compress(Json(), NewFlate(-1), 256, 1<<20), ...).
+func Compress(base link.Protocol, newCompressor func() Compressor, threshold, maxRecv int) link.Protocol {
+	return &compressProtocol{
+		base:          base,
+		newCompressor: newCompressor,
+		threshold:     threshold,
+		maxRecv:       maxRecv,
+	}
+}
+
+func (p *compressProtocol) baseProtocol() link.Protocol {
+	return p.base
+}
+
+func (p *compressProtocol) NewCodec(rw io.ReadWriter) (cc link.Codec, err error) {
+	codec := &compressCodec{
+		rw:               rw,
+		compressor:       p.newCompressor(),
+		compressProtocol: p,
+	}
+	codec.base, err = p.base.NewCodec(&codec.fixlenReadWriter)
+	if err != nil {
+		return
+	}
+	cc = codec
+	return
+}
+
+type compressCodec struct {
+	base       link.Codec
+	rw         io.ReadWriter
+	compressor Compressor
+	recvData   bytes.Buffer
+	plain      bytes.Buffer
+	packet     bytes.Buffer
+	*compressProtocol
+	fixlenReadWriter
+}
+
+func (c *compressCodec) Receive() (interface{}, error) {
+	c.recvData.Reset()
+	if _, err := c.recvData.ReadFrom(c.rw); err != nil {
+		return nil, err
+	}
+	data := c.recvData.Bytes()
+	if len(data) == 0 {
+		return nil, ErrBadCompressFlag
+	}
+	switch data[0] {
+	case compressNone:
+		if len(data)-1 > c.maxRecv {
+			return nil, ErrTooLargePacket
+		}
+		c.recvBuf.Reset(data[1:])
+	case compressed:
+		c.plain.Reset()
+		if err := c.compressor.Decompress(&c.plain, data[1:], c.maxRecv); err != nil {
+			return nil, err
+		}
+		c.recvBuf.Reset(c.plain.Bytes())
+	default:
+		return nil, ErrBadCompressFlag
+	}
+	return c.base.Receive()
+}
+
+func (c *compressCodec) Send(msg interface{}) error {
+	c.sendBuf.Reset()
+	c.sendBuf.WriteByte(compressNone)
+	if err := c.base.Send(msg); err != nil {
+		return err
+	}
+	if c.sendBuf.Len()-1 <= c.threshold {
+		_, err := c.rw.Write(c.sendBuf.Bytes())
+		return err
+	}
+	c.packet.Reset()
+	c.packet.WriteByte(compressed)
+	if err := c.compressor.Compress(&c.packet, c.sendBuf.Bytes()[1:]); err != nil {
+		return err
+	}
+	// Incompressible bodies are sent as they are.
+	if c.packet.Len() >= c.sendBuf.Len() {
+		_, err := c.rw.Write(c.sendBuf.Bytes())
+		return err
+	}
+	_, err := c.rw.Write(c.packet.Bytes())
+	return err
+}
+
+func (c *compressCodec) Close() error {
+	return c.base.Close()
+}
+
+// decompress copies r into dst up to max bytes.
+func decompress(dst *bytes.Buffer, r io.Reader, max int) error {
+	n, err := io.CopyN(dst, r, int64(max)+1)
+	if n > int64(max) {
+		return ErrTooLargePacket
+	}
+	if err != io.EOF {
+		return err
+	}
+	return nil
+}
+
+// NewFlate is a Compressor of raw DEFLATE at level, like flate.NewWriter.
+func NewFlate(level int) func() Compressor {
+	return func() Compressor {
+		return &flateCompressor{level: level}
+	}
+}
+
+type flateCompressor struct {
+	level int
+	w     *flate.Writer
+	r     io.ReadCloser
+	src   bytes.Reader
+}
+
+func (c *flateCompressor) Compress(dst *bytes.Buffer, src []byte) (err error) {
+	if c.w == nil {
+		if c.w, err = flate.NewWriter(dst, c.level); err != nil {
+			return
+		}
+	} else {
+		c.w.Reset(dst)
+	}
+	if _, err = c.w.Write(src); err != nil {
+		return
+	}
+	return c.w.Close()
+}
+
+func (c *flateCompressor) Decompress(dst *bytes.Buffer, src []byte, max int) error {
+	c.src.Reset(src)
+	if c.r == nil {
+		c.r = flate.NewReader(&c.src)
+	} else {
+		c.r.(flate.Resetter).Reset(&c.src, nil)
+	}
+	if err := decompress(dst, c.r, max); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			return flate.CorruptInputError(len(src))
+		}
+		return err
+	}
+	return nil
+}
+
+// NewGzip is a Compressor of gzip at level, like gzip.NewWriterLevel.
+func NewGzip(level int) func() Compressor {
+	return func() Compressor {
+		return &gzipCompressor{level: level}
+	}
+}
+
+type gzipCompressor struct {
+	level int
+	w     *gzip.Writer
+	r     *gzip.Reader
+	src   bytes.Reader
+}
+
+func (c *gzipCompressor) Compress(dst *bytes.Buffer, src []byte) (err error) {
+	if c.w == nil {
+		if c.w, err = gzip.NewWriterLevel(dst, c.level); err != nil {
+			return
+		}
+	} else {
+		c.w.Reset(dst)
+	}
+	if _, err = c.w.Write(src); err != nil {
+		return
+	}
+	return c.w.Close()
+}
+
+func (c *gzipCompressor) Decompress(dst *bytes.Buffer, src []byte, max int) (err error) {
+	c.src.Reset(src)
+	if c.r == nil {
+		c.r, err = gzip.NewReader(&c.src)
+	} else {
+		err = c.r.Reset(&c.src)
+	}
+	if err != nil {
+		return
+	}
+	return decompress(dst, c.r, max)
+}
@@ -0,0 +1,58 @@
+package codec
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+func Test_Compress(t *testing.T) {
+	JsonTest(t, FixLen(Compress(JsonTestProtocol(), NewFlate(-1), 0, 1024), 2, binary.BigEndian, 1024, 1024))
+	JsonTest(t, FixLen(Compress(JsonTestProtocol(), NewGzip(-1), 0, 1024), 2, binary.BigEndian, 1024, 1024))
+
+	for _, newCompressor := range []func() Compressor{NewFlate(-1), NewGzip(-1)} {
+		var stream bytes.Buffer
+
+		codec, _ := FixLen(Compress(Raw(), newCompressor, 16, 1000), 2, binary.BigEndian, 1024, 1024).NewCodec(&stream)
+		codec.Send([]byte("short"))
+		codec.Send(bytes.Repeat([]byte("compress me "), 80))
+		codec.Send(bytes.Repeat([]byte("again "), 100))
+		if stream.Bytes()[2] != compressNone || stream.Len() > 2+1+5+2+100+2+100 {
+			t.Fatalf("not compressed: %d", stream.Len())
+		}
+
+		recv1, _ := codec.Receive()
+		msg1 := recv1.(*InBuffer).Clone()
+		recv2, _ := codec.Receive()
+		msg2 := recv2.(*InBuffer).Clone()
+		recv3, err := codec.Receive()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(msg1.Bytes()) != "short" ||
+			!bytes.Equal(msg2.Bytes(), bytes.Repeat([]byte("compress me "), 80)) ||
+			!bytes.Equal(recv3.(*InBuffer).Bytes(), bytes.Repeat([]byte("again "), 100)) {
+			t.Fatalf("message not match: %q", msg1.Bytes())
+		}
+
+		// A body decompressed beyond maxRecv is refused.
+		codec.Send(make([]byte, 1001))
+		if _, err := codec.Receive(); err != ErrTooLargePacket {
+			t.Fatalf("expected too large packet, got %v", err)
+		}
+	}
+}
+
+func Test_CompressBadFlag(t *testing.T) {
+	var stream bytes.Buffer
+
+	codec, _ := FixLen(Compress(Raw(), NewFlate(-1), 0, 1024), 2, binary.BigEndian, 1024, 1024).NewCodec(&stream)
+	stream.Write([]byte{0, 2, 9, 0})
+	if _, err := codec.Receive(); err != ErrBadCompressFlag {
+		t.Fatalf("expected bad compress flag, got %v", err)
+	}
+	stream.Write([]byte{0, 3, compressed, 0xff, 0xff})
+	if _, err := codec.Receive(); err == nil {
+		t.Fatal("corrupt body accepted")
+	}
+}
@@ -0,0 +1,75 @@
+package codec
+
+import (
+	"io"
+
+	"github.com/funny/link"
+)
+
+type DatagramProtocol struct {
+	base    link.Protocol
+	maxSize int
+}
+
+// Datagram takes each Read as one packet and writes each packet in one Write,
+// for datagram connections like UDP where a packet needs no framing.
+func Datagram(base link.Protocol, maxSize int) *DatagramProtocol {
+	return &DatagramProtocol{
+		base:    base,
+		maxSize: maxSize,
+	}
+}
+
+func (p *DatagramProtocol) NewCodec(rw io.ReadWriter) (cc link.Codec, err error) {
+	codec := &datagramCodec{
+		rw:               rw,
+		bodyBuf:          make([]byte, p.maxSize+1),
+		DatagramProtocol: p,
+	}
+	codec.base, err = p.base.NewCodec(&codec.fixlenReadWriter)
+	if err != nil {
+		return
+	}
+	cc = codec
+	return
+}
+
+type datagramCodec struct {
+	base    link.Codec
+	bodyBuf []byte
+	rw      io.ReadWriter
+	*DatagramProtocol
+	fixlenReadWriter
+}
+
+func (c *datagramCodec) Receive() (interface{}, error) {
+	// A datagram filling the extra byte is truncated.
+	n, err := c.rw.Read(c.bodyBuf)
+	if err != nil {
+		return nil, err
+	}
+	if n > c.maxSize {
+		return nil, ErrTooLargePacket
+	}
+	c.recvBuf.Reset(c.bodyBuf[:n])
+	return c.base.Receive()
+}
+
+func (c *datagramCodec) Send(msg interface{}) error {
+	c.sendBuf.Reset()
+	if err := c.base.Send(msg); err != nil {
+		return err
+	}
+	if c.sendBuf.Len() > c.maxSize {
+		return ErrTooLargePacket
+	}
+	_, err := c.rw.Write(c.sendBuf.Bytes())
+	return err
+}
+
+func (c *datagramCodec) Close() error {
+	if closer, ok := c.rw.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
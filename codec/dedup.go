@@ -0,0 +1,118 @@
+package codec
+
+import (
+	"container/list"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/funny/link"
+)
+
+// DedupCache remembers message IDs for a time, it can be shared by the
+// codecs of all sessions, so a message resent after reconnecting is still
+// seen as a duplicate.
+type DedupCache struct {
+	mutex   sync.Mutex
+	ttl     time.Duration
+	maxSize int
+	ids     map[string]*list.Element
+	order   *list.List
+	dropped uint64
+}
+
+type dedupEntry struct {
+	id     string
+	expire time.Time
+}
+
+// NewDedupCache creates a cache remembers each ID for ttl, and up to maxSize
+// IDs, the oldest ones are forgotten first. Zero maxSize means no limit.
+func NewDedupCache(ttl time.Duration, maxSize int) *DedupCache {
+	return &DedupCache{
+		ttl:     ttl,
+		maxSize: maxSize,
+		ids:     make(map[string]*list.Element),
+		order:   list.New(),
+	}
+}
+
+// Seen returns true if the id was seen in ttl, otherwise remembers it.
+func (cache *DedupCache) Seen(id string) bool {
+	now := time.Now()
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+
+	for e := cache.order.Front(); e != nil && !now.Before(e.Value.(*dedupEntry).expire); e = cache.order.Front() {
+		cache.remove(e)
+	}
+	if _, exists := cache.ids[id]; exists {
+		atomic.AddUint64(&cache.dropped, 1)
+		return true
+	}
+	if cache.maxSize > 0 && cache.order.Len() >= cache.maxSize {
+		cache.remove(cache.order.Front())
+	}
+	cache.ids[id] = cache.order.PushBack(&dedupEntry{id, now.Add(cache.ttl)})
+	return false
+}
+
+func (cache *DedupCache) remove(e *list.Element) {
+	cache.order.Remove(e)
+	delete(cache.ids, e.Value.(*dedupEntry).id)
+}
+
+func (cache *DedupCache) Len() int {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+	return cache.order.Len()
+}
+
+// Dropped returns the number of duplicates found.
+func (cache *DedupCache) Dropped() uint64 {
+	return atomic.LoadUint64(&cache.dropped)
+}
+
+type dedupProtocol struct {
+	base  link.Protocol
+	cache *DedupCache
+	idOf  func(msg interface{}) (string, bool)
+}
+
+// Dedup drops messages received with a ID seen in cache. idOf returns the ID
+// of a message, or false for messages never deduplicated like heartbeats.
+// The ID should contain the identity of client, like a user ID, when the
+// cache is shared.
+func Dedup(base link.Protocol, cache *DedupCache, idOf func(msg interface{}) (string, bool)) link.Protocol {
+	return &dedupProtocol{base, cache, idOf}
+}
+
+func (p *dedupProtocol) baseProtocol() link.Protocol {
+	return p.base
+}
+
+func (p *dedupProtocol) NewCodec(rw io.ReadWriter) (link.Codec, error) {
+	base, err := p.base.NewCodec(rw)
+	if err != nil {
+		return nil, err
+	}
+	return &dedupCodec{base, p}, nil
+}
+
+type dedupCodec struct {
+	link.Codec
+	*dedupProtocol
+}
+
+func (c *dedupCodec) Receive() (interface{}, error) {
+	for {
+		msg, err := c.Codec.Receive()
+		if err != nil {
+			return nil, err
+		}
+		if id, ok := c.idOf(msg); !ok || !c.cache.Seen(id) {
+			return msg, nil
+		}
+	}
+}
@@ -0,0 +1,57 @@
+package codec
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+	"time"
+)
+
+func Test_Dedup(t *testing.T) {
+	var stream bytes.Buffer
+
+	cache := NewDedupCache(50*time.Millisecond, 0)
+	protocol := Dedup(FixLen(Raw(), 2, binary.BigEndian, 1024, 1024), cache, func(msg interface{}) (string, bool) {
+		b := msg.(*InBuffer).Bytes()
+		return string(b[:1]), b[0] != 'h'
+	})
+	w, _ := protocol.NewCodec(&stream)
+	r, _ := protocol.NewCodec(&stream)
+
+	for _, msg := range []string{"1a", "h", "1b", "2a", "h"} {
+		w.Send([]byte(msg))
+	}
+	for _, expect := range []string{"1a", "h", "2a", "h"} {
+		msg, err := r.Receive()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(msg.(*InBuffer).Bytes()) != expect {
+			t.Fatalf("expect %q, got %q", expect, msg.(*InBuffer).Bytes())
+		}
+	}
+	if cache.Dropped() != 1 {
+		t.Fatalf("dropped = %d", cache.Dropped())
+	}
+
+	time.Sleep(60 * time.Millisecond)
+	if cache.Seen("1") {
+		t.Fatal("id not expired")
+	}
+	if cache.Len() != 1 {
+		t.Fatalf("len = %d", cache.Len())
+	}
+}
+
+func Test_DedupCacheMaxSize(t *testing.T) {
+	cache := NewDedupCache(time.Hour, 2)
+	cache.Seen("a")
+	cache.Seen("b")
+	cache.Seen("c")
+	if cache.Seen("a") {
+		t.Fatal("oldest id not forgotten")
+	}
+	if !cache.Seen("c") {
+		t.Fatal("newest id forgotten")
+	}
+}
@@ -0,0 +1,98 @@
+package codec
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+
+	"github.com/funny/link"
+)
+
+var ErrDelimInPacket = errors.New("Delimiter In Packet")
+
+// delimBufferSize is the read buffer of Delim, the packets longer are
+// gathered up to maxRecv as they arrive.
+const delimBufferSize = 4096
+
+type DelimProtocol struct {
+	base    link.Protocol
+	delim   []byte
+	maxRecv int
+	maxSend int
+}
+
+// Delim frames packets by ending them with delim, e.g. "\r\n" for Redis or
+// SMTP. A packet containing delim can not be sent.
+func Delim(base link.Protocol, delim []byte, maxRecv, maxSend int) *DelimProtocol {
+	if len(delim) == 0 {
+		panic("DelimProtocol: empty delimiter")
+	}
+	return &DelimProtocol{
+		base:    base,
+		delim:   append([]byte(nil), delim...),
+		maxRecv: maxRecv,
+		maxSend: maxSend,
+	}
+}
+
+func (p *DelimProtocol) NewCodec(rw io.ReadWriter) (cc link.Codec, err error) {
+	size := p.maxRecv + len(p.delim)
+	if size > delimBufferSize {
+		size = delimBufferSize
+	}
+	codec := &delimCodec{
+		rw:            rw,
+		reader:        bufio.NewReaderSize(rw, size),
+		DelimProtocol: p,
+	}
+	codec.base, err = p.base.NewCodec(&codec.fixlenReadWriter)
+	if err != nil {
+		return
+	}
+	cc = codec
+	return
+}
+
+type delimCodec struct {
+	base   link.Codec
+	rw     io.ReadWriter
+	reader *bufio.Reader
+	*DelimProtocol
+	fixlenReadWriter
+}
+
+func (c *delimCodec) Receive() (interface{}, error) {
+	packet, err := readLineMax(c.reader, c.delim, c.maxRecv+len(c.delim))
+	if err != nil {
+		return nil, err
+	}
+	c.recvBuf.Reset(packet)
+	return c.base.Receive()
+}
+
+func (c *delimCodec) Send(msg interface{}) error {
+	c.sendBuf.Reset()
+	if err := c.base.Send(msg); err != nil {
+		return err
+	}
+	if c.sendBuf.Len() > c.maxSend {
+		return ErrTooLargePacket
+	}
+	// The peer ends the packet at the first delim, which may start in the
+	// packet for a delim like "aa".
+	size := c.sendBuf.Len()
+	c.sendBuf.Write(c.delim)
+	if bytes.Index(c.sendBuf.Bytes(), c.delim) != size {
+		return ErrDelimInPacket
+	}
+	_, err := c.rw.Write(c.sendBuf.Bytes())
+	return err
+}
+
+func (c *delimCodec) Close() error {
+	if closer, ok := c.rw.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
@@ -0,0 +1,77 @@
+package codec
+
+import (
+	"bytes"
+	"testing"
+)
+
+func Test_Delim(t *testing.T) {
+	JsonTest(t, Delim(JsonTestProtocol(), []byte("\r\n"), 1024, 1024))
+
+	var stream bytes.Buffer
+
+	codec, _ := Delim(Raw(), []byte("\r\n"), 8, 8).NewCodec(&stream)
+	codec.Send([]byte("PING"))
+	codec.Send([]byte("a\rb\n"))
+	if stream.String() != "PING\r\na\rb\n\r\n" {
+		t.Fatalf("stream not match: %q", stream.String())
+	}
+	if err := codec.Send([]byte("a\r\nb")); err != ErrDelimInPacket {
+		t.Fatalf("expected delimiter in packet, got %v", err)
+	}
+	if err := codec.Send([]byte("123456789")); err != ErrTooLargePacket {
+		t.Fatalf("expected too large packet, got %v", err)
+	}
+
+	recv1, _ := codec.Receive()
+	msg1 := recv1.(*InBuffer).Clone()
+	recv2, _ := codec.Receive()
+	if string(msg1.Bytes()) != "PING" || string(recv2.(*InBuffer).Bytes()) != "a\rb\n" {
+		t.Fatalf("message not match: %q, %q", msg1.Bytes(), recv2.(*InBuffer).Bytes())
+	}
+
+	stream.WriteString("123456789\r\n")
+	if _, err := codec.Receive(); err != ErrTooLargePacket {
+		t.Fatalf("expected too large packet, got %v", err)
+	}
+}
+
+func Test_DelimOverlap(t *testing.T) {
+	var stream bytes.Buffer
+
+	codec, _ := Delim(Raw(), []byte("aa"), 8, 8).NewCodec(&stream)
+	if err := codec.Send([]byte("xa")); err != ErrDelimInPacket {
+		t.Fatalf("expected delimiter in packet, got %v", err)
+	}
+	if err := codec.Send([]byte("ax")); err != nil {
+		t.Fatal(err)
+	}
+	recv, _ := codec.Receive()
+	if string(recv.(*InBuffer).Bytes()) != "ax" {
+		t.Fatalf("message not match: %q", recv.(*InBuffer).Bytes())
+	}
+}
+
+func Test_DelimLong(t *testing.T) {
+	var stream bytes.Buffer
+
+	codec, _ := Delim(Raw(), []byte("\r\n"), 3*delimBufferSize, 3*delimBufferSize).NewCodec(&stream)
+	if size := codec.(*delimCodec).reader.Size(); size != delimBufferSize {
+		t.Fatalf("expected a buffer of %d bytes, got %d", delimBufferSize, size)
+	}
+	long := bytes.Repeat([]byte("x"), 2*delimBufferSize+1)
+	codec.Send(long)
+	recv, err := codec.Receive()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(recv.(*InBuffer).Bytes(), long) {
+		t.Fatalf("message not match: %d bytes", len(recv.(*InBuffer).Bytes()))
+	}
+
+	stream.Write(bytes.Repeat([]byte("x"), 3*delimBufferSize+1))
+	stream.WriteString("\r\n")
+	if _, err := codec.Receive(); err != ErrTooLargePacket {
+		t.Fatalf("expected too large packet, got %v", err)
+	}
+}
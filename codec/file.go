@@ -0,0 +1,46 @@
+package codec
+
+import (
+	"io"
+	"os"
+)
+
+// FileRegion is a message that sends part of a file as the packet body.
+// Over a TCP connection the body is transmitted with sendfile, so the file
+// contents are not copied through user space. The file offset is moved by
+// Send, files must not be shared between concurrent sends.
+type FileRegion struct {
+	File   *os.File
+	Offset int64
+	Length int64
+}
+
+// fileCopySize is the regions read and written with their head in one
+// Write, so a small body isn't sent in a segment apart from its head.
+const fileCopySize = 16 * 1024
+
+func (r *FileRegion) writeTo(w io.Writer, head []byte) error {
+	if _, err := r.File.Seek(r.Offset, io.SeekStart); err != nil {
+		return err
+	}
+	if r.Length <= fileCopySize {
+		packet := make([]byte, len(head)+int(r.Length))
+		copy(packet, head)
+		if _, err := io.ReadFull(r.File, packet[len(head):]); err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return err
+		}
+		_, err := w.Write(packet)
+		return err
+	}
+	if _, err := w.Write(head); err != nil {
+		return err
+	}
+	n, err := io.Copy(w, io.LimitReader(r.File, r.Length))
+	if err == nil && n < r.Length {
+		err = io.ErrUnexpectedEOF
+	}
+	return err
+}
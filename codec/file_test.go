@@ -0,0 +1,104 @@
+package codec
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net"
+	"os"
+	"testing"
+)
+
+func Test_FileRegion(t *testing.T) {
+	data := make([]byte, 64*1024)
+	rand.Read(data)
+
+	file, err := ioutil.TempFile("", "link")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(file.Name())
+	defer file.Close()
+	file.Write(data)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	protocol := FixLen(BytesTestProtocol(), 4, binary.BigEndian, 1024*1024, 1024*1024)
+
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		codec, _ := protocol.NewCodec(conn)
+		codec.Send(&FileRegion{file, 1000, 50000})
+		codec.Send(&FileRegion{file, 0, 10})
+	}()
+
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	codec, _ := protocol.NewCodec(conn)
+
+	recv, err := codec.Receive()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(recv.([]byte), data[1000:51000]) {
+		t.Fatal("file region not match")
+	}
+	recv, err = codec.Receive()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(recv.([]byte), data[:10]) {
+		t.Fatal("file region not match")
+	}
+
+	if err := codec.Send(&FileRegion{file, 0, 2 * 1024 * 1024}); err != ErrTooLargePacket {
+		t.Fatalf("expected too large packet, got %v", err)
+	}
+}
+
+type countWriter struct {
+	bytes.Buffer
+	writes int
+}
+
+func (w *countWriter) Write(p []byte) (int, error) {
+	w.writes++
+	return w.Buffer.Write(p)
+}
+
+func Test_FileRegionOneWrite(t *testing.T) {
+	file, err := ioutil.TempFile("", "link")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(file.Name())
+	defer file.Close()
+	file.Write([]byte("hello world"))
+
+	var stream countWriter
+	codec, _ := FixLen(BytesTestProtocol(), 2, binary.BigEndian, 1024, 1024).NewCodec(&stream)
+	if err := codec.Send(&FileRegion{file, 6, 5}); err != nil {
+		t.Fatal(err)
+	}
+	if err := codec.Send(Buffers{[]byte("hel"), []byte("lo")}); err != nil {
+		t.Fatal(err)
+	}
+	if stream.writes != 2 || stream.String() != "\x00\x05world\x00\x05hello" {
+		t.Fatalf("packets not written at once: %d, %q", stream.writes, stream.String())
+	}
+	if err := codec.Send(&FileRegion{file, 6, 6}); err != io.ErrUnexpectedEOF {
+		t.Fatalf("expected unexpected EOF, got %v", err)
+	}
+}
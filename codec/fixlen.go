@@ -6,34 +6,46 @@ import (
 	"errors"
 	"io"
 	"math"
+	"sync/atomic"
 
 	"github.com/funny/link"
 )
 
+// ErrTooLargePacket is returned for a packet over the limits of its framing.
+// Framing with Fragment instead splits the large packets into chunks and
+// reassembles them on the other side. The length-prefixed framings receive it
+// as a *PacketTooLargeError.
 var ErrTooLargePacket = errors.New("Too Large Packet")
 
+// ErrBadLength is returned for a head telling less than zero bytes of body,
+// or a body too short for the adjustments of the head.
+var ErrBadLength = errors.New("Bad Packet Length")
+
 type FixLenProtocol struct {
+	maxRecv     int64
+	maxSend     int64
+	maxSize     int
 	base        link.Protocol
 	n           int
-	maxRecv     int
-	maxSend     int
 	headDecoder func([]byte) int
 	headEncoder func([]byte, int)
+	spillSize   int
+	spillDir    string
+	streamSize  int
+	adjustment  int
+	headCounted bool
+	oversize    OversizePolicy
 }
 
 func FixLen(base link.Protocol, n int, byteOrder binary.ByteOrder, maxRecv, maxSend int) *FixLenProtocol {
 	proto := &FixLenProtocol{
-		n:    n,
-		base: base,
+		n:       n,
+		base:    base,
+		maxSize: int(^uint(0) >> 1),
 	}
 	switch n {
 	case 1:
-		if maxRecv > math.MaxUint8 {
-			maxRecv = math.MaxUint8
-		}
-		if maxSend > math.MaxUint8 {
-			maxSend = math.MaxUint8
-		}
+		proto.maxSize = math.MaxUint8
 		proto.headDecoder = func(b []byte) int {
 			return int(b[0])
 		}
@@ -41,12 +53,7 @@ func FixLen(base link.Protocol, n int, byteOrder binary.ByteOrder, maxRecv, maxS
 			b[0] = byte(size)
 		}
 	case 2:
-		if maxRecv > math.MaxUint16 {
-			maxRecv = math.MaxUint16
-		}
-		if maxSend > math.MaxUint16 {
-			maxSend = math.MaxUint16
-		}
+		proto.maxSize = math.MaxUint16
 		proto.headDecoder = func(b []byte) int {
 			return int(byteOrder.Uint16(b))
 		}
@@ -54,12 +61,7 @@ func FixLen(base link.Protocol, n int, byteOrder binary.ByteOrder, maxRecv, maxS
 			byteOrder.PutUint16(b, uint16(size))
 		}
 	case 4:
-		if maxRecv > math.MaxUint32 {
-			maxRecv = math.MaxUint32
-		}
-		if maxSend > math.MaxUint32 {
-			maxSend = math.MaxUint32
-		}
+		proto.maxSize = math.MaxUint32
 		proto.headDecoder = func(b []byte) int {
 			return int(byteOrder.Uint32(b))
 		}
@@ -76,11 +78,89 @@ func FixLen(base link.Protocol, n int, byteOrder binary.ByteOrder, maxRecv, maxS
 	default:
 		panic("FixLenProtocol: unsupported head size")
 	}
-	proto.maxRecv = maxRecv
-	proto.maxSend = maxSend
+	proto.SetMaxRecv(maxRecv)
+	proto.SetMaxSend(maxSend)
 	return proto
 }
 
+// SetMaxRecv changes the receive limit of all codecs created by the protocol,
+// it can be called while sessions are running.
+func (p *FixLenProtocol) SetMaxRecv(maxRecv int) {
+	if maxRecv > p.maxSize {
+		maxRecv = p.maxSize
+	}
+	atomic.StoreInt64(&p.maxRecv, int64(maxRecv))
+}
+
+// SetMaxSend changes the send limit of all codecs created by the protocol,
+// it can be called while sessions are running.
+func (p *FixLenProtocol) SetMaxSend(maxSend int) {
+	if maxSend > p.maxSize {
+		maxSend = p.maxSize
+	}
+	atomic.StoreInt64(&p.maxSend, int64(maxSend))
+}
+
+func (p *FixLenProtocol) MaxRecv() int {
+	return int(atomic.LoadInt64(&p.maxRecv))
+}
+
+func (p *FixLenProtocol) MaxSend() int {
+	return int(atomic.LoadInt64(&p.maxSend))
+}
+
+// SetOversize sets what the codecs do with a received packet over the limit.
+func (p *FixLenProtocol) SetOversize(policy OversizePolicy) {
+	p.oversize = policy
+}
+
+// SpillToDisk makes packets larger than threshold be streamed into a temporary
+// file in dir and delivered as *SpillFile instead of being decoded by base.
+func (p *FixLenProtocol) SpillToDisk(threshold int, dir string) {
+	p.spillSize = threshold
+	p.spillDir = dir
+}
+
+// StreamAbove makes packets larger than threshold be delivered as *PacketStream
+// reading from the connection, instead of being buffered and decoded by base.
+func (p *FixLenProtocol) StreamAbove(threshold int) {
+	p.streamSize = threshold
+}
+
+// SetHeadIncluded makes the head count its own n bytes, like peers sending the
+// size of the whole packet, e.g. some C servers. It must be called before
+// the protocol is used.
+func (p *FixLenProtocol) SetHeadIncluded(included bool) {
+	p.headCounted = included
+}
+
+// SetLengthAdjustment makes the head tell the size of the body plus
+// adjustment, for peers counting extra bytes. It adds up with
+// SetHeadIncluded, and must be called before the protocol is used.
+func (p *FixLenProtocol) SetLengthAdjustment(adjustment int) {
+	p.adjustment = adjustment
+}
+
+// headDelta is what the head tells more than the size of the body.
+func (p *FixLenProtocol) headDelta() int {
+	if p.headCounted {
+		return p.adjustment + p.n
+	}
+	return p.adjustment
+}
+
+func (p *FixLenProtocol) encodeHead(b []byte, size int) error {
+	length := size + p.headDelta()
+	if length < 0 {
+		return ErrBadLength
+	}
+	if length > p.maxSize {
+		return ErrTooLargePacket
+	}
+	p.headEncoder(b, length)
+	return nil
+}
+
 func (p *FixLenProtocol) NewCodec(rw io.ReadWriter) (cc link.Codec, err error) {
 	codec := &fixlenCodec{
 		rw:             rw,
@@ -114,18 +194,35 @@ type fixlenCodec struct {
 	head    [8]byte
 	headBuf []byte
 	bodyBuf []byte
+	stream  *PacketStream
 	rw      io.ReadWriter
 	*FixLenProtocol
 	fixlenReadWriter
 }
 
 func (c *fixlenCodec) Receive() (interface{}, error) {
+	if c.stream != nil {
+		if err := c.stream.skip(); err != nil {
+			return nil, err
+		}
+		c.stream = nil
+	}
 	if _, err := io.ReadFull(c.rw, c.headBuf); err != nil {
 		return nil, err
 	}
-	size := c.headDecoder(c.headBuf)
-	if size > c.maxRecv {
-		return nil, ErrTooLargePacket
+	size := c.headDecoder(c.headBuf) - c.headDelta()
+	if size < 0 {
+		return nil, ErrBadLength
+	}
+	if max := c.MaxRecv(); size > max {
+		return nil, oversize(c.rw, int64(size), max, c.oversize)
+	}
+	if c.streamSize > 0 && size > c.streamSize {
+		c.stream = newPacketStream(c.rw, size)
+		return c.stream, nil
+	}
+	if c.spillSize > 0 && size > c.spillSize {
+		return spillToDisk(c.rw, c.spillDir, int64(size))
 	}
 	if cap(c.bodyBuf) < size {
 		c.bodyBuf = make([]byte, size, size+128)
@@ -140,18 +237,76 @@ func (c *fixlenCodec) Receive() (interface{}, error) {
 }
 
 func (c *fixlenCodec) Send(msg interface{}) error {
+	switch m := msg.(type) {
+	case Buffers:
+		return c.sendBuffers(m)
+	case *FileRegion:
+		return c.sendFile(m)
+	case *SharedBuffer:
+		defer m.Release()
+		return c.sendBuffers(Buffers{m.Bytes()})
+	case *OutBuffer:
+		if _, isRaw := c.base.(*rawCodec); isRaw && m.start >= c.n {
+			return c.sendOutBuffer(m)
+		}
+	}
+	// A zero placeholder, c.headBuf is used by Receive.
+	var head [8]byte
 	c.sendBuf.Reset()
-	c.sendBuf.Write(c.headBuf)
+	c.sendBuf.Write(head[:c.n])
 	err := c.base.Send(msg)
 	if err != nil {
 		return err
 	}
 	buff := c.sendBuf.Bytes()
-	c.headEncoder(buff, len(buff)-c.n)
+	if len(buff)-c.n > c.MaxSend() {
+		return ErrTooLargePacket
+	}
+	if err := c.encodeHead(buff, len(buff)-c.n); err != nil {
+		return err
+	}
 	_, err = c.rw.Write(buff)
 	return err
 }
 
+func (c *fixlenCodec) sendBuffers(buffers Buffers) error {
+	size := buffers.Len()
+	if size > c.MaxSend() {
+		return ErrTooLargePacket
+	}
+	var head [8]byte
+	if err := c.encodeHead(head[:c.n], size); err != nil {
+		return err
+	}
+	return buffers.writeTo(c.rw, head[:c.n])
+}
+
+// sendOutBuffer writes the head into the room of the buffer, then takes it
+// back so the buffer can be sent again.
+func (c *fixlenCodec) sendOutBuffer(buffer *OutBuffer) error {
+	size := buffer.Len()
+	if size > c.MaxSend() {
+		return ErrTooLargePacket
+	}
+	err := c.encodeHead(buffer.Prepend(c.n), size)
+	if err == nil {
+		_, err = c.rw.Write(buffer.Bytes())
+	}
+	buffer.start += c.n
+	return err
+}
+
+func (c *fixlenCodec) sendFile(file *FileRegion) error {
+	if file.Length > int64(c.MaxSend()) {
+		return ErrTooLargePacket
+	}
+	var head [8]byte
+	if err := c.encodeHead(head[:c.n], int(file.Length)); err != nil {
+		return err
+	}
+	return file.writeTo(c.rw, head[:c.n])
+}
+
 func (c *fixlenCodec) Close() error {
 	if closer, ok := c.rw.(io.Closer); ok {
 		return closer.Close()
@@ -1,7 +1,12 @@
 package codec
 
 import (
+	"bytes"
 	"encoding/binary"
+	"errors"
+	"io"
+	"math"
+	"net"
 	"testing"
 )
 
@@ -10,3 +15,148 @@ func Test_FixLen(t *testing.T) {
 	protocol := FixLen(base, 2, binary.LittleEndian, 1024, 1024)
 	JsonTest(t, protocol)
 }
+
+func Test_FixLenSetMaxRecv(t *testing.T) {
+	var stream bytes.Buffer
+
+	protocol := FixLen(BytesTestProtocol(), 2, binary.LittleEndian, 1024, 1024)
+	codec, _ := protocol.NewCodec(&stream)
+
+	codec.Send(make([]byte, 100))
+	protocol.SetMaxRecv(10)
+	if _, err := codec.Receive(); !errors.Is(err, ErrTooLargePacket) {
+		t.Fatalf("expected too large packet, got %v", err)
+	}
+
+	protocol.SetMaxRecv(1 << 20)
+	if protocol.MaxRecv() != math.MaxUint16 {
+		t.Fatalf("max recv not clamped: %d", protocol.MaxRecv())
+	}
+}
+
+func Test_FixLenSetMaxSend(t *testing.T) {
+	var stream bytes.Buffer
+
+	protocol := FixLen(BytesTestProtocol(), 2, binary.LittleEndian, 1024, 1024)
+	codec, _ := protocol.NewCodec(&stream)
+
+	protocol.SetMaxSend(10)
+	if err := codec.Send(make([]byte, 100)); err != ErrTooLargePacket {
+		t.Fatalf("expected too large packet, got %v", err)
+	}
+	if stream.Len() != 0 {
+		t.Fatalf("unexpected bytes sent: %d", stream.Len())
+	}
+}
+
+func Test_FixLenHeadAdjustment(t *testing.T) {
+	for _, c := range []struct {
+		included   bool
+		adjustment int
+		head       []byte
+	}{
+		{false, 0, []byte{0, 3}},
+		{true, 0, []byte{0, 5}},
+		{false, 1, []byte{0, 4}},
+		{true, -1, []byte{0, 4}},
+	} {
+		var stream bytes.Buffer
+		protocol := FixLen(BytesTestProtocol(), 2, binary.BigEndian, 1024, 1024)
+		protocol.SetHeadIncluded(c.included)
+		protocol.SetLengthAdjustment(c.adjustment)
+		codec, _ := protocol.NewCodec(&stream)
+
+		if err := codec.Send([]byte("abc")); err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(stream.Bytes()[:2], c.head) {
+			t.Fatalf("head not match: %v, %v", stream.Bytes()[:2], c.head)
+		}
+		msg, err := codec.Receive()
+		if err != nil || string(msg.([]byte)) != "abc" {
+			t.Fatalf("message not match: %v, %v", msg, err)
+		}
+	}
+
+	var stream bytes.Buffer
+	protocol := FixLen(BytesTestProtocol(), 2, binary.BigEndian, 1024, 1024)
+	protocol.SetHeadIncluded(true)
+	codec, _ := protocol.NewCodec(&stream)
+	stream.Write([]byte{0, 1})
+	if _, err := codec.Receive(); err != ErrBadLength {
+		t.Fatalf("expected bad length, got %v", err)
+	}
+	protocol.SetLengthAdjustment(-10)
+	if err := codec.Send([]byte("abc")); err != ErrBadLength {
+		t.Fatalf("expected bad length, got %v", err)
+	}
+}
+
+func Test_FixLenConcurrentSendReceive(t *testing.T) {
+	c1, c2 := net.Pipe()
+	defer c1.Close()
+	defer c2.Close()
+
+	protocol := FixLen(Raw(), 2, binary.LittleEndian, 1024, 1024)
+	codec, _ := protocol.NewCodec(c1)
+	echo, _ := protocol.NewCodec(c2)
+
+	const n = 100
+	go func() {
+		for i := 0; i < n; i++ {
+			msg, err := echo.Receive()
+			if err != nil {
+				return
+			}
+			if echo.Send(msg.(*InBuffer).Bytes()) != nil {
+				return
+			}
+		}
+	}()
+	go func() {
+		for i := 0; i < n; i++ {
+			if codec.Send([]byte{byte(i)}) != nil {
+				return
+			}
+		}
+	}()
+	for i := 0; i < n; i++ {
+		msg, err := codec.Receive()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if b := msg.(*InBuffer).Bytes(); len(b) != 1 || b[0] != byte(i) {
+			t.Fatalf("message not match: %d, %v", i, b)
+		}
+	}
+}
+
+func Test_FixLenOversize(t *testing.T) {
+	var stream bytes.Buffer
+
+	protocol := FixLen(BytesTestProtocol(), 2, binary.LittleEndian, 1024, 1024)
+	codec, _ := protocol.NewCodec(&stream)
+	codec.Send(make([]byte, 100))
+	codec.Send([]byte("hello"))
+	protocol.SetMaxRecv(10)
+	protocol.SetOversize(OversizeSkip)
+
+	_, err := codec.Receive()
+	var tooLarge *PacketTooLargeError
+	if !errors.As(err, &tooLarge) || !errors.Is(err, ErrTooLargePacket) {
+		t.Fatalf("expected too large packet, got %v", err)
+	}
+	if tooLarge.Size != 100 || tooLarge.Limit != 10 || !tooLarge.Skipped {
+		t.Fatalf("error not match: %+v", tooLarge)
+	}
+	recv, err := codec.Receive()
+	if err != nil || string(recv.([]byte)) != "hello" {
+		t.Fatalf("next packet not match: %v, %v", recv, err)
+	}
+
+	// A body cut short is not skipped.
+	stream.Write([]byte{100, 0, 1, 2})
+	if _, err := codec.Receive(); err != io.ErrUnexpectedEOF {
+		t.Fatalf("expected unexpected EOF, got %v", err)
+	}
+}
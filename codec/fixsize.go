@@ -0,0 +1,97 @@
+package codec
+
+import (
+	"errors"
+	"io"
+
+	"github.com/funny/link"
+)
+
+var ErrPacketSize = errors.New("Wrong Packet Size")
+
+type FixSizeProtocol struct {
+	base    link.Protocol
+	size    int
+	pad     bool
+	padByte byte
+}
+
+// FixSize frames packets without a head, every packet is size bytes, like in
+// legacy binary feeds of market data or telemetry. Encoded packets shorter
+// than size are refused with ErrPacketSize unless SetPadding is called, the
+// longer ones with ErrTooLargePacket.
+func FixSize(base link.Protocol, size int) *FixSizeProtocol {
+	if size <= 0 {
+		panic("FixSizeProtocol: bad packet size")
+	}
+	return &FixSizeProtocol{
+		base: base,
+		size: size,
+	}
+}
+
+// SetPadding makes short packets be padded with b up to the size. The padding
+// is received as part of the packet, base must tolerate it, e.g. spaces
+// after JSON.
+func (p *FixSizeProtocol) SetPadding(b byte) {
+	p.pad = true
+	p.padByte = b
+}
+
+func (p *FixSizeProtocol) NewCodec(rw io.ReadWriter) (cc link.Codec, err error) {
+	codec := &fixsizeCodec{
+		rw:              rw,
+		body:            make([]byte, p.size),
+		FixSizeProtocol: p,
+	}
+	codec.base, err = p.base.NewCodec(&codec.fixlenReadWriter)
+	if err != nil {
+		return
+	}
+	cc = codec
+	return
+}
+
+type fixsizeCodec struct {
+	base link.Codec
+	rw   io.ReadWriter
+	body []byte
+	*FixSizeProtocol
+	fixlenReadWriter
+}
+
+func (c *fixsizeCodec) Receive() (interface{}, error) {
+	if _, err := io.ReadFull(c.rw, c.body); err != nil {
+		return nil, err
+	}
+	c.recvBuf.Reset(c.body)
+	return c.base.Receive()
+}
+
+func (c *fixsizeCodec) Send(msg interface{}) error {
+	c.sendBuf.Reset()
+	if err := c.base.Send(msg); err != nil {
+		return err
+	}
+	size := c.sendBuf.Len()
+	if size > c.size {
+		return ErrTooLargePacket
+	}
+	if size < c.size {
+		if !c.pad {
+			return ErrPacketSize
+		}
+		for ; size < c.size; size++ {
+			c.sendBuf.WriteByte(c.padByte)
+		}
+	}
+	_, err := c.rw.Write(c.sendBuf.Bytes())
+	return err
+}
+
+func (c *fixsizeCodec) Close() error {
+	if closer, ok := c.rw.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
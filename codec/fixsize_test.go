@@ -0,0 +1,49 @@
+package codec
+
+import (
+	"bytes"
+	"testing"
+)
+
+func Test_FixSize(t *testing.T) {
+	protocol := FixSize(JsonTestProtocol(), 256)
+	protocol.SetPadding(' ')
+	JsonTest(t, protocol)
+
+	var stream bytes.Buffer
+	codec, _ := FixSize(Raw(), 4).NewCodec(&stream)
+	if err := codec.Send([]byte("abcd")); err != nil {
+		t.Fatal(err)
+	}
+	if err := codec.Send([]byte("abc")); err != ErrPacketSize {
+		t.Fatalf("short packet not refused: %v", err)
+	}
+	if err := codec.Send([]byte("abcde")); err != ErrTooLargePacket {
+		t.Fatalf("long packet not refused: %v", err)
+	}
+	stream.WriteString("efgh")
+	for _, expect := range []string{"abcd", "efgh"} {
+		msg, err := codec.Receive()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(msg.(*InBuffer).Bytes()) != expect {
+			t.Fatalf("packet not match: %q", msg.(*InBuffer).Bytes())
+		}
+	}
+	stream.WriteString("xy")
+	if _, err := codec.Receive(); err == nil {
+		t.Fatal("partial packet received")
+	}
+
+	protocol = FixSize(Raw(), 4)
+	protocol.SetPadding(0)
+	codec, _ = protocol.NewCodec(&stream)
+	stream.Reset()
+	if err := codec.Send([]byte("ab")); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(stream.Bytes(), []byte{'a', 'b', 0, 0}) {
+		t.Fatalf("padding not match: %q", stream.Bytes())
+	}
+}
@@ -0,0 +1,271 @@
+package codec
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"runtime"
+	"sync"
+	"sync/atomic"
+
+	"github.com/funny/link"
+)
+
+var ErrBadFragment = errors.New("Bad Fragment")
+
+// The 4 byte big-endian head of a frame holds the kind in the top 2 bits and
+// the body size in the others.
+const (
+	fragmentWhole    = 0
+	fragmentMore     = 2 << 30
+	fragmentLast     = 3 << 30
+	fragmentSizeMask = 1<<30 - 1
+)
+
+type FragmentProtocol struct {
+	base      link.Protocol
+	chunkSize int
+	maxRecv   int
+	maxSend   int
+	queueSize int
+}
+
+// Fragment is a framing protocol like FixLen, that splits the packets larger
+// than chunkSize into chunks of a 4 byte head and up to chunkSize bytes.
+func Fragment(base link.Protocol, chunkSize, maxRecv, maxSend int) *FragmentProtocol {
+	if chunkSize <= 0 || chunkSize > fragmentSizeMask {
+		panic("FragmentProtocol: unsupported chunk size")
+	}
+	return &FragmentProtocol{
+		base:      base,
+		chunkSize: chunkSize,
+		maxRecv:   maxRecv,
+		maxSend:   maxSend,
+	}
+}
+
+// Interleave makes the packets larger than the chunk size be written one chunk
+// at a time in background, and the smaller ones be written between the chunks
+// first, so a large state sync doesn't delay heartbeats and inputs. Send of a
+// large packet returns once it is queued, a write error is returned by the
+// next Send. Send blocks when queueSize large packets are waiting, and the
+// waiting ones are dropped by Close.
+func (p *FragmentProtocol) Interleave(queueSize int) {
+	if queueSize < 1 {
+		queueSize = 1
+	}
+	p.queueSize = queueSize
+}
+
+func (p *FragmentProtocol) NewCodec(rw io.ReadWriter) (cc link.Codec, err error) {
+	codec := &fragmentCodec{
+		rw:               rw,
+		closeChan:        make(chan struct{}),
+		loopDone:         make(chan struct{}),
+		FragmentProtocol: p,
+	}
+	codec.base, err = p.base.NewCodec(&codec.fixlenReadWriter)
+	if err != nil {
+		return
+	}
+	if p.queueSize > 0 {
+		codec.queue = make(chan []byte, p.queueSize)
+		go codec.writeLoop()
+	}
+	cc = codec
+	return
+}
+
+type fragmentCodec struct {
+	base       link.Codec
+	rw         io.ReadWriter
+	head       [4]byte
+	bodyBuf    []byte
+	partial    []byte
+	chunkBuf   []byte
+	writeMutex sync.Mutex
+	waiting    int32
+	queue      chan []byte
+	errMutex   sync.Mutex
+	err        error
+	closeOnce  sync.Once
+	closeChan  chan struct{}
+	loopDone   chan struct{}
+	*FragmentProtocol
+	fixlenReadWriter
+}
+
+func (c *fragmentCodec) Receive() (interface{}, error) {
+	for {
+		if _, err := io.ReadFull(c.rw, c.head[:]); err != nil {
+			return nil, err
+		}
+		head := binary.BigEndian.Uint32(c.head[:])
+		size := int(head & fragmentSizeMask)
+
+		switch head &^ fragmentSizeMask {
+		case fragmentWhole:
+			// A small packet may arrive between the chunks of a large one,
+			// so it doesn't touch the partial packet.
+			if size > c.maxRecv {
+				return nil, ErrTooLargePacket
+			}
+			if cap(c.bodyBuf) < size {
+				c.bodyBuf = make([]byte, size, size+128)
+			}
+			buff := c.bodyBuf[:size]
+			if _, err := io.ReadFull(c.rw, buff); err != nil {
+				return nil, err
+			}
+			c.recvBuf.Reset(buff)
+			return c.base.Receive()
+		case fragmentMore, fragmentLast:
+			m := len(c.partial)
+			if m+size > c.maxRecv {
+				return nil, ErrTooLargePacket
+			}
+			c.partial = append(c.partial, make([]byte, size)...)
+			if _, err := io.ReadFull(c.rw, c.partial[m:]); err != nil {
+				return nil, err
+			}
+			if head&^fragmentSizeMask == fragmentMore {
+				continue
+			}
+			c.recvBuf.Reset(c.partial)
+			c.partial = c.partial[:0]
+			return c.base.Receive()
+		default:
+			return nil, ErrBadFragment
+		}
+	}
+}
+
+func (c *fragmentCodec) Send(msg interface{}) error {
+	if err := c.sendErr(); err != nil {
+		return err
+	}
+	var head [4]byte
+	c.sendBuf.Reset()
+	c.sendBuf.Write(head[:])
+	if err := c.base.Send(msg); err != nil {
+		return err
+	}
+	buff := c.sendBuf.Bytes()
+	size := len(buff) - len(head)
+	if size > c.maxSend {
+		return ErrTooLargePacket
+	}
+
+	if size <= c.chunkSize {
+		binary.BigEndian.PutUint32(buff, uint32(size))
+		atomic.AddInt32(&c.waiting, 1)
+		c.writeMutex.Lock()
+		atomic.AddInt32(&c.waiting, -1)
+		defer c.writeMutex.Unlock()
+		_, err := c.rw.Write(buff)
+		return err
+	}
+
+	if c.queue == nil {
+		c.writeMutex.Lock()
+		defer c.writeMutex.Unlock()
+		return c.writeChunks(buff[len(head):], false)
+	}
+	packet := append([]byte(nil), buff[len(head):]...)
+	select {
+	case c.queue <- packet:
+		return nil
+	case <-c.closeChan:
+		return link.SessionClosedError
+	}
+}
+
+func (c *fragmentCodec) writeLoop() {
+	defer close(c.loopDone)
+	for {
+		select {
+		case packet := <-c.queue:
+			// The nil of Release comes after the packets queued.
+			if packet == nil {
+				return
+			}
+			// Keep draining after a failure, so Send never blocks on a
+			// full queue.
+			if c.sendErr() != nil {
+				continue
+			}
+			if err := c.writeChunks(packet, true); err != nil {
+				c.errMutex.Lock()
+				c.err = err
+				c.errMutex.Unlock()
+			}
+		case <-c.closeChan:
+			return
+		}
+	}
+}
+
+// writeChunks writes packet in chunks, when interleave is true it takes the
+// write lock for each chunk and lets the waiting small packets go first.
+func (c *fragmentCodec) writeChunks(packet []byte, interleave bool) error {
+	for len(packet) > 0 {
+		n := len(packet)
+		kind := uint32(fragmentLast)
+		if n > c.chunkSize {
+			n = c.chunkSize
+			kind = fragmentMore
+		}
+		if cap(c.chunkBuf) < 4+c.chunkSize {
+			c.chunkBuf = make([]byte, 4+c.chunkSize)
+		}
+		frame := c.chunkBuf[:4+n]
+		binary.BigEndian.PutUint32(frame, kind|uint32(n))
+		copy(frame[4:], packet[:n])
+
+		if interleave {
+			for atomic.LoadInt32(&c.waiting) > 0 {
+				runtime.Gosched()
+			}
+			c.writeMutex.Lock()
+		}
+		_, err := c.rw.Write(frame)
+		if interleave {
+			c.writeMutex.Unlock()
+		}
+		if err != nil {
+			return err
+		}
+		packet = packet[n:]
+	}
+	return nil
+}
+
+func (c *fragmentCodec) sendErr() error {
+	c.errMutex.Lock()
+	defer c.errMutex.Unlock()
+	return c.err
+}
+
+// Release writes the large packets queued and stops the write loop, for a
+// codec replaced by Session.SetProtocol.
+func (c *fragmentCodec) Release() {
+	if c.queue == nil {
+		return
+	}
+	select {
+	case c.queue <- nil:
+		<-c.loopDone
+	case <-c.loopDone:
+	case <-c.closeChan:
+	}
+}
+
+func (c *fragmentCodec) Close() error {
+	c.closeOnce.Do(func() {
+		close(c.closeChan)
+	})
+	if closer, ok := c.rw.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
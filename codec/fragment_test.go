@@ -0,0 +1,132 @@
+package codec
+
+import (
+	"bytes"
+	"io"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func Test_Fragment(t *testing.T) {
+	JsonTest(t, Fragment(JsonTestProtocol(), 8, 1024, 1024))
+	JsonTest(t, Fragment(JsonTestProtocol(), 1024, 1024, 1024))
+}
+
+func Test_FragmentBadHead(t *testing.T) {
+	var stream bytes.Buffer
+
+	codec, _ := Fragment(BytesTestProtocol(), 8, 1024, 1024).NewCodec(&stream)
+	stream.Write([]byte{0x40, 0, 0, 0})
+	if _, err := codec.Receive(); err != ErrBadFragment {
+		t.Fatalf("expected bad fragment, got %v", err)
+	}
+	if err := codec.Send(make([]byte, 2048)); err != ErrTooLargePacket {
+		t.Fatalf("expected too large packet, got %v", err)
+	}
+}
+
+type gateWriter struct {
+	mutex   sync.Mutex
+	buf     bytes.Buffer
+	blocked chan struct{}
+	gate    chan struct{}
+}
+
+func (w *gateWriter) Write(p []byte) (int, error) {
+	select {
+	case w.blocked <- struct{}{}:
+	default:
+	}
+	<-w.gate
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	return w.buf.Write(p)
+}
+
+func (w *gateWriter) Len() int {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	return w.buf.Len()
+}
+
+func Test_FragmentInterleave(t *testing.T) {
+	w := &gateWriter{
+		blocked: make(chan struct{}, 1),
+		gate:    make(chan struct{}),
+	}
+	protocol := Fragment(Raw(), 1024, 1<<20, 1<<20)
+	protocol.Interleave(4)
+	codec, _ := protocol.NewCodec(struct {
+		io.Reader
+		io.Writer
+	}{nil, w})
+	defer codec.Close()
+
+	large := make([]byte, 100*1024)
+	rand.Read(large)
+	if err := codec.Send(large); err != nil {
+		t.Fatal(err)
+	}
+	<-w.blocked
+
+	// The first chunk is being written, the small packet waits for it only.
+	sent := make(chan error, 1)
+	go func() {
+		sent <- codec.Send([]byte("ping"))
+	}()
+	for atomic.LoadInt32(&codec.(*fragmentCodec).waiting) == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	close(w.gate)
+	if err := <-sent; err != nil {
+		t.Fatal(err)
+	}
+	for w.Len() < 4+4+100*(4+1024) {
+		time.Sleep(time.Millisecond)
+	}
+
+	reader, _ := protocol.NewCodec(struct {
+		io.Reader
+		io.Writer
+	}{bytes.NewReader(w.buf.Bytes()), nil})
+	defer reader.Close()
+	msg, err := reader.Receive()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(msg.(*InBuffer).Bytes()) != "ping" {
+		t.Fatalf("small packet not first: %d bytes", len(msg.(*InBuffer).Bytes()))
+	}
+	msg, err = reader.Receive()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(msg.(*InBuffer).Bytes(), large) {
+		t.Fatal("large packet not match")
+	}
+}
+
+func Test_FragmentRelease(t *testing.T) {
+	var stream bytes.Buffer
+
+	protocol := Fragment(Raw(), 1024, 1<<20, 1<<20)
+	protocol.Interleave(4)
+	codec, _ := protocol.NewCodec(&stream)
+	if err := codec.Send(make([]byte, 10*1024)); err != nil {
+		t.Fatal(err)
+	}
+
+	// The packets queued are written, and the write loop stops.
+	codec.(*fragmentCodec).Release()
+	select {
+	case <-codec.(*fragmentCodec).loopDone:
+	default:
+		t.Fatal("write loop not stopped")
+	}
+	if stream.Len() != 10*(4+1024) {
+		t.Fatalf("expected the packet written, got %d bytes", stream.Len())
+	}
+}
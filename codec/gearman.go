@@ -0,0 +1,169 @@
+package codec
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+
+	"github.com/funny/link"
+)
+
+var ErrBadGearmanMagic = errors.New("Bad Gearman Magic")
+
+const (
+	GearmanReq = "\x00REQ"
+	GearmanRes = "\x00RES"
+)
+
+const (
+	GearmanCanDo             = 1
+	GearmanCantDo            = 2
+	GearmanResetAbilities    = 3
+	GearmanPreSleep          = 4
+	GearmanNoop              = 6
+	GearmanSubmitJob         = 7
+	GearmanJobCreated        = 8
+	GearmanGrabJob           = 9
+	GearmanNoJob             = 10
+	GearmanJobAssign         = 11
+	GearmanWorkStatus        = 12
+	GearmanWorkComplete      = 13
+	GearmanWorkFail          = 14
+	GearmanGetStatus         = 15
+	GearmanEchoReq           = 16
+	GearmanEchoRes           = 17
+	GearmanSubmitJobBg       = 18
+	GearmanError             = 19
+	GearmanStatusRes         = 20
+	GearmanSubmitJobHigh     = 21
+	GearmanSetClientID       = 22
+	GearmanCanDoTimeout      = 23
+	GearmanAllYours          = 24
+	GearmanWorkException     = 25
+	GearmanOptionReq         = 26
+	GearmanOptionRes         = 27
+	GearmanWorkData          = 28
+	GearmanWorkWarning       = 29
+	GearmanGrabJobUniq       = 30
+	GearmanJobAssignUniq     = 31
+	GearmanSubmitJobHighBg   = 32
+	GearmanSubmitJobLow      = 33
+	GearmanSubmitJobLowBg    = 34
+	GearmanSubmitJobSched    = 35
+	GearmanSubmitJobEpoch    = 36
+	GearmanSubmitReduceJob   = 37
+	GearmanSubmitReduceJobBg = 38
+	GearmanGrabJobAll        = 39
+	GearmanJobAssignAll      = 40
+	GearmanGetStatusUnique   = 41
+	GearmanStatusResUnique   = 42
+)
+
+// The number of NUL separated arguments of each packet type,
+// the last argument is opaque data and may contain NUL bytes.
+var gearmanArgc = map[uint32]int{
+	GearmanCanDo: 1, GearmanCantDo: 1, GearmanSubmitJob: 3, GearmanJobCreated: 1,
+	GearmanJobAssign: 3, GearmanWorkStatus: 3, GearmanWorkComplete: 2, GearmanWorkFail: 1,
+	GearmanGetStatus: 1, GearmanEchoReq: 1, GearmanEchoRes: 1, GearmanSubmitJobBg: 3,
+	GearmanError: 2, GearmanStatusRes: 5, GearmanSubmitJobHigh: 3, GearmanSetClientID: 1,
+	GearmanCanDoTimeout: 2, GearmanWorkException: 2, GearmanOptionReq: 1, GearmanOptionRes: 1,
+	GearmanWorkData: 2, GearmanWorkWarning: 2, GearmanJobAssignUniq: 4, GearmanSubmitJobHighBg: 3,
+	GearmanSubmitJobLow: 3, GearmanSubmitJobLowBg: 3, GearmanSubmitJobSched: 8, GearmanSubmitJobEpoch: 4,
+	GearmanSubmitReduceJob: 4, GearmanSubmitReduceJobBg: 4, GearmanJobAssignAll: 5,
+	GearmanGetStatusUnique: 1, GearmanStatusResUnique: 6,
+}
+
+type GearmanPacket struct {
+	Magic string
+	Type  uint32
+	Args  [][]byte
+}
+
+type GearmanProtocol struct {
+	maxRecv int
+	maxSend int
+}
+
+func Gearman(maxRecv, maxSend int) *GearmanProtocol {
+	return &GearmanProtocol{
+		maxRecv: maxRecv,
+		maxSend: maxSend,
+	}
+}
+
+func (p *GearmanProtocol) NewCodec(rw io.ReadWriter) (link.Codec, error) {
+	return &gearmanCodec{
+		rw:              rw,
+		GearmanProtocol: p,
+	}, nil
+}
+
+type gearmanCodec struct {
+	rw      io.ReadWriter
+	head    [12]byte
+	sendBuf bytes.Buffer
+	*GearmanProtocol
+}
+
+func (c *gearmanCodec) Receive() (interface{}, error) {
+	if _, err := io.ReadFull(c.rw, c.head[:]); err != nil {
+		return nil, err
+	}
+	magic := string(c.head[:4])
+	if magic != GearmanReq && magic != GearmanRes {
+		return nil, ErrBadGearmanMagic
+	}
+	packet := &GearmanPacket{
+		Magic: magic,
+		Type:  binary.BigEndian.Uint32(c.head[4:]),
+	}
+	size := binary.BigEndian.Uint32(c.head[8:])
+	if size > uint32(c.maxRecv) {
+		return nil, ErrTooLargePacket
+	}
+	buff := make([]byte, size)
+	if _, err := io.ReadFull(c.rw, buff); err != nil {
+		return nil, err
+	}
+	if argc := gearmanArgc[packet.Type]; argc > 0 {
+		packet.Args = bytes.SplitN(buff, []byte{0}, argc)
+	} else if size > 0 {
+		packet.Args = [][]byte{buff}
+	}
+	return packet, nil
+}
+
+func (c *gearmanCodec) Send(msg interface{}) error {
+	packet := msg.(*GearmanPacket)
+	if packet.Magic != GearmanReq && packet.Magic != GearmanRes {
+		return ErrBadGearmanMagic
+	}
+
+	// A zero placeholder, c.head is used by Receive.
+	var head [12]byte
+	c.sendBuf.Reset()
+	c.sendBuf.Write(head[:])
+	for i, arg := range packet.Args {
+		if i > 0 {
+			c.sendBuf.WriteByte(0)
+		}
+		c.sendBuf.Write(arg)
+	}
+	buff := c.sendBuf.Bytes()
+	if len(buff)-12 > c.maxSend {
+		return ErrTooLargePacket
+	}
+	copy(buff, packet.Magic)
+	binary.BigEndian.PutUint32(buff[4:], packet.Type)
+	binary.BigEndian.PutUint32(buff[8:], uint32(len(buff)-12))
+	_, err := c.rw.Write(buff)
+	return err
+}
+
+func (c *gearmanCodec) Close() error {
+	if closer, ok := c.rw.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
@@ -0,0 +1,41 @@
+package codec
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func Test_Gearman(t *testing.T) {
+	var stream bytes.Buffer
+
+	codec, _ := Gearman(1024, 1024).NewCodec(&stream)
+
+	packets := []*GearmanPacket{
+		{Magic: GearmanReq, Type: GearmanCanDo, Args: [][]byte{[]byte("reverse")}},
+		{Magic: GearmanReq, Type: GearmanGrabJob},
+		{Magic: GearmanRes, Type: GearmanNoop},
+		{Magic: GearmanRes, Type: GearmanJobAssign, Args: [][]byte{[]byte("H:lap:1"), []byte("reverse"), []byte("data\x00with\x00nul")}},
+		{Magic: GearmanReq, Type: GearmanWorkComplete, Args: [][]byte{[]byte("H:lap:1"), []byte("")}},
+	}
+	for _, packet := range packets {
+		if err := codec.Send(packet); err != nil {
+			t.Fatal(err)
+		}
+		recv, err := codec.Receive()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(packet, recv) {
+			t.Fatalf("packet not match: %#v, %#v", packet, recv)
+		}
+	}
+
+	stream.WriteString("\x00XYZ\x00\x00\x00\x01\x00\x00\x00\x00")
+	if _, err := codec.Receive(); err != ErrBadGearmanMagic {
+		t.Fatalf("expected bad magic, got %v", err)
+	}
+	if err := codec.Send(&GearmanPacket{Type: GearmanNoop}); err != ErrBadGearmanMagic {
+		t.Fatalf("expected bad magic, got %v", err)
+	}
+}
@@ -0,0 +1,239 @@
+package codec
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+var ErrUnreadTooFar = errors.New("Unread Too Far")
+
+// DebugBuffers makes recycled InBuffers poisoned, any access after recycle panics.
+var DebugBuffers = false
+
+// InBuffer is a received packet body. It is recycled by the next Receive of
+// the codec that produced it, unless it is detached.
+type InBuffer struct {
+	data     []byte
+	pos      int
+	err      error
+	arena    *Arena
+	detached bool
+	recycled bool
+}
+
+func (b *InBuffer) check() {
+	if b.recycled {
+		panic("InBuffer: use after recycle")
+	}
+}
+
+func (b *InBuffer) Bytes() []byte {
+	b.check()
+	return b.data
+}
+
+// Arena returns the allocator that lives as long as the buffer, it is nil
+// for buffers that are not produced by a codec.
+func (b *InBuffer) Arena() *Arena {
+	b.check()
+	return b.arena
+}
+
+func (b *InBuffer) Len() int {
+	b.check()
+	return len(b.data)
+}
+
+// Remaining returns the unread part without copying.
+func (b *InBuffer) Remaining() []byte {
+	b.check()
+	return b.data[b.pos:]
+}
+
+func (b *InBuffer) Read(p []byte) (int, error) {
+	b.check()
+	if b.pos >= len(b.data) {
+		return 0, io.EOF
+	}
+	n := copy(p, b.data[b.pos:])
+	b.pos += n
+	return n, nil
+}
+
+func (b *InBuffer) ReadByte() (byte, error) {
+	b.check()
+	if b.pos >= len(b.data) {
+		return 0, io.EOF
+	}
+	b.pos++
+	return b.data[b.pos-1], nil
+}
+
+// Peek returns the next n bytes without moving the read position.
+func (b *InBuffer) Peek(n int) ([]byte, error) {
+	b.check()
+	if b.pos+n > len(b.data) {
+		return b.data[b.pos:], io.ErrUnexpectedEOF
+	}
+	return b.data[b.pos : b.pos+n], nil
+}
+
+// Unread moves the read position n bytes back.
+func (b *InBuffer) Unread(n int) error {
+	b.check()
+	if n > b.pos {
+		return ErrUnreadTooFar
+	}
+	b.pos -= n
+	return nil
+}
+
+// Clone returns a copy that is owned by the caller.
+func (b *InBuffer) Clone() *InBuffer {
+	b.check()
+	data := make([]byte, len(b.data))
+	copy(data, b.data)
+	return &InBuffer{data: data, pos: b.pos, detached: true}
+}
+
+// Detach takes the ownership of the buffer, the codec will not recycle it.
+func (b *InBuffer) Detach() *InBuffer {
+	b.check()
+	b.detached = true
+	return b
+}
+
+// recycle returns the backing slice for reuse and resets the arena,
+// or returns nil if it was detached.
+func (b *InBuffer) recycle() []byte {
+	if b.detached {
+		return nil
+	}
+	if b.arena != nil {
+		b.arena.Reset()
+	}
+	data := b.data[:0]
+	if DebugBuffers {
+		for i := range b.data {
+			b.data[i] = 0xdd
+		}
+		b.recycled = true
+	}
+	b.data = nil
+	return data
+}
+
+// Err returns the first error of the ReadUint8 to ReadVarint methods. After
+// an error they return zero values, so a body can be decoded field by field
+// and checked once at the end.
+func (b *InBuffer) Err() error {
+	return b.err
+}
+
+// next returns the next n bytes, or nil after an error.
+func (b *InBuffer) next(n int) []byte {
+	b.check()
+	if b.err != nil {
+		return nil
+	}
+	if n < 0 || b.pos+n > len(b.data) {
+		b.err = io.ErrUnexpectedEOF
+		return nil
+	}
+	b.pos += n
+	return b.data[b.pos-n : b.pos]
+}
+
+func (b *InBuffer) ReadUint8() uint8 {
+	if p := b.next(1); p != nil {
+		return p[0]
+	}
+	return 0
+}
+
+func (b *InBuffer) ReadUint16() uint16 {
+	if p := b.next(2); p != nil {
+		return binary.BigEndian.Uint16(p)
+	}
+	return 0
+}
+
+func (b *InBuffer) ReadUint16LE() uint16 {
+	if p := b.next(2); p != nil {
+		return binary.LittleEndian.Uint16(p)
+	}
+	return 0
+}
+
+func (b *InBuffer) ReadUint32() uint32 {
+	if p := b.next(4); p != nil {
+		return binary.BigEndian.Uint32(p)
+	}
+	return 0
+}
+
+func (b *InBuffer) ReadUint32LE() uint32 {
+	if p := b.next(4); p != nil {
+		return binary.LittleEndian.Uint32(p)
+	}
+	return 0
+}
+
+func (b *InBuffer) ReadUint64() uint64 {
+	if p := b.next(8); p != nil {
+		return binary.BigEndian.Uint64(p)
+	}
+	return 0
+}
+
+func (b *InBuffer) ReadUint64LE() uint64 {
+	if p := b.next(8); p != nil {
+		return binary.LittleEndian.Uint64(p)
+	}
+	return 0
+}
+
+// ReadBytes returns the next n bytes without copying, they are recycled with
+// the buffer.
+func (b *InBuffer) ReadBytes(n int) []byte {
+	return b.next(n)
+}
+
+func (b *InBuffer) ReadString(n int) string {
+	return string(b.next(n))
+}
+
+func (b *InBuffer) ReadUvarint() uint64 {
+	b.check()
+	if b.err != nil {
+		return 0
+	}
+	v, n := binary.Uvarint(b.data[b.pos:])
+	if n <= 0 {
+		b.err = ErrBadVarint
+		if n == 0 {
+			b.err = io.ErrUnexpectedEOF
+		}
+		return 0
+	}
+	b.pos += n
+	return v
+}
+
+func (b *InBuffer) ReadVarint() int64 {
+	b.check()
+	if b.err != nil {
+		return 0
+	}
+	v, n := binary.Varint(b.data[b.pos:])
+	if n <= 0 {
+		b.err = ErrBadVarint
+		if n == 0 {
+			b.err = io.ErrUnexpectedEOF
+		}
+		return 0
+	}
+	b.pos += n
+	return v
+}
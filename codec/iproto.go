@@ -0,0 +1,254 @@
+package codec
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"sort"
+
+	"github.com/funny/link"
+)
+
+var ErrBadMsgpack = errors.New("Bad Msgpack")
+
+const (
+	IProtoRequestType   = 0x00
+	IProtoSync          = 0x01
+	IProtoSchemaVersion = 0x05
+)
+
+const IProtoGreetingSize = 128
+
+type IProtoPacket struct {
+	Header map[uint64]uint64
+	Body   []byte // raw msgpack
+}
+
+type IProtoProtocol struct {
+	maxRecv      int
+	maxSend      int
+	greeting     []byte
+	readGreeting bool
+}
+
+func IProto(maxRecv, maxSend int) *IProtoProtocol {
+	return &IProtoProtocol{
+		maxRecv: maxRecv,
+		maxSend: maxSend,
+	}
+}
+
+// SetGreeting makes new codec write the greeting before any packet,
+// like a Tarantool instance does.
+func (p *IProtoProtocol) SetGreeting(greeting []byte) {
+	p.greeting = make([]byte, IProtoGreetingSize)
+	copy(p.greeting, greeting)
+}
+
+// SetReadGreeting makes new codec consume the greeting sent by a Tarantool instance.
+func (p *IProtoProtocol) SetReadGreeting(read bool) {
+	p.readGreeting = read
+}
+
+func (p *IProtoProtocol) NewCodec(rw io.ReadWriter) (link.Codec, error) {
+	codec := &IProtoCodec{
+		rw:             rw,
+		IProtoProtocol: p,
+	}
+	if p.greeting != nil {
+		if _, err := rw.Write(p.greeting); err != nil {
+			return nil, err
+		}
+	}
+	if p.readGreeting {
+		codec.greeting = make([]byte, IProtoGreetingSize)
+		if _, err := io.ReadFull(rw, codec.greeting); err != nil {
+			return nil, err
+		}
+	}
+	return codec, nil
+}
+
+type IProtoCodec struct {
+	rw       io.ReadWriter
+	greeting []byte
+	head     [9]byte
+	sendBuf  bytes.Buffer
+	*IProtoProtocol
+}
+
+func (c *IProtoCodec) Greeting() []byte {
+	return c.greeting
+}
+
+func (c *IProtoCodec) Receive() (interface{}, error) {
+	size, err := c.readSize()
+	if err != nil {
+		return nil, err
+	}
+	if size > uint64(c.maxRecv) {
+		return nil, ErrTooLargePacket
+	}
+	buff := make([]byte, size)
+	if _, err := io.ReadFull(c.rw, buff); err != nil {
+		return nil, err
+	}
+
+	n, count, err := msgpackMapLen(buff)
+	if err != nil {
+		return nil, err
+	}
+	packet := &IProtoPacket{
+		Header: make(map[uint64]uint64, count),
+	}
+	for i := 0; i < count; i++ {
+		key, m, err := msgpackUint(buff[n:])
+		if err != nil {
+			return nil, err
+		}
+		n += m
+		value, m, err := msgpackUint(buff[n:])
+		if err != nil {
+			return nil, err
+		}
+		n += m
+		packet.Header[key] = value
+	}
+	packet.Body = buff[n:]
+	return packet, nil
+}
+
+func (c *IProtoCodec) readSize() (uint64, error) {
+	if _, err := io.ReadFull(c.rw, c.head[:1]); err != nil {
+		return 0, err
+	}
+	n, ok := msgpackUintSize(c.head[0])
+	if !ok {
+		return 0, ErrBadMsgpack
+	}
+	if _, err := io.ReadFull(c.rw, c.head[1:n]); err != nil {
+		return 0, err
+	}
+	size, _, err := msgpackUint(c.head[:n])
+	return size, err
+}
+
+func (c *IProtoCodec) Send(msg interface{}) error {
+	packet := msg.(*IProtoPacket)
+
+	keys := make([]uint64, 0, len(packet.Header))
+	for key := range packet.Header {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i] < keys[j] })
+
+	c.sendBuf.Reset()
+	c.sendBuf.Write([]byte{0xce, 0, 0, 0, 0})
+	msgpackPutMapLen(&c.sendBuf, len(keys))
+	for _, key := range keys {
+		msgpackPutUint(&c.sendBuf, key)
+		msgpackPutUint(&c.sendBuf, packet.Header[key])
+	}
+	c.sendBuf.Write(packet.Body)
+
+	buff := c.sendBuf.Bytes()
+	if len(buff)-5 > c.maxSend {
+		return ErrTooLargePacket
+	}
+	binary.BigEndian.PutUint32(buff[1:], uint32(len(buff)-5))
+	_, err := c.rw.Write(buff)
+	return err
+}
+
+func (c *IProtoCodec) Close() error {
+	if closer, ok := c.rw.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+func msgpackUintSize(b byte) (int, bool) {
+	switch b {
+	case 0xcc:
+		return 2, true
+	case 0xcd:
+		return 3, true
+	case 0xce:
+		return 5, true
+	case 0xcf:
+		return 9, true
+	}
+	return 1, b <= 0x7f
+}
+
+func msgpackUint(b []byte) (uint64, int, error) {
+	if len(b) == 0 {
+		return 0, 0, ErrBadMsgpack
+	}
+	n, ok := msgpackUintSize(b[0])
+	if !ok || len(b) < n {
+		return 0, 0, ErrBadMsgpack
+	}
+	if n == 1 {
+		return uint64(b[0]), 1, nil
+	}
+	var v uint64
+	for _, x := range b[1:n] {
+		v = v<<8 | uint64(x)
+	}
+	return v, n, nil
+}
+
+func msgpackMapLen(b []byte) (int, int, error) {
+	if len(b) == 0 {
+		return 0, 0, ErrBadMsgpack
+	}
+	switch {
+	case b[0]&0xf0 == 0x80:
+		return 1, int(b[0] & 0x0f), nil
+	case b[0] == 0xde && len(b) >= 3:
+		return 3, int(binary.BigEndian.Uint16(b[1:])), nil
+	case b[0] == 0xdf && len(b) >= 5:
+		return 5, int(binary.BigEndian.Uint32(b[1:])), nil
+	}
+	return 0, 0, ErrBadMsgpack
+}
+
+func msgpackPutUint(w *bytes.Buffer, v uint64) {
+	var b [9]byte
+	switch {
+	case v <= 0x7f:
+		w.WriteByte(byte(v))
+	case v <= 0xff:
+		w.Write([]byte{0xcc, byte(v)})
+	case v <= 0xffff:
+		b[0] = 0xcd
+		binary.BigEndian.PutUint16(b[1:], uint16(v))
+		w.Write(b[:3])
+	case v <= 0xffffffff:
+		b[0] = 0xce
+		binary.BigEndian.PutUint32(b[1:], uint32(v))
+		w.Write(b[:5])
+	default:
+		b[0] = 0xcf
+		binary.BigEndian.PutUint64(b[1:], v)
+		w.Write(b[:9])
+	}
+}
+
+func msgpackPutMapLen(w *bytes.Buffer, n int) {
+	var b [5]byte
+	switch {
+	case n <= 0x0f:
+		w.WriteByte(0x80 | byte(n))
+	case n <= 0xffff:
+		b[0] = 0xde
+		binary.BigEndian.PutUint16(b[1:], uint16(n))
+		w.Write(b[:3])
+	default:
+		b[0] = 0xdf
+		binary.BigEndian.PutUint32(b[1:], uint32(n))
+		w.Write(b[:5])
+	}
+}
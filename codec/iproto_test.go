@@ -0,0 +1,64 @@
+package codec
+
+import (
+	"bytes"
+	"testing"
+)
+
+func Test_IProto(t *testing.T) {
+	var stream bytes.Buffer
+
+	server := IProto(1024, 1024)
+	server.SetGreeting([]byte("Tarantool 2.10.0 (Binary) 00000000-0000-0000-0000-000000000000"))
+	client := IProto(1024, 1024)
+	client.SetReadGreeting(true)
+
+	w, err := server.NewCodec(&stream)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := client.NewCodec(&stream)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasPrefix(r.(*IProtoCodec).Greeting(), []byte("Tarantool")) {
+		t.Fatalf("greeting not match: %q", r.(*IProtoCodec).Greeting())
+	}
+
+	sendMsg := &IProtoPacket{
+		Header: map[uint64]uint64{
+			IProtoRequestType:   0x40,
+			IProtoSync:          0x1234567890,
+			IProtoSchemaVersion: 300,
+		},
+		Body: []byte{0x81, 0x10, 0xcd, 0x01, 0x00},
+	}
+	if err := w.Send(sendMsg); err != nil {
+		t.Fatal(err)
+	}
+	recvMsg, err := r.Receive()
+	if err != nil {
+		t.Fatal(err)
+	}
+	packet := recvMsg.(*IProtoPacket)
+	if len(packet.Header) != len(sendMsg.Header) {
+		t.Fatalf("header not match: %v", packet.Header)
+	}
+	for key, value := range sendMsg.Header {
+		if packet.Header[key] != value {
+			t.Fatalf("header not match: %v", packet.Header)
+		}
+	}
+	if !bytes.Equal(packet.Body, sendMsg.Body) {
+		t.Fatalf("body not match: %v", packet.Body)
+	}
+
+	if err := w.Send(&IProtoPacket{Body: make([]byte, 2048)}); err != ErrTooLargePacket {
+		t.Fatalf("expected too large packet, got %v", err)
+	}
+	w, _ = IProto(4096, 4096).NewCodec(&stream)
+	w.Send(&IProtoPacket{Body: make([]byte, 2048)})
+	if _, err := r.Receive(); err != ErrTooLargePacket {
+		t.Fatalf("expected too large packet, got %v", err)
+	}
+}
@@ -0,0 +1,101 @@
+package codec
+
+import (
+	"bytes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"errors"
+	"hash"
+	"io"
+
+	"github.com/funny/link"
+)
+
+var ErrMACMismatch = errors.New("MAC Mismatch")
+
+type macProtocol struct {
+	base    link.Protocol
+	newHash func() hash.Hash
+	key     []byte
+}
+
+// MAC appends a HMAC tag after the body encoded by base and verifies it on
+// receive. Like Checksum it must be placed under a framing protocol.
+func MAC(base link.Protocol, newHash func() hash.Hash, key []byte) link.Protocol {
+	if newHash == nil || len(key) == 0 {
+		panic("MAC: hash and key are required")
+	}
+	return &macProtocol{
+		base:    base,
+		newHash: newHash,
+		key:     key,
+	}
+}
+
+// EncryptThenMAC encrypts the packets encoded by base and then authenticates
+// the ciphertext, the MAC is verified before anything is decrypted.
+func EncryptThenMAC(base link.Protocol, block cipher.Block, newHash func() hash.Hash, key []byte) link.Protocol {
+	if block == nil {
+		panic("EncryptThenMAC: block cipher is required")
+	}
+	return MAC(StreamCipher(base, block), newHash, key)
+}
+
+func (p *macProtocol) NewCodec(rw io.ReadWriter) (cc link.Codec, err error) {
+	codec := &macCodec{
+		rw:       rw,
+		sendHash: hmac.New(p.newHash, p.key),
+		recvHash: hmac.New(p.newHash, p.key),
+	}
+	codec.base, err = p.base.NewCodec(&codec.fixlenReadWriter)
+	if err != nil {
+		return
+	}
+	cc = codec
+	return
+}
+
+type macCodec struct {
+	base     link.Codec
+	rw       io.ReadWriter
+	recvData bytes.Buffer
+	sendHash hash.Hash
+	recvHash hash.Hash
+	fixlenReadWriter
+}
+
+func (c *macCodec) Receive() (interface{}, error) {
+	c.recvData.Reset()
+	if _, err := c.recvData.ReadFrom(c.rw); err != nil {
+		return nil, err
+	}
+	data := c.recvData.Bytes()
+	n := c.recvHash.Size()
+	if len(data) < n {
+		return nil, ErrMACMismatch
+	}
+	body := data[:len(data)-n]
+	c.recvHash.Reset()
+	c.recvHash.Write(body)
+	if !hmac.Equal(c.recvHash.Sum(nil), data[len(body):]) {
+		return nil, ErrMACMismatch
+	}
+	c.recvBuf.Reset(body)
+	return c.base.Receive()
+}
+
+func (c *macCodec) Send(msg interface{}) error {
+	c.sendBuf.Reset()
+	if err := c.base.Send(msg); err != nil {
+		return err
+	}
+	c.sendHash.Reset()
+	c.sendHash.Write(c.sendBuf.Bytes())
+	c.sendBuf.Write(c.sendHash.Sum(nil))
+	_, err := c.rw.Write(c.sendBuf.Bytes())
+	return err
+}
+
+func (c *macCodec) Close() error {
+	return c.base.Close()
+}
@@ -0,0 +1,48 @@
+package codec
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/sha256"
+	"encoding/binary"
+	"hash/crc32"
+	"testing"
+)
+
+func Test_EncryptThenMAC(t *testing.T) {
+	block, _ := aes.NewCipher(make([]byte, 16))
+	key := []byte("mac key")
+
+	JsonTest(t, FixLen(EncryptThenMAC(JsonTestProtocol(), block, sha256.New, key), 2, binary.BigEndian, 1024, 1024))
+
+	var stream bytes.Buffer
+	codec, _ := FixLen(EncryptThenMAC(BytesTestProtocol(), block, sha256.New, key), 2, binary.BigEndian, 1024, 1024).NewCodec(&stream)
+	codec.Send([]byte("hello"))
+	if bytes.Contains(stream.Bytes(), []byte("hello")) {
+		t.Fatal("packet not encrypted")
+	}
+	stream.Bytes()[20] ^= 0x01
+	if _, err := codec.Receive(); err != ErrMACMismatch {
+		t.Fatalf("expected MAC mismatch, got %v", err)
+	}
+}
+
+func Test_MACThenEncrypt(t *testing.T) {
+	block, _ := aes.NewCipher(make([]byte, 16))
+	defer func() {
+		if recover() == nil {
+			t.Fatal("MAC-then-encrypt not rejected")
+		}
+	}()
+	StreamCipher(MAC(JsonTestProtocol(), sha256.New, []byte("mac key")), block)
+}
+
+func Test_NestedMACThenEncrypt(t *testing.T) {
+	block, _ := aes.NewCipher(make([]byte, 16))
+	defer func() {
+		if recover() == nil {
+			t.Fatal("nested MAC-then-encrypt not rejected")
+		}
+	}()
+	StreamCipher(Checksum(MAC(JsonTestProtocol(), sha256.New, []byte("mac key")), crc32.NewIEEE), block)
+}
@@ -0,0 +1,126 @@
+package codec
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+	"errors"
+	"io"
+
+	"github.com/funny/link"
+)
+
+var ErrNotProtoMessage = errors.New("Not Proto Message")
+
+// Marshaler encodes typed messages into packet bodies and decodes them back.
+type Marshaler interface {
+	Marshal(buf *bytes.Buffer, msg interface{}) error
+	Unmarshal(data []byte, msg interface{}) error
+}
+
+var (
+	JsonMarshaler  Marshaler = jsonMarshaler{}
+	GobMarshaler   Marshaler = gobMarshaler{}
+	ProtoMarshaler Marshaler = protoMarshaler{}
+)
+
+type jsonMarshaler struct{}
+
+func (jsonMarshaler) Marshal(buf *bytes.Buffer, msg interface{}) error {
+	return json.NewEncoder(buf).Encode(msg)
+}
+
+func (jsonMarshaler) Unmarshal(data []byte, msg interface{}) error {
+	return json.Unmarshal(data, msg)
+}
+
+// gobMarshaler encodes each message by itself, with its type description,
+// since packets may be dropped or reordered by the layers above.
+type gobMarshaler struct{}
+
+func (gobMarshaler) Marshal(buf *bytes.Buffer, msg interface{}) error {
+	return gob.NewEncoder(buf).Encode(msg)
+}
+
+func (gobMarshaler) Unmarshal(data []byte, msg interface{}) error {
+	return gob.NewDecoder(bytes.NewReader(data)).Decode(msg)
+}
+
+// protoMarshaler uses the Marshal and Unmarshal methods of messages, like the
+// ones generated by gogo/protobuf, so no protobuf package is imported here.
+type protoMarshaler struct{}
+
+func (protoMarshaler) Marshal(buf *bytes.Buffer, msg interface{}) error {
+	m, ok := msg.(interface{ Marshal() ([]byte, error) })
+	if !ok {
+		return ErrNotProtoMessage
+	}
+	data, err := m.Marshal()
+	if err != nil {
+		return err
+	}
+	buf.Write(data)
+	return nil
+}
+
+func (protoMarshaler) Unmarshal(data []byte, msg interface{}) error {
+	m, ok := msg.(interface{ Unmarshal([]byte) error })
+	if !ok {
+		return ErrNotProtoMessage
+	}
+	return m.Unmarshal(data)
+}
+
+type marshalProtocol struct {
+	marshaler Marshaler
+}
+
+// Marshal sends messages encoded by m, and receives packets as *Encoded to be
+// decoded into a message of the receiver's choice, e.g. by
+// Session.ReceiveInto. It must be placed under a framing protocol.
+func Marshal(m Marshaler) link.Protocol {
+	return marshalProtocol{m}
+}
+
+func (p marshalProtocol) NewCodec(rw io.ReadWriter) (link.Codec, error) {
+	return &marshalCodec{rw: rw, marshaler: p.marshaler}, nil
+}
+
+// Encoded is a received packet body of Marshal, valid until the next Receive.
+type Encoded struct {
+	Data      []byte
+	marshaler Marshaler
+}
+
+// Decode unmarshals the body into msg.
+func (e *Encoded) Decode(msg interface{}) error {
+	return e.marshaler.Unmarshal(e.Data, msg)
+}
+
+type marshalCodec struct {
+	rw        io.ReadWriter
+	marshaler Marshaler
+	recvData  bytes.Buffer
+	sendBuf   bytes.Buffer
+}
+
+func (c *marshalCodec) Receive() (interface{}, error) {
+	c.recvData.Reset()
+	if _, err := c.recvData.ReadFrom(c.rw); err != nil {
+		return nil, err
+	}
+	return &Encoded{c.recvData.Bytes(), c.marshaler}, nil
+}
+
+func (c *marshalCodec) Send(msg interface{}) error {
+	c.sendBuf.Reset()
+	if err := c.marshaler.Marshal(&c.sendBuf, msg); err != nil {
+		return err
+	}
+	_, err := c.rw.Write(c.sendBuf.Bytes())
+	return err
+}
+
+func (c *marshalCodec) Close() error {
+	return nil
+}
@@ -0,0 +1,76 @@
+package codec
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"net"
+	"testing"
+
+	"github.com/funny/link"
+)
+
+// protoTestMessage has the methods of a gogo/protobuf generated message.
+type protoTestMessage struct {
+	Value string
+}
+
+func (m *protoTestMessage) Marshal() ([]byte, error) {
+	return []byte(m.Value), nil
+}
+
+func (m *protoTestMessage) Unmarshal(data []byte) error {
+	if len(data) == 0 {
+		return errors.New("empty")
+	}
+	m.Value = string(data)
+	return nil
+}
+
+func Test_Marshal(t *testing.T) {
+	for _, m := range []Marshaler{JsonMarshaler, GobMarshaler} {
+		var stream bytes.Buffer
+
+		codec, _ := FixLen(Marshal(m), 2, binary.BigEndian, 1024, 1024).NewCodec(&stream)
+		codec.Send(&MyMessage1{"abc", 123})
+		codec.Send(MyMessage2{456, "def"})
+
+		var msg1 MyMessage1
+		var msg2 MyMessage2
+		recv1, _ := codec.Receive()
+		if err := recv1.(*Encoded).Decode(&msg1); err != nil {
+			t.Fatal(err)
+		}
+		recv2, _ := codec.Receive()
+		if err := recv2.(*Encoded).Decode(&msg2); err != nil {
+			t.Fatal(err)
+		}
+		if msg1 != (MyMessage1{"abc", 123}) || msg2 != (MyMessage2{456, "def"}) {
+			t.Fatalf("message not match: %v, %v", msg1, msg2)
+		}
+	}
+
+	var stream bytes.Buffer
+	codec, _ := FixLen(Marshal(ProtoMarshaler), 2, binary.BigEndian, 1024, 1024).NewCodec(&stream)
+	if err := codec.Send(MyMessage1{}); err != ErrNotProtoMessage {
+		t.Fatalf("expected not proto message, got %v", err)
+	}
+}
+
+func Test_ReceiveInto(t *testing.T) {
+	protocol := FixLen(Marshal(ProtoMarshaler), 2, binary.BigEndian, 1024, 1024)
+	conn1, conn2 := net.Pipe()
+	codec1, _ := protocol.NewCodec(conn1)
+	codec2, _ := protocol.NewCodec(conn2)
+	client := link.NewSession(codec1, 0)
+	server := link.NewSession(codec2, 0)
+	defer client.Close()
+	defer server.Close()
+
+	go client.Send(&protoTestMessage{"hello"})
+
+	var msg protoTestMessage
+	if err := server.ReceiveInto(&msg); err != nil || msg.Value != "hello" {
+		t.Fatalf("message not match: %v, %v", msg, err)
+	}
+}
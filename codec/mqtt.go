@@ -0,0 +1,167 @@
+package codec
+
+import (
+	"errors"
+	"io"
+
+	"github.com/funny/link"
+)
+
+var ErrNotMQTTPacket = errors.New("Not MQTT Packet")
+
+// mqttMaxLength is the largest remaining length of 4 bytes.
+const mqttMaxLength = 268435455
+
+// MQTTPacket is a body of the base protocol with the control packet type and
+// the flags of the MQTT fixed header, it is link.TypedMsg by type.
+type MQTTPacket struct {
+	Type  byte // 1 to 15, e.g. 3 for PUBLISH
+	Flags byte // the low 4 bits of the fixed header
+	Body  interface{}
+}
+
+func (msg *MQTTPacket) MsgType() uint16 {
+	return uint16(msg.Type)
+}
+
+func (msg *MQTTPacket) MsgBody() interface{} {
+	return msg.Body
+}
+
+type MQTTProtocol struct {
+	base     link.Protocol
+	maxRecv  int
+	maxSend  int
+	oversize OversizePolicy
+}
+
+// MQTT frames packets like the MQTT fixed header, a byte of type and flags
+// followed by the remaining length in 1 to 4 bytes of 7 bits. Messages are
+// received as *MQTTPacket and sent as MQTTPacket or *MQTTPacket. The limits
+// are clamped to the 256MB of the encoding.
+func MQTT(base link.Protocol, maxRecv, maxSend int) *MQTTProtocol {
+	if maxRecv > mqttMaxLength {
+		maxRecv = mqttMaxLength
+	}
+	if maxSend > mqttMaxLength {
+		maxSend = mqttMaxLength
+	}
+	return &MQTTProtocol{
+		base:    base,
+		maxRecv: maxRecv,
+		maxSend: maxSend,
+	}
+}
+
+// SetOversize sets what the codecs do with a received packet over the limit.
+func (p *MQTTProtocol) SetOversize(policy OversizePolicy) {
+	p.oversize = policy
+}
+
+func (p *MQTTProtocol) NewCodec(rw io.ReadWriter) (cc link.Codec, err error) {
+	codec := &mqttCodec{
+		rw:           rw,
+		MQTTProtocol: p,
+	}
+	codec.base, err = p.base.NewCodec(&codec.fixlenReadWriter)
+	if err != nil {
+		return
+	}
+	cc = codec
+	return
+}
+
+type mqttCodec struct {
+	base    link.Codec
+	head    [5]byte
+	bodyBuf []byte
+	rw      io.ReadWriter
+	*MQTTProtocol
+	fixlenReadWriter
+}
+
+func (c *mqttCodec) Receive() (interface{}, error) {
+	if _, err := io.ReadFull(c.rw, c.head[:2]); err != nil {
+		return nil, err
+	}
+	size := 0
+	for i := 1; ; i++ {
+		b := c.head[i]
+		size |= int(b&0x7f) << (7 * uint(i-1))
+		if b&0x80 == 0 {
+			break
+		}
+		if i == 4 {
+			return nil, ErrBadVarint
+		}
+		if _, err := io.ReadFull(c.rw, c.head[i+1:i+2]); err != nil {
+			return nil, err
+		}
+	}
+	if size > c.maxRecv {
+		return nil, oversize(c.rw, int64(size), c.maxRecv, c.oversize)
+	}
+	if cap(c.bodyBuf) < size {
+		c.bodyBuf = make([]byte, size, size+128)
+	}
+	buff := c.bodyBuf[:size]
+	if _, err := io.ReadFull(c.rw, buff); err != nil {
+		return nil, err
+	}
+	c.recvBuf.Reset(buff)
+	body, err := c.base.Receive()
+	if err != nil {
+		return nil, err
+	}
+	return &MQTTPacket{c.head[0] >> 4, c.head[0] & 0x0f, body}, nil
+}
+
+func (c *mqttCodec) Send(msg interface{}) error {
+	var packet *MQTTPacket
+	switch m := msg.(type) {
+	case MQTTPacket:
+		packet = &m
+	case *MQTTPacket:
+		packet = m
+	default:
+		return ErrNotMQTTPacket
+	}
+	if packet.Type > 15 || packet.Flags > 15 {
+		return ErrNotMQTTPacket
+	}
+	// Room for the longest head, the body is moved after the actual one.
+	var head [5]byte
+	c.sendBuf.Reset()
+	c.sendBuf.Write(head[:])
+	if err := c.base.Send(packet.Body); err != nil {
+		return err
+	}
+	buff := c.sendBuf.Bytes()
+	size := len(buff) - len(head)
+	if size > c.maxSend {
+		return ErrTooLargePacket
+	}
+	n := 1
+	for length := size; ; n++ {
+		b := byte(length & 0x7f)
+		if length >>= 7; length > 0 {
+			b |= 0x80
+		}
+		head[n] = b
+		if length == 0 {
+			break
+		}
+	}
+	head[0] = packet.Type<<4 | packet.Flags
+	buff = buff[len(head)-n-1:]
+	copy(buff, head[:n+1])
+	_, err := c.rw.Write(buff)
+	return err
+}
+
+func (c *mqttCodec) Close() error {
+	if closer, ok := c.rw.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
@@ -0,0 +1,115 @@
+package codec
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"testing"
+
+	"github.com/funny/link"
+)
+
+func Test_MQTT(t *testing.T) {
+	JsonTest(t, MQTTJsonTestProtocol{})
+
+	for _, size := range []int{0, 127, 128, 16383, 16384, 2097152} {
+		var stream bytes.Buffer
+		codec, _ := MQTT(Raw(), 4<<20, 4<<20).NewCodec(&stream)
+		if err := codec.Send(MQTTPacket{3, 0x0b, make([]byte, size)}); err != nil {
+			t.Fatalf("%d: %v", size, err)
+		}
+		headSize := stream.Len() - size
+		if expected := map[int]int{0: 2, 127: 2, 128: 3, 16383: 3, 16384: 4, 2097152: 5}[size]; headSize != expected {
+			t.Fatalf("%d: head of %d bytes, expected %d", size, headSize, expected)
+		}
+		if stream.Bytes()[0] != 0x3b {
+			t.Fatalf("%d: bad fixed header: %x", size, stream.Bytes()[0])
+		}
+		recv, err := codec.Receive()
+		if err != nil {
+			t.Fatalf("%d: %v", size, err)
+		}
+		packet := recv.(*MQTTPacket)
+		if packet.Type != 3 || packet.Flags != 0x0b || packet.Body.(*InBuffer).Len() != size {
+			t.Fatalf("%d: packet not match: %v, %v", size, packet.Type, packet.Flags)
+		}
+	}
+}
+
+type MQTTJsonTestProtocol struct{}
+
+func (MQTTJsonTestProtocol) NewCodec(rw io.ReadWriter) (link.Codec, error) {
+	base, err := MQTT(JsonTestProtocol(), 1024, 1024).NewCodec(rw)
+	return &mqttUnwrapCodec{base}, err
+}
+
+// mqttUnwrapCodec sends the messages of JsonTest as PUBLISH packets.
+type mqttUnwrapCodec struct {
+	link.Codec
+}
+
+func (c *mqttUnwrapCodec) Receive() (interface{}, error) {
+	msg, err := c.Codec.Receive()
+	if err != nil {
+		return nil, err
+	}
+	return msg.(*MQTTPacket).Body, nil
+}
+
+func (c *mqttUnwrapCodec) Send(msg interface{}) error {
+	return c.Codec.Send(&MQTTPacket{Type: 3, Body: msg})
+}
+
+func Test_MQTTError(t *testing.T) {
+	codec, _ := MQTT(Raw(), 1024, 16).NewCodec(bytes.NewBuffer([]byte{0x30, 0x80, 0x80, 0x80, 0x80, 0x01}))
+	if _, err := codec.Receive(); err != ErrBadVarint {
+		t.Fatalf("expected bad varint, got %v", err)
+	}
+	codec, _ = MQTT(Raw(), 1024, 16).NewCodec(bytes.NewBuffer([]byte{0x30, 0x81, 0x08}))
+	if _, err := codec.Receive(); !errors.Is(err, ErrTooLargePacket) {
+		t.Fatalf("expected too large packet, got %v", err)
+	}
+	codec, _ = MQTT(Raw(), 1024, 16).NewCodec(bytes.NewBuffer([]byte{0x30, 0x85}))
+	if _, err := codec.Receive(); err != io.ErrUnexpectedEOF && err != io.EOF {
+		t.Fatalf("expected EOF in the remaining length, got %v", err)
+	}
+	codec, _ = MQTT(Raw(), 1024, 16).NewCodec(bytes.NewBuffer([]byte{0x30, 0x05, 'a', 'b'}))
+	if _, err := codec.Receive(); err != io.ErrUnexpectedEOF {
+		t.Fatalf("expected unexpected EOF, got %v", err)
+	}
+
+	var stream bytes.Buffer
+	codec, _ = MQTT(Raw(), 1024, 16).NewCodec(&stream)
+	if err := codec.Send([]byte("hello")); err != ErrNotMQTTPacket {
+		t.Fatalf("expected not MQTT packet, got %v", err)
+	}
+	if err := codec.Send(MQTTPacket{16, 0, []byte{}}); err != ErrNotMQTTPacket {
+		t.Fatalf("expected not MQTT packet, got %v", err)
+	}
+	if err := codec.Send(MQTTPacket{3, 0, make([]byte, 17)}); err != ErrTooLargePacket {
+		t.Fatalf("expected too large packet, got %v", err)
+	}
+}
+
+func Test_MQTTPartialRead(t *testing.T) {
+	conn1, conn2 := net.Pipe()
+	defer conn1.Close()
+	codec, _ := MQTT(Raw(), 1024, 1024).NewCodec(conn2)
+
+	// The head and the body arrive a byte at a time.
+	go func() {
+		for _, b := range []byte{0x32, 0x82, 0x01, 'x'} {
+			conn1.Write([]byte{b})
+		}
+		conn1.Write(make([]byte, 129))
+	}()
+	recv, err := codec.Receive()
+	if err != nil {
+		t.Fatal(err)
+	}
+	packet := recv.(*MQTTPacket)
+	if packet.Type != 3 || packet.Flags != 2 || packet.Body.(*InBuffer).Len() != 130 || packet.Body.(*InBuffer).Bytes()[0] != 'x' {
+		t.Fatalf("packet not match: %v, %v, %d", packet.Type, packet.Flags, packet.Body.(*InBuffer).Len())
+	}
+}
@@ -0,0 +1,137 @@
+package codec
+
+import (
+	"errors"
+	"io"
+	"sync/atomic"
+
+	"github.com/funny/link"
+)
+
+var ErrSequenceMismatch = errors.New("Sequence Mismatch")
+
+const mysqlMaxPayload = 1<<24 - 1
+
+type MySQLProtocol struct {
+	base    link.Protocol
+	maxRecv int
+	maxSend int
+}
+
+// MySQL frames packets with a 3 byte little-endian length and a 1 byte
+// sequence ID. Payloads of 16MB-1 or more are split into continuation packets.
+func MySQL(base link.Protocol, maxRecv, maxSend int) *MySQLProtocol {
+	return &MySQLProtocol{
+		base:    base,
+		maxRecv: maxRecv,
+		maxSend: maxSend,
+	}
+}
+
+func (p *MySQLProtocol) NewCodec(rw io.ReadWriter) (cc link.Codec, err error) {
+	codec := &MySQLCodec{
+		rw:            rw,
+		MySQLProtocol: p,
+	}
+	codec.base, err = p.base.NewCodec(&codec.fixlenReadWriter)
+	if err != nil {
+		return
+	}
+	cc = codec
+	return
+}
+
+type MySQLCodec struct {
+	base    link.Codec
+	seq     uint32
+	head    [4]byte
+	bodyBuf []byte
+	rw      io.ReadWriter
+	*MySQLProtocol
+	fixlenReadWriter
+}
+
+// Sequence returns the sequence ID expected by the next packet.
+func (c *MySQLCodec) Sequence() byte {
+	return byte(atomic.LoadUint32(&c.seq))
+}
+
+// ResetSequence must be called at the start of each new command.
+func (c *MySQLCodec) ResetSequence() {
+	atomic.StoreUint32(&c.seq, 0)
+}
+
+func (c *MySQLCodec) nextSequence() byte {
+	return byte(atomic.AddUint32(&c.seq, 1) - 1)
+}
+
+func (c *MySQLCodec) Receive() (interface{}, error) {
+	c.bodyBuf = c.bodyBuf[:0]
+	for {
+		if _, err := io.ReadFull(c.rw, c.head[:]); err != nil {
+			return nil, err
+		}
+		if c.head[3] != c.nextSequence() {
+			return nil, ErrSequenceMismatch
+		}
+		size := int(c.head[0]) | int(c.head[1])<<8 | int(c.head[2])<<16
+		n := len(c.bodyBuf)
+		if n+size > c.maxRecv {
+			return nil, ErrTooLargePacket
+		}
+		if cap(c.bodyBuf) < n+size {
+			buff := make([]byte, n, n+size+128)
+			copy(buff, c.bodyBuf)
+			c.bodyBuf = buff
+		}
+		c.bodyBuf = c.bodyBuf[:n+size]
+		if _, err := io.ReadFull(c.rw, c.bodyBuf[n:]); err != nil {
+			return nil, err
+		}
+		if size < mysqlMaxPayload {
+			break
+		}
+	}
+	c.recvBuf.Reset(c.bodyBuf)
+	return c.base.Receive()
+}
+
+func (c *MySQLCodec) Send(msg interface{}) error {
+	// A zero placeholder, c.head is used by Receive.
+	var head [4]byte
+	c.sendBuf.Reset()
+	c.sendBuf.Write(head[:])
+	if err := c.base.Send(msg); err != nil {
+		return err
+	}
+	buff := c.sendBuf.Bytes()
+	if len(buff)-4 > c.maxSend {
+		return ErrTooLargePacket
+	}
+	// Each continuation header overwrites the tail of the previous chunk,
+	// which has already been written out.
+	for off := 4; ; off += mysqlMaxPayload {
+		size := len(buff) - off
+		if size > mysqlMaxPayload {
+			size = mysqlMaxPayload
+		}
+		head := buff[off-4 : off]
+		head[0] = byte(size)
+		head[1] = byte(size >> 8)
+		head[2] = byte(size >> 16)
+		head[3] = c.nextSequence()
+		if _, err := c.rw.Write(buff[off-4 : off+size]); err != nil {
+			return err
+		}
+		if size < mysqlMaxPayload {
+			return nil
+		}
+	}
+}
+
+func (c *MySQLCodec) Close() error {
+	if closer, ok := c.rw.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
@@ -0,0 +1,88 @@
+package codec
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"testing"
+
+	"github.com/funny/link"
+)
+
+func BytesTestProtocol() link.Protocol {
+	return link.ProtocolFunc(func(rw io.ReadWriter) (link.Codec, error) {
+		return &bytesTestCodec{rw}, nil
+	})
+}
+
+type bytesTestCodec struct {
+	rw io.ReadWriter
+}
+
+func (c *bytesTestCodec) Receive() (interface{}, error) {
+	return ioutil.ReadAll(c.rw)
+}
+
+func (c *bytesTestCodec) Send(msg interface{}) error {
+	_, err := c.rw.Write(msg.([]byte))
+	return err
+}
+
+func (c *bytesTestCodec) Close() error {
+	return nil
+}
+
+func Test_MySQL(t *testing.T) {
+	var stream bytes.Buffer
+
+	protocol := MySQL(BytesTestProtocol(), 64*1024*1024, 64*1024*1024)
+	w, _ := protocol.NewCodec(&stream)
+	r, _ := protocol.NewCodec(&stream)
+
+	sizes := []int{0, 10, mysqlMaxPayload - 1, mysqlMaxPayload, mysqlMaxPayload + 10, 2 * mysqlMaxPayload}
+	for _, size := range sizes {
+		msg := make([]byte, size)
+		rand.Read(msg)
+
+		if err := w.Send(msg); err != nil {
+			t.Fatal(err)
+		}
+		recv, err := r.Receive()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(msg, recv.([]byte)) {
+			t.Fatalf("message not match, size = %d", size)
+		}
+		if w.(*MySQLCodec).Sequence() != r.(*MySQLCodec).Sequence() {
+			t.Fatal("sequence not match")
+		}
+	}
+
+	w.Send([]byte("abc"))
+	r.(*MySQLCodec).ResetSequence()
+	if _, err := r.Receive(); err != ErrSequenceMismatch {
+		t.Fatalf("expected sequence mismatch, got %v", err)
+	}
+}
+
+func Test_MySQLJson(t *testing.T) {
+	var stream bytes.Buffer
+
+	protocol := MySQL(JsonTestProtocol(), 1024, 1024)
+	w, _ := protocol.NewCodec(&stream)
+	r, _ := protocol.NewCodec(&stream)
+
+	sendMsg := MyMessage1{"abc", 123}
+	if err := w.Send(&sendMsg); err != nil {
+		t.Fatal(err)
+	}
+	recvMsg, err := r.Receive()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sendMsg != *(recvMsg.(*MyMessage1)) {
+		t.Fatalf("message not match: %v, %v", sendMsg, recvMsg)
+	}
+}
@@ -0,0 +1,184 @@
+package codec
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/funny/link"
+)
+
+var ErrBadNatsMsg = errors.New("Bad NATS Message")
+
+// NatsMsg is one operation of the NATS client protocol.
+// The byte counts of PUB, MSG, HPUB and HMSG are not kept in Args,
+// they are derived from Header and Payload.
+type NatsMsg struct {
+	Op      string
+	Args    []string
+	Header  []byte
+	Payload []byte
+}
+
+type NatsProtocol struct {
+	maxLine    int
+	maxPayload int
+}
+
+func Nats(maxLine, maxPayload int) *NatsProtocol {
+	return &NatsProtocol{
+		maxLine:    maxLine,
+		maxPayload: maxPayload,
+	}
+}
+
+func (p *NatsProtocol) NewCodec(rw io.ReadWriter) (link.Codec, error) {
+	codec := &natsCodec{
+		rw:           rw,
+		reader:       bufio.NewReaderSize(rw, p.maxLine),
+		NatsProtocol: p,
+	}
+	return codec, nil
+}
+
+type natsCodec struct {
+	rw      io.ReadWriter
+	reader  *bufio.Reader
+	sendBuf bytes.Buffer
+	*NatsProtocol
+}
+
+func (c *natsCodec) Receive() (interface{}, error) {
+	line, err := readLine(c.reader, []byte("\n"))
+	if err != nil {
+		return nil, err
+	}
+
+	msg := &NatsMsg{}
+	text := strings.TrimSpace(string(line))
+	if i := strings.IndexAny(text, " \t"); i >= 0 {
+		msg.Op = strings.ToUpper(text[:i])
+		text = strings.TrimSpace(text[i+1:])
+	} else {
+		msg.Op = strings.ToUpper(text)
+		text = ""
+	}
+
+	switch msg.Op {
+	case "INFO", "CONNECT", "-ERR":
+		msg.Args = []string{text}
+		return msg, nil
+	case "PUB", "MSG", "HPUB", "HMSG":
+	default:
+		msg.Args = strings.Fields(text)
+		return msg, nil
+	}
+
+	args := strings.Fields(text)
+	hsize, size := 0, 0
+	if msg.Op[0] == 'H' {
+		if len(args) < 3 {
+			return nil, ErrBadNatsMsg
+		}
+		if hsize, err = strconv.Atoi(args[len(args)-2]); err != nil {
+			return nil, ErrBadNatsMsg
+		}
+		args = append(args[:len(args)-2], args[len(args)-1])
+	}
+	if len(args) < 2 {
+		return nil, ErrBadNatsMsg
+	}
+	if size, err = strconv.Atoi(args[len(args)-1]); err != nil || hsize < 0 || hsize > size {
+		return nil, ErrBadNatsMsg
+	}
+	if size > c.maxPayload {
+		return nil, ErrTooLargePacket
+	}
+	msg.Args = args[:len(args)-1]
+
+	buff := make([]byte, size+2)
+	if _, err := io.ReadFull(c.reader, buff); err != nil {
+		return nil, err
+	}
+	if buff[size] != '\r' || buff[size+1] != '\n' {
+		return nil, ErrBadNatsMsg
+	}
+	if hsize > 0 {
+		msg.Header = buff[:hsize]
+	}
+	msg.Payload = buff[hsize:size]
+	return msg, nil
+}
+
+func (c *natsCodec) Send(m interface{}) error {
+	msg := m.(*NatsMsg)
+	if len(msg.Header)+len(msg.Payload) > c.maxPayload {
+		return ErrTooLargePacket
+	}
+
+	c.sendBuf.Reset()
+	c.sendBuf.WriteString(msg.Op)
+	for _, arg := range msg.Args {
+		c.sendBuf.WriteByte(' ')
+		c.sendBuf.WriteString(arg)
+	}
+	switch msg.Op {
+	case "HPUB", "HMSG":
+		c.sendBuf.WriteByte(' ')
+		c.sendBuf.WriteString(strconv.Itoa(len(msg.Header)))
+		fallthrough
+	case "PUB", "MSG":
+		c.sendBuf.WriteByte(' ')
+		c.sendBuf.WriteString(strconv.Itoa(len(msg.Header) + len(msg.Payload)))
+		c.sendBuf.WriteString("\r\n")
+		c.sendBuf.Write(msg.Header)
+		c.sendBuf.Write(msg.Payload)
+	}
+	c.sendBuf.WriteString("\r\n")
+	_, err := c.rw.Write(c.sendBuf.Bytes())
+	return err
+}
+
+func (c *natsCodec) Close() error {
+	if closer, ok := c.rw.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+// readLine reads from r until delim, the result not include delim.
+// A line longer than the reader's buffer is reported as ErrTooLargePacket.
+func readLine(r *bufio.Reader, delim []byte) ([]byte, error) {
+	return readLineMax(r, delim, r.Size())
+}
+
+// readLineMax is readLine for lines of up to max bytes with delim, which may
+// be longer than the reader's buffer.
+func readLineMax(r *bufio.Reader, delim []byte, max int) ([]byte, error) {
+	last := delim[len(delim)-1]
+	var line []byte
+	for {
+		b, err := r.ReadSlice(last)
+		if err == bufio.ErrBufferFull {
+			// The line needs one more byte at least.
+			if len(line)+len(b) >= max {
+				return nil, ErrTooLargePacket
+			}
+			line = append(line, b...)
+			continue
+		}
+		if len(line)+len(b) > max {
+			return nil, ErrTooLargePacket
+		}
+		if err != nil {
+			return nil, err
+		}
+		line = append(line, b...)
+		if bytes.HasSuffix(line, delim) {
+			return line[:len(line)-len(delim)], nil
+		}
+	}
+}
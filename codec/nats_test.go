@@ -0,0 +1,57 @@
+package codec
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func Test_Nats(t *testing.T) {
+	var stream bytes.Buffer
+
+	codec, _ := Nats(1024, 1024).NewCodec(&stream)
+
+	msgs := []*NatsMsg{
+		{Op: "INFO", Args: []string{`{"server_id":"abc", "max_payload":1024}`}},
+		{Op: "SUB", Args: []string{"foo.*", "workers", "1"}},
+		{Op: "PUB", Args: []string{"foo.bar", "reply"}, Payload: []byte("hello\r\nworld")},
+		{Op: "MSG", Args: []string{"foo.bar", "1"}, Payload: []byte{}},
+		{Op: "HMSG", Args: []string{"foo.bar", "1", "reply"}, Header: []byte("NATS/1.0\r\nA: B\r\n\r\n"), Payload: []byte("x")},
+		{Op: "PING", Args: []string{}},
+		{Op: "-ERR", Args: []string{"'Unknown Protocol Operation'"}},
+	}
+	for _, msg := range msgs {
+		if err := codec.Send(msg); err != nil {
+			t.Fatal(err)
+		}
+		recv, err := codec.Receive()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(msg, recv) {
+			t.Fatalf("message not match: %#v, %#v", msg, recv)
+		}
+	}
+
+	stream.WriteString("pub foo 5\r\nhello\r\n")
+	recv, err := codec.Receive()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg := recv.(*NatsMsg); msg.Op != "PUB" || string(msg.Payload) != "hello" {
+		t.Fatalf("message not match: %#v", msg)
+	}
+
+	stream.WriteString("PUB foo 2048\r\n")
+	if _, err := codec.Receive(); err != ErrTooLargePacket {
+		t.Fatalf("expected too large packet, got %v", err)
+	}
+
+	stream.Reset()
+	codec, _ = Nats(64, 1024).NewCodec(&stream)
+	stream.WriteString("SUB " + strings.Repeat("a", 100) + " 1\r\n")
+	if _, err := codec.Receive(); err != ErrTooLargePacket {
+		t.Fatalf("expected too large packet, got %v", err)
+	}
+}
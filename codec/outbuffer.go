@@ -0,0 +1,119 @@
+package codec
+
+import (
+	"encoding/binary"
+)
+
+// OutBufferReserve is the bytes NewOutBuffer reserves in front, enough for
+// the head of any FixLen.
+const OutBufferReserve = 8
+
+// OutBuffer is a packet body built in place with room reserved in front of
+// it. A framing protocol over Raw, like FixLen(Raw(), ...), writes its head
+// into the room and sends head and body in one Write without copying. It
+// must not be changed or sent by another session until Send returned.
+type OutBuffer struct {
+	data    []byte
+	start   int
+	reserve int
+}
+
+// NewOutBuffer returns an empty buffer of capacity bytes after
+// OutBufferReserve bytes of room.
+func NewOutBuffer(capacity int) *OutBuffer {
+	return &OutBuffer{
+		data:    make([]byte, OutBufferReserve, OutBufferReserve+capacity),
+		start:   OutBufferReserve,
+		reserve: OutBufferReserve,
+	}
+}
+
+// Bytes returns the body, after what is prepended.
+func (b *OutBuffer) Bytes() []byte {
+	return b.data[b.start:]
+}
+
+func (b *OutBuffer) Len() int {
+	return len(b.data) - b.start
+}
+
+func (b *OutBuffer) Write(p []byte) (int, error) {
+	b.data = append(b.data, p...)
+	return len(p), nil
+}
+
+func (b *OutBuffer) WriteByte(c byte) error {
+	b.data = append(b.data, c)
+	return nil
+}
+
+// Prepend returns n bytes in front of the body to fill, they become part of
+// it. It returns nil if the room left is less than n.
+func (b *OutBuffer) Prepend(n int) []byte {
+	if n > b.start {
+		return nil
+	}
+	b.start -= n
+	return b.data[b.start : b.start+n]
+}
+
+// Reset empties the buffer and gives back the room, for reuse.
+func (b *OutBuffer) Reset() {
+	b.data = b.data[:b.reserve]
+	b.start = b.reserve
+}
+
+func (b *OutBuffer) WriteUint8(v uint8) {
+	b.data = append(b.data, v)
+}
+
+func (b *OutBuffer) WriteUint16(v uint16) {
+	b.data = append(b.data, byte(v>>8), byte(v))
+}
+
+func (b *OutBuffer) WriteUint16LE(v uint16) {
+	b.data = append(b.data, byte(v), byte(v>>8))
+}
+
+func (b *OutBuffer) WriteUint32(v uint32) {
+	var p [4]byte
+	binary.BigEndian.PutUint32(p[:], v)
+	b.data = append(b.data, p[:]...)
+}
+
+func (b *OutBuffer) WriteUint32LE(v uint32) {
+	var p [4]byte
+	binary.LittleEndian.PutUint32(p[:], v)
+	b.data = append(b.data, p[:]...)
+}
+
+func (b *OutBuffer) WriteUint64(v uint64) {
+	var p [8]byte
+	binary.BigEndian.PutUint64(p[:], v)
+	b.data = append(b.data, p[:]...)
+}
+
+func (b *OutBuffer) WriteUint64LE(v uint64) {
+	var p [8]byte
+	binary.LittleEndian.PutUint64(p[:], v)
+	b.data = append(b.data, p[:]...)
+}
+
+func (b *OutBuffer) WriteBytes(p []byte) {
+	b.data = append(b.data, p...)
+}
+
+func (b *OutBuffer) WriteString(s string) (int, error) {
+	b.data = append(b.data, s...)
+	return len(s), nil
+}
+
+func (b *OutBuffer) WriteUvarint(v uint64) {
+	var p [binary.MaxVarintLen64]byte
+	b.data = append(b.data, p[:binary.PutUvarint(p[:], v)]...)
+}
+
+func (b *OutBuffer) WriteVarint(v int64) {
+	var p [binary.MaxVarintLen64]byte
+	b.data = append(b.data, p[:binary.PutVarint(p[:], v)]...)
+}
@@ -0,0 +1,115 @@
+package codec
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"testing"
+)
+
+func Test_OutBuffer(t *testing.T) {
+	var stream countWriter
+
+	codec, _ := FixLen(Raw(), 4, binary.BigEndian, 1024, 1024).NewCodec(&stream)
+	buffer := NewOutBuffer(16)
+	buffer.Write([]byte("hello"))
+	codec.Send(buffer)
+	codec.Send(buffer)
+	if stream.writes != 2 || stream.String() != "\x00\x00\x00\x05hello\x00\x00\x00\x05hello" {
+		t.Fatalf("packets not match: %d, %q", stream.writes, stream.String())
+	}
+	if allocs := testing.AllocsPerRun(100, func() { codec.Send(buffer) }); allocs > 0 {
+		t.Fatalf("%v allocations per send", allocs)
+	}
+
+	// Over other bases it is sent as a body.
+	var stream2 bytes.Buffer
+	codec2, _ := FixLen(Checksum(Raw(), NewCRC32C), 2, binary.BigEndian, 1024, 1024).NewCodec(&stream2)
+	codec2.Send(buffer)
+	recv, err := codec2.Receive()
+	if err != nil || string(recv.(*InBuffer).Bytes()) != "hello" {
+		t.Fatalf("message not match: %v, %v", recv, err)
+	}
+
+	if head := buffer.Prepend(2); len(head) != 2 || buffer.Len() != 7 {
+		t.Fatalf("bad prepend: %d", buffer.Len())
+	}
+	if buffer.Prepend(OutBufferReserve) != nil {
+		t.Fatal("prepend beyond the room")
+	}
+	buffer.Reset()
+	if buffer.Len() != 0 || buffer.Prepend(OutBufferReserve) == nil {
+		t.Fatal("room not given back")
+	}
+}
+
+func Test_BufferCursor(t *testing.T) {
+	out := NewOutBuffer(64)
+	out.WriteUint8(1)
+	out.WriteUint16(2)
+	out.WriteUint16LE(3)
+	out.WriteUint32(4)
+	out.WriteUint32LE(5)
+	out.WriteUint64(6)
+	out.WriteUint64LE(7)
+	out.WriteUvarint(300)
+	out.WriteVarint(-300)
+	out.WriteString("hi")
+	out.WriteBytes([]byte("yo"))
+
+	in := &InBuffer{data: append([]byte(nil), out.Bytes()...)}
+	if v := in.ReadUint8(); v != 1 {
+		t.Fatalf("uint8: %d", v)
+	}
+	if v := in.ReadUint16(); v != 2 {
+		t.Fatalf("uint16: %d", v)
+	}
+	if v := in.ReadUint16LE(); v != 3 {
+		t.Fatalf("uint16le: %d", v)
+	}
+	if v := in.ReadUint32(); v != 4 {
+		t.Fatalf("uint32: %d", v)
+	}
+	if v := in.ReadUint32LE(); v != 5 {
+		t.Fatalf("uint32le: %d", v)
+	}
+	if v := in.ReadUint64(); v != 6 {
+		t.Fatalf("uint64: %d", v)
+	}
+	if v := in.ReadUint64LE(); v != 7 {
+		t.Fatalf("uint64le: %d", v)
+	}
+	if v := in.ReadUvarint(); v != 300 {
+		t.Fatalf("uvarint: %d", v)
+	}
+	if v := in.ReadVarint(); v != -300 {
+		t.Fatalf("varint: %d", v)
+	}
+	if v := in.ReadString(2); v != "hi" {
+		t.Fatalf("string: %q", v)
+	}
+	if v := in.ReadBytes(2); string(v) != "yo" {
+		t.Fatalf("bytes: %q", v)
+	}
+	if in.Err() != nil {
+		t.Fatalf("unexpected error: %v", in.Err())
+	}
+
+	// The error sticks, even for reads that would fit.
+	in = &InBuffer{data: []byte{1, 2, 3}}
+	if in.ReadUint32() != 0 || in.Err() != io.ErrUnexpectedEOF {
+		t.Fatalf("short read not failed: %v", in.Err())
+	}
+	if in.ReadUint8() != 0 || in.Err() != io.ErrUnexpectedEOF {
+		t.Fatal("error not sticky")
+	}
+
+	in = &InBuffer{data: []byte{0x80, 0x80}}
+	if in.ReadUvarint() != 0 || in.Err() != io.ErrUnexpectedEOF {
+		t.Fatalf("short varint not failed: %v", in.Err())
+	}
+	in = &InBuffer{data: bytes.Repeat([]byte{0xff}, 11)}
+	if in.ReadVarint() != 0 || in.Err() != ErrBadVarint {
+		t.Fatalf("bad varint not failed: %v", in.Err())
+	}
+}
@@ -0,0 +1,53 @@
+package codec
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+)
+
+// PacketTooLargeError is returned by the length-prefixed framings for a
+// received packet over the limit, it is ErrTooLargePacket for errors.Is.
+type PacketTooLargeError struct {
+	Size  int64
+	Limit int
+	// Skipped tells the body was discarded by OversizeSkip, so the stream is
+	// still usable.
+	Skipped bool
+}
+
+func (e *PacketTooLargeError) Error() string {
+	return fmt.Sprintf("Too Large Packet: %d bytes, limit %d", e.Size, e.Limit)
+}
+
+func (e *PacketTooLargeError) Unwrap() error {
+	return ErrTooLargePacket
+}
+
+// OversizePolicy is what a framing does with a received packet over its limit.
+type OversizePolicy int
+
+const (
+	// OversizeClose leaves the body unread, the stream is lost and the
+	// session should be closed. It is the default.
+	OversizeClose OversizePolicy = iota
+	// OversizeSkip discards the body, Receive returns the error and the next
+	// Receive reads the following packet.
+	OversizeSkip
+)
+
+// oversize returns the error of a packet of size over limit, discarding the
+// body from r by policy.
+func oversize(r io.Reader, size int64, limit int, policy OversizePolicy) error {
+	tooLarge := &PacketTooLargeError{Size: size, Limit: limit}
+	if policy == OversizeSkip {
+		if _, err := io.CopyN(ioutil.Discard, r, size); err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return err
+		}
+		tooLarge.Skipped = true
+	}
+	return tooLarge
+}
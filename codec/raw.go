@@ -0,0 +1,65 @@
+package codec
+
+import (
+	"bytes"
+	"io"
+
+	"github.com/funny/link"
+)
+
+type rawProtocol struct{}
+
+// Raw delivers each packet as an *InBuffer without decoding, and sends
+// []byte, *InBuffer, *SharedBuffer and *OutBuffer messages as is. It must be placed under a framing protocol.
+func Raw() link.Protocol {
+	return rawProtocol{}
+}
+
+func (rawProtocol) NewCodec(rw io.ReadWriter) (link.Codec, error) {
+	return &rawCodec{rw: rw}, nil
+}
+
+type rawCodec struct {
+	rw    io.ReadWriter
+	last  *InBuffer
+	arena *Arena
+}
+
+func (c *rawCodec) Receive() (interface{}, error) {
+	var data []byte
+	if c.last != nil {
+		if data = c.last.recycle(); data == nil {
+			c.arena = nil
+		}
+		c.last = nil
+	}
+	if c.arena == nil {
+		c.arena = NewArena(defaultArenaChunk)
+	}
+	buff := bytes.NewBuffer(data)
+	if _, err := buff.ReadFrom(c.rw); err != nil {
+		return nil, err
+	}
+	c.last = &InBuffer{data: buff.Bytes(), arena: c.arena}
+	return c.last, nil
+}
+
+func (c *rawCodec) Send(msg interface{}) error {
+	var err error
+	switch m := msg.(type) {
+	case *InBuffer:
+		_, err = c.rw.Write(m.Bytes())
+	case *SharedBuffer:
+		_, err = c.rw.Write(m.Bytes())
+		m.Release()
+	case *OutBuffer:
+		_, err = c.rw.Write(m.Bytes())
+	default:
+		_, err = c.rw.Write(msg.([]byte))
+	}
+	return err
+}
+
+func (c *rawCodec) Close() error {
+	return nil
+}
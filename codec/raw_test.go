@@ -0,0 +1,80 @@
+package codec
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"testing"
+)
+
+func Test_Raw(t *testing.T) {
+	var stream bytes.Buffer
+
+	codec, _ := FixLen(Raw(), 2, binary.BigEndian, 1024, 1024).NewCodec(&stream)
+
+	codec.Send([]byte("first"))
+	codec.Send([]byte("second"))
+	codec.Send([]byte("third"))
+
+	recv1, _ := codec.Receive()
+	msg1 := recv1.(*InBuffer).Clone()
+	recv2, _ := codec.Receive()
+	msg2 := recv2.(*InBuffer).Detach()
+	recv3, _ := codec.Receive()
+
+	if string(msg1.Bytes()) != "first" || string(msg2.Bytes()) != "second" || string(recv3.(*InBuffer).Bytes()) != "third" {
+		t.Fatalf("message not match: %q, %q, %q", msg1.Bytes(), msg2.Bytes(), recv3.(*InBuffer).Bytes())
+	}
+}
+
+func Test_RawUseAfterRecycle(t *testing.T) {
+	DebugBuffers = true
+	defer func() { DebugBuffers = false }()
+
+	var stream bytes.Buffer
+
+	codec, _ := FixLen(Raw(), 2, binary.BigEndian, 1024, 1024).NewCodec(&stream)
+	codec.Send([]byte("first"))
+	codec.Send([]byte("second"))
+
+	recv1, _ := codec.Receive()
+	codec.Receive()
+
+	defer func() {
+		if recover() == nil {
+			t.Fatal("use after recycle not detected")
+		}
+	}()
+	recv1.(*InBuffer).Bytes()
+}
+
+func Test_InBufferPeek(t *testing.T) {
+	b := &InBuffer{data: []byte{1, 2, 'a', 'b', 'c'}}
+
+	head, err := b.Peek(2)
+	if err != nil || !bytes.Equal(head, []byte{1, 2}) {
+		t.Fatalf("peek not match: %v, %v", head, err)
+	}
+	if x, _ := b.ReadByte(); x != 1 {
+		t.Fatalf("read byte not match: %v", x)
+	}
+	if err := b.Unread(1); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Unread(1); err != ErrUnreadTooFar {
+		t.Fatalf("expected unread too far, got %v", err)
+	}
+	b.ReadByte()
+	b.ReadByte()
+	if string(b.Remaining()) != "abc" {
+		t.Fatalf("remaining not match: %q", b.Remaining())
+	}
+	if _, err := b.Peek(4); err != io.ErrUnexpectedEOF {
+		t.Fatalf("expected unexpected EOF, got %v", err)
+	}
+	rest, _ := ioutil.ReadAll(b)
+	if string(rest) != "abc" {
+		t.Fatalf("rest not match: %q", rest)
+	}
+}
@@ -0,0 +1,301 @@
+package codec
+
+import (
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/funny/link"
+)
+
+var (
+	ErrUnknownProtocol = errors.New("Unknown Protocol")
+	ErrBadProtocolArgs = errors.New("Bad Protocol Arguments")
+)
+
+// SpecError tells which element of a spec failed to build.
+type SpecError struct {
+	Element string
+	Err     error
+}
+
+func (e *SpecError) Error() string {
+	return e.Element + ": " + e.Err.Error()
+}
+
+func (e *SpecError) Unwrap() error {
+	return e.Err
+}
+
+// BaseFactory creates a protocol encoding messages from the arguments of a
+// spec element, like json or raw.
+type BaseFactory func(args []string) (link.Protocol, error)
+
+// WrapperFactory creates a protocol over base from the arguments of a spec
+// element, like a framing, checksum or cipher.
+type WrapperFactory func(base link.Protocol, args []string) (link.Protocol, error)
+
+var registry = struct {
+	sync.RWMutex
+	bases    map[string]BaseFactory
+	wrappers map[string]WrapperFactory
+}{
+	bases:    make(map[string]BaseFactory),
+	wrappers: make(map[string]WrapperFactory),
+}
+
+// RegisterBase makes a base protocol buildable by name, it panics if the name
+// is taken.
+func RegisterBase(name string, factory BaseFactory) {
+	registry.Lock()
+	defer registry.Unlock()
+	if _, exists := registry.bases[name]; exists {
+		panic("RegisterBase: duplicate protocol " + name)
+	}
+	if _, exists := registry.wrappers[name]; exists {
+		panic("RegisterBase: duplicate protocol " + name)
+	}
+	registry.bases[name] = factory
+}
+
+// RegisterWrapper makes a wrapper protocol buildable by name, it panics if the
+// name is taken. Wrappers needing keys or callbacks, like StreamCipher, MAC
+// or Dedup, are not registered by default, an application registers them
+// with its own keys.
+func RegisterWrapper(name string, factory WrapperFactory) {
+	registry.Lock()
+	defer registry.Unlock()
+	if _, exists := registry.bases[name]; exists {
+		panic("RegisterWrapper: duplicate protocol " + name)
+	}
+	if _, exists := registry.wrappers[name]; exists {
+		panic("RegisterWrapper: duplicate protocol " + name)
+	}
+	registry.wrappers[name] = factory
+}
+
+// Build creates a protocol from a spec like "json+crc32c+packet4:be,65536".
+// Elements are separated by "+" from the innermost to the outermost, and
+// take comma separated arguments after a ":". A spec not starting with a
+// base protocol is over Raw.
+func Build(spec string) (link.Protocol, error) {
+	registry.RLock()
+	defer registry.RUnlock()
+
+	var protocol link.Protocol
+	for i, element := range strings.Split(spec, "+") {
+		name, args := element, []string(nil)
+		if j := strings.IndexByte(element, ':'); j >= 0 {
+			name, args = element[:j], strings.Split(element[j+1:], ",")
+		}
+		var err error
+		if base, exists := registry.bases[name]; exists && i == 0 {
+			protocol, err = base(args)
+		} else if wrapper, exists := registry.wrappers[name]; exists {
+			if protocol == nil {
+				protocol = Raw()
+			}
+			protocol, err = wrapper(protocol, args)
+		} else {
+			err = ErrUnknownProtocol
+		}
+		if err != nil {
+			return nil, &SpecError{element, err}
+		}
+	}
+	return protocol, nil
+}
+
+// intArgs parses args into ints, the missing ones keep their defaults.
+func intArgs(args []string, values ...*int) error {
+	if len(args) > len(values) {
+		return ErrBadProtocolArgs
+	}
+	for i, arg := range args {
+		n, err := strconv.Atoi(arg)
+		if err != nil || n < 0 {
+			return ErrBadProtocolArgs
+		}
+		*values[i] = n
+	}
+	return nil
+}
+
+func init() {
+	RegisterBase("raw", func(args []string) (link.Protocol, error) {
+		if len(args) != 0 {
+			return nil, ErrBadProtocolArgs
+		}
+		return Raw(), nil
+	})
+	RegisterBase("json", func(args []string) (link.Protocol, error) {
+		if len(args) != 0 {
+			return nil, ErrBadProtocolArgs
+		}
+		return Json(), nil
+	})
+	RegisterBase("marshal", func(args []string) (link.Protocol, error) {
+		marshalers := map[string]Marshaler{
+			"json":  JsonMarshaler,
+			"gob":   GobMarshaler,
+			"proto": ProtoMarshaler,
+		}
+		if len(args) != 1 || marshalers[args[0]] == nil {
+			return nil, ErrBadProtocolArgs
+		}
+		return Marshal(marshalers[args[0]]), nil
+	})
+
+	// The other bases take their two limits, 64KB by default.
+	limits := map[string]func(a, b int) link.Protocol{
+		"beanstalk": func(a, b int) link.Protocol { return Beanstalk(a, b) },
+		"gearman":   func(a, b int) link.Protocol { return Gearman(a, b) },
+		"iproto":    func(a, b int) link.Protocol { return IProto(a, b) },
+		"nats":      func(a, b int) link.Protocol { return Nats(a, b) },
+		"resp":      func(a, b int) link.Protocol { return RESP(a, b) },
+		"rtmp":      func(a, b int) link.Protocol { return RTMP(a, b) },
+		"sip":       func(a, b int) link.Protocol { return SIP(a, b) },
+	}
+	for name, newProtocol := range limits {
+		newProtocol := newProtocol
+		RegisterBase(name, func(args []string) (link.Protocol, error) {
+			a, b := 64*1024, 64*1024
+			if err := intArgs(args, &a, &b); err != nil {
+				return nil, err
+			}
+			return newProtocol(a, b), nil
+		})
+	}
+
+	// packet1 to packet8 are FixLen, e.g. "packet2:le,4096", by default
+	// big-endian with a limit of 64KB.
+	for _, n := range []int{1, 2, 4, 8} {
+		n := n
+		RegisterWrapper("packet"+strconv.Itoa(n), func(base link.Protocol, args []string) (link.Protocol, error) {
+			var byteOrder binary.ByteOrder = binary.BigEndian
+			if len(args) > 0 {
+				switch args[0] {
+				case "be":
+				case "le":
+					byteOrder = binary.LittleEndian
+				default:
+					return nil, ErrBadProtocolArgs
+				}
+				args = args[1:]
+			}
+			max := 64 * 1024
+			if err := intArgs(args, &max); err != nil {
+				return nil, err
+			}
+			return FixLen(base, n, byteOrder, max, max), nil
+		})
+	}
+	RegisterWrapper("varint", func(base link.Protocol, args []string) (link.Protocol, error) {
+		max := 64 * 1024
+		if err := intArgs(args, &max); err != nil {
+			return nil, err
+		}
+		return Varint(base, max, max), nil
+	})
+	RegisterWrapper("mqtt", func(base link.Protocol, args []string) (link.Protocol, error) {
+		max := 64 * 1024
+		if err := intArgs(args, &max); err != nil {
+			return nil, err
+		}
+		return MQTT(base, max, max), nil
+	})
+	RegisterWrapper("fixsize", func(base link.Protocol, args []string) (link.Protocol, error) {
+		var size int
+		if len(args) != 1 {
+			return nil, ErrBadProtocolArgs
+		}
+		if err := intArgs(args, &size); err != nil || size == 0 {
+			return nil, ErrBadProtocolArgs
+		}
+		return FixSize(base, size), nil
+	})
+	RegisterWrapper("datagram", func(base link.Protocol, args []string) (link.Protocol, error) {
+		max := 1400
+		if err := intArgs(args, &max); err != nil {
+			return nil, err
+		}
+		return Datagram(base, max), nil
+	})
+	RegisterWrapper("bufio", func(base link.Protocol, args []string) (link.Protocol, error) {
+		readBuf, writeBuf := 4096, 4096
+		if err := intArgs(args, &readBuf, &writeBuf); err != nil {
+			return nil, err
+		}
+		return Bufio(base, readBuf, writeBuf), nil
+	})
+	// batch is Batch with the delay in milliseconds, e.g. "batch:16384,5".
+	RegisterWrapper("batch", func(base link.Protocol, args []string) (link.Protocol, error) {
+		size, delay := 4096, 1
+		if err := intArgs(args, &size, &delay); err != nil {
+			return nil, err
+		}
+		return Batch(base, size, time.Duration(delay)*time.Millisecond), nil
+	})
+	RegisterWrapper("crc32", func(base link.Protocol, args []string) (link.Protocol, error) {
+		if len(args) != 0 {
+			return nil, ErrBadProtocolArgs
+		}
+		return Checksum(base, crc32.NewIEEE), nil
+	})
+	RegisterWrapper("crc32c", func(base link.Protocol, args []string) (link.Protocol, error) {
+		if len(args) != 0 {
+			return nil, ErrBadProtocolArgs
+		}
+		return Checksum(base, NewCRC32C), nil
+	})
+	RegisterWrapper("xxh32", func(base link.Protocol, args []string) (link.Protocol, error) {
+		if len(args) != 0 {
+			return nil, ErrBadProtocolArgs
+		}
+		return Checksum(base, NewXXH32), nil
+	})
+	for name, newCompressor := range map[string]func(int) func() Compressor{
+		"flate": NewFlate,
+		"gzip":  NewGzip,
+	} {
+		newCompressor := newCompressor
+		RegisterWrapper(name, func(base link.Protocol, args []string) (link.Protocol, error) {
+			threshold, max := 256, 16*1024*1024
+			if err := intArgs(args, &threshold, &max); err != nil {
+				return nil, err
+			}
+			return Compress(base, newCompressor(-1), threshold, max), nil
+		})
+	}
+	RegisterWrapper("fragment", func(base link.Protocol, args []string) (link.Protocol, error) {
+		chunkSize, max := 16*1024, 16*1024*1024
+		if err := intArgs(args, &chunkSize, &max); err != nil {
+			return nil, err
+		}
+		if chunkSize == 0 || chunkSize > fragmentSizeMask {
+			return nil, ErrBadProtocolArgs
+		}
+		return Fragment(base, chunkSize, max, max), nil
+	})
+	RegisterWrapper("mysql", func(base link.Protocol, args []string) (link.Protocol, error) {
+		max := 16 * 1024 * 1024
+		if err := intArgs(args, &max); err != nil {
+			return nil, err
+		}
+		return MySQL(base, max, max), nil
+	})
+	RegisterWrapper("throttle", func(base link.Protocol, args []string) (link.Protocol, error) {
+		var sendRate, recvRate int
+		if len(args) != 2 {
+			return nil, ErrBadProtocolArgs
+		}
+		if err := intArgs(args, &sendRate, &recvRate); err != nil {
+			return nil, err
+		}
+		return Throttle(base, sendRate, recvRate), nil
+	})
+}
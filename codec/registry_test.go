@@ -0,0 +1,67 @@
+package codec
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/funny/link"
+)
+
+func init() {
+	RegisterBase("jsontest", func(args []string) (link.Protocol, error) {
+		return JsonTestProtocol(), nil
+	})
+}
+
+func Test_Build(t *testing.T) {
+	protocol, err := Build("jsontest+gzip:0+crc32c+packet4:be")
+	if err != nil {
+		t.Fatal(err)
+	}
+	JsonTest(t, protocol)
+
+	// Without a base the messages are raw.
+	protocol, err = Build("crc32+packet2:le,1024")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var stream bytes.Buffer
+	codec, _ := protocol.NewCodec(&stream)
+	if err := codec.Send(make([]byte, 1021)); err != ErrTooLargePacket {
+		t.Fatalf("expected too large packet, got %v", err)
+	}
+	codec.Send([]byte("hello"))
+	if stream.Bytes()[0] != 9 || stream.Bytes()[1] != 0 {
+		t.Fatalf("head not little-endian: %v", stream.Bytes()[:2])
+	}
+	recv, err := codec.Receive()
+	if err != nil || string(recv.(*InBuffer).Bytes()) != "hello" {
+		t.Fatalf("message not match: %v, %v", recv, err)
+	}
+}
+
+func Test_BuildError(t *testing.T) {
+	for spec, expected := range map[string]error{
+		"json+unknown":      ErrUnknownProtocol,
+		"packet4+json":      ErrUnknownProtocol,
+		"packet4:me":        ErrBadProtocolArgs,
+		"packet4:be,1,2":    ErrBadProtocolArgs,
+		"nats:-1":           ErrBadProtocolArgs,
+		"raw+throttle:1024": ErrBadProtocolArgs,
+		"raw+fixsize":       ErrBadProtocolArgs,
+		"raw+fixsize:0":     ErrBadProtocolArgs,
+		"raw+mqtt:1,2":      ErrBadProtocolArgs,
+		"marshal:xml":       ErrBadProtocolArgs,
+	} {
+		_, err := Build(spec)
+		if !errors.Is(err, expected) {
+			t.Fatalf("%s: expected %v, got %v", spec, expected, err)
+		}
+	}
+
+	_, err := Build("json+packet4:be+fragment:0")
+	if err == nil || err.Error() != "fragment:0: Bad Protocol Arguments" {
+		t.Fatalf("element not reported: %v", err)
+	}
+}
@@ -0,0 +1,261 @@
+package codec
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/funny/link"
+)
+
+var ErrBadRESP = errors.New("Bad RESP Message")
+var ErrRESPType = errors.New("Unsupported RESP Type")
+
+// respMaxDepth limits the nesting of arrays.
+const respMaxDepth = 32
+
+// respElementSize is what an element of an array takes from the budget of a
+// message, about the memory of its interface value.
+const respElementSize = 16
+
+// RESPSimple is a simple string, like +OK.
+type RESPSimple string
+
+// RESPError is an error reply, like -ERR unknown command.
+type RESPError string
+
+func (e RESPError) Error() string {
+	return string(e)
+}
+
+// RESPNull is the null bulk string and the null array.
+type RESPNull struct{}
+
+type RESPProtocol struct {
+	maxLine int
+	maxBulk int
+}
+
+// RESP is the Redis serialization protocol version 2. It receives
+// RESPSimple, RESPError, int64, []byte for bulk strings, RESPNull and
+// []interface{} for arrays. Inline commands, lines not starting with a type,
+// are received as arrays of []byte split by spaces, like redis-server does.
+// It sends the same types, with string as bulk string, int as integer, nil
+// in arrays as null and [][]byte as an array of bulk strings, the usual
+// commands. maxBulk limits the bytes of the bulk strings and the elements of
+// the arrays of a message in total, an element taking 16 bytes.
+func RESP(maxLine, maxBulk int) *RESPProtocol {
+	return &RESPProtocol{
+		maxLine: maxLine,
+		maxBulk: maxBulk,
+	}
+}
+
+func (p *RESPProtocol) NewCodec(rw io.ReadWriter) (link.Codec, error) {
+	codec := &respCodec{
+		rw:           rw,
+		reader:       bufio.NewReaderSize(rw, p.maxLine),
+		RESPProtocol: p,
+	}
+	return codec, nil
+}
+
+type respCodec struct {
+	rw      io.ReadWriter
+	reader  *bufio.Reader
+	sendBuf bytes.Buffer
+	*RESPProtocol
+}
+
+func (c *respCodec) Receive() (interface{}, error) {
+	for {
+		line, err := c.readLine()
+		if err != nil {
+			return nil, err
+		}
+		if len(line) > 0 && bytes.IndexByte([]byte("+-:$*"), line[0]) >= 0 {
+			budget := c.maxBulk
+			return c.receive(line, 0, &budget)
+		}
+		// Empty lines are skipped like redis-server does.
+		if fields := bytes.Fields(line); len(fields) > 0 {
+			args := make([]interface{}, len(fields))
+			for i, field := range fields {
+				args[i] = append([]byte(nil), field...)
+			}
+			return args, nil
+		}
+	}
+}
+
+func (c *respCodec) readLine() ([]byte, error) {
+	line, err := readLine(c.reader, []byte("\n"))
+	if err != nil {
+		return nil, err
+	}
+	if n := len(line); n > 0 && line[n-1] == '\r' {
+		line = line[:n-1]
+	}
+	return line, nil
+}
+
+// receive takes the bulk strings and the array elements from budget, so
+// a message can't take more memory than its limit.
+func (c *respCodec) receive(line []byte, depth int, budget *int) (interface{}, error) {
+	if len(line) == 0 {
+		return nil, ErrBadRESP
+	}
+	body := line[1:]
+	switch line[0] {
+	case '+':
+		return RESPSimple(body), nil
+	case '-':
+		return RESPError(body), nil
+	case ':':
+		n, err := strconv.ParseInt(string(body), 10, 64)
+		if err != nil {
+			return nil, ErrBadRESP
+		}
+		return n, nil
+	case '$':
+		n, err := c.size(body, budget, 1)
+		if err != nil || n < 0 {
+			return RESPNull{}, err
+		}
+		buf := make([]byte, n+2)
+		if _, err := io.ReadFull(c.reader, buf); err != nil {
+			return nil, err
+		}
+		if buf[n] != '\r' || buf[n+1] != '\n' {
+			return nil, ErrBadRESP
+		}
+		return buf[:n], nil
+	case '*':
+		if depth == respMaxDepth {
+			return nil, ErrBadRESP
+		}
+		n, err := c.size(body, budget, respElementSize)
+		if err != nil || n < 0 {
+			return RESPNull{}, err
+		}
+		// Grown as the elements arrive, not by the length claimed.
+		items := make([]interface{}, 0, respInitCap(n))
+		for i := 0; i < n; i++ {
+			line, err := c.readLine()
+			if err != nil {
+				return nil, err
+			}
+			item, err := c.receive(line, depth+1, budget)
+			if err != nil {
+				return nil, err
+			}
+			items = append(items, item)
+		}
+		return items, nil
+	}
+	return nil, ErrBadRESP
+}
+
+// size parses the length of a bulk string or an array, -1 is null, and
+// takes n units of unit bytes from budget.
+func (c *respCodec) size(body []byte, budget *int, unit int) (int, error) {
+	n, err := strconv.Atoi(string(body))
+	if err != nil || n < -1 {
+		return 0, ErrBadRESP
+	}
+	if n > *budget/unit {
+		return 0, ErrTooLargePacket
+	}
+	if n > 0 {
+		*budget -= n * unit
+	}
+	return n, nil
+}
+
+func respInitCap(n int) int {
+	if n > 64 {
+		return 64
+	}
+	return n
+}
+
+func (c *respCodec) Send(msg interface{}) error {
+	c.sendBuf.Reset()
+	if err := c.encode(msg); err != nil {
+		return err
+	}
+	_, err := c.rw.Write(c.sendBuf.Bytes())
+	return err
+}
+
+func (c *respCodec) encode(msg interface{}) error {
+	switch m := msg.(type) {
+	case RESPSimple:
+		return c.writeSimple('+', string(m))
+	case RESPError:
+		return c.writeSimple('-', string(m))
+	case int:
+		c.writeLine(':', strconv.Itoa(m))
+	case int64:
+		c.writeLine(':', strconv.FormatInt(m, 10))
+	case []byte:
+		return c.writeBulk(m)
+	case string:
+		return c.writeBulk([]byte(m))
+	case nil, RESPNull:
+		c.sendBuf.WriteString("$-1\r\n")
+	case [][]byte:
+		c.writeLine('*', strconv.Itoa(len(m)))
+		for _, arg := range m {
+			if err := c.writeBulk(arg); err != nil {
+				return err
+			}
+		}
+	case []interface{}:
+		c.writeLine('*', strconv.Itoa(len(m)))
+		for _, item := range m {
+			if err := c.encode(item); err != nil {
+				return err
+			}
+		}
+	default:
+		return ErrRESPType
+	}
+	return nil
+}
+
+// writeSimple writes a simple string or an error, which can't have line
+// breaks.
+func (c *respCodec) writeSimple(kind byte, body string) error {
+	if strings.ContainsAny(body, "\r\n") {
+		return ErrBadRESP
+	}
+	c.writeLine(kind, body)
+	return nil
+}
+
+func (c *respCodec) writeLine(kind byte, body string) {
+	c.sendBuf.WriteByte(kind)
+	c.sendBuf.WriteString(body)
+	c.sendBuf.WriteString("\r\n")
+}
+
+func (c *respCodec) writeBulk(data []byte) error {
+	if len(data) > c.maxBulk {
+		return ErrTooLargePacket
+	}
+	c.writeLine('$', strconv.Itoa(len(data)))
+	c.sendBuf.Write(data)
+	c.sendBuf.WriteString("\r\n")
+	return nil
+}
+
+func (c *respCodec) Close() error {
+	if closer, ok := c.rw.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
@@ -0,0 +1,99 @@
+package codec
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func Test_RESP(t *testing.T) {
+	var stream bytes.Buffer
+
+	codec, _ := RESP(1024, 1024).NewCodec(&stream)
+
+	msgs := []interface{}{
+		RESPSimple("OK"),
+		RESPError("ERR unknown command"),
+		int64(-42),
+		[]byte("hello\r\nworld"),
+		[]byte{},
+		RESPNull{},
+		[]interface{}{},
+		[]interface{}{[]byte("SET"), []byte("key"), int64(1), []interface{}{RESPSimple("x"), RESPNull{}}},
+	}
+	for _, msg := range msgs {
+		if err := codec.Send(msg); err != nil {
+			t.Fatal(err)
+		}
+		recv, err := codec.Receive()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(msg, recv) {
+			t.Fatalf("message not match: %#v, %#v", msg, recv)
+		}
+	}
+
+	codec.Send([][]byte{[]byte("GET"), []byte("key")})
+	if stream.String() != "*2\r\n$3\r\nGET\r\n$3\r\nkey\r\n" {
+		t.Fatalf("command not match: %q", stream.String())
+	}
+	stream.Reset()
+	codec.Send([]interface{}{"a", 1, nil})
+	if stream.String() != "*3\r\n$1\r\na\r\n:1\r\n$-1\r\n" {
+		t.Fatalf("array not match: %q", stream.String())
+	}
+	stream.Reset()
+
+	stream.WriteString("\r\nPING  extra\n*-1\r\n")
+	recv, err := codec.Receive()
+	if err != nil || !reflect.DeepEqual(recv, []interface{}{[]byte("PING"), []byte("extra")}) {
+		t.Fatalf("inline command not match: %#v, %v", recv, err)
+	}
+	recv, err = codec.Receive()
+	if err != nil || recv != (RESPNull{}) {
+		t.Fatalf("null array not match: %#v, %v", recv, err)
+	}
+
+	if err := codec.Send(RESPSimple("a\r\nb")); err != ErrBadRESP {
+		t.Fatalf("expected bad message, got %v", err)
+	}
+	if err := codec.Send(3.5); err != ErrRESPType {
+		t.Fatalf("expected unsupported type, got %v", err)
+	}
+	if err := codec.Send(make([]byte, 1025)); err != ErrTooLargePacket {
+		t.Fatalf("expected too large packet, got %v", err)
+	}
+	for input, expected := range map[string]error{
+		"$1025\r\n": ErrTooLargePacket,
+		"*1025\r\n": ErrTooLargePacket,
+		strings.Repeat("*1\r\n", respMaxDepth+1) + ":1\r\n": ErrBadRESP,
+		"$3\r\nabcd\r\n": ErrBadRESP,
+		":x\r\n":         ErrBadRESP,
+		// The elements and bulk strings of a message share the limit.
+		"*2\r\n$600\r\n" + strings.Repeat("x", 600) + "\r\n$600\r\n": ErrTooLargePacket,
+		"*50\r\n*20\r\n":      ErrTooLargePacket,
+		"*64\r\n:1\r\n*1\r\n": ErrTooLargePacket,
+	} {
+		codec, _ := RESP(1024, 1024).NewCodec(bytes.NewBufferString(input))
+		if _, err := codec.Receive(); err != expected {
+			t.Fatalf("%q: expected %v, got %v", input, expected, err)
+		}
+	}
+}
+
+func Test_RESPLimit(t *testing.T) {
+	// A message exactly at the limit is received, arrays grow as their
+	// elements arrive.
+	input := "*64\r\n" + strings.Repeat(":1\r\n", 64)
+	codec, _ := RESP(1024, 64*respElementSize).NewCodec(bytes.NewBufferString(input))
+	recv, err := codec.Receive()
+	if err != nil || len(recv.([]interface{})) != 64 {
+		t.Fatalf("message not match: %v, %v", recv, err)
+	}
+	codec, _ = RESP(1024, 1<<30).NewCodec(bytes.NewBufferString("*65536\r\n:1\r\n"))
+	if _, err := codec.Receive(); err == nil {
+		t.Fatal("expected end of stream")
+	}
+}
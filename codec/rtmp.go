@@ -0,0 +1,359 @@
+package codec
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+
+	"github.com/funny/link"
+)
+
+var ErrBadRTMPChunk = errors.New("Bad RTMP Chunk")
+var ErrTooManyRTMPStreams = errors.New("Too Many RTMP Chunk Streams")
+
+// rtmpMaxPartials limits the chunk streams receiving a message at the same time.
+const rtmpMaxPartials = 64
+
+const (
+	RTMPSetChunkSize     = 1
+	RTMPAbort            = 2
+	RTMPAck              = 3
+	RTMPUserControl      = 4
+	RTMPWindowAckSize    = 5
+	RTMPSetPeerBandwidth = 6
+	RTMPAudio            = 8
+	RTMPVideo            = 9
+	RTMPDataAMF3         = 15
+	RTMPCommandAMF3      = 17
+	RTMPDataAMF0         = 18
+	RTMPCommandAMF0      = 20
+)
+
+const rtmpDefaultChunkSize = 128
+
+// RTMPMessage is a message reassembled from a RTMP chunk stream.
+type RTMPMessage struct {
+	ChunkStreamID uint32
+	Timestamp     uint32
+	TypeID        uint8
+	StreamID      uint32
+	Payload       []byte
+}
+
+type RTMPProtocol struct {
+	maxRecv int
+	maxSend int
+}
+
+// RTMP parses and serializes the chunk stream after the handshake is done.
+// Set Chunk Size and Abort messages are applied by the codec and still
+// delivered to the caller.
+func RTMP(maxRecv, maxSend int) *RTMPProtocol {
+	return &RTMPProtocol{
+		maxRecv: maxRecv,
+		maxSend: maxSend,
+	}
+}
+
+func (p *RTMPProtocol) NewCodec(rw io.ReadWriter) (link.Codec, error) {
+	return &rtmpCodec{
+		rw:            rw,
+		reader:        bufio.NewReader(rw),
+		recvChunkSize: rtmpDefaultChunkSize,
+		sendChunkSize: rtmpDefaultChunkSize,
+		recvStreams:   make(map[uint32]*rtmpChunkStream),
+		sendStreams:   make(map[uint32]*rtmpChunkStream),
+		RTMPProtocol:  p,
+	}, nil
+}
+
+type rtmpChunkStream struct {
+	timestamp uint32
+	delta     uint32
+	length    uint32
+	typeID    uint8
+	streamID  uint32
+	extended  bool
+	payload   []byte
+	partial   bool
+}
+
+type rtmpCodec struct {
+	rw            io.ReadWriter
+	reader        *bufio.Reader
+	head          [11]byte
+	sendBuf       bytes.Buffer
+	recvChunkSize uint32
+	sendChunkSize uint32
+	recvStreams   map[uint32]*rtmpChunkStream
+	sendStreams   map[uint32]*rtmpChunkStream
+	partials      int
+	*RTMPProtocol
+}
+
+func (c *rtmpCodec) Receive() (interface{}, error) {
+	for {
+		msg, err := c.readChunk()
+		if err != nil {
+			return nil, err
+		}
+		if msg == nil {
+			continue
+		}
+		switch msg.TypeID {
+		case RTMPSetChunkSize:
+			if len(msg.Payload) < 4 {
+				return nil, ErrBadRTMPChunk
+			}
+			size := binary.BigEndian.Uint32(msg.Payload) & 0x7fffffff
+			if size == 0 {
+				return nil, ErrBadRTMPChunk
+			}
+			c.recvChunkSize = size
+		case RTMPAbort:
+			if len(msg.Payload) < 4 {
+				return nil, ErrBadRTMPChunk
+			}
+			if cs, exists := c.recvStreams[binary.BigEndian.Uint32(msg.Payload)]; exists {
+				c.endMessage(cs)
+			}
+		}
+		return msg, nil
+	}
+}
+
+func (c *rtmpCodec) readChunk() (*RTMPMessage, error) {
+	b, err := c.reader.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+	format := b >> 6
+	csid := uint32(b & 0x3f)
+	switch csid {
+	case 0:
+		if _, err := io.ReadFull(c.reader, c.head[:1]); err != nil {
+			return nil, err
+		}
+		csid = 64 + uint32(c.head[0])
+	case 1:
+		if _, err := io.ReadFull(c.reader, c.head[:2]); err != nil {
+			return nil, err
+		}
+		csid = 64 + uint32(c.head[0]) + uint32(c.head[1])<<8
+	}
+
+	cs, exists := c.recvStreams[csid]
+	if !exists {
+		if format != 0 {
+			return nil, ErrBadRTMPChunk
+		}
+		cs = &rtmpChunkStream{}
+		c.recvStreams[csid] = cs
+	}
+
+	var ts uint32
+	switch format {
+	case 0:
+		if _, err := io.ReadFull(c.reader, c.head[:11]); err != nil {
+			return nil, err
+		}
+		ts = rtmpUint24(c.head[0:])
+		cs.length = rtmpUint24(c.head[3:])
+		cs.typeID = c.head[6]
+		cs.streamID = binary.LittleEndian.Uint32(c.head[7:])
+	case 1:
+		if _, err := io.ReadFull(c.reader, c.head[:7]); err != nil {
+			return nil, err
+		}
+		ts = rtmpUint24(c.head[0:])
+		cs.length = rtmpUint24(c.head[3:])
+		cs.typeID = c.head[6]
+	case 2:
+		if _, err := io.ReadFull(c.reader, c.head[:3]); err != nil {
+			return nil, err
+		}
+		ts = rtmpUint24(c.head[0:])
+	}
+	if format != 3 {
+		cs.extended = ts == 0xffffff
+	}
+	if cs.extended {
+		if _, err := io.ReadFull(c.reader, c.head[:4]); err != nil {
+			return nil, err
+		}
+		ts = binary.BigEndian.Uint32(c.head[:4])
+	}
+
+	switch {
+	case format == 0:
+		cs.timestamp = ts
+		cs.delta = 0
+		c.endMessage(cs)
+	case format != 3:
+		cs.delta = ts
+		cs.timestamp += ts
+		c.endMessage(cs)
+	case !cs.partial:
+		cs.timestamp += cs.delta
+	}
+
+	if !cs.partial {
+		if cs.length > uint32(c.maxRecv) {
+			return nil, ErrTooLargePacket
+		}
+		// The payload grows as chunks arrive instead of trusting the length
+		// declared, and the partial messages are limited, so a peer can't
+		// make us allocate much more than it sends.
+		if c.partials >= rtmpMaxPartials {
+			return nil, ErrTooManyRTMPStreams
+		}
+		c.partials++
+		cs.partial = true
+		size := cs.length
+		if size > c.recvChunkSize {
+			size = c.recvChunkSize
+		}
+		cs.payload = make([]byte, 0, size)
+	}
+	n := cs.length - uint32(len(cs.payload))
+	if n > c.recvChunkSize {
+		n = c.recvChunkSize
+	}
+	m := len(cs.payload)
+	cs.payload = append(cs.payload, make([]byte, n)...)
+	if _, err := io.ReadFull(c.reader, cs.payload[m:]); err != nil {
+		return nil, err
+	}
+	if uint32(len(cs.payload)) < cs.length {
+		return nil, nil
+	}
+
+	msg := &RTMPMessage{
+		ChunkStreamID: csid,
+		Timestamp:     cs.timestamp,
+		TypeID:        cs.typeID,
+		StreamID:      cs.streamID,
+		Payload:       cs.payload,
+	}
+	c.endMessage(cs)
+	return msg, nil
+}
+
+func (c *rtmpCodec) endMessage(cs *rtmpChunkStream) {
+	if cs.partial {
+		cs.partial = false
+		c.partials--
+	}
+	cs.payload = nil
+}
+
+func (c *rtmpCodec) Send(m interface{}) error {
+	msg := m.(*RTMPMessage)
+	if len(msg.Payload) > c.maxSend || len(msg.Payload) > 0xffffff {
+		return ErrTooLargePacket
+	}
+	if msg.ChunkStreamID < 2 || msg.ChunkStreamID > 65599 {
+		return ErrBadRTMPChunk
+	}
+	length := uint32(len(msg.Payload))
+
+	cs, exists := c.sendStreams[msg.ChunkStreamID]
+	if !exists {
+		cs = &rtmpChunkStream{}
+		c.sendStreams[msg.ChunkStreamID] = cs
+	}
+
+	var format byte
+	var ts uint32
+	switch {
+	case !exists || msg.StreamID != cs.streamID || msg.Timestamp < cs.timestamp:
+		format, ts = 0, msg.Timestamp
+		cs.delta = 0
+	case length != cs.length || msg.TypeID != cs.typeID:
+		format, ts = 1, msg.Timestamp-cs.timestamp
+		cs.delta = ts
+	// Format 3 repeats the extended timestamp of the last header, so it
+	// needs one of the delta exactly when the delta is extended.
+	case msg.Timestamp-cs.timestamp != cs.delta || cs.extended != (cs.delta >= 0xffffff):
+		format, ts = 2, msg.Timestamp-cs.timestamp
+		cs.delta = ts
+	default:
+		format, ts = 3, cs.delta
+	}
+	cs.timestamp = msg.Timestamp
+	cs.length = length
+	cs.typeID = msg.TypeID
+	cs.streamID = msg.StreamID
+
+	c.sendBuf.Reset()
+	c.writeBasicHeader(format, msg.ChunkStreamID)
+	if format != 3 {
+		cs.extended = ts >= 0xffffff
+		field := ts
+		if cs.extended {
+			field = 0xffffff
+		}
+		c.sendBuf.Write([]byte{byte(field >> 16), byte(field >> 8), byte(field)})
+		if format <= 1 {
+			c.sendBuf.Write([]byte{byte(length >> 16), byte(length >> 8), byte(length), msg.TypeID})
+		}
+		if format == 0 {
+			var sid [4]byte
+			binary.LittleEndian.PutUint32(sid[:], msg.StreamID)
+			c.sendBuf.Write(sid[:])
+		}
+	}
+
+	payload := msg.Payload
+	for {
+		if cs.extended {
+			var ext [4]byte
+			binary.BigEndian.PutUint32(ext[:], ts)
+			c.sendBuf.Write(ext[:])
+		}
+		n := len(payload)
+		if n > int(c.sendChunkSize) {
+			n = int(c.sendChunkSize)
+		}
+		c.sendBuf.Write(payload[:n])
+		payload = payload[n:]
+		if len(payload) == 0 {
+			break
+		}
+		c.writeBasicHeader(3, msg.ChunkStreamID)
+	}
+
+	if _, err := c.rw.Write(c.sendBuf.Bytes()); err != nil {
+		return err
+	}
+	if msg.TypeID == RTMPSetChunkSize && len(msg.Payload) >= 4 {
+		if size := binary.BigEndian.Uint32(msg.Payload) & 0x7fffffff; size > 0 {
+			c.sendChunkSize = size
+		}
+	}
+	return nil
+}
+
+func (c *rtmpCodec) writeBasicHeader(format byte, csid uint32) {
+	switch {
+	case csid < 64:
+		c.sendBuf.WriteByte(format<<6 | byte(csid))
+	case csid < 320:
+		c.sendBuf.Write([]byte{format << 6, byte(csid - 64)})
+	default:
+		c.sendBuf.Write([]byte{format<<6 | 1, byte(csid - 64), byte((csid - 64) >> 8)})
+	}
+}
+
+func (c *rtmpCodec) Close() error {
+	if closer, ok := c.rw.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+func rtmpUint24(b []byte) uint32 {
+	return uint32(b[0])<<16 | uint32(b[1])<<8 | uint32(b[2])
+}
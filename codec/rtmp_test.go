@@ -0,0 +1,92 @@
+package codec
+
+import (
+	"bytes"
+	"math/rand"
+	"reflect"
+	"testing"
+)
+
+func Test_RTMP(t *testing.T) {
+	var stream bytes.Buffer
+
+	codec, _ := RTMP(1024*1024, 1024*1024).NewCodec(&stream)
+
+	big := make([]byte, 100*1024)
+	rand.Read(big)
+
+	msgs := []*RTMPMessage{
+		{ChunkStreamID: 3, Timestamp: 0, TypeID: RTMPCommandAMF0, Payload: []byte("connect")},
+		{ChunkStreamID: 4, Timestamp: 1000, TypeID: RTMPAudio, StreamID: 1, Payload: make([]byte, 300)},
+		{ChunkStreamID: 4, Timestamp: 1020, TypeID: RTMPAudio, StreamID: 1, Payload: make([]byte, 300)},
+		{ChunkStreamID: 4, Timestamp: 1040, TypeID: RTMPAudio, StreamID: 1, Payload: make([]byte, 300)},
+		{ChunkStreamID: 4, Timestamp: 1045, TypeID: RTMPAudio, StreamID: 1, Payload: make([]byte, 10)},
+		{ChunkStreamID: 2, TypeID: RTMPSetChunkSize, Payload: []byte{0, 0, 0x10, 0}},
+		{ChunkStreamID: 6, Timestamp: 0x1234567, TypeID: RTMPVideo, StreamID: 1, Payload: big},
+		{ChunkStreamID: 6, Timestamp: 0x1234600, TypeID: RTMPVideo, StreamID: 1, Payload: big},
+		{ChunkStreamID: 300, Timestamp: 5, TypeID: RTMPDataAMF0, StreamID: 1, Payload: []byte{}},
+		{ChunkStreamID: 40000, Timestamp: 5, TypeID: RTMPDataAMF0, StreamID: 1, Payload: []byte("x")},
+	}
+	for _, msg := range msgs {
+		if err := codec.Send(msg); err != nil {
+			t.Fatal(err)
+		}
+		recv, err := codec.Receive()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(msg, recv) {
+			t.Fatalf("message not match: %v, %v", msg.Timestamp, recv.(*RTMPMessage).Timestamp)
+		}
+	}
+	if stream.Len() != 0 {
+		t.Fatalf("unread bytes: %d", stream.Len())
+	}
+
+	if err := codec.Send(&RTMPMessage{ChunkStreamID: 1}); err != ErrBadRTMPChunk {
+		t.Fatalf("expected bad chunk, got %v", err)
+	}
+}
+
+func Test_RTMPExtendedDelta(t *testing.T) {
+	var stream bytes.Buffer
+
+	codec, _ := RTMP(1024, 1024).NewCodec(&stream)
+
+	for _, ts := range []uint32{0, 0x1000000} {
+		codec.Send(&RTMPMessage{ChunkStreamID: 3, Timestamp: ts, TypeID: RTMPVideo, StreamID: 1, Payload: []byte("a")})
+		if _, err := codec.Receive(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The same extended delta again is sent with format 3 and the delta in
+	// its extended timestamp.
+	msg := &RTMPMessage{ChunkStreamID: 3, Timestamp: 0x2000000, TypeID: RTMPVideo, StreamID: 1, Payload: []byte("b")}
+	codec.Send(msg)
+	if wire := stream.Bytes(); !bytes.Equal(wire, []byte{3<<6 | 3, 1, 0, 0, 0, 'b'}) {
+		t.Fatalf("unexpected chunk: % x", wire)
+	}
+	recv, err := codec.Receive()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(msg, recv) {
+		t.Fatalf("message not match: %v, %v", msg.Timestamp, recv.(*RTMPMessage).Timestamp)
+	}
+}
+
+func Test_RTMPTooManyStreams(t *testing.T) {
+	var stream bytes.Buffer
+	codec, _ := RTMP(1024*1024, 1024*1024).NewCodec(&stream)
+
+	// Each chunk stream starts a message of 1MB but sends only one chunk.
+	for csid := 64; csid < 64+rtmpMaxPartials+1; csid++ {
+		stream.Write([]byte{0, byte(csid - 64)})
+		stream.Write([]byte{0, 0, 0, 0x10, 0, 0, 8, 1, 0, 0, 0})
+		stream.Write(make([]byte, 128))
+	}
+	if _, err := codec.Receive(); err != ErrTooManyRTMPStreams {
+		t.Fatalf("expected too many streams, got %v", err)
+	}
+}
@@ -0,0 +1,65 @@
+package codec
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+var sharedBufferPool = sync.Pool{
+	New: func() interface{} {
+		return &SharedBuffer{}
+	},
+}
+
+// SharedBuffer is a reference counted read-only payload. A broadcaster
+// Retains it once per session before Send, the codec Releases it after the
+// payload is written, so all send queues hold the same bytes. The buffer goes
+// back to the pool when the last reference is released.
+type SharedBuffer struct {
+	refs int32
+	data []byte
+}
+
+// NewSharedBuffer returns a buffer of n bytes with one reference owned by the caller.
+func NewSharedBuffer(n int) *SharedBuffer {
+	b := sharedBufferPool.Get().(*SharedBuffer)
+	if cap(b.data) < n {
+		b.data = make([]byte, n)
+	}
+	b.data = b.data[:n]
+	b.refs = 1
+	return b
+}
+
+// Bytes returns the payload, it must be filled before the buffer is shared
+// and not be modified after that.
+func (b *SharedBuffer) Bytes() []byte {
+	return b.data
+}
+
+func (b *SharedBuffer) Retain() *SharedBuffer {
+	if atomic.AddInt32(&b.refs, 1) <= 1 {
+		panic("SharedBuffer: retain after free")
+	}
+	return b
+}
+
+func (b *SharedBuffer) Release() {
+	switch refs := atomic.AddInt32(&b.refs, -1); {
+	case refs == 0:
+		sharedBufferPool.Put(b)
+	case refs < 0:
+		panic("SharedBuffer: release after free")
+	}
+}
+
+// Writable returns a payload that is safe to modify. It is the buffer itself
+// when the caller holds the only reference, otherwise a copy.
+func (b *SharedBuffer) Writable() []byte {
+	if atomic.LoadInt32(&b.refs) == 1 {
+		return b.data
+	}
+	data := make([]byte, len(b.data))
+	copy(data, b.data)
+	return data
+}
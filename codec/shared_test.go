@@ -0,0 +1,49 @@
+package codec
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+func Test_SharedBuffer(t *testing.T) {
+	var streams [10]bytes.Buffer
+
+	protocol := FixLen(Raw(), 2, binary.BigEndian, 1024, 1024)
+
+	shared := NewSharedBuffer(5)
+	copy(shared.Bytes(), "hello")
+	for i := range streams {
+		codec, _ := protocol.NewCodec(&streams[i])
+		if err := codec.Send(shared.Retain()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if shared.refs != 1 {
+		t.Fatalf("refs not match: %d", shared.refs)
+	}
+
+	w := shared.Writable()
+	w[0] = 'j'
+	shared.Release()
+
+	for i := range streams {
+		codec, _ := protocol.NewCodec(&streams[i])
+		recv, err := codec.Receive()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(recv.(*InBuffer).Bytes()) != "hello" {
+			t.Fatalf("message not match: %q", recv.(*InBuffer).Bytes())
+		}
+	}
+
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Fatal("release after free not detected")
+			}
+		}()
+		shared.Release()
+	}()
+}
@@ -0,0 +1,216 @@
+package codec
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/funny/link"
+)
+
+var ErrBadSIPMessage = errors.New("Bad SIP Message")
+
+type SIPHeader struct {
+	Name  string
+	Value string
+}
+
+type SIPMessage struct {
+	StartLine string
+	Headers   []SIPHeader
+	Body      []byte
+}
+
+// Header returns the first value of the named header, compact forms not included.
+func (m *SIPMessage) Header(name string) string {
+	for _, h := range m.Headers {
+		if strings.EqualFold(h.Name, name) {
+			return h.Value
+		}
+	}
+	return ""
+}
+
+// SIPKeepAlive is the RFC 5626 CRLF keepalive. Received pings are answered
+// by the codec and not delivered, received pongs are delivered as SIPPong. A
+// CRLF received while a ping sent is unanswered is its pong, otherwise it
+// starts a ping.
+type SIPKeepAlive int
+
+const (
+	SIPPing SIPKeepAlive = iota
+	SIPPong
+)
+
+type SIPProtocol struct {
+	maxHeader int
+	maxBody   int
+}
+
+func SIP(maxHeader, maxBody int) *SIPProtocol {
+	return &SIPProtocol{
+		maxHeader: maxHeader,
+		maxBody:   maxBody,
+	}
+}
+
+func (p *SIPProtocol) NewCodec(rw io.ReadWriter) (link.Codec, error) {
+	return &sipCodec{
+		rw:          rw,
+		reader:      bufio.NewReaderSize(rw, p.maxHeader),
+		SIPProtocol: p,
+	}, nil
+}
+
+type sipCodec struct {
+	rw        io.ReadWriter
+	reader    *bufio.Reader
+	sendMutex sync.Mutex
+	sendBuf   bytes.Buffer
+	pings     int32 // the pings sent waiting for pongs
+	*SIPProtocol
+}
+
+func (c *sipCodec) Receive() (interface{}, error) {
+	var msg *SIPMessage
+	var total int
+	for {
+		line, err := readLine(c.reader, []byte("\n"))
+		if err != nil {
+			return nil, err
+		}
+		line = bytes.TrimSuffix(line, []byte("\r"))
+		if total += len(line); total > c.maxHeader {
+			return nil, ErrTooLargePacket
+		}
+
+		if msg == nil {
+			if len(line) != 0 {
+				msg = &SIPMessage{StartLine: string(line)}
+				continue
+			}
+			if c.pong() {
+				return SIPPong, nil
+			}
+			// A ping may arrive in two segments, so wait for the second CRLF
+			// instead of checking what is buffered.
+			if next, err := c.reader.Peek(2); err != nil || string(next) != "\r\n" {
+				return SIPPong, nil
+			}
+			c.reader.Discard(2)
+			if err := c.write([]byte("\r\n")); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		if len(line) == 0 {
+			break
+		}
+		if line[0] == ' ' || line[0] == '\t' {
+			if len(msg.Headers) == 0 {
+				return nil, ErrBadSIPMessage
+			}
+			h := &msg.Headers[len(msg.Headers)-1]
+			h.Value += " " + strings.TrimSpace(string(line))
+			continue
+		}
+		i := bytes.IndexByte(line, ':')
+		if i <= 0 {
+			return nil, ErrBadSIPMessage
+		}
+		msg.Headers = append(msg.Headers, SIPHeader{
+			Name:  strings.TrimSpace(string(line[:i])),
+			Value: strings.TrimSpace(string(line[i+1:])),
+		})
+	}
+
+	size := 0
+	for _, h := range msg.Headers {
+		if strings.EqualFold(h.Name, "Content-Length") || h.Name == "l" {
+			n, err := strconv.Atoi(h.Value)
+			if err != nil || n < 0 {
+				return nil, ErrBadSIPMessage
+			}
+			size = n
+			break
+		}
+	}
+	if size > c.maxBody {
+		return nil, ErrTooLargePacket
+	}
+	msg.Body = make([]byte, size)
+	if _, err := io.ReadFull(c.reader, msg.Body); err != nil {
+		return nil, err
+	}
+	return msg, nil
+}
+
+// pong takes a ping waiting for its pong.
+func (c *sipCodec) pong() bool {
+	for {
+		pings := atomic.LoadInt32(&c.pings)
+		if pings == 0 {
+			return false
+		}
+		if atomic.CompareAndSwapInt32(&c.pings, pings, pings-1) {
+			return true
+		}
+	}
+}
+
+func (c *sipCodec) Send(m interface{}) error {
+	switch m {
+	case SIPPing:
+		atomic.AddInt32(&c.pings, 1)
+		return c.write([]byte("\r\n\r\n"))
+	case SIPPong:
+		return c.write([]byte("\r\n"))
+	}
+
+	msg := m.(*SIPMessage)
+	if len(msg.Body) > c.maxBody {
+		return ErrTooLargePacket
+	}
+
+	c.sendMutex.Lock()
+	defer c.sendMutex.Unlock()
+
+	c.sendBuf.Reset()
+	c.sendBuf.WriteString(msg.StartLine)
+	c.sendBuf.WriteString("\r\n")
+	for _, h := range msg.Headers {
+		if strings.EqualFold(h.Name, "Content-Length") || h.Name == "l" {
+			continue
+		}
+		c.sendBuf.WriteString(h.Name)
+		c.sendBuf.WriteString(": ")
+		c.sendBuf.WriteString(h.Value)
+		c.sendBuf.WriteString("\r\n")
+	}
+	c.sendBuf.WriteString("Content-Length: ")
+	c.sendBuf.WriteString(strconv.Itoa(len(msg.Body)))
+	c.sendBuf.WriteString("\r\n\r\n")
+	c.sendBuf.Write(msg.Body)
+	_, err := c.rw.Write(c.sendBuf.Bytes())
+	return err
+}
+
+func (c *sipCodec) write(b []byte) error {
+	c.sendMutex.Lock()
+	defer c.sendMutex.Unlock()
+	_, err := c.rw.Write(b)
+	return err
+}
+
+func (c *sipCodec) Close() error {
+	if closer, ok := c.rw.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
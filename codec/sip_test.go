@@ -0,0 +1,110 @@
+package codec
+
+import (
+	"bytes"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+	"testing/iotest"
+	"time"
+)
+
+func Test_SIP(t *testing.T) {
+	var stream bytes.Buffer
+
+	codec, _ := SIP(1024, 1024).NewCodec(&stream)
+
+	msg := &SIPMessage{
+		StartLine: "INVITE sip:bob@example.com SIP/2.0",
+		Headers: []SIPHeader{
+			{"Via", "SIP/2.0/TCP pc33.example.com;branch=z9hG4bK776asdhds"},
+			{"To", "Bob <sip:bob@example.com>"},
+			{"Call-ID", "a84b4c76e66710"},
+			{"Content-Length", "4"},
+		},
+		Body: []byte("v=0\n"),
+	}
+	if err := codec.Send(msg); err != nil {
+		t.Fatal(err)
+	}
+	recv, err := codec.Receive()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(msg, recv) {
+		t.Fatalf("message not match: %#v, %#v", msg, recv)
+	}
+
+	stream.WriteString("OPTIONS sip:a@b SIP/2.0\r\nSubject: long\r\n  folded\r\nl: 0\r\n\r\n")
+	recv, err = codec.Receive()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if recv.(*SIPMessage).Header("subject") != "long folded" || len(recv.(*SIPMessage).Body) != 0 {
+		t.Fatalf("message not match: %#v", recv)
+	}
+
+	// A ping of the peer is answered.
+	stream.WriteString("\r\n\r\nOPTIONS sip:a@b SIP/2.0\r\nl: 0\r\n\r\n")
+	recv, err = codec.Receive()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := recv.(*SIPMessage); !ok || stream.String() != "\r\n" {
+		t.Fatalf("keepalive not match: %v, %q", recv, stream.String())
+	}
+	stream.Reset()
+
+	stream.WriteString("MESSAGE sip:a@b SIP/2.0\r\nContent-Length: 2048\r\n\r\n")
+	if _, err := codec.Receive(); err != ErrTooLargePacket {
+		t.Fatalf("expected too large packet, got %v", err)
+	}
+}
+
+func Test_SIPSplitPing(t *testing.T) {
+	var out bytes.Buffer
+	in := iotest.OneByteReader(strings.NewReader("\r\n\r\nOPTIONS sip:a@b SIP/2.0\r\nl: 0\r\n\r\n"))
+	codec, _ := SIP(1024, 1024).NewCodec(struct {
+		io.Reader
+		io.Writer
+	}{in, &out})
+
+	recv, err := codec.Receive()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := recv.(*SIPMessage); !ok || out.String() != "\r\n" {
+		t.Fatalf("ping not answered: %v, %q", recv, out.String())
+	}
+}
+
+func Test_SIPPong(t *testing.T) {
+	var out bytes.Buffer
+	in, peer := io.Pipe()
+	defer peer.Close()
+	codec, _ := SIP(1024, 1024).NewCodec(struct {
+		io.Reader
+		io.Writer
+	}{in, &out})
+
+	codec.Send(SIPPing)
+	if out.String() != "\r\n\r\n" {
+		t.Fatalf("ping not match: %q", out.String())
+	}
+	go peer.Write([]byte("\r\n"))
+	// The pong is delivered without waiting for more bytes.
+	received := make(chan interface{}, 1)
+	go func() {
+		recv, _ := codec.Receive()
+		received <- recv
+	}()
+	select {
+	case recv := <-received:
+		if recv != SIPPong {
+			t.Fatalf("expected pong, got %v", recv)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("pong not delivered")
+	}
+}
@@ -0,0 +1,38 @@
+package codec
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+)
+
+// SpillFile is delivered instead of a decoded message when a packet body is
+// larger than the spill threshold. The body is kept in a temporary file;
+// the receiver must Close it to remove the file.
+type SpillFile struct {
+	*os.File
+	Size int64
+}
+
+func (f *SpillFile) Close() error {
+	err := f.File.Close()
+	os.Remove(f.File.Name())
+	return err
+}
+
+func spillToDisk(r io.Reader, dir string, size int64) (*SpillFile, error) {
+	file, err := ioutil.TempFile(dir, "link-spill-")
+	if err != nil {
+		return nil, err
+	}
+	spill := &SpillFile{file, size}
+	if _, err := io.CopyN(file, r, size); err != nil {
+		spill.Close()
+		return nil, err
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		spill.Close()
+		return nil, err
+	}
+	return spill, nil
+}
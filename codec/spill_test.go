@@ -0,0 +1,63 @@
+package codec
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"testing"
+)
+
+func Test_SpillToDisk(t *testing.T) {
+	var stream bytes.Buffer
+
+	protocol := FixLen(BytesTestProtocol(), 4, binary.BigEndian, 1024*1024, 1024*1024)
+	protocol.SpillToDisk(1024, "")
+	codec, _ := protocol.NewCodec(&stream)
+
+	small := []byte("small")
+	big := make([]byte, 100*1024)
+	rand.Read(big)
+
+	codec.Send(small)
+	codec.Send(big)
+	codec.Send(small)
+
+	recv, err := codec.Receive()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(recv.([]byte), small) {
+		t.Fatal("message not match")
+	}
+
+	recv, err = codec.Receive()
+	if err != nil {
+		t.Fatal(err)
+	}
+	spill := recv.(*SpillFile)
+	if spill.Size != int64(len(big)) {
+		t.Fatalf("spill size not match: %d", spill.Size)
+	}
+	part := make([]byte, 100)
+	if _, err := spill.ReadAt(part, 5000); err != nil || !bytes.Equal(part, big[5000:5100]) {
+		t.Fatal("spill ReadAt not match")
+	}
+	data, err := ioutil.ReadAll(spill)
+	if err != nil || !bytes.Equal(data, big) {
+		t.Fatal("spill data not match")
+	}
+	spill.Close()
+	if _, err := os.Stat(spill.Name()); !os.IsNotExist(err) {
+		t.Fatal("spill file not removed")
+	}
+
+	recv, err = codec.Receive()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(recv.([]byte), small) {
+		t.Fatal("message not match")
+	}
+}
@@ -0,0 +1,29 @@
+package codec
+
+import (
+	"io"
+	"io/ioutil"
+)
+
+// PacketStream is delivered instead of a decoded message when a packet body
+// is larger than the stream threshold. The body is read directly from the
+// connection; any unread remainder is skipped by the next Receive.
+type PacketStream struct {
+	Size int
+	io.LimitedReader
+}
+
+func newPacketStream(r io.Reader, size int) *PacketStream {
+	return &PacketStream{
+		Size:          size,
+		LimitedReader: io.LimitedReader{R: r, N: int64(size)},
+	}
+}
+
+func (s *PacketStream) skip() error {
+	if s.N > 0 {
+		_, err := io.Copy(ioutil.Discard, &s.LimitedReader)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,54 @@
+package codec
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"math/rand"
+	"testing"
+)
+
+func Test_PacketStream(t *testing.T) {
+	var stream bytes.Buffer
+
+	protocol := FixLen(BytesTestProtocol(), 4, binary.BigEndian, 1024*1024, 1024*1024)
+	protocol.StreamAbove(1024)
+	codec, _ := protocol.NewCodec(&stream)
+
+	big := make([]byte, 100*1024)
+	rand.Read(big)
+
+	codec.Send(big)
+	codec.Send(big)
+	codec.Send([]byte("small"))
+
+	recv, err := codec.Receive()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ps := recv.(*PacketStream)
+	if ps.Size != len(big) {
+		t.Fatalf("stream size not match: %d", ps.Size)
+	}
+	head := make([]byte, 16)
+	if _, err := io.ReadFull(ps, head); err != nil || !bytes.Equal(head, big[:16]) {
+		t.Fatal("stream head not match")
+	}
+
+	recv, err = codec.Receive()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var body bytes.Buffer
+	if _, err := io.Copy(&body, recv.(*PacketStream)); err != nil || !bytes.Equal(body.Bytes(), big) {
+		t.Fatal("stream body not match")
+	}
+
+	recv, err = codec.Receive()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(recv.([]byte), []byte("small")) {
+		t.Fatal("message not match")
+	}
+}
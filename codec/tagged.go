@@ -0,0 +1,131 @@
+package codec
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+
+	"github.com/funny/link"
+)
+
+var ErrNotTaggedMsg = errors.New("Not Tagged Message")
+
+const taggedHeadSize = 6
+
+// TaggedMsg is a body of the base protocol with its message type, it is
+// link.TypedMsg for Session.Dispatch.
+type TaggedMsg struct {
+	Type uint16
+	Body interface{}
+}
+
+func (msg *TaggedMsg) MsgType() uint16 {
+	return msg.Type
+}
+
+func (msg *TaggedMsg) MsgBody() interface{} {
+	return msg.Body
+}
+
+type TaggedProtocol struct {
+	base      link.Protocol
+	byteOrder binary.ByteOrder
+	maxRecv   int
+	maxSend   int
+	oversize  OversizePolicy
+}
+
+// Tagged frames packets with a 4 byte body length and a 2 byte message type.
+// Messages are received as *TaggedMsg and sent as TaggedMsg or *TaggedMsg.
+func Tagged(base link.Protocol, byteOrder binary.ByteOrder, maxRecv, maxSend int) *TaggedProtocol {
+	return &TaggedProtocol{
+		base:      base,
+		byteOrder: byteOrder,
+		maxRecv:   maxRecv,
+		maxSend:   maxSend,
+	}
+}
+
+// SetOversize sets what the codecs do with a received packet over the limit.
+func (p *TaggedProtocol) SetOversize(policy OversizePolicy) {
+	p.oversize = policy
+}
+
+func (p *TaggedProtocol) NewCodec(rw io.ReadWriter) (cc link.Codec, err error) {
+	codec := &taggedCodec{
+		rw:             rw,
+		TaggedProtocol: p,
+	}
+	codec.base, err = p.base.NewCodec(&codec.fixlenReadWriter)
+	if err != nil {
+		return
+	}
+	cc = codec
+	return
+}
+
+type taggedCodec struct {
+	base    link.Codec
+	head    [taggedHeadSize]byte
+	bodyBuf []byte
+	rw      io.ReadWriter
+	*TaggedProtocol
+	fixlenReadWriter
+}
+
+func (c *taggedCodec) Receive() (interface{}, error) {
+	if _, err := io.ReadFull(c.rw, c.head[:]); err != nil {
+		return nil, err
+	}
+	size := int64(c.byteOrder.Uint32(c.head[:]))
+	if size > int64(c.maxRecv) {
+		return nil, oversize(c.rw, size, c.maxRecv, c.oversize)
+	}
+	if int64(cap(c.bodyBuf)) < size {
+		c.bodyBuf = make([]byte, size, size+128)
+	}
+	buff := c.bodyBuf[:size]
+	if _, err := io.ReadFull(c.rw, buff); err != nil {
+		return nil, err
+	}
+	c.recvBuf.Reset(buff)
+	body, err := c.base.Receive()
+	if err != nil {
+		return nil, err
+	}
+	return &TaggedMsg{c.byteOrder.Uint16(c.head[4:]), body}, nil
+}
+
+func (c *taggedCodec) Send(msg interface{}) error {
+	var tagged *TaggedMsg
+	switch m := msg.(type) {
+	case TaggedMsg:
+		tagged = &m
+	case *TaggedMsg:
+		tagged = m
+	default:
+		return ErrNotTaggedMsg
+	}
+	// A zero placeholder, c.head is used by Receive.
+	var head [taggedHeadSize]byte
+	c.sendBuf.Reset()
+	c.sendBuf.Write(head[:])
+	if err := c.base.Send(tagged.Body); err != nil {
+		return err
+	}
+	buff := c.sendBuf.Bytes()
+	if len(buff)-taggedHeadSize > c.maxSend {
+		return ErrTooLargePacket
+	}
+	c.byteOrder.PutUint32(buff, uint32(len(buff)-taggedHeadSize))
+	c.byteOrder.PutUint16(buff[4:], tagged.Type)
+	_, err := c.rw.Write(buff)
+	return err
+}
+
+func (c *taggedCodec) Close() error {
+	if closer, ok := c.rw.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
@@ -0,0 +1,71 @@
+package codec
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"testing"
+
+	"github.com/funny/link"
+)
+
+func Test_Tagged(t *testing.T) {
+	var stream bytes.Buffer
+
+	codec, _ := Tagged(Raw(), binary.BigEndian, 1024, 1024).NewCodec(&stream)
+	codec.Send(TaggedMsg{1, []byte("hello")})
+	codec.Send(&TaggedMsg{0x0203, []byte{}})
+	if !bytes.Equal(stream.Bytes()[:6], []byte{0, 0, 0, 5, 0, 1}) {
+		t.Fatalf("bad head: %v", stream.Bytes()[:6])
+	}
+	if err := codec.Send([]byte("hello")); err != ErrNotTaggedMsg {
+		t.Fatalf("expected not tagged message, got %v", err)
+	}
+	if err := codec.Send(TaggedMsg{1, make([]byte, 1025)}); err != ErrTooLargePacket {
+		t.Fatalf("expected too large packet, got %v", err)
+	}
+
+	recv1, _ := codec.Receive()
+	msg1 := recv1.(*TaggedMsg)
+	body1 := msg1.Body.(*InBuffer).Clone()
+	recv2, _ := codec.Receive()
+	msg2 := recv2.(*TaggedMsg)
+	if msg1.Type != 1 || string(body1.Bytes()) != "hello" || msg2.Type != 0x0203 || len(msg2.Body.(*InBuffer).Bytes()) != 0 {
+		t.Fatalf("message not match: %v, %q, %v", msg1.Type, body1.Bytes(), msg2.Type)
+	}
+}
+
+func Test_TaggedDispatch(t *testing.T) {
+	protocol := Tagged(JsonTestProtocol(), binary.LittleEndian, 1024, 1024)
+	conn1, conn2 := net.Pipe()
+	codec1, _ := protocol.NewCodec(conn1)
+	codec2, _ := protocol.NewCodec(conn2)
+	client := link.NewSession(codec1, 0)
+	server := link.NewSession(codec2, 0)
+
+	go func() {
+		client.Send(TaggedMsg{1, &MyMessage1{"abc", 123}})
+		client.Send(TaggedMsg{2, &MyMessage2{456, "def"}})
+		client.Send(TaggedMsg{3, &MyMessage1{}})
+	}()
+
+	var got []interface{}
+	server.Handle(1, func(body interface{}) {
+		got = append(got, *body.(*MyMessage1))
+	})
+	server.Handle(2, func(body interface{}) {
+		got = append(got, *body.(*MyMessage2))
+	})
+	server.Handle(3, func(body interface{}) {
+		t.Fatal("removed handler called")
+	})
+	server.Handle(3, nil)
+
+	if err := server.Dispatch(); err != link.ErrNoHandler {
+		t.Fatalf("expected no handler, got %v", err)
+	}
+	if !server.IsClosed() || len(got) != 2 || got[0] != (MyMessage1{"abc", 123}) || got[1] != (MyMessage2{456, "def"}) {
+		t.Fatalf("messages not match: %v", got)
+	}
+	client.Close()
+}
@@ -0,0 +1,78 @@
+package codec
+
+import (
+	"io"
+
+	"github.com/funny/link"
+)
+
+type ThrottleProtocol struct {
+	base       link.Protocol
+	sendRate   int
+	recvRate   int
+	sendBucket *link.TokenBucket
+	recvBucket *link.TokenBucket
+}
+
+// Throttle shapes the bytes written and read by each codec to sendRate and
+// recvRate bytes per second, zero means unlimited.
+func Throttle(base link.Protocol, sendRate, recvRate int) *ThrottleProtocol {
+	return &ThrottleProtocol{
+		base:     base,
+		sendRate: sendRate,
+		recvRate: recvRate,
+	}
+}
+
+// SetGlobal makes all codecs of the protocol share the buckets too,
+// either of them can be nil.
+func (p *ThrottleProtocol) SetGlobal(sendBucket, recvBucket *link.TokenBucket) {
+	p.sendBucket = sendBucket
+	p.recvBucket = recvBucket
+}
+
+func (p *ThrottleProtocol) baseProtocol() link.Protocol {
+	return p.base
+}
+
+func (p *ThrottleProtocol) NewCodec(rw io.ReadWriter) (link.Codec, error) {
+	return p.base.NewCodec(&throttleReadWriter{
+		rw:         rw,
+		sendBucket: link.NewTokenBucket(p.sendRate, p.sendRate),
+		recvBucket: link.NewTokenBucket(p.recvRate, p.recvRate),
+		global:     p,
+	})
+}
+
+type throttleReadWriter struct {
+	rw         io.ReadWriter
+	sendBucket *link.TokenBucket
+	recvBucket *link.TokenBucket
+	global     *ThrottleProtocol
+}
+
+func (t *throttleReadWriter) Read(p []byte) (int, error) {
+	n, err := t.rw.Read(p)
+	if n > 0 {
+		t.recvBucket.Wait(n)
+		if t.global.recvBucket != nil {
+			t.global.recvBucket.Wait(n)
+		}
+	}
+	return n, err
+}
+
+func (t *throttleReadWriter) Write(p []byte) (int, error) {
+	t.sendBucket.Wait(len(p))
+	if t.global.sendBucket != nil {
+		t.global.sendBucket.Wait(len(p))
+	}
+	return t.rw.Write(p)
+}
+
+func (t *throttleReadWriter) Close() error {
+	if closer, ok := t.rw.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
@@ -0,0 +1,28 @@
+package codec
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+	"time"
+
+	"github.com/funny/link"
+)
+
+func Test_Throttle(t *testing.T) {
+	JsonTest(t, Throttle(FixLen(JsonTestProtocol(), 2, binary.BigEndian, 1024, 1024), 1024*1024, 1024*1024))
+
+	var stream bytes.Buffer
+
+	protocol := Throttle(FixLen(BytesTestProtocol(), 2, binary.BigEndian, 64*1024, 64*1024), 0, 0)
+	protocol.SetGlobal(link.NewTokenBucket(100*1024, 10*1024), nil)
+	codec, _ := protocol.NewCodec(&stream)
+
+	begin := time.Now()
+	for i := 0; i < 3; i++ {
+		codec.Send(make([]byte, 10*1024))
+	}
+	if d := time.Since(begin); d < 150*time.Millisecond {
+		t.Fatalf("send not throttled: %v", d)
+	}
+}
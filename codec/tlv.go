@@ -0,0 +1,172 @@
+package codec
+
+import (
+	"encoding/binary"
+	"io"
+
+	"github.com/funny/link"
+)
+
+type TLVProtocol struct {
+	base       link.Protocol
+	typeSize   int
+	lengthSize int
+	byteOrder  binary.ByteOrder
+	maxType    int
+	maxRecv    int
+	maxSend    int
+	oversize   OversizePolicy
+}
+
+// TLV frames packets with a type of typeSize bytes, 1 or 2, followed by the
+// body length of lengthSize bytes, 1, 2 or 4. Messages are received as
+// *TaggedMsg and sent as TaggedMsg or *TaggedMsg, like by Tagged.
+func TLV(base link.Protocol, typeSize, lengthSize int, byteOrder binary.ByteOrder, maxRecv, maxSend int) *TLVProtocol {
+	p := &TLVProtocol{
+		base:       base,
+		typeSize:   typeSize,
+		lengthSize: lengthSize,
+		byteOrder:  byteOrder,
+		maxRecv:    maxRecv,
+		maxSend:    maxSend,
+	}
+	switch typeSize {
+	case 1:
+		p.maxType = 0xff
+	case 2:
+		p.maxType = 0xffff
+	default:
+		panic("TLVProtocol: unsupported type size")
+	}
+	// uint64, 0xffffffff doesn't fit in the int of 32-bit platforms.
+	var maxSize uint64
+	switch lengthSize {
+	case 1:
+		maxSize = 0xff
+	case 2:
+		maxSize = 0xffff
+	case 4:
+		maxSize = 0xffffffff
+	default:
+		panic("TLVProtocol: unsupported length size")
+	}
+	if uint64(p.maxRecv) > maxSize {
+		p.maxRecv = int(maxSize)
+	}
+	if uint64(p.maxSend) > maxSize {
+		p.maxSend = int(maxSize)
+	}
+	return p
+}
+
+// SetOversize sets what the codecs do with a received packet over the limit.
+func (p *TLVProtocol) SetOversize(policy OversizePolicy) {
+	p.oversize = policy
+}
+
+func (p *TLVProtocol) NewCodec(rw io.ReadWriter) (cc link.Codec, err error) {
+	codec := &tlvCodec{
+		rw:          rw,
+		TLVProtocol: p,
+	}
+	codec.headBuf = codec.head[:p.typeSize+p.lengthSize]
+	codec.base, err = p.base.NewCodec(&codec.fixlenReadWriter)
+	if err != nil {
+		return
+	}
+	cc = codec
+	return
+}
+
+type tlvCodec struct {
+	base    link.Codec
+	head    [6]byte
+	headBuf []byte
+	bodyBuf []byte
+	rw      io.ReadWriter
+	*TLVProtocol
+	fixlenReadWriter
+}
+
+func (p *TLVProtocol) getUint(b []byte) uint64 {
+	switch len(b) {
+	case 1:
+		return uint64(b[0])
+	case 2:
+		return uint64(p.byteOrder.Uint16(b))
+	}
+	return uint64(p.byteOrder.Uint32(b))
+}
+
+func (p *TLVProtocol) putUint(b []byte, v int) {
+	switch len(b) {
+	case 1:
+		b[0] = byte(v)
+	case 2:
+		p.byteOrder.PutUint16(b, uint16(v))
+	default:
+		p.byteOrder.PutUint32(b, uint32(v))
+	}
+}
+
+func (c *tlvCodec) Receive() (interface{}, error) {
+	if _, err := io.ReadFull(c.rw, c.headBuf); err != nil {
+		return nil, err
+	}
+	n := c.getUint(c.headBuf[c.typeSize:])
+	if n > uint64(c.maxRecv) {
+		return nil, oversize(c.rw, int64(n), c.maxRecv, c.oversize)
+	}
+	size := int(n)
+	if cap(c.bodyBuf) < size {
+		c.bodyBuf = make([]byte, size, size+128)
+	}
+	buff := c.bodyBuf[:size]
+	if _, err := io.ReadFull(c.rw, buff); err != nil {
+		return nil, err
+	}
+	c.recvBuf.Reset(buff)
+	body, err := c.base.Receive()
+	if err != nil {
+		return nil, err
+	}
+	return &TaggedMsg{uint16(c.getUint(c.headBuf[:c.typeSize])), body}, nil
+}
+
+func (c *tlvCodec) Send(msg interface{}) error {
+	var tagged *TaggedMsg
+	switch m := msg.(type) {
+	case TaggedMsg:
+		tagged = &m
+	case *TaggedMsg:
+		tagged = m
+	default:
+		return ErrNotTaggedMsg
+	}
+	if int(tagged.Type) > c.maxType {
+		return ErrNotTaggedMsg
+	}
+	// A zero placeholder, c.head is used by Receive.
+	var head [6]byte
+	headSize := c.typeSize + c.lengthSize
+	c.sendBuf.Reset()
+	c.sendBuf.Write(head[:headSize])
+	if err := c.base.Send(tagged.Body); err != nil {
+		return err
+	}
+	buff := c.sendBuf.Bytes()
+	if len(buff)-headSize > c.maxSend {
+		return ErrTooLargePacket
+	}
+	c.putUint(buff[:c.typeSize], int(tagged.Type))
+	c.putUint(buff[c.typeSize:headSize], len(buff)-headSize)
+	_, err := c.rw.Write(buff)
+	return err
+}
+
+func (c *tlvCodec) Close() error {
+	if closer, ok := c.rw.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
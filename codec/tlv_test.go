@@ -0,0 +1,76 @@
+package codec
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"testing"
+)
+
+func Test_TLV(t *testing.T) {
+	for _, sizes := range [][2]int{{1, 1}, {1, 2}, {2, 2}, {2, 4}} {
+		var stream bytes.Buffer
+		codec, _ := TLV(Raw(), sizes[0], sizes[1], binary.LittleEndian, 1024, 1024).NewCodec(&stream)
+		if err := codec.Send(TaggedMsg{7, []byte("hello")}); err != nil {
+			t.Fatalf("%v: %v", sizes, err)
+		}
+		if err := codec.Send(&TaggedMsg{9, []byte{}}); err != nil {
+			t.Fatalf("%v: %v", sizes, err)
+		}
+		head := stream.Bytes()[:sizes[0]+sizes[1]]
+		if head[0] != 7 || head[sizes[0]] != 5 || stream.Len() != 2*len(head)+5 {
+			t.Fatalf("%v: bad head: %v", sizes, head)
+		}
+		recv1, _ := codec.Receive()
+		msg1 := recv1.(*TaggedMsg)
+		body1 := msg1.Body.(*InBuffer).Clone()
+		recv2, _ := codec.Receive()
+		msg2 := recv2.(*TaggedMsg)
+		if msg1.Type != 7 || string(body1.Bytes()) != "hello" || msg2.Type != 9 || msg2.Body.(*InBuffer).Len() != 0 {
+			t.Fatalf("%v: message not match: %v, %q, %v", sizes, msg1.Type, body1.Bytes(), msg2.Type)
+		}
+	}
+}
+
+func Test_TLVError(t *testing.T) {
+	var stream bytes.Buffer
+	codec, _ := TLV(Raw(), 1, 2, binary.BigEndian, 1024, 16).NewCodec(&stream)
+	if err := codec.Send([]byte("hello")); err != ErrNotTaggedMsg {
+		t.Fatalf("expected not tagged message, got %v", err)
+	}
+	if err := codec.Send(TaggedMsg{256, []byte{}}); err != ErrNotTaggedMsg {
+		t.Fatalf("expected not tagged message, got %v", err)
+	}
+	if err := codec.Send(TaggedMsg{1, make([]byte, 17)}); err != ErrTooLargePacket {
+		t.Fatalf("expected too large packet, got %v", err)
+	}
+	if stream.Len() != 0 {
+		t.Fatalf("failed sends written: %v", stream.Bytes())
+	}
+
+	codec, _ = TLV(Raw(), 1, 2, binary.BigEndian, 1024, 16).NewCodec(bytes.NewBuffer([]byte{1, 0x04, 0x01}))
+	if _, err := codec.Receive(); !errors.Is(err, ErrTooLargePacket) {
+		t.Fatalf("expected too large packet, got %v", err)
+	}
+	codec, _ = TLV(Raw(), 1, 2, binary.BigEndian, 1024, 16).NewCodec(bytes.NewBuffer([]byte{1, 0}))
+	if _, err := codec.Receive(); err != io.ErrUnexpectedEOF {
+		t.Fatalf("expected unexpected EOF, got %v", err)
+	}
+	codec, _ = TLV(Raw(), 1, 2, binary.BigEndian, 1024, 16).NewCodec(bytes.NewBuffer([]byte{1, 0, 3, 'a'}))
+	if _, err := codec.Receive(); err != io.ErrUnexpectedEOF {
+		t.Fatalf("expected unexpected EOF, got %v", err)
+	}
+	// The largest 4 byte length is too large, not negative on 32-bit.
+	codec, _ = TLV(Raw(), 1, 4, binary.BigEndian, 1024, 16).NewCodec(bytes.NewBuffer([]byte{1, 0xff, 0xff, 0xff, 0xff}))
+	if _, err := codec.Receive(); !errors.Is(err, ErrTooLargePacket) {
+		t.Fatalf("expected too large packet, got %v", err)
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Fatal("expected panic of a bad length size")
+		}
+	}()
+	TLV(Raw(), 1, 3, binary.BigEndian, 1024, 1024)
+}
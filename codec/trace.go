@@ -0,0 +1,139 @@
+package codec
+
+import (
+	"encoding/hex"
+	"io"
+	"log"
+	"net"
+
+	"github.com/funny/link"
+)
+
+// TraceEvent is a packet received or sent by a traced codec.
+type TraceEvent struct {
+	Send bool
+	// Addr is the remote address when the codec is over a net.Conn.
+	Addr net.Addr
+	Msg  interface{}
+	Err  error
+	// Wire is the bytes read or written for the packet, it is only valid
+	// during the call of the tracer.
+	Wire []byte
+}
+
+// Tracer is called by traced codecs, by the receiving goroutine for the
+// received packets and by the sending one for the sent packets.
+type Tracer func(event *TraceEvent)
+
+type traceProtocol struct {
+	base   link.Protocol
+	tracer Tracer
+}
+
+// Trace calls tracer with the bytes on the wire of each packet base receives
+// and sends, e.g. Trace(FixLen(Json(), ...), HexDumpTracer(logger, 256)) for
+// a server, or given to Session.SetProtocol for one session. The bytes a
+// buffering codec reads ahead are given with the packet they were read for.
+func Trace(base link.Protocol, tracer Tracer) link.Protocol {
+	return &traceProtocol{
+		base:   base,
+		tracer: tracer,
+	}
+}
+
+func (p *traceProtocol) baseProtocol() link.Protocol {
+	return p.base
+}
+
+func (p *traceProtocol) NewCodec(rw io.ReadWriter) (cc link.Codec, err error) {
+	codec := &traceCodec{tracer: p.tracer}
+	codec.rw.rw = rw
+	if conn, ok := rw.(net.Conn); ok {
+		codec.addr = conn.RemoteAddr()
+	}
+	codec.base, err = p.base.NewCodec(&codec.rw)
+	if err != nil {
+		return
+	}
+	cc = codec
+	return
+}
+
+type traceCodec struct {
+	base   link.Codec
+	tracer Tracer
+	addr   net.Addr
+	rw     traceReadWriter
+}
+
+func (c *traceCodec) Receive() (interface{}, error) {
+	c.rw.recvData = c.rw.recvData[:0]
+	msg, err := c.base.Receive()
+	c.tracer(&TraceEvent{Addr: c.addr, Msg: msg, Err: err, Wire: c.rw.recvData})
+	return msg, err
+}
+
+func (c *traceCodec) Send(msg interface{}) error {
+	c.rw.sendData = c.rw.sendData[:0]
+	err := c.base.Send(msg)
+	c.tracer(&TraceEvent{Send: true, Addr: c.addr, Msg: msg, Err: err, Wire: c.rw.sendData})
+	return err
+}
+
+func (c *traceCodec) Close() error {
+	return c.base.Close()
+}
+
+// traceReadWriter keeps the bytes of the current packets.
+type traceReadWriter struct {
+	rw       io.ReadWriter
+	recvData []byte
+	sendData []byte
+}
+
+func (t *traceReadWriter) Read(p []byte) (int, error) {
+	n, err := t.rw.Read(p)
+	t.recvData = append(t.recvData, p[:n]...)
+	return n, err
+}
+
+func (t *traceReadWriter) Write(p []byte) (int, error) {
+	n, err := t.rw.Write(p)
+	t.sendData = append(t.sendData, p[:n]...)
+	return n, err
+}
+
+func (t *traceReadWriter) Close() error {
+	if closer, ok := t.rw.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+// HexDumpTracer logs the direction, the address and the size of each packet
+// to logger, followed by a hex dump of its first limit bytes when limit is
+// not zero.
+func HexDumpTracer(logger *log.Logger, limit int) Tracer {
+	return func(event *TraceEvent) {
+		direction := "recv"
+		if event.Send {
+			direction = "send"
+		}
+		addr := "-"
+		if event.Addr != nil {
+			addr = event.Addr.String()
+		}
+		if event.Err != nil {
+			logger.Printf("%s %s %d bytes: %v", direction, addr, len(event.Wire), event.Err)
+		} else {
+			logger.Printf("%s %s %d bytes", direction, addr, len(event.Wire))
+		}
+		if limit != 0 && len(event.Wire) > 0 {
+			wire := event.Wire
+			if len(wire) > limit {
+				wire = wire[:limit]
+			}
+			logger.Print(hex.Dump(wire))
+		}
+	}
+}
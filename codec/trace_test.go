@@ -0,0 +1,45 @@
+package codec
+
+import (
+	"bytes"
+	"encoding/binary"
+	"log"
+	"strings"
+	"testing"
+)
+
+func Test_Trace(t *testing.T) {
+	var events []TraceEvent
+	tracer := func(event *TraceEvent) {
+		e := *event
+		e.Wire = append([]byte(nil), event.Wire...)
+		events = append(events, e)
+	}
+	JsonTest(t, Trace(FixLen(JsonTestProtocol(), 2, binary.BigEndian, 1024, 1024), tracer))
+	if len(events) == 0 || len(events)%2 != 0 {
+		t.Fatalf("events not match: %d", len(events))
+	}
+	send, recv := events[0], events[1]
+	if !send.Send || recv.Send || send.Err != nil || recv.Err != nil {
+		t.Fatalf("directions not match: %+v, %+v", send, recv)
+	}
+	if !bytes.Equal(send.Wire, recv.Wire) || int(binary.BigEndian.Uint16(send.Wire)) != len(send.Wire)-2 {
+		t.Fatalf("wire bytes not match: %q, %q", send.Wire, recv.Wire)
+	}
+
+	var logs bytes.Buffer
+	var stream bytes.Buffer
+	codec, _ := Trace(FixLen(Raw(), 2, binary.BigEndian, 1024, 1024), HexDumpTracer(log.New(&logs, "", 0), 3)).NewCodec(&stream)
+	codec.Send([]byte("hello"))
+	codec.Receive()
+	codec.Receive()
+	for _, line := range []string{
+		"send - 7 bytes\n00000000  00 05 68 ",
+		"recv - 7 bytes\n",
+		"recv - 0 bytes: EOF\n",
+	} {
+		if !strings.Contains(logs.String(), line) {
+			t.Fatalf("log not match: %q", logs.String())
+		}
+	}
+}
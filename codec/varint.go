@@ -0,0 +1,141 @@
+package codec
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"math"
+
+	"github.com/funny/link"
+)
+
+var ErrBadVarint = errors.New("Bad Varint")
+
+type VarintProtocol struct {
+	base       link.Protocol
+	maxRecv    int
+	maxSend    int
+	streamSize int
+	oversize   OversizePolicy
+}
+
+// Varint frames packets with a protobuf style varint length head, small
+// packets take a 1 byte head.
+func Varint(base link.Protocol, maxRecv, maxSend int) *VarintProtocol {
+	return &VarintProtocol{
+		base:    base,
+		maxRecv: maxRecv,
+		maxSend: maxSend,
+	}
+}
+
+// StreamAbove makes packets larger than threshold be delivered as *PacketStream
+// reading from the connection, instead of being buffered and decoded by base.
+func (p *VarintProtocol) StreamAbove(threshold int) {
+	p.streamSize = threshold
+}
+
+// SetOversize sets what the codecs do with a received packet over the limit.
+func (p *VarintProtocol) SetOversize(policy OversizePolicy) {
+	p.oversize = policy
+}
+
+func (p *VarintProtocol) NewCodec(rw io.ReadWriter) (cc link.Codec, err error) {
+	codec := &varintCodec{
+		rw:             rw,
+		VarintProtocol: p,
+	}
+	if br, ok := rw.(io.ByteReader); ok {
+		codec.headReader = br
+	} else {
+		codec.headReader = &byteReader{r: rw}
+	}
+	codec.base, err = p.base.NewCodec(&codec.fixlenReadWriter)
+	if err != nil {
+		return
+	}
+	cc = codec
+	return
+}
+
+// byteReader reads the head byte by byte, so no byte of the body is taken.
+type byteReader struct {
+	r io.Reader
+	b [1]byte
+}
+
+func (r *byteReader) ReadByte() (byte, error) {
+	_, err := io.ReadFull(r.r, r.b[:])
+	return r.b[0], err
+}
+
+type varintCodec struct {
+	base       link.Codec
+	headReader io.ByteReader
+	bodyBuf    []byte
+	stream     *PacketStream
+	rw         io.ReadWriter
+	*VarintProtocol
+	fixlenReadWriter
+}
+
+func (c *varintCodec) Receive() (interface{}, error) {
+	if c.stream != nil {
+		if err := c.stream.skip(); err != nil {
+			return nil, err
+		}
+		c.stream = nil
+	}
+	size, err := binary.ReadUvarint(c.headReader)
+	if err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return nil, err
+		}
+		return nil, ErrBadVarint
+	}
+	if size > math.MaxInt64 {
+		return nil, ErrBadVarint
+	}
+	if size > uint64(c.maxRecv) {
+		return nil, oversize(c.rw, int64(size), c.maxRecv, c.oversize)
+	}
+	if c.streamSize > 0 && size > uint64(c.streamSize) {
+		c.stream = newPacketStream(c.rw, int(size))
+		return c.stream, nil
+	}
+	if cap(c.bodyBuf) < int(size) {
+		c.bodyBuf = make([]byte, size, size+128)
+	}
+	buff := c.bodyBuf[:size]
+	if _, err := io.ReadFull(c.rw, buff); err != nil {
+		return nil, err
+	}
+	c.recvBuf.Reset(buff)
+	return c.base.Receive()
+}
+
+func (c *varintCodec) Send(msg interface{}) error {
+	// The head is right aligned in the reserved bytes once the size is known.
+	var head [binary.MaxVarintLen64]byte
+	c.sendBuf.Reset()
+	c.sendBuf.Write(head[:])
+	if err := c.base.Send(msg); err != nil {
+		return err
+	}
+	buff := c.sendBuf.Bytes()
+	size := len(buff) - len(head)
+	if size > c.maxSend {
+		return ErrTooLargePacket
+	}
+	n := binary.PutUvarint(head[:], uint64(size))
+	copy(buff[len(head)-n:], head[:n])
+	_, err := c.rw.Write(buff[len(head)-n:])
+	return err
+}
+
+func (c *varintCodec) Close() error {
+	if closer, ok := c.rw.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
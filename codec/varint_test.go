@@ -0,0 +1,70 @@
+package codec
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func Test_Varint(t *testing.T) {
+	JsonTest(t, Varint(JsonTestProtocol(), 1024, 1024))
+
+	var stream bytes.Buffer
+
+	codec, _ := Varint(Raw(), 300, 300).NewCodec(&stream)
+	codec.Send([]byte("hello"))
+	codec.Send(make([]byte, 300))
+	if stream.Len() != 1+5+2+300 || stream.Bytes()[0] != 5 {
+		t.Fatalf("bad heads: %v", stream.Bytes()[:8])
+	}
+	if err := codec.Send(make([]byte, 301)); err != ErrTooLargePacket {
+		t.Fatalf("expected too large packet, got %v", err)
+	}
+
+	recv1, _ := codec.Receive()
+	msg1 := recv1.(*InBuffer).Clone()
+	recv2, _ := codec.Receive()
+	if string(msg1.Bytes()) != "hello" || len(recv2.(*InBuffer).Bytes()) != 300 {
+		t.Fatalf("message not match: %q, %d", msg1.Bytes(), len(recv2.(*InBuffer).Bytes()))
+	}
+
+	stream.Write([]byte{0xad, 0x02})
+	if _, err := codec.Receive(); !errors.Is(err, ErrTooLargePacket) {
+		t.Fatalf("expected too large packet, got %v", err)
+	}
+	stream.Write(bytes.Repeat([]byte{0xff}, 11))
+	if _, err := codec.Receive(); err != ErrBadVarint {
+		t.Fatalf("expected bad varint, got %v", err)
+	}
+}
+
+func Test_VarintStream(t *testing.T) {
+	var stream bytes.Buffer
+
+	protocol := Varint(BytesTestProtocol(), 1024*1024, 1024*1024)
+	protocol.StreamAbove(1024)
+	codec, _ := protocol.NewCodec(&stream)
+
+	big := bytes.Repeat([]byte("0123456789"), 10*1024)
+	codec.Send(big)
+	codec.Send([]byte("small"))
+
+	recv, err := codec.Receive()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ps := recv.(*PacketStream)
+	head := make([]byte, 10)
+	if ps.Size != len(big) || ps.N != int64(len(big)) {
+		t.Fatalf("stream size not match: %d", ps.Size)
+	}
+	if _, err := ps.Read(head); err != nil || string(head) != "0123456789" {
+		t.Fatal("stream head not match")
+	}
+
+	// The unread remainder is skipped.
+	recv, err = codec.Receive()
+	if err != nil || string(recv.([]byte)) != "small" {
+		t.Fatalf("message not match: %v, %v", recv, err)
+	}
+}
@@ -0,0 +1,108 @@
+package codec
+
+import (
+	"encoding/binary"
+	"hash"
+	"math/bits"
+)
+
+const (
+	xxPrime1 uint32 = 2654435761
+	xxPrime2 uint32 = 2246822519
+	xxPrime3 uint32 = 3266489917
+	xxPrime4 uint32 = 668265263
+	xxPrime5 uint32 = 374761393
+)
+
+type xxh32 struct {
+	v     [4]uint32
+	buf   [16]byte
+	n     int
+	total uint64
+}
+
+// NewXXH32 returns a 32 bit xxHash with seed 0, a faster checksum than CRC32
+// on CPUs without CRC instructions, e.g. Checksum(Json(), NewXXH32).
+func NewXXH32() hash.Hash32 {
+	h := &xxh32{}
+	h.Reset()
+	return h
+}
+
+func (h *xxh32) Reset() {
+	// The sums wrap around, which constants are not allowed to.
+	p1, p2 := xxPrime1, xxPrime2
+	h.v = [4]uint32{p1 + p2, p2, 0, -p1}
+	h.n = 0
+	h.total = 0
+}
+
+func (h *xxh32) Size() int {
+	return 4
+}
+
+func (h *xxh32) BlockSize() int {
+	return 16
+}
+
+func xxRound(v, input uint32) uint32 {
+	return bits.RotateLeft32(v+input*xxPrime2, 13) * xxPrime1
+}
+
+func (h *xxh32) stripe(p []byte) {
+	h.v[0] = xxRound(h.v[0], binary.LittleEndian.Uint32(p))
+	h.v[1] = xxRound(h.v[1], binary.LittleEndian.Uint32(p[4:]))
+	h.v[2] = xxRound(h.v[2], binary.LittleEndian.Uint32(p[8:]))
+	h.v[3] = xxRound(h.v[3], binary.LittleEndian.Uint32(p[12:]))
+}
+
+func (h *xxh32) Write(p []byte) (int, error) {
+	n := len(p)
+	h.total += uint64(n)
+	if h.n > 0 {
+		m := copy(h.buf[h.n:], p)
+		h.n += m
+		p = p[m:]
+		if h.n < 16 {
+			return n, nil
+		}
+		h.stripe(h.buf[:])
+		h.n = 0
+	}
+	for ; len(p) >= 16; p = p[16:] {
+		h.stripe(p)
+	}
+	h.n = copy(h.buf[:], p)
+	return n, nil
+}
+
+func (h *xxh32) Sum32() uint32 {
+	var sum uint32
+	if h.total >= 16 {
+		sum = bits.RotateLeft32(h.v[0], 1) + bits.RotateLeft32(h.v[1], 7) +
+			bits.RotateLeft32(h.v[2], 12) + bits.RotateLeft32(h.v[3], 18)
+	} else {
+		sum = h.v[2] + xxPrime5
+	}
+	sum += uint32(h.total)
+
+	p := h.buf[:h.n]
+	for ; len(p) >= 4; p = p[4:] {
+		sum = bits.RotateLeft32(sum+binary.LittleEndian.Uint32(p)*xxPrime3, 17) * xxPrime4
+	}
+	for _, b := range p {
+		sum = bits.RotateLeft32(sum+uint32(b)*xxPrime5, 11) * xxPrime1
+	}
+
+	sum ^= sum >> 15
+	sum *= xxPrime2
+	sum ^= sum >> 13
+	sum *= xxPrime3
+	sum ^= sum >> 16
+	return sum
+}
+
+func (h *xxh32) Sum(b []byte) []byte {
+	sum := h.Sum32()
+	return append(b, byte(sum>>24), byte(sum>>16), byte(sum>>8), byte(sum))
+}
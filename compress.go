@@ -0,0 +1,101 @@
+package link
+
+import (
+	"compress/flate"
+	"io"
+)
+
+// Features of Offer for compressing each direction of the sessions of
+// CompressNegotiated, e.g. a mobile client offers FeatureCompressDown only,
+// to save the CPU of compressing and still get smaller downloads.
+const (
+	// FeatureCompressUp compresses the messages sent by the client.
+	FeatureCompressUp uint32 = 1 << 30
+	// FeatureCompressDown compresses the messages sent by the server.
+	FeatureCompressDown uint32 = 1 << 31
+)
+
+type compressStreamProtocol struct {
+	base       Protocol
+	level      int
+	send, recv bool
+}
+
+// CompressStream compresses the byte stream of base by flate for the
+// directions enabled, so each side chooses what it compresses and the peer
+// must choose the same for its other direction. Each write of the codec is
+// flushed, the compression keeps its window across messages.
+func CompressStream(base Protocol, level int, send, recv bool) Protocol {
+	if level < flate.HuffmanOnly || level > flate.BestCompression {
+		panic("CompressStream: bad compression level")
+	}
+	return &compressStreamProtocol{base, level, send, recv}
+}
+
+func (p *compressStreamProtocol) NewCodec(rw io.ReadWriter) (Codec, error) {
+	stream := &compressedStream{rw: rw}
+	if p.send {
+		stream.writer, _ = flate.NewWriter(rw, p.level)
+	}
+	if p.recv {
+		stream.reader = flate.NewReader(rw)
+	}
+	return p.base.NewCodec(stream)
+}
+
+type compressedStream struct {
+	rw     io.ReadWriter
+	reader io.Reader
+	writer *flate.Writer
+}
+
+func (s *compressedStream) Read(p []byte) (int, error) {
+	if s.reader == nil {
+		return s.rw.Read(p)
+	}
+	return s.reader.Read(p)
+}
+
+func (s *compressedStream) Write(p []byte) (int, error) {
+	if s.writer == nil {
+		return s.rw.Write(p)
+	}
+	if _, err := s.writer.Write(p); err != nil {
+		return 0, err
+	}
+	if err := s.writer.Flush(); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func (s *compressedStream) Close() error {
+	if closer, ok := s.rw.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+// SetCompression switches the session to protocol with the stream of each
+// direction compressed or not, like SetProtocol. Both sides must switch at
+// the same message, and a later SetProtocol drops the compression.
+func (session *Session) SetCompression(protocol Protocol, level int, send, recv bool) error {
+	return session.SetProtocol(CompressStream(protocol, level, send, recv))
+}
+
+// CompressNegotiated is the NewProtocolFunc of Negotiate, or of
+// NegotiateClient when client is true, switching to base with the directions
+// of FeatureCompressUp and FeatureCompressDown in the agreement compressed.
+func CompressNegotiated(base Protocol, level int, client bool) NewProtocolFunc {
+	return func(agreement Agreement) (Protocol, error) {
+		up := agreement.Features&FeatureCompressUp != 0
+		down := agreement.Features&FeatureCompressDown != 0
+		if !up && !down {
+			return base, nil
+		}
+		if client {
+			return CompressStream(base, level, up, down), nil
+		}
+		return CompressStream(base, level, down, up), nil
+	}
+}
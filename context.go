@@ -0,0 +1,118 @@
+package link
+
+import (
+	"context"
+	"net"
+	"sync/atomic"
+	"time"
+)
+
+// DialContext is Dial that gives up connecting when ctx is done.
+func DialContext(ctx context.Context, network, address string, protocol Protocol, sendChanSize int) (*Session, error) {
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, network, address)
+	if err != nil {
+		return nil, err
+	}
+	codec, err := protocol.NewCodec(conn)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return newConnSession(nil, conn, codec, sendChanSize), nil
+}
+
+// ServeContext is Serve that stops the server when ctx is done, and then
+// returns the error of ctx.
+func (server *Server) ServeContext(ctx context.Context) error {
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		select {
+		case <-ctx.Done():
+			server.Stop()
+		case <-stop:
+		}
+	}()
+	err := server.Serve()
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return err
+}
+
+// SendContext is SendAsync waiting for the result until ctx is done, also
+// for room in a full send channel. A message still queued then is dropped,
+// and the write of one being written is interrupted by the connection
+// deadline, which fails the session like a write timeout since the peer may
+// have got a part of it. Sessions without connection can't be interrupted.
+func (session *Session) SendContext(ctx context.Context, msg interface{}) error {
+	future := session.sendAsync(ctx, msg)
+	select {
+	case <-future.Done():
+		return future.err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// interruptWrite interrupts the write in progress when ctx is done, until
+// the returned func is called with the result of the write, it returns the
+// error of ctx for an interrupted write.
+func (session *Session) interruptWrite(ctx context.Context) func(error) error {
+	if ctx == nil || ctx.Done() == nil || session.conn == nil {
+		return func(err error) error { return err }
+	}
+	stop := make(chan struct{})
+	interrupted := make(chan bool, 1)
+	go func() {
+		select {
+		case <-ctx.Done():
+			session.conn.SetWriteDeadline(time.Unix(1, 0))
+			interrupted <- true
+		case <-stop:
+			interrupted <- false
+		}
+	}()
+	return func(err error) error {
+		close(stop)
+		if <-interrupted {
+			if err != nil {
+				return ctx.Err()
+			}
+			// Done just after the write, the deadline is given back.
+			session.conn.SetWriteDeadline(deadline(time.Duration(atomic.LoadInt64(&session.writeTimeout))))
+		}
+		return err
+	}
+}
+
+// ReceiveContext is Receive that gives up when ctx is done. It interrupts the
+// read by the connection deadline, so like a read timeout it closes the
+// session. Sessions without connection can't be interrupted.
+func (session *Session) ReceiveContext(ctx context.Context) (interface{}, error) {
+	if session.conn == nil || ctx.Done() == nil {
+		return session.Receive()
+	}
+	stop := make(chan struct{})
+	interrupted := make(chan bool, 1)
+	go func() {
+		select {
+		case <-ctx.Done():
+			session.conn.SetReadDeadline(time.Unix(1, 0))
+			interrupted <- true
+		case <-stop:
+			interrupted <- false
+		}
+	}()
+	msg, err := session.Receive()
+	close(stop)
+	if <-interrupted {
+		if err != nil {
+			return nil, ctx.Err()
+		}
+		// Done just after the message, the deadline is given back.
+		session.conn.SetReadDeadline(deadline(time.Duration(atomic.LoadInt64(&session.readTimeout))))
+	}
+	return msg, err
+}
@@ -0,0 +1,190 @@
+package link
+
+import (
+	"bufio"
+	"encoding/base64"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+var ErrUnknownProxy = errors.New("Unknown Proxy Scheme")
+var ErrProxyRefused = errors.New("Proxy Refused")
+var ErrProxyListen = errors.New("Proxy Cannot Listen")
+
+// ProxyTransport is the transport dialing TCP connections through the proxy
+// of proxyURL, "socks5://host:port" or "http://host:port" for HTTP CONNECT,
+// with optional "user:password@". Use it like
+// DialTransport(transport, address, timeout, protocol, sendChanSize).
+func ProxyTransport(proxyURL string) (Transport, error) {
+	u, err := url.Parse(proxyURL)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "socks5" && u.Scheme != "http" {
+		return nil, ErrUnknownProxy
+	}
+	return &proxyTransport{u}, nil
+}
+
+type proxyTransport struct {
+	url *url.URL
+}
+
+func (t *proxyTransport) Listen(address string) (net.Listener, error) {
+	return nil, ErrProxyListen
+}
+
+// Dial gives timeout to the connection to the proxy and its handshake.
+func (t *proxyTransport) Dial(address string, timeout time.Duration) (net.Conn, error) {
+	conn, err := net.DialTimeout("tcp", t.url.Host, timeout)
+	if err != nil {
+		return nil, err
+	}
+	if timeout > 0 {
+		conn.SetDeadline(time.Now().Add(timeout))
+	}
+	if t.url.Scheme == "socks5" {
+		err = t.socks5(conn, address)
+	} else {
+		conn, err = t.connect(conn, address)
+	}
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	conn.SetDeadline(time.Time{})
+	return conn, nil
+}
+
+// socks5 runs the handshake of RFC 1928, with the username and password
+// authentication of RFC 1929 when the URL has a user.
+func (t *proxyTransport) socks5(conn net.Conn, address string) error {
+	host, portStr, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	port, err := strconv.ParseUint(portStr, 10, 16)
+	if err != nil {
+		return err
+	}
+
+	method := byte(0)
+	if t.url.User != nil {
+		method = 2
+	}
+	if _, err := conn.Write([]byte{5, 1, method}); err != nil {
+		return err
+	}
+	var reply [4]byte
+	if _, err := io.ReadFull(conn, reply[:2]); err != nil {
+		return err
+	}
+	if reply[0] != 5 || reply[1] != method {
+		return ErrProxyRefused
+	}
+	if method == 2 {
+		user := t.url.User.Username()
+		password, _ := t.url.User.Password()
+		if len(user) > 255 || len(password) > 255 {
+			return ErrProxyRefused
+		}
+		auth := []byte{1, byte(len(user))}
+		auth = append(auth, user...)
+		auth = append(auth, byte(len(password)))
+		auth = append(auth, password...)
+		if _, err := conn.Write(auth); err != nil {
+			return err
+		}
+		if _, err := io.ReadFull(conn, reply[:2]); err != nil {
+			return err
+		}
+		if reply[1] != 0 {
+			return ErrProxyRefused
+		}
+	}
+
+	req := []byte{5, 1, 0}
+	if ip := net.ParseIP(host); ip == nil {
+		if len(host) > 255 {
+			return ErrProxyRefused
+		}
+		req = append(req, 3, byte(len(host)))
+		req = append(req, host...)
+	} else if ip4 := ip.To4(); ip4 != nil {
+		req = append(req, 1)
+		req = append(req, ip4...)
+	} else {
+		req = append(req, 4)
+		req = append(req, ip...)
+	}
+	req = append(req, byte(port>>8), byte(port))
+	if _, err := conn.Write(req); err != nil {
+		return err
+	}
+
+	if _, err := io.ReadFull(conn, reply[:]); err != nil {
+		return err
+	}
+	if reply[0] != 5 || reply[1] != 0 {
+		return ErrProxyRefused
+	}
+	// Skip the bound address and port.
+	var skip int
+	switch reply[3] {
+	case 1:
+		skip = net.IPv4len + 2
+	case 4:
+		skip = net.IPv6len + 2
+	case 3:
+		if _, err := io.ReadFull(conn, reply[:1]); err != nil {
+			return err
+		}
+		skip = int(reply[0]) + 2
+	default:
+		return ErrProxyRefused
+	}
+	_, err = io.ReadFull(conn, make([]byte, skip))
+	return err
+}
+
+// connect opens a tunnel by HTTP CONNECT, the bytes sent by the server behind
+// the proxy with its reply are kept for the first reads.
+func (t *proxyTransport) connect(conn net.Conn, address string) (net.Conn, error) {
+	req := &http.Request{
+		Method: "CONNECT",
+		URL:    &url.URL{Opaque: address},
+		Host:   address,
+		Header: make(http.Header),
+	}
+	if t.url.User != nil {
+		password, _ := t.url.User.Password()
+		auth := t.url.User.Username() + ":" + password
+		req.Header.Set("Proxy-Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte(auth)))
+	}
+	if err := req.Write(conn); err != nil {
+		return conn, err
+	}
+	reader := bufio.NewReader(conn)
+	rsp, err := http.ReadResponse(reader, req)
+	if err != nil {
+		return conn, err
+	}
+	// The body of a CONNECT reply is the tunnel, it is not read.
+	if rsp.StatusCode != http.StatusOK {
+		return conn, ErrProxyRefused
+	}
+	if reader.Buffered() == 0 {
+		return conn, nil
+	}
+	return &proxyConn{
+		Conn:   conn,
+		reader: reader,
+		remote: conn.RemoteAddr(),
+		local:  conn.LocalAddr(),
+	}, nil
+}
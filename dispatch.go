@@ -0,0 +1,78 @@
+package link
+
+import (
+	"errors"
+)
+
+var ErrUntypedMsg = errors.New("Untyped Message")
+var ErrNoHandler = errors.New("No Handler")
+var ErrNotDecoder = errors.New("Message Not Decoder")
+
+// TypedMsg is a received message carrying a type, like codec.TaggedMsg.
+type TypedMsg interface {
+	MsgType() uint16
+	MsgBody() interface{}
+}
+
+// Handle makes Dispatch give the body of the messages of msgType to handler,
+// a nil handler removes it.
+func (session *Session) Handle(msgType uint16, handler func(body interface{})) {
+	session.handlerMutex.Lock()
+	defer session.handlerMutex.Unlock()
+	if handler == nil {
+		delete(session.handlers, msgType)
+		return
+	}
+	if session.handlers == nil {
+		session.handlers = make(map[uint16]func(interface{}))
+	}
+	session.handlers[msgType] = handler
+}
+
+func (session *Session) handler(msgType uint16) func(interface{}) {
+	session.handlerMutex.RLock()
+	defer session.handlerMutex.RUnlock()
+	return session.handlers[msgType]
+}
+
+// Dispatch receives messages until an error, routing each one to the handler
+// of its type. A message not being TypedMsg or without handler closes the
+// session with ErrUntypedMsg or ErrNoHandler.
+func (session *Session) Dispatch() error {
+	for {
+		msg, err := session.Receive()
+		if err != nil {
+			return err
+		}
+		typed, ok := msg.(TypedMsg)
+		if !ok {
+			session.Close()
+			return ErrUntypedMsg
+		}
+		handler := session.handler(typed.MsgType())
+		if handler == nil {
+			session.Close()
+			return ErrNoHandler
+		}
+		handler(typed.MsgBody())
+	}
+}
+
+// Decoder is a received message decoded on demand into a message of the
+// receiver's choice, like codec.Encoded.
+type Decoder interface {
+	Decode(msg interface{}) error
+}
+
+// ReceiveInto receives a message and decodes it into msg.
+func (session *Session) ReceiveInto(msg interface{}) error {
+	recv, err := session.Receive()
+	if err != nil {
+		return err
+	}
+	decoder, ok := recv.(Decoder)
+	if !ok {
+		return ErrNotDecoder
+	}
+	return decoder.Decode(msg)
+}
@@ -0,0 +1,54 @@
+package link
+
+import (
+	"errors"
+	"net"
+)
+
+var ErrFiltered = errors.New("Filtered")
+
+// AcceptFilter tells whether a server keeps a new connection, it runs before
+// any handshake, buffer or goroutine of the connection. An error refuses it.
+type AcceptFilter func(conn net.Conn) error
+
+// CIDRFilter returns a filter refusing the IPs in deny, and the IPs not in
+// allow unless allow is empty. The items are CIDRs or single IPs.
+func CIDRFilter(allow, deny []string) (AcceptFilter, error) {
+	parse := func(items []string) ([]*net.IPNet, error) {
+		ipnets := make([]*net.IPNet, 0, len(items))
+		for _, item := range items {
+			ipnet, err := ParseCIDR(item)
+			if err != nil {
+				return nil, err
+			}
+			ipnets = append(ipnets, ipnet)
+		}
+		return ipnets, nil
+	}
+	contains := func(ipnets []*net.IPNet, ip net.IP) bool {
+		for _, ipnet := range ipnets {
+			if ipnet.Contains(ip) {
+				return true
+			}
+		}
+		return false
+	}
+	allowNets, err := parse(allow)
+	if err != nil {
+		return nil, err
+	}
+	denyNets, err := parse(deny)
+	if err != nil {
+		return nil, err
+	}
+	return func(conn net.Conn) error {
+		ip := AddrIP(conn.RemoteAddr())
+		if ip == nil {
+			return ErrFiltered
+		}
+		if contains(denyNets, ip) || (len(allowNets) > 0 && !contains(allowNets, ip)) {
+			return ErrFiltered
+		}
+		return nil
+	}, nil
+}
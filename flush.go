@@ -0,0 +1,69 @@
+package link
+
+import "context"
+
+// Flusher is a codec buffering the messages it sends until they are flushed,
+// like codec.Batch.
+type Flusher interface {
+	Flush() error
+}
+
+// flushSend is the message of Flush, it is not given to the codec.
+type flushSend struct{}
+
+func flushCodec(codec Codec) error {
+	if flusher, ok := codec.(Flusher); ok {
+		return flusher.Flush()
+	}
+	return nil
+}
+
+// Flush writes the messages the codec of the session buffered, it does
+// nothing for codecs not Flusher. With a send channel it waits for the
+// messages queued before it to be sent.
+func (session *Session) Flush() error {
+	return session.flushContext(context.Background())
+}
+
+// flushContext is Flush giving up when ctx is done, like SendContext.
+func (session *Session) flushContext(ctx context.Context) error {
+	if session.sendChan == nil {
+		done := session.interruptWrite(ctx)
+		return done(session.sendMsg(flushSend{}))
+	}
+
+	session.sendMutex.RLock()
+	if session.IsClosed() {
+		session.sendMutex.RUnlock()
+		return SessionClosedError
+	}
+	future := &SendFuture{done: make(chan struct{})}
+	err := session.enqueue(&asyncSend{flushSend{}, future, ctx}, ctx.Done())
+	session.sendMutex.RUnlock()
+	if err != nil {
+		if err == SessionBlockedError {
+			session.closeWith(err)
+		}
+		if err == MessageDroppedError && ctx.Err() != nil {
+			err = ctx.Err()
+		}
+		return err
+	}
+	select {
+	case <-future.Done():
+		return future.err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// switchSendCodec flushes and releases the old codec before the messages of
+// the new one, it must be called by the send loop or with sendMutex locked.
+func (session *Session) switchSendCodec(codec Codec) error {
+	err := flushCodec(session.sendCodec)
+	if releaser, ok := session.sendCodec.(Releaser); ok {
+		releaser.Release()
+	}
+	session.sendCodec = codec
+	return err
+}
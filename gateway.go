@@ -0,0 +1,194 @@
+package link
+
+import (
+	"sync"
+)
+
+// GatewayFrame is a message between a gateway and a backend server. Session
+// is the ID of the frontend session on the gateway. A frame with Close set
+// tells the other side the frontend session is gone, or should be.
+type GatewayFrame struct {
+	Session uint64
+	Msg     interface{}
+	Close   bool
+}
+
+// Gateway is a Handler of frontend sessions that forwards each message to the
+// backend server owning its routing key, and the replies back. Backend
+// protocols send and receive *GatewayFrame.
+//
+// Sends are synchronous both ways, so a slow backend stops the gateway
+// reading from the frontends routed to it, and a slow frontend stops the
+// gateway reading from its backend session. Use a write timeout on the
+// frontend server to bound the latter.
+type Gateway struct {
+	protocol Protocol
+	poolSize int
+	routeKey func(msg interface{}) (string, error)
+	owner    func(key string) (string, error)
+
+	dialMutex sync.Mutex
+	dial      func(addr string) (*Session, error)
+
+	poolMutex sync.Mutex
+	pools     map[string][]*gatewayBackend
+}
+
+type gatewayBackend struct {
+	session   *Session
+	frontends *Channel
+}
+
+// NewGateway creates a gateway keeps up to poolSize backend sessions per
+// server. routeKey extracts the routing key of a message, and owner returns
+// the address of the server owning a key.
+func NewGateway(protocol Protocol, poolSize int, routeKey func(msg interface{}) (string, error), owner func(key string) (string, error)) *Gateway {
+	if poolSize < 1 {
+		poolSize = 1
+	}
+	gateway := &Gateway{
+		protocol: protocol,
+		poolSize: poolSize,
+		routeKey: routeKey,
+		owner:    owner,
+		pools:    make(map[string][]*gatewayBackend),
+	}
+	gateway.dial = func(addr string) (*Session, error) {
+		return Dial("tcp", addr, gateway.protocol, 0)
+	}
+	return gateway
+}
+
+// SetDial changes how backend sessions are created, it must return sessions
+// without send channel to keep the backpressure.
+func (gateway *Gateway) SetDial(dial func(addr string) (*Session, error)) {
+	gateway.dialMutex.Lock()
+	defer gateway.dialMutex.Unlock()
+	gateway.dial = dial
+}
+
+func (gateway *Gateway) HandleSession(frontend *Session) {
+	var used []*gatewayBackend
+	defer func() {
+		for _, backend := range used {
+			backend.frontends.Remove(frontend.ID())
+			backend.session.Send(&GatewayFrame{Session: frontend.ID(), Close: true})
+		}
+		frontend.Close()
+	}()
+
+	for {
+		msg, err := frontend.Receive()
+		if err != nil {
+			return
+		}
+		key, err := gateway.routeKey(msg)
+		if err != nil {
+			return
+		}
+		addr, err := gateway.owner(key)
+		if err != nil {
+			return
+		}
+		backend, err := gateway.backend(addr, frontend.ID())
+		if err != nil {
+			return
+		}
+		if backend.frontends.Get(frontend.ID()) == nil {
+			backend.frontends.Put(frontend.ID(), frontend)
+			used = append(used, backend)
+		}
+		if err := backend.session.Send(&GatewayFrame{Session: frontend.ID(), Msg: msg}); err != nil {
+			return
+		}
+	}
+}
+
+// backend picks a backend session by the frontend session ID, so messages
+// of a frontend session keep their order.
+func (gateway *Gateway) backend(addr string, id uint64) (*gatewayBackend, error) {
+	gateway.poolMutex.Lock()
+	pool := gateway.pools[addr]
+	if pool == nil {
+		pool = make([]*gatewayBackend, gateway.poolSize)
+		gateway.pools[addr] = pool
+	}
+	i := int(id % uint64(gateway.poolSize))
+	backend := pool[i]
+	gateway.poolMutex.Unlock()
+	if backend != nil {
+		return backend, nil
+	}
+
+	gateway.dialMutex.Lock()
+	dial := gateway.dial
+	gateway.dialMutex.Unlock()
+	session, err := dial(addr)
+	if err != nil {
+		return nil, err
+	}
+
+	gateway.poolMutex.Lock()
+	if pool[i] != nil {
+		gateway.poolMutex.Unlock()
+		session.Close()
+		return pool[i], nil
+	}
+	backend = &gatewayBackend{session, NewChannel()}
+	pool[i] = backend
+	gateway.poolMutex.Unlock()
+
+	go gateway.serveBackend(addr, i, backend)
+	return backend, nil
+}
+
+func (gateway *Gateway) serveBackend(addr string, i int, backend *gatewayBackend) {
+	defer func() {
+		gateway.poolMutex.Lock()
+		if pool := gateway.pools[addr]; pool[i] == backend {
+			pool[i] = nil
+		}
+		gateway.poolMutex.Unlock()
+		backend.session.Close()
+		backend.frontends.FetchAndRemove(func(frontend *Session) {
+			frontend.Close()
+		})
+	}()
+
+	for {
+		msg, err := backend.session.Receive()
+		if err != nil {
+			return
+		}
+		frame, ok := msg.(*GatewayFrame)
+		if !ok {
+			return
+		}
+		frontend := backend.frontends.Get(frame.Session)
+		if frontend == nil {
+			continue
+		}
+		if frame.Close {
+			frontend.Close()
+			continue
+		}
+		frontend.Send(frame.Msg)
+	}
+}
+
+// Close closes all backend sessions, and the frontend sessions routed to them.
+func (gateway *Gateway) Close() {
+	gateway.poolMutex.Lock()
+	var backends []*gatewayBackend
+	for _, pool := range gateway.pools {
+		for _, backend := range pool {
+			if backend != nil {
+				backends = append(backends, backend)
+			}
+		}
+	}
+	gateway.poolMutex.Unlock()
+	for _, backend := range backends {
+		backend.session.Close()
+	}
+}
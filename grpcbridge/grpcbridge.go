@@ -0,0 +1,114 @@
+// Package grpcbridge bridges gRPC streams and link sessions, so gRPC
+// services can talk to link servers. It depends on no gRPC package, any
+// grpc.ServerStream or grpc.ClientStream is a Stream.
+//
+//	func (s *chatServer) Chat(stream pb.Chat_ChatServer) error {
+//		backend, err := link.Dial("tcp", backendAddr, protocol, 0)
+//		if err != nil {
+//			return err
+//		}
+//		return grpcbridge.Bridge(stream, func() interface{} { return new(pb.Packet) }, backend, toPacket, fromPacket)
+//	}
+package grpcbridge
+
+import (
+	"io"
+
+	"github.com/funny/link"
+)
+
+// Stream is the part of a gRPC stream used by the bridge.
+type Stream interface {
+	SendMsg(m interface{}) error
+	RecvMsg(m interface{}) error
+}
+
+type closeSender interface {
+	CloseSend() error
+}
+
+// NewCodec makes a stream a link.Codec, so it can be used by a link.Session.
+// newMsg returns the message to receive into.
+func NewCodec(stream Stream, newMsg func() interface{}) link.Codec {
+	return &streamCodec{stream, newMsg}
+}
+
+type streamCodec struct {
+	stream Stream
+	newMsg func() interface{}
+}
+
+func (c *streamCodec) Receive() (interface{}, error) {
+	msg := c.newMsg()
+	if err := c.stream.RecvMsg(msg); err != nil {
+		return nil, err
+	}
+	return msg, nil
+}
+
+func (c *streamCodec) Send(msg interface{}) error {
+	return c.stream.SendMsg(msg)
+}
+
+// Close half closes client streams, server streams end when the handler returns.
+func (c *streamCodec) Close() error {
+	if sender, ok := c.stream.(closeSender); ok {
+		return sender.CloseSend()
+	}
+	return nil
+}
+
+// Bridge copies messages between the stream and the session until either
+// side ends, then closes the session. toPacket converts stream messages into
+// session messages and fromPacket the other way. The end of the stream, or
+// the session closed by the other side, is not an error.
+func Bridge(stream Stream, newMsg func() interface{}, session *link.Session, toPacket, fromPacket func(interface{}) (interface{}, error)) error {
+	codec := NewCodec(stream, newMsg)
+	errChan := make(chan error, 2)
+
+	go func() {
+		for {
+			msg, err := codec.Receive()
+			if err != nil {
+				if err == io.EOF {
+					err = nil
+				}
+				errChan <- err
+				return
+			}
+			packet, err := toPacket(msg)
+			if err != nil {
+				errChan <- err
+				return
+			}
+			if err := session.Send(packet); err != nil {
+				errChan <- nil
+				return
+			}
+		}
+	}()
+
+	go func() {
+		for {
+			packet, err := session.Receive()
+			if err != nil {
+				errChan <- nil
+				return
+			}
+			msg, err := fromPacket(packet)
+			if err != nil {
+				errChan <- err
+				return
+			}
+			if err := codec.Send(msg); err != nil {
+				errChan <- err
+				return
+			}
+		}
+	}()
+
+	err := <-errChan
+	session.Close()
+	codec.Close()
+	return err
+}
@@ -0,0 +1,81 @@
+package grpcbridge
+
+import (
+	"encoding/binary"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/funny/link"
+	"github.com/funny/link/codec"
+	"github.com/funny/utest"
+)
+
+type Packet struct {
+	Text string
+}
+
+// fakeStream is a gRPC stream fed by recv and sending to send.
+type fakeStream struct {
+	recv chan *Packet
+	send chan *Packet
+}
+
+func (s *fakeStream) SendMsg(m interface{}) error {
+	s.send <- m.(*Packet)
+	return nil
+}
+
+func (s *fakeStream) RecvMsg(m interface{}) error {
+	p, ok := <-s.recv
+	if !ok {
+		return io.EOF
+	}
+	*m.(*Packet) = *p
+	return nil
+}
+
+func Test_Bridge(t *testing.T) {
+	protocol := codec.FixLen(codec.Raw(), 2, binary.BigEndian, 1024, 1024)
+	server, err := link.Listen("tcp", "127.0.0.1:0", protocol, 0, link.HandlerFunc(func(session *link.Session) {
+		for {
+			msg, err := session.Receive()
+			if err != nil {
+				return
+			}
+			session.Send(append([]byte("echo:"), msg.(*codec.InBuffer).Bytes()...))
+		}
+	}))
+	utest.IsNilNow(t, err)
+	go server.Serve()
+	defer server.Stop()
+
+	backend, err := link.Dial("tcp", server.Listener().Addr().String(), protocol, 0)
+	utest.IsNilNow(t, err)
+	stream := &fakeStream{make(chan *Packet), make(chan *Packet, 1)}
+	done := make(chan error, 1)
+	go func() {
+		done <- Bridge(stream, func() interface{} { return new(Packet) }, backend,
+			func(msg interface{}) (interface{}, error) { return []byte(msg.(*Packet).Text), nil },
+			func(packet interface{}) (interface{}, error) {
+				return &Packet{string(packet.(*codec.InBuffer).Bytes())}, nil
+			})
+	}()
+
+	stream.recv <- &Packet{"hello"}
+	select {
+	case p := <-stream.send:
+		utest.EqualNow(t, p.Text, "echo:hello")
+	case <-time.After(time.Second):
+		t.Fatal("reply not received")
+	}
+
+	close(stream.recv)
+	select {
+	case err := <-done:
+		utest.IsNilNow(t, err)
+	case <-time.After(time.Second):
+		t.Fatal("bridge not ended")
+	}
+	utest.Assert(t, backend.IsClosed())
+}
@@ -0,0 +1,124 @@
+package link
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"errors"
+	"hash"
+	"sync/atomic"
+	"time"
+)
+
+var ErrAuthFailed = errors.New("Authentication Failed")
+
+// Authenticator runs the handshake of a session before the application gets
+// it, e.g. exchanging a version and token, or a challenge-response. The same
+// interface serves both sides, see Server.SetAuthenticator and Handshake.
+type Authenticator interface {
+	Authenticate(session *Session) error
+}
+
+type AuthenticatorFunc func(*Session) error
+
+func (f AuthenticatorFunc) Authenticate(session *Session) error {
+	return f(session)
+}
+
+// Handshake runs auth on session, with both read and write limited to timeout
+// if it is not zero. The session is closed if auth fails.
+func Handshake(session *Session, auth Authenticator, timeout time.Duration) error {
+	if err := handshake(session, auth, timeout); err != nil {
+		session.Close()
+		return err
+	}
+	return nil
+}
+
+func handshake(session *Session, auth Authenticator, timeout time.Duration) error {
+	if timeout > 0 {
+		readTimeout := time.Duration(atomic.LoadInt64(&session.readTimeout))
+		writeTimeout := time.Duration(atomic.LoadInt64(&session.writeTimeout))
+		session.SetReadTimeout(timeout)
+		session.SetWriteTimeout(timeout)
+		defer session.SetReadTimeout(readTimeout)
+		defer session.SetWriteTimeout(writeTimeout)
+	}
+	return auth.Authenticate(session)
+}
+
+// TokenAuth accepts a session if verify accepts its first message, e.g. a
+// login message with a version and a token. The client sends it with
+// SendToken.
+func TokenAuth(verify func(session *Session, msg interface{}) error) Authenticator {
+	return AuthenticatorFunc(func(session *Session) error {
+		msg, err := session.Receive()
+		if err != nil {
+			return err
+		}
+		return verify(session, msg)
+	})
+}
+
+// SendToken is the client side of TokenAuth.
+func SendToken(msg interface{}) Authenticator {
+	return AuthenticatorFunc(func(session *Session) error {
+		return session.Send(msg)
+	})
+}
+
+// challengeSize is the random bytes of a challenge of ChallengeAuth.
+const challengeSize = 32
+
+// ChallengeAuth sends a random challenge and accepts a session replying the
+// HMAC of it with key, so the key is never sent. Both messages are []byte, so
+// the protocol must send a []byte as it is and receive it as []byte or a
+// buffer with a Bytes method. The client replies with ChallengeResponse.
+func ChallengeAuth(newHash func() hash.Hash, key []byte) Authenticator {
+	return AuthenticatorFunc(func(session *Session) error {
+		challenge := make([]byte, challengeSize)
+		if _, err := rand.Read(challenge); err != nil {
+			return err
+		}
+		if err := session.Send(challenge); err != nil {
+			return err
+		}
+		msg, err := session.Receive()
+		if err != nil {
+			return err
+		}
+		mac := hmac.New(newHash, key)
+		mac.Write(challenge)
+		response, ok := msgBytes(msg)
+		if !ok || !hmac.Equal(response, mac.Sum(nil)) {
+			return ErrAuthFailed
+		}
+		return nil
+	})
+}
+
+// ChallengeResponse is the client side of ChallengeAuth.
+func ChallengeResponse(newHash func() hash.Hash, key []byte) Authenticator {
+	return AuthenticatorFunc(func(session *Session) error {
+		msg, err := session.Receive()
+		if err != nil {
+			return err
+		}
+		challenge, ok := msgBytes(msg)
+		if !ok {
+			return ErrAuthFailed
+		}
+		mac := hmac.New(newHash, key)
+		mac.Write(challenge)
+		return session.Send(mac.Sum(nil))
+	})
+}
+
+func msgBytes(msg interface{}) ([]byte, bool) {
+	switch m := msg.(type) {
+	case []byte:
+		return m, true
+	case interface{ Bytes() []byte }:
+		return m.Bytes(), true
+	}
+	return nil, false
+}
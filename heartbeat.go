@@ -0,0 +1,99 @@
+package link
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// Heartbeat detects dead peers of an idle session by application level
+// pings, e.g. mobile clients gone without closing the connection.
+type Heartbeat struct {
+	// Idle is how long the session receives nothing before a ping is sent,
+	// and then the time given to each ping.
+	Idle time.Duration
+	// MaxMissed is the number of unanswered pings that closes the session.
+	MaxMissed int
+
+	// Ping is sent when the session is idle, any message received answers it.
+	Ping interface{}
+	// IsPong tells the replies to pings, Receive skips them and feeds their
+	// round trip time to Quality. It may be nil.
+	IsPong func(msg interface{}) bool
+	// IsPing tells the pings of the peer, Receive answers them with Pong and
+	// skips them. It may be nil.
+	IsPing func(msg interface{}) bool
+	Pong   interface{}
+
+	// OnIdle is called when the first ping of an idle period is sent, and
+	// OnTimeout before the session is closed. They may be nil.
+	OnIdle    func(*Session)
+	OnTimeout func(*Session)
+}
+
+// StartHeartbeat starts pinging the session whenever it is idle, it is called
+// once. The messages are received only by Receive, so the session must keep
+// calling it.
+func (session *Session) StartHeartbeat(heartbeat *Heartbeat) {
+	atomic.StoreInt64(&session.lastRecv, time.Now().UnixNano())
+	session.heartbeat.Store(heartbeat)
+	go session.heartbeatLoop(heartbeat)
+}
+
+func (session *Session) heartbeatLoop(heartbeat *Heartbeat) {
+	var missed int
+	var pingAt time.Time
+	timer := time.NewTimer(heartbeat.Idle)
+	defer timer.Stop()
+	for {
+		select {
+		case <-timer.C:
+		case <-session.closeChan:
+			return
+		}
+		// Anything received after the last ping answers it.
+		lastRecv := time.Unix(0, atomic.LoadInt64(&session.lastRecv))
+		if lastRecv.After(pingAt) {
+			missed = 0
+		}
+		if idle := time.Since(lastRecv); idle < heartbeat.Idle {
+			timer.Reset(heartbeat.Idle - idle)
+			continue
+		}
+		if missed >= heartbeat.MaxMissed {
+			if heartbeat.OnTimeout != nil {
+				heartbeat.OnTimeout(session)
+			}
+			session.Close()
+			return
+		}
+		if missed == 0 && heartbeat.OnIdle != nil {
+			heartbeat.OnIdle(session)
+		}
+		missed++
+		pingAt = time.Now()
+		atomic.StoreInt64(&session.pingSent, pingAt.UnixNano())
+		session.SendPriority(heartbeat.Ping, PriorityControl)
+		timer.Reset(heartbeat.Idle)
+	}
+}
+
+// heartbeatSkip tells whether Receive skips msg, it answers the pings and
+// takes the round trip time of the pongs.
+func (session *Session) heartbeatSkip(msg interface{}) bool {
+	heartbeat, _ := session.heartbeat.Load().(*Heartbeat)
+	if heartbeat == nil {
+		return false
+	}
+	atomic.StoreInt64(&session.lastRecv, time.Now().UnixNano())
+	if heartbeat.IsPing != nil && heartbeat.IsPing(msg) {
+		session.SendPriority(heartbeat.Pong, PriorityControl)
+		return true
+	}
+	if heartbeat.IsPong != nil && heartbeat.IsPong(msg) {
+		if sent := atomic.SwapInt64(&session.pingSent, 0); sent != 0 {
+			session.AddRTTSample(time.Since(time.Unix(0, sent)))
+		}
+		return true
+	}
+	return false
+}
@@ -0,0 +1,58 @@
+package link
+
+import "io"
+
+// SessionHooks are lifecycle callbacks of sessions, for cleanup and audit
+// logs. They may be nil.
+type SessionHooks struct {
+	// OnConnect is called by the server when a session passed the checks of
+	// the server, before it is given to the handler.
+	OnConnect func(*Session)
+	// OnClose is called once a session is closed, after its close callbacks,
+	// with the error that closed it or nil when it was closed by Close.
+	OnClose func(session *Session, reason error)
+	// OnError is called with an error of the codec or the middlewares right
+	// before it closes the session. The end of the stream, io.EOF, is only
+	// reported to OnClose.
+	OnError func(session *Session, err error)
+}
+
+type closeError struct {
+	err error
+}
+
+// SetHooks sets the hooks of the session, nil removes them.
+func (session *Session) SetHooks(hooks *SessionHooks) {
+	session.hooks.Store(hooks)
+}
+
+func (session *Session) loadHooks() *SessionHooks {
+	hooks, _ := session.hooks.Load().(*SessionHooks)
+	return hooks
+}
+
+// CloseError returns the error that closed the session, nil when it is open
+// or was closed by Close.
+func (session *Session) CloseError() error {
+	reason, _ := session.closeErr.Load().(closeError)
+	return reason.err
+}
+
+// fail closes the session for err, it is not an error of the session when the
+// session was already closed.
+func (session *Session) fail(err error) {
+	if session.IsClosed() {
+		return
+	}
+	if hooks := session.loadHooks(); hooks != nil && hooks.OnError != nil && err != io.EOF {
+		hooks.OnError(session, err)
+	}
+	session.closeWith(err)
+}
+
+// SetHooks sets the hooks of new sessions, nil removes them.
+func (server *Server) SetHooks(hooks *SessionHooks) {
+	server.configMutex.Lock()
+	defer server.configMutex.Unlock()
+	server.hooks = hooks
+}
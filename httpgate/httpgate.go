@@ -0,0 +1,146 @@
+// Package httpgate translates HTTP requests into messages on backend link
+// sessions and the replies back to JSON responses, so tools and webhooks
+// can talk to link servers without the binary protocol.
+package httpgate
+
+import (
+	"bytes"
+	"encoding/json"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/funny/link"
+)
+
+// Gateway is a http.Handler. Each request takes a backend session from a
+// pool for the round trip, so the backend replies to messages in order and
+// needs no correlation ID.
+type Gateway struct {
+	dial   func() (*link.Session, error)
+	decode func(r *http.Request) (msg interface{}, reply bool, err error)
+	encode func(msg interface{}) (interface{}, error)
+
+	mutex   sync.Mutex
+	idle    []*link.Session
+	maxIdle int
+	timeout time.Duration
+	closed  bool
+}
+
+// New creates a gateway that dials backend sessions with dial. decode turns
+// a request into a message, and tells whether the backend replies to it.
+// encode turns a reply into a value to be marshaled as JSON.
+func New(dial func() (*link.Session, error), decode func(r *http.Request) (interface{}, bool, error), encode func(msg interface{}) (interface{}, error)) *Gateway {
+	return &Gateway{
+		dial:    dial,
+		decode:  decode,
+		encode:  encode,
+		maxIdle: 8,
+		timeout: 10 * time.Second,
+	}
+}
+
+// SetMaxIdle changes the number of idle backend sessions kept, default is 8.
+func (gateway *Gateway) SetMaxIdle(n int) {
+	gateway.mutex.Lock()
+	defer gateway.mutex.Unlock()
+	gateway.maxIdle = n
+}
+
+// SetTimeout changes the time limit of a round trip, default is 10 seconds.
+func (gateway *Gateway) SetTimeout(timeout time.Duration) {
+	gateway.mutex.Lock()
+	defer gateway.mutex.Unlock()
+	gateway.timeout = timeout
+}
+
+func (gateway *Gateway) get() (*link.Session, time.Duration, error) {
+	gateway.mutex.Lock()
+	timeout := gateway.timeout
+	for n := len(gateway.idle); n > 0; n-- {
+		session := gateway.idle[n-1]
+		gateway.idle = gateway.idle[:n-1]
+		if !session.IsClosed() {
+			gateway.mutex.Unlock()
+			return session, timeout, nil
+		}
+	}
+	gateway.mutex.Unlock()
+	session, err := gateway.dial()
+	return session, timeout, err
+}
+
+func (gateway *Gateway) put(session *link.Session) {
+	gateway.mutex.Lock()
+	defer gateway.mutex.Unlock()
+	if gateway.closed || len(gateway.idle) >= gateway.maxIdle {
+		session.Close()
+		return
+	}
+	gateway.idle = append(gateway.idle, session)
+}
+
+func (gateway *Gateway) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	msg, reply, err := gateway.decode(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	session, timeout, err := gateway.get()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	session.SetWriteTimeout(timeout)
+	session.SetReadTimeout(timeout)
+	if err := session.Send(msg); err != nil {
+		session.Close()
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	if !reply {
+		gateway.put(session)
+		w.WriteHeader(http.StatusAccepted)
+		return
+	}
+
+	resp, err := session.Receive()
+	if err != nil {
+		session.Close()
+		if ne, ok := err.(net.Error); ok && ne.Timeout() {
+			http.Error(w, err.Error(), http.StatusGatewayTimeout)
+		} else {
+			http.Error(w, err.Error(), http.StatusBadGateway)
+		}
+		return
+	}
+
+	// The reply may be reused by the next Receive, so it is encoded before
+	// the session goes back to the pool.
+	var buf bytes.Buffer
+	body, err := gateway.encode(resp)
+	if err == nil {
+		err = json.NewEncoder(&buf).Encode(body)
+	}
+	gateway.put(session)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(buf.Bytes())
+}
+
+// Close closes the idle backend sessions, and the ones in use when returned.
+func (gateway *Gateway) Close() {
+	gateway.mutex.Lock()
+	defer gateway.mutex.Unlock()
+	gateway.closed = true
+	for _, session := range gateway.idle {
+		session.Close()
+	}
+	gateway.idle = nil
+}
@@ -0,0 +1,84 @@
+package httpgate
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/funny/link"
+	"github.com/funny/link/codec"
+	"github.com/funny/utest"
+)
+
+func Test_Gateway(t *testing.T) {
+	protocol := codec.FixLen(codec.Json(), 2, binary.BigEndian, 1024, 1024)
+	notified := make(chan interface{}, 1)
+	server, err := link.Listen("tcp", "127.0.0.1:0", protocol, 0, link.HandlerFunc(func(session *link.Session) {
+		for {
+			msg, err := session.Receive()
+			if err != nil {
+				return
+			}
+			m := msg.(map[string]interface{})
+			switch m["cmd"] {
+			case "echo":
+				session.Send(m)
+			case "notify":
+				notified <- m["text"]
+			}
+		}
+	}))
+	utest.IsNilNow(t, err)
+	go server.Serve()
+	defer server.Stop()
+
+	gateway := New(func() (*link.Session, error) {
+		return link.Dial("tcp", server.Listener().Addr().String(), protocol, 0)
+	}, func(r *http.Request) (interface{}, bool, error) {
+		var msg map[string]interface{}
+		err := json.NewDecoder(r.Body).Decode(&msg)
+		return msg, msg["cmd"] != "notify", err
+	}, func(msg interface{}) (interface{}, error) {
+		return msg, nil
+	})
+	defer gateway.Close()
+	gateway.SetTimeout(time.Second)
+
+	w := httptest.NewRecorder()
+	gateway.ServeHTTP(w, httptest.NewRequest("POST", "/", strings.NewReader(`{"cmd":"echo","text":"hi"}`)))
+	utest.EqualNow(t, w.Code, 200)
+	var resp map[string]interface{}
+	utest.IsNilNow(t, json.Unmarshal(w.Body.Bytes(), &resp))
+	utest.EqualNow(t, resp["text"], "hi")
+
+	w = httptest.NewRecorder()
+	gateway.ServeHTTP(w, httptest.NewRequest("POST", "/", strings.NewReader(`{"cmd":"notify","text":"hook"}`)))
+	utest.EqualNow(t, w.Code, 202)
+	select {
+	case text := <-notified:
+		utest.EqualNow(t, text, "hook")
+	case <-time.After(time.Second):
+		t.Fatal("message not received")
+	}
+
+	gateway.SetTimeout(50 * time.Millisecond)
+	w = httptest.NewRecorder()
+	gateway.ServeHTTP(w, httptest.NewRequest("POST", "/", strings.NewReader(`{"cmd":"silent"}`)))
+	utest.EqualNow(t, w.Code, 504)
+
+	w = httptest.NewRecorder()
+	gateway.ServeHTTP(w, httptest.NewRequest("POST", "/", strings.NewReader(`bad`)))
+	utest.EqualNow(t, w.Code, 400)
+
+	gateway.SetTimeout(time.Second)
+	for _, session := range gateway.idle {
+		session.Close()
+	}
+	w = httptest.NewRecorder()
+	gateway.ServeHTTP(w, httptest.NewRequest("POST", "/", strings.NewReader(`{"cmd":"echo","text":"again"}`)))
+	utest.EqualNow(t, w.Code, 200)
+}
@@ -0,0 +1,203 @@
+package link
+
+import (
+	"bufio"
+	"errors"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+var ErrUpgradeRefused = errors.New("HTTP Upgrade Refused")
+
+// UpgradeListener is a net.Listener of the connections of HTTP requests
+// upgraded to the protocol token, and the http.Handler upgrading them, so a
+// server shares the port of an HTTP server, e.g. behind firewalls allowing
+// only :443. Give it to NewServer and mount it on the mux of the HTTP server.
+// Use Sniffer instead to hand plain HTTP connections of a link port to an
+// http.Handler.
+type UpgradeListener struct {
+	token    string
+	fallback http.Handler
+	addr     upgradeAddr
+
+	conns     chan net.Conn
+	closeOnce sync.Once
+	closeChan chan struct{}
+}
+
+// NewUpgradeListener creates the listener of token. Requests not asking to
+// upgrade to token go to fallback, or are answered 426 Upgrade Required when
+// it is nil.
+func NewUpgradeListener(token string, fallback http.Handler) *UpgradeListener {
+	return &UpgradeListener{
+		token:     token,
+		fallback:  fallback,
+		addr:      upgradeAddr(token),
+		conns:     make(chan net.Conn),
+		closeChan: make(chan struct{}),
+	}
+}
+
+// ServeHTTP hijacks the connection and answers 101 Switching Protocols, the
+// link protocol starts after it. It waits for Accept, so the HTTP server
+// keeps the request until the server takes the connection.
+func (l *UpgradeListener) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !headerHas(r.Header, "Connection", "upgrade") || !headerHas(r.Header, "Upgrade", l.token) {
+		if l.fallback != nil {
+			l.fallback.ServeHTTP(w, r)
+			return
+		}
+		w.Header().Set("Connection", "Upgrade")
+		w.Header().Set("Upgrade", l.token)
+		http.Error(w, http.StatusText(http.StatusUpgradeRequired), http.StatusUpgradeRequired)
+		return
+	}
+	select {
+	case <-l.closeChan:
+		http.Error(w, http.StatusText(http.StatusServiceUnavailable), http.StatusServiceUnavailable)
+		return
+	default:
+	}
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "hijacking unsupported", http.StatusInternalServerError)
+		return
+	}
+	conn, rw, err := hijacker.Hijack()
+	if err != nil {
+		return
+	}
+	// The deadlines of the HTTP server are not the session's.
+	conn.SetDeadline(time.Time{})
+	if _, err := conn.Write([]byte("HTTP/1.1 101 Switching Protocols\r\nConnection: Upgrade\r\nUpgrade: " + l.token + "\r\n\r\n")); err != nil {
+		conn.Close()
+		return
+	}
+	if rw.Reader.Buffered() > 0 {
+		conn = &proxyConn{
+			Conn:   conn,
+			reader: rw.Reader,
+			remote: conn.RemoteAddr(),
+			local:  conn.LocalAddr(),
+		}
+	}
+	select {
+	case l.conns <- conn:
+	case <-l.closeChan:
+		conn.Close()
+	}
+}
+
+// Accept returns the same error of use of closed network connection like
+// net.Listener, which link.Accept reports as io.EOF.
+func (l *UpgradeListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.conns:
+		return conn, nil
+	case <-l.closeChan:
+		return nil, &net.OpError{Op: "accept", Net: "http", Addr: l.addr, Err: errClosedListener}
+	}
+}
+
+// Close stops upgrading, the HTTP server keeps running.
+func (l *UpgradeListener) Close() error {
+	l.closeOnce.Do(func() {
+		close(l.closeChan)
+	})
+	return nil
+}
+
+func (l *UpgradeListener) Addr() net.Addr {
+	return l.addr
+}
+
+type upgradeAddr string
+
+func (addr upgradeAddr) Network() string { return "http" }
+func (addr upgradeAddr) String() string  { return string(addr) }
+
+// headerHas tells whether the comma separated values of the header key
+// contain value, case insensitive.
+func headerHas(header http.Header, key, value string) bool {
+	for _, line := range header[http.CanonicalHeaderKey(key)] {
+		for _, v := range strings.Split(line, ",") {
+			if strings.EqualFold(strings.TrimSpace(v), value) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// UpgradeTransport is the transport dialing over base, e.g. one of tls.Dial
+// for :443, and asking the HTTP server of the address to upgrade the request
+// of path to token, the client of UpgradeListener. Listen is the one of base.
+func UpgradeTransport(base Transport, path, token string) Transport {
+	return &upgradeTransport{base, path, token}
+}
+
+type upgradeTransport struct {
+	base  Transport
+	path  string
+	token string
+}
+
+func (t *upgradeTransport) Listen(address string) (net.Listener, error) {
+	return t.base.Listen(address)
+}
+
+// Dial gives timeout to the connection and the upgrade.
+func (t *upgradeTransport) Dial(address string, timeout time.Duration) (net.Conn, error) {
+	conn, err := t.base.Dial(address, timeout)
+	if err != nil {
+		return nil, err
+	}
+	if timeout > 0 {
+		conn.SetDeadline(time.Now().Add(timeout))
+	}
+	conn, err = t.upgrade(conn, address)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	conn.SetDeadline(time.Time{})
+	return conn, nil
+}
+
+// upgrade keeps the bytes sent by the server with its reply for the first
+// reads, like proxyTransport.connect.
+func (t *upgradeTransport) upgrade(conn net.Conn, address string) (net.Conn, error) {
+	req := &http.Request{
+		Method: "GET",
+		URL:    &url.URL{Path: t.path},
+		Host:   address,
+		Header: make(http.Header),
+	}
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Upgrade", t.token)
+	if err := req.Write(conn); err != nil {
+		return conn, err
+	}
+	reader := bufio.NewReader(conn)
+	rsp, err := http.ReadResponse(reader, req)
+	if err != nil {
+		return conn, err
+	}
+	if rsp.StatusCode != http.StatusSwitchingProtocols || !headerHas(rsp.Header, "Upgrade", t.token) {
+		rsp.Body.Close()
+		return conn, ErrUpgradeRefused
+	}
+	if reader.Buffered() == 0 {
+		return conn, nil
+	}
+	return &proxyConn{
+		Conn:   conn,
+		reader: reader,
+		remote: conn.RemoteAddr(),
+		local:  conn.LocalAddr(),
+	}, nil
+}
@@ -0,0 +1,74 @@
+package link
+
+import (
+	"errors"
+	"sync/atomic"
+	"time"
+)
+
+var SessionIdleError = errors.New("Session Idle")
+
+// LastActive returns when the session last received or sent a message, or
+// its creation time.
+func (session *Session) LastActive() time.Time {
+	return time.Unix(0, atomic.LoadInt64(&session.lastActive))
+}
+
+func (session *Session) touch() {
+	atomic.StoreInt64(&session.lastActive, time.Now().UnixNano())
+}
+
+// SetIdleTimeout closes the sessions which received and sent nothing for
+// timeout with SessionIdleError, after calling onIdle when it is not nil.
+// Heartbeats count as activity. The sessions are checked every quarter of
+// timeout, zero disables it.
+func (server *Server) SetIdleTimeout(timeout time.Duration, onIdle func(*Session)) {
+	server.configMutex.Lock()
+	defer server.configMutex.Unlock()
+	server.idleTimeout = timeout
+	server.onIdle = onIdle
+	if timeout > 0 && !server.reaping {
+		server.reaping = true
+		go server.reapLoop()
+	}
+}
+
+func (server *Server) reapLoop() {
+	for {
+		server.configMutex.Lock()
+		timeout, onIdle := server.idleTimeout, server.onIdle
+		if timeout <= 0 {
+			server.reaping = false
+			server.configMutex.Unlock()
+			return
+		}
+		server.configMutex.Unlock()
+
+		interval := timeout / 4
+		if interval < time.Millisecond {
+			interval = time.Millisecond
+		}
+		timer := time.NewTimer(interval)
+		select {
+		case <-timer.C:
+		case <-server.stopChan:
+			timer.Stop()
+			return
+		}
+
+		var idle []*Session
+		now := time.Now()
+		server.manager.Range(func(session *Session) bool {
+			if now.Sub(session.LastActive()) >= timeout {
+				idle = append(idle, session)
+			}
+			return true
+		})
+		for _, session := range idle {
+			if onIdle != nil {
+				onIdle(session)
+			}
+			session.closeWith(SessionIdleError)
+		}
+	}
+}
@@ -0,0 +1,138 @@
+// Package linktest runs protocol handlers without sockets, over net.Pipe, and
+// records and replays the packets of sessions for regression tests.
+package linktest
+
+import (
+	"errors"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/funny/link"
+)
+
+var ErrNoListener = errors.New("No Listener")
+
+type pipeAddr string
+
+func (addr pipeAddr) Network() string { return "pipe" }
+func (addr pipeAddr) String() string  { return string(addr) }
+
+// PipeTransport is a link.Transport of in-memory connections by net.Pipe,
+// the addresses are any names listened on.
+type PipeTransport struct {
+	mutex     sync.Mutex
+	listeners map[string]*pipeListener
+}
+
+func NewPipeTransport() *PipeTransport {
+	return &PipeTransport{listeners: make(map[string]*pipeListener)}
+}
+
+func (t *PipeTransport) Listen(address string) (net.Listener, error) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if _, exists := t.listeners[address]; exists {
+		return nil, &net.OpError{Op: "listen", Net: "pipe", Addr: pipeAddr(address), Err: errors.New("address already in use")}
+	}
+	listener := &pipeListener{
+		transport: t,
+		addr:      pipeAddr(address),
+		conns:     make(chan net.Conn),
+		closeChan: make(chan struct{}),
+	}
+	t.listeners[address] = listener
+	return listener, nil
+}
+
+// Dial connects to the listener of address, it waits for the listener to
+// accept until timeout, a zero timeout means none.
+func (t *PipeTransport) Dial(address string, timeout time.Duration) (net.Conn, error) {
+	t.mutex.Lock()
+	listener := t.listeners[address]
+	t.mutex.Unlock()
+	if listener == nil {
+		return nil, &net.OpError{Op: "dial", Net: "pipe", Addr: pipeAddr(address), Err: ErrNoListener}
+	}
+
+	var expired <-chan time.Time
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		expired = timer.C
+	}
+	client, server := net.Pipe()
+	select {
+	case listener.conns <- server:
+		return client, nil
+	case <-listener.closeChan:
+		return nil, &net.OpError{Op: "dial", Net: "pipe", Addr: pipeAddr(address), Err: ErrNoListener}
+	case <-expired:
+		return nil, &net.OpError{Op: "dial", Net: "pipe", Addr: pipeAddr(address), Err: errors.New("i/o timeout")}
+	}
+}
+
+type pipeListener struct {
+	transport *PipeTransport
+	addr      pipeAddr
+	conns     chan net.Conn
+	closeOnce sync.Once
+	closeChan chan struct{}
+}
+
+func (l *pipeListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.conns:
+		return conn, nil
+	case <-l.closeChan:
+		return nil, net.ErrClosed
+	}
+}
+
+func (l *pipeListener) Close() error {
+	l.closeOnce.Do(func() {
+		close(l.closeChan)
+		l.transport.mutex.Lock()
+		delete(l.transport.listeners, string(l.addr))
+		l.transport.mutex.Unlock()
+	})
+	return nil
+}
+
+func (l *pipeListener) Addr() net.Addr {
+	return l.addr
+}
+
+// Harness is a server running handler over a PipeTransport.
+type Harness struct {
+	Server    *link.Server
+	Transport *PipeTransport
+	protocol  link.Protocol
+}
+
+// NewHarness starts serving handler, configure Server before dialing.
+func NewHarness(protocol link.Protocol, handler link.Handler) *Harness {
+	transport := NewPipeTransport()
+	server, _ := link.ListenTransport(transport, "harness", protocol, 0, handler)
+	go server.Serve()
+	return &Harness{
+		Server:    server,
+		Transport: transport,
+		protocol:  protocol,
+	}
+}
+
+// Dial returns a client session of the server.
+func (h *Harness) Dial() (*link.Session, error) {
+	return link.DialTransport(h.Transport, "harness", 0, h.protocol, 0)
+}
+
+// DialConn returns a client connection of the server, for raw bytes.
+func (h *Harness) DialConn() (net.Conn, error) {
+	return h.Transport.Dial("harness", 0)
+}
+
+// Close stops the server and closes its sessions.
+func (h *Harness) Close() {
+	h.Server.Stop()
+}
@@ -0,0 +1,124 @@
+package linktest
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/funny/link"
+	"github.com/funny/link/codec"
+	"github.com/funny/utest"
+)
+
+type syncBuffer struct {
+	sync.Mutex
+	bytes.Buffer
+	closed chan struct{}
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.Lock()
+	defer b.Unlock()
+	return b.Buffer.Write(p)
+}
+
+func (b *syncBuffer) Close() error {
+	close(b.closed)
+	return nil
+}
+
+func echo(transform func([]byte) []byte) link.Handler {
+	return link.HandlerFunc(func(session *link.Session) {
+		for {
+			msg, err := session.Receive()
+			if err != nil {
+				return
+			}
+			session.Send(transform(msg.(*codec.InBuffer).Bytes()))
+		}
+	})
+}
+
+func Test_RecordReplay(t *testing.T) {
+	protocol := codec.FixLen(codec.Raw(), 2, binary.BigEndian, 1024, 1024)
+	record := &syncBuffer{closed: make(chan struct{})}
+	harness := NewHarness(Record(protocol, func() (io.Writer, error) {
+		return record, nil
+	}), echo(func(b []byte) []byte { return b }))
+
+	// The client is not recorded.
+	session, err := link.DialTransport(harness.Transport, "harness", 0, protocol, 0)
+	utest.IsNilNow(t, err)
+	utest.Assert(t, session.RemoteAddr().Network() == "pipe")
+	for _, msg := range []string{"a", "bc", "def"} {
+		utest.IsNilNow(t, session.Send([]byte(msg)))
+		reply, err := session.Receive()
+		utest.IsNilNow(t, err)
+		utest.EqualNow(t, string(reply.(*codec.InBuffer).Bytes()), msg)
+	}
+	session.Close()
+	harness.Close()
+	select {
+	case <-record.closed:
+	case <-time.After(time.Second):
+		t.Fatal("record not closed")
+	}
+
+	packets, err := ReadRecords(bytes.NewReader(record.Bytes()))
+	utest.IsNilNow(t, err)
+	utest.EqualNow(t, len(packets), 6)
+	utest.Assert(t, !packets[0].Send && packets[1].Send)
+	utest.EqualNow(t, packets[5].Data, []byte{0, 3, 'd', 'e', 'f'})
+
+	result, err := Replay(bytes.NewReader(record.Bytes()), protocol, echo(func(b []byte) []byte { return b }), time.Second)
+	utest.IsNilNow(t, err)
+	utest.Assert(t, result.Equal())
+	utest.EqualNow(t, len(result.Actual), 3)
+
+	result, err = Replay(bytes.NewReader(record.Bytes()), protocol, echo(bytes.ToUpper), time.Second)
+	utest.IsNilNow(t, err)
+	utest.Assert(t, !result.Equal())
+	utest.EqualNow(t, result.Actual[2], []byte{0, 3, 'D', 'E', 'F'})
+
+	// A handler not replying stops the replay at the first missing packet.
+	result, err = Replay(bytes.NewReader(record.Bytes()), protocol, link.HandlerFunc(func(session *link.Session) {
+		session.Receive()
+	}), 50*time.Millisecond)
+	utest.IsNilNow(t, err)
+	utest.EqualNow(t, len(result.Expected), 3)
+	utest.EqualNow(t, len(result.Actual), 0)
+}
+
+func Test_PipeTransport(t *testing.T) {
+	transport := NewPipeTransport()
+	_, err := transport.Dial("nowhere", 0)
+	utest.NotNilNow(t, err)
+
+	listener, err := transport.Listen("a")
+	utest.IsNilNow(t, err)
+	_, err = transport.Listen("a")
+	utest.NotNilNow(t, err)
+	_, err = transport.Dial("a", 10*time.Millisecond)
+	utest.NotNilNow(t, err)
+
+	go func() {
+		conn, err := listener.Accept()
+		if err == nil {
+			conn.Write([]byte("hi"))
+			conn.Close()
+		}
+	}()
+	conn, err := transport.Dial("a", time.Second)
+	utest.IsNilNow(t, err)
+	b, _ := io.ReadAll(conn)
+	utest.EqualNow(t, string(b), "hi")
+
+	listener.Close()
+	_, err = listener.Accept()
+	utest.NotNilNow(t, err)
+	_, err = transport.Listen("a")
+	utest.IsNilNow(t, err)
+}
@@ -0,0 +1,212 @@
+package linktest
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/funny/link"
+	"github.com/funny/link/codec"
+)
+
+var ErrBadRecord = errors.New("Bad Record")
+
+// Packet is a recorded packet, the bytes on the wire received or sent by the
+// recorded session.
+type Packet struct {
+	Send bool
+	Time time.Duration // since the codec was created
+	Data []byte
+}
+
+type recordProtocol struct {
+	base   link.Protocol
+	create func() (io.Writer, error)
+}
+
+// Record records the packets of each codec of base to a writer given by
+// create, e.g. a new file for each session of a server. The writer is closed
+// with the codec if it is an io.Closer. The records are
+//
+//	direction byte, 1 for sent | nanoseconds uint64 | size uint32 | bytes
+//
+// in big-endian. Errors writing records are ignored.
+func Record(base link.Protocol, create func() (io.Writer, error)) link.Protocol {
+	return &recordProtocol{base, create}
+}
+
+func (p *recordProtocol) NewCodec(rw io.ReadWriter) (link.Codec, error) {
+	w, err := p.create()
+	if err != nil {
+		return nil, err
+	}
+	recorder := &recorder{w: w, start: time.Now()}
+	base, err := codec.Trace(p.base, recorder.trace).NewCodec(rw)
+	if err != nil {
+		recorder.close()
+		return nil, err
+	}
+	return &recordCodec{Codec: base, recorder: recorder}, nil
+}
+
+type recorder struct {
+	mutex sync.Mutex
+	w     io.Writer
+	start time.Time
+	head  [13]byte
+}
+
+func (r *recorder) trace(event *codec.TraceEvent) {
+	if len(event.Wire) == 0 {
+		return
+	}
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if r.w == nil {
+		return
+	}
+	r.head[0] = 0
+	if event.Send {
+		r.head[0] = 1
+	}
+	binary.BigEndian.PutUint64(r.head[1:], uint64(time.Since(r.start)))
+	binary.BigEndian.PutUint32(r.head[9:], uint32(len(event.Wire)))
+	r.w.Write(r.head[:])
+	r.w.Write(event.Wire)
+}
+
+func (r *recorder) close() {
+	if closer, ok := r.w.(io.Closer); ok {
+		closer.Close()
+	}
+	r.w = nil
+}
+
+// recordCodec closes the writer after the packets in flight are recorded.
+type recordCodec struct {
+	link.Codec
+	recorder *recorder
+	inFlight sync.RWMutex
+}
+
+func (c *recordCodec) Receive() (interface{}, error) {
+	c.inFlight.RLock()
+	defer c.inFlight.RUnlock()
+	return c.Codec.Receive()
+}
+
+func (c *recordCodec) Send(msg interface{}) error {
+	c.inFlight.RLock()
+	defer c.inFlight.RUnlock()
+	return c.Codec.Send(msg)
+}
+
+func (c *recordCodec) Close() error {
+	err := c.Codec.Close()
+	c.inFlight.Lock()
+	c.recorder.mutex.Lock()
+	c.recorder.close()
+	c.recorder.mutex.Unlock()
+	c.inFlight.Unlock()
+	return err
+}
+
+// ReadRecords reads the packets written by Record.
+func ReadRecords(r io.Reader) ([]Packet, error) {
+	var packets []Packet
+	reader := bufio.NewReader(r)
+	var head [13]byte
+	for {
+		if _, err := io.ReadFull(reader, head[:]); err != nil {
+			if err == io.EOF {
+				return packets, nil
+			}
+			return packets, err
+		}
+		if head[0] > 1 {
+			return packets, ErrBadRecord
+		}
+		data := make([]byte, binary.BigEndian.Uint32(head[9:]))
+		if _, err := io.ReadFull(reader, data); err != nil {
+			return packets, err
+		}
+		packets = append(packets, Packet{
+			Send: head[0] == 1,
+			Time: time.Duration(binary.BigEndian.Uint64(head[1:])),
+			Data: data,
+		})
+	}
+}
+
+// ReplayResult is the packets sent by the recorded session and the ones sent
+// back by the handler the recording was replayed to.
+type ReplayResult struct {
+	Expected [][]byte
+	Actual   [][]byte
+}
+
+// Equal tells whether the handler sent the recorded packets.
+func (result *ReplayResult) Equal() bool {
+	if len(result.Expected) != len(result.Actual) {
+		return false
+	}
+	for i := range result.Expected {
+		if !bytes.Equal(result.Expected[i], result.Actual[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// Replay sends the packets received by a recorded session to a new session
+// of handler over net.Pipe, in the recorded order without the recorded
+// delays. At each packet the recorded session sent it waits up to wait for
+// the handler to send one, and stops at the first missing one.
+func Replay(r io.Reader, protocol link.Protocol, handler link.Handler, wait time.Duration) (*ReplayResult, error) {
+	packets, err := ReadRecords(r)
+	if err != nil {
+		return nil, err
+	}
+	harness := NewHarness(protocol, handler)
+	defer harness.Close()
+	conn, err := harness.DialConn()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	var wire []byte
+	client, err := codec.Trace(protocol, func(event *codec.TraceEvent) {
+		wire = append(wire[:0], event.Wire...)
+	}).NewCodec(conn)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &ReplayResult{}
+	failed := false
+	for _, packet := range packets {
+		if packet.Send {
+			result.Expected = append(result.Expected, packet.Data)
+			if failed {
+				continue
+			}
+			conn.SetReadDeadline(time.Now().Add(wait))
+			if _, err := client.Receive(); err != nil {
+				failed = true
+				continue
+			}
+			result.Actual = append(result.Actual, append([]byte(nil), wire...))
+		} else if !failed {
+			conn.SetWriteDeadline(time.Now().Add(wait))
+			if _, err := conn.Write(packet.Data); err != nil {
+				failed = true
+			}
+		}
+	}
+	return result, nil
+}
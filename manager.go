@@ -1,6 +1,9 @@
 package link
 
-import "sync"
+import (
+	"net"
+	"sync"
+)
 
 const sessionMapNum = 32
 
@@ -45,6 +48,60 @@ func (manager *Manager) NewSession(codec Codec, sendChanSize int) *Session {
 	return session
 }
 
+// NewConnSession is NewSession for a codec over conn, for servers accepting
+// the connections themselves, so the session has Conn and RemoteAddr.
+func (manager *Manager) NewConnSession(conn net.Conn, codec Codec, sendChanSize int) *Session {
+	session := newConnSession(manager, conn, codec, sendChanSize)
+	manager.putSession(session)
+	return session
+}
+
+// Len returns the number of live sessions.
+func (manager *Manager) Len() int {
+	n := 0
+	for i := 0; i < sessionMapNum; i++ {
+		smap := &manager.sessionMaps[i]
+		smap.RLock()
+		n += len(smap.sessions)
+		smap.RUnlock()
+	}
+	return n
+}
+
+// Fetch calls callback for each live session, the callback must not
+// create new sessions of this manager.
+func (manager *Manager) Fetch(callback func(*Session)) {
+	for i := 0; i < sessionMapNum; i++ {
+		smap := &manager.sessionMaps[i]
+		smap.RLock()
+		for _, session := range smap.sessions {
+			callback(session)
+		}
+		smap.RUnlock()
+	}
+}
+
+// Range calls callback for each live session until it returns false. Each
+// shard is copied before its sessions are visited, so the callback may create
+// and close sessions, the ones created on the way may or may not be visited.
+func (manager *Manager) Range(callback func(*Session) bool) {
+	var sessions []*Session
+	for i := 0; i < sessionMapNum; i++ {
+		smap := &manager.sessionMaps[i]
+		smap.RLock()
+		sessions = sessions[:0]
+		for _, session := range smap.sessions {
+			sessions = append(sessions, session)
+		}
+		smap.RUnlock()
+		for _, session := range sessions {
+			if !callback(session) {
+				return
+			}
+		}
+	}
+}
+
 func (manager *Manager) GetSession(sessionID uint64) *Session {
 	smap := &manager.sessionMaps[sessionID%sessionMapNum]
 	smap.RLock()
@@ -75,6 +132,10 @@ func (manager *Manager) delSession(session *Session) {
 	smap.Lock()
 	defer smap.Unlock()
 
-	delete(smap.sessions, session.id)
-	manager.disposeWait.Done()
+	// A session closed before putSession added it, refused by the
+	// authenticator of a server or by a disposed manager, was never counted.
+	if _, exists := smap.sessions[session.id]; exists {
+		delete(smap.sessions, session.id)
+		manager.disposeWait.Done()
+	}
 }
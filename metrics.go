@@ -0,0 +1,117 @@
+package link
+
+import (
+	"io"
+	"net"
+	"sync/atomic"
+)
+
+// Metrics receives the counters of the sessions of a server, see
+// Server.SetMetrics and the metrics package for expvar and Prometheus.
+// The methods are called concurrently.
+type Metrics interface {
+	SessionOpened()
+	SessionClosed()
+	BytesRead(n int)
+	BytesWritten(n int)
+	// PacketReceived and PacketSent are given the size of the message, or -1
+	// when it is unknown, see MessageSize.
+	PacketReceived(size int)
+	PacketSent(size int)
+}
+
+// MessageSize returns the size of msg if it is a []byte or a string or it has
+// a Size or Len method, or -1.
+func MessageSize(msg interface{}) int {
+	switch m := msg.(type) {
+	case []byte:
+		return len(m)
+	case string:
+		return len(m)
+	case interface{ Size() int }:
+		return m.Size()
+	case interface{ Len() int }:
+		return m.Len()
+	}
+	return -1
+}
+
+// countConn counts the bytes of a session for its metrics.
+type countConn struct {
+	bytesIn  uint64
+	bytesOut uint64
+	net.Conn
+	metrics Metrics
+}
+
+func (c *countConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	if n > 0 {
+		atomic.AddUint64(&c.bytesIn, uint64(n))
+		c.metrics.BytesRead(n)
+	}
+	return n, err
+}
+
+func (c *countConn) Write(p []byte) (int, error) {
+	n, err := c.Conn.Write(p)
+	if n > 0 {
+		atomic.AddUint64(&c.bytesOut, uint64(n))
+		c.metrics.BytesWritten(n)
+	}
+	return n, err
+}
+
+// ReadFrom keeps the sendfile of the connection, e.g. for codec.FileRegion.
+func (c *countConn) ReadFrom(r io.Reader) (int64, error) {
+	n, err := io.Copy(c.Conn, r)
+	c.countOut(n)
+	return n, err
+}
+
+// WriteBuffers keeps the writev of the connection for net.Buffers, which only
+// sees it on the connections of the net package.
+func (c *countConn) WriteBuffers(buffers *net.Buffers) (int64, error) {
+	n, err := buffers.WriteTo(c.Conn)
+	c.countOut(n)
+	return n, err
+}
+
+// NetConn returns the connection counted.
+func (c *countConn) NetConn() net.Conn {
+	return c.Conn
+}
+
+func (c *countConn) countOut(n int64) {
+	if n > 0 {
+		atomic.AddUint64(&c.bytesOut, uint64(n))
+		c.metrics.BytesWritten(int(n))
+	}
+}
+
+// BytesIn returns the bytes read from the connection of the session, it is
+// only counted for the sessions of a server with metrics.
+func (session *Session) BytesIn() uint64 {
+	if session.counter == nil {
+		return 0
+	}
+	return atomic.LoadUint64(&session.counter.bytesIn)
+}
+
+// BytesOut returns the bytes written to the connection of the session, like
+// BytesIn.
+func (session *Session) BytesOut() uint64 {
+	if session.counter == nil {
+		return 0
+	}
+	return atomic.LoadUint64(&session.counter.bytesOut)
+}
+
+// SetMetrics makes new sessions report to metrics, nil disables it. The
+// codecs of the sessions are given a counting wrapper of the connection,
+// Session.Conn still returns the connection.
+func (server *Server) SetMetrics(metrics Metrics) {
+	server.configMutex.Lock()
+	defer server.configMutex.Unlock()
+	server.metrics = metrics
+}
@@ -0,0 +1,181 @@
+package metrics
+
+import (
+	"expvar"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"sync/atomic"
+
+	"github.com/funny/link"
+)
+
+// sizeBuckets are the upper bounds of the packet size histograms, in bytes.
+var sizeBuckets = []int{64, 256, 1 << 10, 4 << 10, 16 << 10, 64 << 10, 256 << 10, 1 << 20}
+
+// Collector is the link.Metrics of a server, it counts the sessions, bytes
+// and packets, and reads the sessions and the send queues of the server
+// when exported.
+type Collector struct {
+	// 64-bit atomic fields first for alignment on 32-bit platforms.
+	opened     uint64
+	closed     uint64
+	bytesIn    uint64
+	bytesOut   uint64
+	packetsIn  uint64
+	packetsOut uint64
+
+	recvSizes histogram
+	sendSizes histogram
+	server    *link.Server
+}
+
+type histogram struct {
+	sum    uint64
+	counts [9]uint64 // by sizeBuckets, then +Inf
+}
+
+func (h *histogram) observe(size int) {
+	if size < 0 {
+		return
+	}
+	i := sort.SearchInts(sizeBuckets, size)
+	atomic.AddUint64(&h.counts[i], 1)
+	atomic.AddUint64(&h.sum, uint64(size))
+}
+
+func (h *histogram) snapshot() Histogram {
+	snapshot := Histogram{Buckets: sizeBuckets, Counts: make([]uint64, len(h.counts))}
+	for i := range h.counts {
+		snapshot.Count += atomic.LoadUint64(&h.counts[i])
+		snapshot.Counts[i] = snapshot.Count
+	}
+	snapshot.Sum = atomic.LoadUint64(&h.sum)
+	return snapshot
+}
+
+// New creates a collector of server and sets it as the metrics of server, so
+// it counts the sessions created after.
+func New(server *link.Server) *Collector {
+	collector := &Collector{server: server}
+	server.SetMetrics(collector)
+	return collector
+}
+
+func (c *Collector) SessionOpened()       { atomic.AddUint64(&c.opened, 1) }
+func (c *Collector) SessionClosed()       { atomic.AddUint64(&c.closed, 1) }
+func (c *Collector) BytesRead(n int)      { atomic.AddUint64(&c.bytesIn, uint64(n)) }
+func (c *Collector) BytesWritten(n int)   { atomic.AddUint64(&c.bytesOut, uint64(n)) }
+func (c *Collector) PacketReceived(n int) { atomic.AddUint64(&c.packetsIn, 1); c.recvSizes.observe(n) }
+func (c *Collector) PacketSent(n int)     { atomic.AddUint64(&c.packetsOut, 1); c.sendSizes.observe(n) }
+
+// Histogram is a snapshot of packet sizes, Counts are cumulative like in
+// Prometheus, the last one is for any size. Buckets are the upper bounds of
+// the others, from 64 bytes to 1MB.
+type Histogram struct {
+	Buckets []int    `json:"buckets"`
+	Counts  []uint64 `json:"counts"`
+	Count   uint64   `json:"count"`
+	Sum     uint64   `json:"sum"`
+}
+
+// Snapshot is the state of a collector.
+type Snapshot struct {
+	Sessions       int              `json:"sessions"`   // live sessions of the server
+	SendQueue      int              `json:"send_queue"` // messages waiting in the send channels
+	SessionsOpened uint64           `json:"sessions_opened"`
+	SessionsClosed uint64           `json:"sessions_closed"`
+	BytesIn        uint64           `json:"bytes_in"`
+	BytesOut       uint64           `json:"bytes_out"`
+	PacketsIn      uint64           `json:"packets_in"`
+	PacketsOut     uint64           `json:"packets_out"`
+	RecvSizes      Histogram        `json:"recv_sizes"`
+	SendSizes      Histogram        `json:"send_sizes"`
+	Accept         link.AcceptStats `json:"accept"`
+}
+
+// Snapshot reads the counters, and walks the sessions of the server for the
+// send queue depth.
+func (c *Collector) Snapshot() Snapshot {
+	snapshot := Snapshot{
+		SessionsOpened: atomic.LoadUint64(&c.opened),
+		SessionsClosed: atomic.LoadUint64(&c.closed),
+		BytesIn:        atomic.LoadUint64(&c.bytesIn),
+		BytesOut:       atomic.LoadUint64(&c.bytesOut),
+		PacketsIn:      atomic.LoadUint64(&c.packetsIn),
+		PacketsOut:     atomic.LoadUint64(&c.packetsOut),
+		RecvSizes:      c.recvSizes.snapshot(),
+		SendSizes:      c.sendSizes.snapshot(),
+		Accept:         c.server.AcceptStats(),
+	}
+	c.server.Manager().Range(func(session *link.Session) bool {
+		snapshot.Sessions++
+		snapshot.SendQueue += session.SendChanLen()
+		return true
+	})
+	return snapshot
+}
+
+// Publish exports the snapshots of the collector by expvar under name, it
+// panics if name is already used like expvar.Publish.
+func (c *Collector) Publish(name string) {
+	expvar.Publish(name, expvar.Func(func() interface{} {
+		return c.Snapshot()
+	}))
+}
+
+// Prometheus returns a handler serving the snapshots of the collector in the
+// Prometheus text format, the metrics are named namespace_*.
+func (c *Collector) Prometheus(namespace string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		snapshot := c.Snapshot()
+		snapshot.writePrometheus(w, namespace)
+	})
+}
+
+func (s *Snapshot) writePrometheus(w io.Writer, ns string) {
+	metric := func(name, kind, help string) {
+		fmt.Fprintf(w, "# HELP %s_%s %s\n# TYPE %s_%s %s\n", ns, name, help, ns, name, kind)
+	}
+	value := func(name, kind, help string, v interface{}) {
+		metric(name, kind, help)
+		fmt.Fprintf(w, "%s_%s %v\n", ns, name, v)
+	}
+	value("sessions", "gauge", "Live sessions.", s.Sessions)
+	value("send_queue", "gauge", "Messages waiting in the send channels.", s.SendQueue)
+	value("sessions_opened_total", "counter", "Sessions created.", s.SessionsOpened)
+	value("sessions_closed_total", "counter", "Sessions closed.", s.SessionsClosed)
+	value("bytes_received_total", "counter", "Bytes read from the connections.", s.BytesIn)
+	value("bytes_sent_total", "counter", "Bytes written to the connections.", s.BytesOut)
+	value("packets_received_total", "counter", "Messages received.", s.PacketsIn)
+	value("packets_sent_total", "counter", "Messages sent.", s.PacketsOut)
+	value("accepted_total", "counter", "Connections became sessions.", s.Accept.Accepted)
+
+	metric("rejected_total", "counter", "Connections refused by reason.")
+	reasons := make([]string, 0, len(s.Accept.Rejected))
+	for reason := range s.Accept.Rejected {
+		reasons = append(reasons, reason)
+	}
+	sort.Strings(reasons)
+	for _, reason := range reasons {
+		fmt.Fprintf(w, "%s_rejected_total{reason=%q} %d\n", ns, reason, s.Accept.Rejected[reason])
+	}
+
+	metric("packet_size_bytes", "histogram", "Sizes of the messages of known size.")
+	for _, h := range []struct {
+		direction string
+		histogram Histogram
+	}{{"recv", s.RecvSizes}, {"send", s.SendSizes}} {
+		for i, count := range h.histogram.Counts {
+			le := "+Inf"
+			if i < len(h.histogram.Buckets) {
+				le = fmt.Sprint(h.histogram.Buckets[i])
+			}
+			fmt.Fprintf(w, "%s_packet_size_bytes_bucket{direction=%q,le=%q} %d\n", ns, h.direction, le, count)
+		}
+		fmt.Fprintf(w, "%s_packet_size_bytes_sum{direction=%q} %d\n", ns, h.direction, h.histogram.Sum)
+		fmt.Fprintf(w, "%s_packet_size_bytes_count{direction=%q} %d\n", ns, h.direction, h.histogram.Count)
+	}
+}
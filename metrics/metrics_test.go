@@ -0,0 +1,75 @@
+package metrics
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"expvar"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/funny/link"
+	"github.com/funny/link/codec"
+	"github.com/funny/utest"
+)
+
+func Test_Collector(t *testing.T) {
+	protocol := codec.FixLen(codec.Raw(), 2, binary.BigEndian, 64*1024, 64*1024)
+	server, err := link.Listen("tcp", "127.0.0.1:0", protocol, 0, link.HandlerFunc(func(session *link.Session) {
+		for {
+			msg, err := session.Receive()
+			if err != nil {
+				return
+			}
+			session.Send(msg)
+		}
+	}))
+	utest.IsNilNow(t, err)
+	collector := New(server)
+	go server.Serve()
+	defer server.Stop()
+
+	session, err := link.Dial("tcp", server.Listener().Addr().String(), protocol, 0)
+	utest.IsNilNow(t, err)
+	for _, size := range []int{10, 100, 1000} {
+		utest.IsNilNow(t, session.Send(make([]byte, size)))
+		_, err := session.Receive()
+		utest.IsNilNow(t, err)
+	}
+
+	snapshot := collector.Snapshot()
+	utest.EqualNow(t, snapshot.Sessions, 1)
+	utest.EqualNow(t, snapshot.SessionsOpened, uint64(1))
+	utest.EqualNow(t, snapshot.PacketsIn, uint64(3))
+	utest.EqualNow(t, snapshot.PacketsOut, uint64(3))
+	utest.EqualNow(t, snapshot.BytesIn, uint64(1110+3*2))
+	utest.EqualNow(t, snapshot.BytesOut, uint64(1110+3*2))
+	utest.EqualNow(t, snapshot.RecvSizes.Counts, []uint64{1, 2, 3, 3, 3, 3, 3, 3, 3})
+	utest.EqualNow(t, snapshot.RecvSizes.Sum, uint64(1110))
+	server.Manager().Range(func(s *link.Session) bool {
+		utest.EqualNow(t, s.BytesIn(), uint64(1116))
+		return true
+	})
+
+	w := httptest.NewRecorder()
+	collector.Prometheus("link").ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	body := w.Body.String()
+	for _, line := range []string{
+		"# TYPE link_sessions gauge\nlink_sessions 1\n",
+		"link_packets_received_total 3\n",
+		"link_bytes_sent_total 1116\n",
+		"link_packet_size_bytes_bucket{direction=\"recv\",le=\"256\"} 2\n",
+		"link_packet_size_bytes_bucket{direction=\"send\",le=\"+Inf\"} 3\n",
+		"link_packet_size_bytes_count{direction=\"send\"} 3\n",
+	} {
+		utest.Assert(t, strings.Contains(body, line), line)
+	}
+
+	// Published once by process, the name can't be reused.
+	if expvar.Get("link_test") == nil {
+		collector.Publish("link_test")
+	}
+	var published Snapshot
+	utest.IsNilNow(t, json.Unmarshal([]byte(expvar.Get("link_test").String()), &published))
+	utest.EqualNow(t, published.PacketsOut, uint64(3))
+}
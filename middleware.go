@@ -0,0 +1,54 @@
+package link
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// MessageFunc takes a message and returns the one to pass on, a nil message
+// with a nil error drops it.
+type MessageFunc func(msg interface{}) (interface{}, error)
+
+// Middleware wraps the handling of messages, e.g. for logging, metrics or rate
+// limiting, it calls next to pass a message on.
+type Middleware func(next MessageFunc) MessageFunc
+
+type middlewareChain struct {
+	mutex       sync.Mutex
+	middlewares []Middleware
+	chain       atomic.Value
+}
+
+func (c *middlewareChain) use(middlewares []Middleware) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.middlewares = append(c.middlewares, middlewares...)
+	var chain MessageFunc = func(msg interface{}) (interface{}, error) {
+		return msg, nil
+	}
+	for i := len(c.middlewares) - 1; i >= 0; i-- {
+		chain = c.middlewares[i](chain)
+	}
+	c.chain.Store(chain)
+}
+
+func (c *middlewareChain) run(msg interface{}) (interface{}, error) {
+	if chain, _ := c.chain.Load().(MessageFunc); chain != nil {
+		return chain(msg)
+	}
+	return msg, nil
+}
+
+// UseRecv adds middlewares to the received messages, they run in the order of
+// the calls. An error closes the session and is returned by Receive, a
+// dropped message is not returned.
+func (session *Session) UseRecv(middlewares ...Middleware) {
+	session.recvChain.use(middlewares)
+}
+
+// UseSend adds middlewares to the messages sent, they run in the order of
+// the calls by the goroutine sending. An error is returned by Send without
+// closing the session, a dropped message is not sent.
+func (session *Session) UseSend(middlewares ...Middleware) {
+	session.sendChain.use(middlewares)
+}
@@ -0,0 +1,113 @@
+package mux
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/funny/link"
+	"github.com/funny/link/codec"
+	"github.com/funny/utest"
+)
+
+func newTestSessions() (*Session, *Session) {
+	conn1, conn2 := net.Pipe()
+	return Client(conn1, Config{Window: 4096}), Server(conn2, Config{Window: 4096})
+}
+
+func Test_LinkSessions(t *testing.T) {
+	client, server := newTestSessions()
+	defer client.Close()
+	protocol := codec.FixLen(codec.Raw(), 4, binary.BigEndian, 1<<20, 1<<20)
+	linkServer := link.NewServer(server, protocol, 0, link.HandlerFunc(func(session *link.Session) {
+		for {
+			msg, err := session.Receive()
+			if err != nil {
+				return
+			}
+			session.Send(msg)
+		}
+	}))
+	go linkServer.Serve()
+	defer linkServer.Stop()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			stream, err := client.OpenStream()
+			utest.IsNilNow(t, err)
+			c, _ := protocol.NewCodec(stream)
+			session := link.NewSession(c, 0)
+			defer session.Close()
+			// Larger than the window, so it takes window updates.
+			msg := bytes.Repeat([]byte{byte(i)}, 10000+i)
+			for j := 0; j < 5; j++ {
+				utest.IsNilNow(t, session.Send(msg))
+				recv, err := session.Receive()
+				utest.IsNilNow(t, err)
+				utest.EqualNow(t, recv.(*codec.InBuffer).Bytes(), msg)
+			}
+		}(i)
+	}
+	wg.Wait()
+}
+
+func Test_StreamFlowControl(t *testing.T) {
+	client, server := newTestSessions()
+	defer client.Close()
+	defer server.Close()
+
+	stream1, err := client.OpenStream()
+	utest.IsNilNow(t, err)
+	stream2, err := client.OpenStream()
+	utest.IsNilNow(t, err)
+	peer1, err := server.Accept()
+	utest.IsNilNow(t, err)
+	peer2, err := server.Accept()
+	utest.IsNilNow(t, err)
+
+	// A stream not read blocks its writer only.
+	stream1.SetWriteDeadline(time.Now().Add(50 * time.Millisecond))
+	n, err := stream1.Write(make([]byte, 5000))
+	utest.EqualNow(t, n, 4096)
+	utest.Assert(t, err.(net.Error).Timeout())
+
+	_, err = stream2.Write([]byte("hello"))
+	utest.IsNilNow(t, err)
+	b := make([]byte, 5)
+	_, err = io.ReadFull(peer2, b)
+	utest.IsNilNow(t, err)
+	utest.EqualNow(t, string(b), "hello")
+
+	// Reading gives the window back.
+	_, err = io.ReadFull(peer1, make([]byte, 4096))
+	utest.IsNilNow(t, err)
+	stream1.SetWriteDeadline(time.Time{})
+	_, err = stream1.Write(make([]byte, 4096))
+	utest.IsNilNow(t, err)
+
+	// The peer reads the data before the end of stream.
+	stream1.Close()
+	_, err = io.ReadFull(peer1, make([]byte, 4096))
+	utest.IsNilNow(t, err)
+	_, err = peer1.Read(b)
+	utest.EqualNow(t, err, io.EOF)
+	_, err = peer1.Write(b)
+	utest.NotNilNow(t, err)
+	peer1.Close()
+	for i := 0; i < 100 && client.NumStreams() != 1; i++ {
+		time.Sleep(time.Millisecond)
+	}
+	utest.EqualNow(t, client.NumStreams(), 1)
+
+	// Closing the session closes the streams.
+	server.Close()
+	_, err = stream2.Read(b)
+	utest.NotNilNow(t, err)
+}
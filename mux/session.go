@@ -0,0 +1,278 @@
+// Package mux runs many independent streams over one connection, like yamux
+// or smux. Each stream has its own flow control window, so a slow stream
+// doesn't block the others.
+//
+// A Session is a net.Listener of the streams opened by the peer, so a link
+// server can serve them, and OpenStream gives a net.Conn for a link session:
+//
+//	session := mux.Server(conn, mux.Config{})
+//	server := link.NewServer(session, protocol, 0, handler)
+//
+//	session := mux.Client(conn, mux.Config{})
+//	stream, err := session.OpenStream()
+//	codec, err := protocol.NewCodec(stream)
+package mux
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"sync"
+)
+
+var (
+	ErrBadFrame       = errors.New("Bad Mux Frame")
+	ErrStreamReset    = errors.New("Stream Reset")
+	ErrTooManyStreams = errors.New("Too Many Streams")
+)
+
+const (
+	typeData   = 0
+	typeWindow = 1
+	typeFin    = 2
+	typeRst    = 3
+
+	// frameHeadSize is [type 1][stream ID 4][length 4].
+	frameHeadSize = 9
+	maxFrameSize  = 16 * 1024
+)
+
+// Config of sessions, the zero value uses defaults.
+type Config struct {
+	// Window is the bytes a stream accepts before they are read, default
+	// is 256KB.
+	Window int
+
+	// AcceptBacklog is the streams opened by the peer and not yet accepted,
+	// beyond which new ones are reset, default is 256.
+	AcceptBacklog int
+}
+
+func (config Config) withDefaults() Config {
+	if config.Window <= 0 {
+		config.Window = 256 * 1024
+	}
+	if config.AcceptBacklog <= 0 {
+		config.AcceptBacklog = 256
+	}
+	return config
+}
+
+// Session multiplexes the streams of a connection.
+type Session struct {
+	conn   net.Conn
+	config Config
+
+	writeMutex sync.Mutex
+	writeBuf   []byte
+
+	mutex   sync.Mutex
+	nextID  uint32
+	peerID  uint32
+	streams map[uint32]*Stream
+	err     error
+
+	accept    chan *Stream
+	closeOnce sync.Once
+	closeChan chan struct{}
+}
+
+// Client creates the session of the side dialing conn.
+func Client(conn net.Conn, config Config) *Session {
+	return newSession(conn, config, 1)
+}
+
+// Server creates the session of the side accepting conn.
+func Server(conn net.Conn, config Config) *Session {
+	return newSession(conn, config, 2)
+}
+
+func newSession(conn net.Conn, config Config, firstID uint32) *Session {
+	config = config.withDefaults()
+	session := &Session{
+		conn:      conn,
+		config:    config,
+		nextID:    firstID,
+		writeBuf:  make([]byte, frameHeadSize+maxFrameSize),
+		streams:   make(map[uint32]*Stream),
+		accept:    make(chan *Stream, config.AcceptBacklog),
+		closeChan: make(chan struct{}),
+	}
+	go session.readLoop()
+	return session
+}
+
+// OpenStream opens a stream, the peer accepts it with its first frame.
+func (session *Session) OpenStream() (*Stream, error) {
+	// The IDs are put on the wire in order, the peer drops smaller ones.
+	session.writeMutex.Lock()
+	defer session.writeMutex.Unlock()
+
+	session.mutex.Lock()
+	if session.err != nil {
+		session.mutex.Unlock()
+		return nil, session.err
+	}
+	id := session.nextID
+	if id+2 < id {
+		session.mutex.Unlock()
+		return nil, ErrTooManyStreams
+	}
+	session.nextID += 2
+	stream := newStream(session, id)
+	session.streams[id] = stream
+	session.mutex.Unlock()
+
+	// An empty window update opens the stream on the peer.
+	if err := session.writeFrameLocked(typeWindow, id, make([]byte, 4)); err != nil {
+		return nil, err
+	}
+	return stream, nil
+}
+
+// Accept accepts a stream opened by the peer.
+func (session *Session) Accept() (net.Conn, error) {
+	select {
+	case stream := <-session.accept:
+		return stream, nil
+	case <-session.closeChan:
+		session.mutex.Lock()
+		defer session.mutex.Unlock()
+		return nil, &net.OpError{Op: "accept", Net: "mux", Addr: session.Addr(), Err: session.err}
+	}
+}
+
+func (session *Session) Addr() net.Addr {
+	return session.conn.LocalAddr()
+}
+
+// Close closes the connection and so all the streams.
+func (session *Session) Close() error {
+	session.fail(net.ErrClosed)
+	return nil
+}
+
+// NumStreams is the streams open.
+func (session *Session) NumStreams() int {
+	session.mutex.Lock()
+	defer session.mutex.Unlock()
+	return len(session.streams)
+}
+
+func (session *Session) fail(err error) {
+	session.closeOnce.Do(func() {
+		session.mutex.Lock()
+		session.err = err
+		streams := session.streams
+		session.streams = make(map[uint32]*Stream)
+		session.mutex.Unlock()
+		close(session.closeChan)
+		session.conn.Close()
+		for _, stream := range streams {
+			stream.fail(err)
+		}
+	})
+}
+
+func (session *Session) writeFrame(typ byte, id uint32, payload []byte) error {
+	session.writeMutex.Lock()
+	defer session.writeMutex.Unlock()
+	return session.writeFrameLocked(typ, id, payload)
+}
+
+// writeFrameLocked writes a frame in one Write, it must be called with
+// writeMutex locked.
+func (session *Session) writeFrameLocked(typ byte, id uint32, payload []byte) error {
+	frame := session.writeBuf[:frameHeadSize]
+	frame[0] = typ
+	binary.BigEndian.PutUint32(frame[1:], id)
+	binary.BigEndian.PutUint32(frame[5:], uint32(len(payload)))
+	frame = append(frame, payload...)
+	if _, err := session.conn.Write(frame); err != nil {
+		session.fail(err)
+		return err
+	}
+	return nil
+}
+
+func (session *Session) readLoop() {
+	var head [frameHeadSize]byte
+	buf := make([]byte, maxFrameSize)
+	for {
+		if _, err := io.ReadFull(session.conn, head[:]); err != nil {
+			session.fail(err)
+			return
+		}
+		typ := head[0]
+		id := binary.BigEndian.Uint32(head[1:])
+		size := binary.BigEndian.Uint32(head[5:])
+		if size > maxFrameSize {
+			session.fail(ErrBadFrame)
+			return
+		}
+		payload := buf[:size]
+		if _, err := io.ReadFull(session.conn, payload); err != nil {
+			session.fail(err)
+			return
+		}
+		if err := session.input(typ, id, payload); err != nil {
+			session.fail(err)
+			return
+		}
+	}
+}
+
+func (session *Session) input(typ byte, id uint32, payload []byte) error {
+	stream := session.stream(typ, id)
+	if stream == nil {
+		return nil
+	}
+	switch typ {
+	case typeData:
+		return stream.inputData(payload)
+	case typeWindow:
+		if len(payload) != 4 {
+			return ErrBadFrame
+		}
+		stream.inputWindow(binary.BigEndian.Uint32(payload))
+	case typeFin:
+		stream.inputFin()
+	case typeRst:
+		stream.fail(ErrStreamReset)
+		session.removeStream(id)
+	default:
+		return ErrBadFrame
+	}
+	return nil
+}
+
+// stream finds the stream of id, a new one is opened by the first frame
+// of the peer. The frames of closed streams are dropped.
+func (session *Session) stream(typ byte, id uint32) *Stream {
+	session.mutex.Lock()
+	defer session.mutex.Unlock()
+	if stream := session.streams[id]; stream != nil {
+		return stream
+	}
+	// The IDs of the peer have the other parity, and only grow.
+	if id%2 == session.nextID%2 || id < session.peerID || typ == typeRst || session.err != nil {
+		return nil
+	}
+	session.peerID = id + 2
+	stream := newStream(session, id)
+	select {
+	case session.accept <- stream:
+	default:
+		go session.writeFrame(typeRst, id, nil)
+		return nil
+	}
+	session.streams[id] = stream
+	return stream
+}
+
+func (session *Session) removeStream(id uint32) {
+	session.mutex.Lock()
+	defer session.mutex.Unlock()
+	delete(session.streams, id)
+}
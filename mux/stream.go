@@ -0,0 +1,265 @@
+package mux
+
+import (
+	"encoding/binary"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+// Stream is a net.Conn multiplexed in a Session.
+type Stream struct {
+	session *Session
+	id      uint32
+
+	mutex      sync.Mutex
+	recvBuf    []byte
+	recvWindow int
+	consumed   int
+	sendWindow int
+	finRecv    bool
+	finSent    bool
+	err        error
+
+	readDeadline  time.Time
+	writeDeadline time.Time
+	readChan      chan struct{}
+	writeChan     chan struct{}
+	closeOnce     sync.Once
+	closeChan     chan struct{}
+}
+
+func newStream(session *Session, id uint32) *Stream {
+	return &Stream{
+		session:    session,
+		id:         id,
+		recvWindow: session.config.Window,
+		sendWindow: session.config.Window,
+		readChan:   make(chan struct{}, 1),
+		writeChan:  make(chan struct{}, 1),
+		closeChan:  make(chan struct{}),
+	}
+}
+
+func notify(ch chan struct{}) {
+	select {
+	case ch <- struct{}{}:
+	default:
+	}
+}
+
+// ID is odd for the streams opened by the client and even for the server.
+func (s *Stream) ID() uint32 {
+	return s.id
+}
+
+func (s *Stream) inputData(payload []byte) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.err != nil || s.finRecv {
+		return nil
+	}
+	if len(payload) > s.recvWindow {
+		return ErrBadFrame
+	}
+	s.recvWindow -= len(payload)
+	s.recvBuf = append(s.recvBuf, payload...)
+	notify(s.readChan)
+	return nil
+}
+
+func (s *Stream) inputWindow(n uint32) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.sendWindow += int(n)
+	notify(s.writeChan)
+}
+
+func (s *Stream) inputFin() {
+	s.mutex.Lock()
+	s.finRecv = true
+	done := s.finSent
+	s.mutex.Unlock()
+	notify(s.readChan)
+	notify(s.writeChan)
+	if done {
+		s.session.removeStream(s.id)
+	}
+}
+
+// wait blocks until ch is notified, the stream closed, or deadline.
+func (s *Stream) wait(ch chan struct{}, deadline time.Time) error {
+	if deadline.IsZero() {
+		select {
+		case <-ch:
+		case <-s.closeChan:
+		}
+		return nil
+	}
+	d := time.Until(deadline)
+	if d <= 0 {
+		return timeoutError{}
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ch:
+	case <-s.closeChan:
+	case <-timer.C:
+		return timeoutError{}
+	}
+	return nil
+}
+
+func (s *Stream) Read(b []byte) (int, error) {
+	for {
+		s.mutex.Lock()
+		if len(s.recvBuf) > 0 {
+			n := copy(b, s.recvBuf)
+			s.recvBuf = s.recvBuf[n:]
+			if len(s.recvBuf) == 0 {
+				s.recvBuf = nil
+			}
+			// The window is given back once half of it is read.
+			s.consumed += n
+			var update uint32
+			if s.consumed >= s.session.config.Window/2 {
+				update = uint32(s.consumed)
+				s.recvWindow += s.consumed
+				s.consumed = 0
+			}
+			s.mutex.Unlock()
+			if update > 0 {
+				var payload [4]byte
+				binary.BigEndian.PutUint32(payload[:], update)
+				s.session.writeFrame(typeWindow, s.id, payload[:])
+			}
+			return n, nil
+		}
+		err := s.err
+		if err == nil && s.finRecv {
+			err = io.EOF
+		}
+		deadline := s.readDeadline
+		s.mutex.Unlock()
+		if err == io.EOF {
+			return 0, err
+		}
+		if err != nil {
+			return 0, s.opError("read", err)
+		}
+		if err := s.wait(s.readChan, deadline); err != nil {
+			return 0, s.opError("read", err)
+		}
+	}
+}
+
+func (s *Stream) Write(b []byte) (int, error) {
+	written := 0
+	for written < len(b) {
+		s.mutex.Lock()
+		err := s.err
+		if err == nil && s.finSent {
+			err = net.ErrClosed
+		} else if err == nil && s.finRecv {
+			err = io.ErrClosedPipe
+		}
+		if err != nil {
+			s.mutex.Unlock()
+			return written, s.opError("write", err)
+		}
+		if s.sendWindow == 0 {
+			deadline := s.writeDeadline
+			s.mutex.Unlock()
+			if err := s.wait(s.writeChan, deadline); err != nil {
+				return written, s.opError("write", err)
+			}
+			continue
+		}
+		n := len(b) - written
+		if n > s.sendWindow {
+			n = s.sendWindow
+		}
+		if n > maxFrameSize {
+			n = maxFrameSize
+		}
+		s.sendWindow -= n
+		s.mutex.Unlock()
+
+		if err := s.session.writeFrame(typeData, s.id, b[written:written+n]); err != nil {
+			return written, s.opError("write", err)
+		}
+		written += n
+	}
+	return written, nil
+}
+
+func (s *Stream) fail(err error) {
+	s.mutex.Lock()
+	if s.err == nil {
+		s.err = err
+	}
+	s.mutex.Unlock()
+	s.closeOnce.Do(func() {
+		close(s.closeChan)
+	})
+}
+
+// Close sends the end of stream, the peer reads the data sent before it and
+// can't write anymore. Reading after Close fails.
+func (s *Stream) Close() error {
+	s.mutex.Lock()
+	if s.err != nil || s.finSent {
+		s.mutex.Unlock()
+		return s.opError("close", net.ErrClosed)
+	}
+	s.finSent = true
+	done := s.finRecv
+	s.mutex.Unlock()
+	err := s.session.writeFrame(typeFin, s.id, nil)
+	s.fail(net.ErrClosed)
+	if done {
+		s.session.removeStream(s.id)
+	}
+	return err
+}
+
+func (s *Stream) opError(op string, err error) error {
+	return &net.OpError{Op: op, Net: "mux", Source: s.LocalAddr(), Addr: s.RemoteAddr(), Err: err}
+}
+
+func (s *Stream) LocalAddr() net.Addr {
+	return s.session.conn.LocalAddr()
+}
+
+func (s *Stream) RemoteAddr() net.Addr {
+	return s.session.conn.RemoteAddr()
+}
+
+func (s *Stream) SetDeadline(t time.Time) error {
+	s.SetReadDeadline(t)
+	return s.SetWriteDeadline(t)
+}
+
+func (s *Stream) SetReadDeadline(t time.Time) error {
+	s.mutex.Lock()
+	s.readDeadline = t
+	s.mutex.Unlock()
+	notify(s.readChan)
+	return nil
+}
+
+func (s *Stream) SetWriteDeadline(t time.Time) error {
+	s.mutex.Lock()
+	s.writeDeadline = t
+	s.mutex.Unlock()
+	notify(s.writeChan)
+	return nil
+}
+
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
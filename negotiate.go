@@ -0,0 +1,160 @@
+package link
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+var ErrNoCommonVersion = errors.New("No Common Protocol Version")
+var ErrBadOffer = errors.New("Bad Negotiation Offer")
+
+// Offer is what one side of a negotiation supports, the protocol versions
+// and the feature flags defined by the application, e.g. compression or
+// encryption.
+type Offer struct {
+	Versions []uint16
+	Features uint32
+}
+
+// Agreement is the configuration selected by a negotiation, the highest
+// version of both sides and the features both have.
+type Agreement struct {
+	Version  uint16
+	Features uint32
+}
+
+// NewProtocolFunc returns the protocol of an agreement, which the session
+// switches to by SetProtocol, or nil to keep the current one.
+type NewProtocolFunc func(agreement Agreement) (Protocol, error)
+
+type agreementKey struct{}
+
+// Negotiated returns the agreement of a session negotiated by Negotiate or
+// NegotiateClient.
+func Negotiated(session *Session) (Agreement, bool) {
+	value, ok := session.Get(agreementKey{})
+	if !ok {
+		return Agreement{}, false
+	}
+	return value.(Agreement), true
+}
+
+// Negotiate is the server side of a negotiation, for Server.SetAuthenticator.
+// It receives the offer of the client in the first message, replies the
+// agreement with offer of the server, and switches the session to the
+// protocol of it, so frame formats can change while old clients are still
+// served. Both messages are []byte, like ChallengeAuth. The client is
+// replied an empty message and the session fails with ErrNoCommonVersion
+// when they have no common version.
+func Negotiate(offer Offer, newProtocol NewProtocolFunc) Authenticator {
+	return AuthenticatorFunc(func(session *Session) error {
+		msg, err := session.Receive()
+		if err != nil {
+			return err
+		}
+		data, ok := msgBytes(msg)
+		if !ok {
+			return ErrBadOffer
+		}
+		peer, err := decodeOffer(data)
+		if err != nil {
+			return err
+		}
+		agreement, ok := agree(offer, peer)
+		if !ok {
+			session.Send([]byte{})
+			return ErrNoCommonVersion
+		}
+		reply := make([]byte, 6)
+		binary.BigEndian.PutUint16(reply, agreement.Version)
+		binary.BigEndian.PutUint32(reply[2:], agreement.Features)
+		if err := session.Send(reply); err != nil {
+			return err
+		}
+		return switchAgreement(session, agreement, newProtocol)
+	})
+}
+
+// NegotiateClient is the client side of Negotiate, for Handshake.
+func NegotiateClient(offer Offer, newProtocol NewProtocolFunc) Authenticator {
+	return AuthenticatorFunc(func(session *Session) error {
+		if err := session.Send(encodeOffer(offer)); err != nil {
+			return err
+		}
+		msg, err := session.Receive()
+		if err != nil {
+			return err
+		}
+		data, ok := msgBytes(msg)
+		if !ok {
+			return ErrBadOffer
+		}
+		if len(data) == 0 {
+			return ErrNoCommonVersion
+		}
+		if len(data) != 6 {
+			return ErrBadOffer
+		}
+		agreement := Agreement{
+			Version:  binary.BigEndian.Uint16(data),
+			Features: binary.BigEndian.Uint32(data[2:]) & offer.Features,
+		}
+		if _, ok := agree(offer, Offer{Versions: []uint16{agreement.Version}}); !ok {
+			return ErrNoCommonVersion
+		}
+		return switchAgreement(session, agreement, newProtocol)
+	})
+}
+
+func switchAgreement(session *Session, agreement Agreement, newProtocol NewProtocolFunc) error {
+	session.Set(agreementKey{}, agreement)
+	if newProtocol == nil {
+		return nil
+	}
+	protocol, err := newProtocol(agreement)
+	if err != nil || protocol == nil {
+		return err
+	}
+	return session.SetProtocol(protocol)
+}
+
+// agree selects the highest version of both offers.
+func agree(a, b Offer) (Agreement, bool) {
+	var agreement Agreement
+	found := false
+	for _, v := range a.Versions {
+		for _, w := range b.Versions {
+			if v == w && (!found || v > agreement.Version) {
+				agreement.Version = v
+				found = true
+			}
+		}
+	}
+	agreement.Features = a.Features & b.Features
+	return agreement, found
+}
+
+// An offer is encoded as
+//
+//	features uint32 | versions uint16...
+//
+// in big-endian.
+func encodeOffer(offer Offer) []byte {
+	data := make([]byte, 4+2*len(offer.Versions))
+	binary.BigEndian.PutUint32(data, offer.Features)
+	for i, v := range offer.Versions {
+		binary.BigEndian.PutUint16(data[4+2*i:], v)
+	}
+	return data
+}
+
+func decodeOffer(data []byte) (Offer, error) {
+	if len(data) < 4 || len(data)%2 != 0 {
+		return Offer{}, ErrBadOffer
+	}
+	offer := Offer{Features: binary.BigEndian.Uint32(data)}
+	for i := 4; i < len(data); i += 2 {
+		offer.Versions = append(offer.Versions, binary.BigEndian.Uint16(data[i:]))
+	}
+	return offer, nil
+}
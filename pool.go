@@ -0,0 +1,134 @@
+package link
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+var ErrPoolClosed = errors.New("Pool Closed")
+
+// Pool shares up to size client sessions to a backend between goroutines,
+// each session is used by one goroutine at a time.
+type Pool struct {
+	dial   func() (*Session, error)
+	check  func(*Session) error
+	tokens chan struct{}
+
+	mutex  sync.Mutex
+	idle   []*Session
+	closed bool
+}
+
+// NewPool creates a pool creating sessions by dial, e.g. a closure of Dial.
+func NewPool(dial func() (*Session, error), size int) *Pool {
+	if size < 1 {
+		size = 1
+	}
+	pool := &Pool{
+		dial:   dial,
+		tokens: make(chan struct{}, size),
+	}
+	for i := 0; i < size; i++ {
+		pool.tokens <- struct{}{}
+	}
+	return pool
+}
+
+// SetHealthCheck makes Get run check on an idle session before giving it,
+// e.g. a ping, the failed ones are closed and replaced. Closed sessions are
+// always replaced.
+func (pool *Pool) SetHealthCheck(check func(*Session) error) {
+	pool.mutex.Lock()
+	defer pool.mutex.Unlock()
+	pool.check = check
+}
+
+// Get returns an idle session, or dials a new one. It waits until ctx is done
+// when size sessions are in use.
+func (pool *Pool) Get(ctx context.Context) (*Session, error) {
+	select {
+	case <-pool.tokens:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	for {
+		pool.mutex.Lock()
+		if pool.closed {
+			pool.mutex.Unlock()
+			pool.tokens <- struct{}{}
+			return nil, ErrPoolClosed
+		}
+		var session *Session
+		if n := len(pool.idle); n > 0 {
+			session = pool.idle[n-1]
+			pool.idle[n-1] = nil
+			pool.idle = pool.idle[:n-1]
+		}
+		check := pool.check
+		pool.mutex.Unlock()
+
+		if session == nil {
+			break
+		}
+		if !session.IsClosed() && (check == nil || check(session) == nil) {
+			return session, nil
+		}
+		session.Close()
+	}
+
+	session, err := pool.dial()
+	if err != nil {
+		pool.tokens <- struct{}{}
+		return nil, err
+	}
+	return session, nil
+}
+
+// Put gives back a session returned by Get, a closed one is dropped.
+func (pool *Pool) Put(session *Session) {
+	pool.mutex.Lock()
+	if pool.closed {
+		pool.mutex.Unlock()
+		session.Close()
+	} else {
+		if !session.IsClosed() {
+			pool.idle = append(pool.idle, session)
+		}
+		pool.mutex.Unlock()
+	}
+	pool.tokens <- struct{}{}
+}
+
+// Do calls fn with a session of the pool, the session is closed instead of
+// being reused when fn fails.
+func (pool *Pool) Do(ctx context.Context, fn func(*Session) error) error {
+	session, err := pool.Get(ctx)
+	if err != nil {
+		return err
+	}
+	err = fn(session)
+	if err != nil {
+		session.Close()
+	}
+	pool.Put(session)
+	return err
+}
+
+// Idle returns the number of idle sessions.
+func (pool *Pool) Idle() int {
+	pool.mutex.Lock()
+	defer pool.mutex.Unlock()
+	return len(pool.idle)
+}
+
+// Close closes the idle sessions, and the ones in use when they are put back.
+func (pool *Pool) Close() {
+	pool.mutex.Lock()
+	defer pool.mutex.Unlock()
+	pool.closed = true
+	for _, session := range pool.idle {
+		session.Close()
+	}
+	pool.idle = nil
+}
@@ -0,0 +1,123 @@
+package link
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// Priority is the lane of a message sent by SendPriority, the messages of a
+// higher lane are written before the ones waiting in the lower lanes.
+type Priority int
+
+const (
+	// PriorityBulk is the send channel, like Send.
+	PriorityBulk Priority = iota
+	PriorityRealtime
+	PriorityControl
+)
+
+// priorityLanes are the queues of the lanes above PriorityBulk, each holds up
+// to the size of the send channel.
+type priorityLanes struct {
+	mutex  sync.Mutex
+	queues [PriorityControl][]interface{}
+	notify chan struct{}
+}
+
+// SendPriority is Send in a lane, so e.g. heartbeats and control messages
+// are not stuck behind a bulk transfer to a slow client. A session without
+// send channel writes in the order of the calls. A full lane follows the send
+// policy except BlockWhenFull, which closes the session like CloseWhenFull.
+// The messages of a lane above PriorityBulk may pass a SetProtocol switch
+// queued before them.
+func (session *Session) SendPriority(msg interface{}, priority Priority) error {
+	if priority <= PriorityBulk || session.sendChan == nil {
+		return session.Send(msg)
+	}
+	if priority > PriorityControl {
+		priority = PriorityControl
+	}
+	msg, err := session.sendChain.run(msg)
+	if err != nil || msg == nil {
+		return err
+	}
+
+	size, err := session.charge(msg)
+	if err != nil {
+		return err
+	}
+	session.sendMutex.RLock()
+	if session.IsClosed() {
+		session.sendMutex.RUnlock()
+		session.uncharge(size)
+		return SessionClosedError
+	}
+	err = session.pushLane(msg, priority)
+	session.sendMutex.RUnlock()
+	if err != nil {
+		session.uncharge(size)
+	}
+	if err == SessionBlockedError {
+		session.closeWith(err)
+	}
+	return err
+}
+
+// pushLane must be called with sendMutex read locked.
+func (session *Session) pushLane(msg interface{}, priority Priority) error {
+	lanes := &session.lanes
+	lanes.mutex.Lock()
+	queue := &lanes.queues[priority-1]
+	if len(*queue) >= cap(session.sendChan) {
+		switch SendPolicy(atomic.LoadInt32(&session.sendPolicy)) {
+		case DropNewest:
+			lanes.mutex.Unlock()
+			return MessageDroppedError
+		case DropOldest:
+			dropped := (*queue)[0]
+			(*queue)[0] = nil
+			*queue = (*queue)[1:]
+			session.released(dropped)
+			defer session.clearDropped(dropped)
+		default:
+			lanes.mutex.Unlock()
+			return SessionBlockedError
+		}
+	}
+	*queue = append(*queue, msg)
+	lanes.mutex.Unlock()
+	select {
+	case lanes.notify <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// popLane takes the oldest message of the highest lane not empty.
+func (session *Session) popLane() (interface{}, bool) {
+	lanes := &session.lanes
+	lanes.mutex.Lock()
+	defer lanes.mutex.Unlock()
+	for i := len(lanes.queues) - 1; i >= 0; i-- {
+		if queue := &lanes.queues[i]; len(*queue) > 0 {
+			msg := (*queue)[0]
+			(*queue)[0] = nil
+			*queue = (*queue)[1:]
+			return msg, true
+		}
+	}
+	return nil, false
+}
+
+// drainLanes takes the messages of all lanes out at Close.
+func (session *Session) drainLanes() []interface{} {
+	lanes := &session.lanes
+	lanes.mutex.Lock()
+	defer lanes.mutex.Unlock()
+	var msgs []interface{}
+	for i := len(lanes.queues) - 1; i >= 0; i-- {
+		msgs = append(msgs, lanes.queues[i]...)
+		lanes.queues[i] = nil
+	}
+	return msgs
+}
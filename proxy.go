@@ -0,0 +1,227 @@
+package link
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var ErrBadProxyHeader = errors.New("Bad PROXY Header")
+
+var proxyV2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+// ProxyListener reads the HAProxy PROXY protocol header, version 1 or 2, of
+// each connection accepted by listener, so RemoteAddr of the connections and
+// their sessions is the client address, not the one of the load balancer.
+// A connection without a valid header within timeout is closed. The headers
+// are read in background, a slow client doesn't delay the others. Use it like
+// NewServer(ProxyListener(listener, time.Second), ...), under a TLS listener
+// if any.
+func ProxyListener(listener net.Listener, timeout time.Duration) net.Listener {
+	l := &proxyListener{
+		Listener:  listener,
+		timeout:   timeout,
+		conns:     make(chan net.Conn),
+		closeChan: make(chan struct{}),
+		doneChan:  make(chan struct{}),
+	}
+	go l.acceptLoop()
+	return l
+}
+
+type proxyListener struct {
+	net.Listener
+	timeout   time.Duration
+	conns     chan net.Conn
+	err       error
+	closeOnce sync.Once
+	closeChan chan struct{}
+	doneChan  chan struct{}
+}
+
+func (l *proxyListener) acceptLoop() {
+	for {
+		conn, err := accept(l.Listener, nil)
+		if err != nil {
+			l.err = err
+			close(l.doneChan)
+			l.Close()
+			return
+		}
+		go l.handshake(conn)
+	}
+}
+
+func (l *proxyListener) handshake(conn net.Conn) {
+	if l.timeout > 0 {
+		conn.SetReadDeadline(time.Now().Add(l.timeout))
+	}
+	proxyConn, err := readProxyHeader(conn)
+	if err != nil {
+		conn.Close()
+		return
+	}
+	conn.SetReadDeadline(time.Time{})
+	select {
+	case l.conns <- proxyConn:
+	case <-l.closeChan:
+		conn.Close()
+	}
+}
+
+func (l *proxyListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.conns:
+		return conn, nil
+	case <-l.doneChan:
+		return nil, l.err
+	}
+}
+
+func (l *proxyListener) Close() error {
+	var err error
+	l.closeOnce.Do(func() {
+		close(l.closeChan)
+		err = l.Listener.Close()
+	})
+	return err
+}
+
+// proxyConn reads the bytes buffered after the header first.
+type proxyConn struct {
+	net.Conn
+	reader *bufio.Reader
+	remote net.Addr
+	local  net.Addr
+}
+
+func (c *proxyConn) Read(p []byte) (int, error) {
+	return c.reader.Read(p)
+}
+
+func (c *proxyConn) RemoteAddr() net.Addr {
+	return c.remote
+}
+
+func (c *proxyConn) LocalAddr() net.Addr {
+	return c.local
+}
+
+// readProxyHeader keeps the addresses of conn for the LOCAL command of
+// version 2 and the UNKNOWN protocol of version 1, like health checks of the
+// load balancer.
+func readProxyHeader(conn net.Conn) (*proxyConn, error) {
+	c := &proxyConn{
+		Conn:   conn,
+		reader: bufio.NewReader(conn),
+		remote: conn.RemoteAddr(),
+		local:  conn.LocalAddr(),
+	}
+	head, err := c.reader.Peek(len(proxyV2Signature))
+	if err != nil {
+		return nil, err
+	}
+	if bytes.Equal(head, proxyV2Signature) {
+		err = c.readV2()
+	} else {
+		err = c.readV1()
+	}
+	if err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func (c *proxyConn) readV1() error {
+	// The longest version 1 header is 107 bytes.
+	var line []byte
+	for len(line) < 107 {
+		b, err := c.reader.ReadByte()
+		if err != nil {
+			return err
+		}
+		line = append(line, b)
+		if b == '\n' {
+			break
+		}
+	}
+	if !bytes.HasSuffix(line, []byte("\r\n")) {
+		return ErrBadProxyHeader
+	}
+	fields := strings.Split(string(line[:len(line)-2]), " ")
+	if len(fields) < 2 || fields[0] != "PROXY" {
+		return ErrBadProxyHeader
+	}
+	if fields[1] == "UNKNOWN" {
+		return nil
+	}
+	if len(fields) != 6 || (fields[1] != "TCP4" && fields[1] != "TCP6") {
+		return ErrBadProxyHeader
+	}
+	src, dst := net.ParseIP(fields[2]), net.ParseIP(fields[3])
+	srcPort, err1 := strconv.ParseUint(fields[4], 10, 16)
+	dstPort, err2 := strconv.ParseUint(fields[5], 10, 16)
+	if src == nil || dst == nil || err1 != nil || err2 != nil {
+		return ErrBadProxyHeader
+	}
+	c.remote = &net.TCPAddr{IP: src, Port: int(srcPort)}
+	c.local = &net.TCPAddr{IP: dst, Port: int(dstPort)}
+	return nil
+}
+
+func (c *proxyConn) readV2() error {
+	var head [16]byte
+	if _, err := io.ReadFull(c.reader, head[:]); err != nil {
+		return err
+	}
+	if head[12]>>4 != 2 {
+		return ErrBadProxyHeader
+	}
+	body := make([]byte, binary.BigEndian.Uint16(head[14:]))
+	if _, err := io.ReadFull(c.reader, body); err != nil {
+		return err
+	}
+	switch head[12] & 0xf {
+	case 0:
+		return nil
+	case 1:
+	default:
+		return ErrBadProxyHeader
+	}
+
+	var ipLen int
+	switch head[13] >> 4 {
+	case 1:
+		ipLen = net.IPv4len
+	case 2:
+		ipLen = net.IPv6len
+	default:
+		// AF_UNSPEC and AF_UNIX have no IP to tell.
+		return nil
+	}
+	if len(body) < 2*ipLen+4 {
+		return ErrBadProxyHeader
+	}
+	src := net.IP(append([]byte(nil), body[:ipLen]...))
+	dst := net.IP(append([]byte(nil), body[ipLen:2*ipLen]...))
+	srcPort := int(binary.BigEndian.Uint16(body[2*ipLen:]))
+	dstPort := int(binary.BigEndian.Uint16(body[2*ipLen+2:]))
+	switch head[13] & 0xf {
+	case 1:
+		c.remote = &net.TCPAddr{IP: src, Port: srcPort}
+		c.local = &net.TCPAddr{IP: dst, Port: dstPort}
+	case 2:
+		c.remote = &net.UDPAddr{IP: src, Port: srcPort}
+		c.local = &net.UDPAddr{IP: dst, Port: dstPort}
+	default:
+		return ErrBadProxyHeader
+	}
+	return nil
+}
@@ -0,0 +1,84 @@
+package link
+
+import (
+	"sync"
+	"time"
+)
+
+// Quality is an estimation of the connection quality of a session.
+type Quality struct {
+	RTT         time.Duration // smoothed round trip time
+	Jitter      time.Duration // round trip time variation
+	Throughput  float64       // messages sent per second
+	Retransmits uint64        // retransmission hints reported
+	Score       float64       // 1 is perfect, 0 is unusable
+}
+
+type qualityEstimator struct {
+	mutex       sync.Mutex
+	srtt        time.Duration
+	rttvar      time.Duration
+	samples     int
+	retransmits uint64
+	lastTime    time.Time
+	lastPackets uint64
+	throughput  float64
+}
+
+// addRTT updates the estimation like RFC 6298 does.
+func (q *qualityEstimator) addRTT(rtt time.Duration) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	if q.samples == 0 {
+		q.srtt = rtt
+		q.rttvar = rtt / 2
+	} else {
+		delta := q.srtt - rtt
+		if delta < 0 {
+			delta = -delta
+		}
+		q.rttvar = (3*q.rttvar + delta) / 4
+		q.srtt = (7*q.srtt + rtt) / 8
+	}
+	q.samples++
+}
+
+func (q *qualityEstimator) addRetransmits(n uint64) {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+	q.retransmits += n
+}
+
+// get measures the throughput between calls at least one second apart.
+func (q *qualityEstimator) get(sendPackets uint64, now time.Time) Quality {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	if q.lastTime.IsZero() {
+		q.lastTime, q.lastPackets = now, sendPackets
+	} else if elapsed := now.Sub(q.lastTime).Seconds(); elapsed >= 1 {
+		rate := float64(sendPackets-q.lastPackets) / elapsed
+		if q.throughput == 0 {
+			q.throughput = rate
+		} else {
+			q.throughput = 0.7*q.throughput + 0.3*rate
+		}
+		q.lastTime, q.lastPackets = now, sendPackets
+	}
+
+	quality := Quality{
+		RTT:         q.srtt,
+		Jitter:      q.rttvar,
+		Throughput:  q.throughput,
+		Retransmits: q.retransmits,
+		Score:       1,
+	}
+	// Every 100ms of latency, 50ms of jitter or 10% of the messages sent
+	// retransmitted add 1 to the penalty, e.g. 200ms of latency scores 1/3.
+	penalty := float64(q.srtt)/float64(100*time.Millisecond) + float64(q.rttvar)/float64(50*time.Millisecond)
+	if sendPackets > 0 {
+		penalty += float64(q.retransmits) / float64(sendPackets) * 10
+	}
+	quality.Score = 1 / (1 + penalty)
+	return quality
+}
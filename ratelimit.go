@@ -0,0 +1,46 @@
+package link
+
+import (
+	"errors"
+)
+
+var ErrRateLimited = errors.New("Rate Limited")
+
+// LimitPolicy tells what RateLimit does with a message over the limit.
+type LimitPolicy int
+
+const (
+	// LimitDelay waits until the message is within the limit.
+	LimitDelay LimitPolicy = iota
+	// LimitDrop drops the message.
+	LimitDrop
+	// LimitClose fails with ErrRateLimited, which closes a receiving session.
+	LimitClose
+)
+
+// RateLimit returns a middleware limiting the messages by bucket, the same
+// bucket for many sessions limits them together. cost tells the tokens of a
+// message, e.g. its size in bytes, nil means one per message.
+func RateLimit(bucket *TokenBucket, cost func(msg interface{}) int, policy LimitPolicy) Middleware {
+	return func(next MessageFunc) MessageFunc {
+		return func(msg interface{}) (interface{}, error) {
+			n := 1
+			if cost != nil {
+				n = cost(msg)
+			}
+			switch policy {
+			case LimitDelay:
+				bucket.Wait(n)
+			case LimitDrop:
+				if !bucket.Allow(n) {
+					return nil, nil
+				}
+			default:
+				if !bucket.Allow(n) {
+					return nil, ErrRateLimited
+				}
+			}
+			return next(msg)
+		}
+	}
+}
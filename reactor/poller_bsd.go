@@ -0,0 +1,101 @@
+//go:build darwin || freebsd
+// +build darwin freebsd
+
+package reactor
+
+import (
+	"sync"
+	"syscall"
+)
+
+type kqueuePoller struct {
+	kq     int
+	wake   [2]int
+	mutex  sync.RWMutex
+	closed bool
+	events [256]syscall.Kevent_t
+}
+
+func newPoller() (poller, error) {
+	kq, err := syscall.Kqueue()
+	if err != nil {
+		return nil, err
+	}
+	syscall.CloseOnExec(kq)
+	p := &kqueuePoller{kq: kq}
+	if err := syscall.Pipe(p.wake[:]); err != nil {
+		syscall.Close(kq)
+		return nil, err
+	}
+	syscall.CloseOnExec(p.wake[0])
+	syscall.CloseOnExec(p.wake[1])
+	syscall.SetNonblock(p.wake[1], true)
+	if err := p.register(p.wake[0], syscall.EV_ADD); err != nil {
+		p.release()
+		return nil, err
+	}
+	return p, nil
+}
+
+func (p *kqueuePoller) register(fd, flags int) error {
+	var change [1]syscall.Kevent_t
+	syscall.SetKevent(&change[0], fd, syscall.EVFILT_READ, flags)
+	_, err := syscall.Kevent(p.kq, change[:], nil, nil)
+	return err
+}
+
+func (p *kqueuePoller) ctl(fd int) error {
+	p.mutex.RLock()
+	defer p.mutex.RUnlock()
+	if p.closed {
+		return errPollerClosed
+	}
+	return p.register(fd, syscall.EV_ADD|syscall.EV_ONESHOT)
+}
+
+func (p *kqueuePoller) add(fd int) error {
+	return p.ctl(fd)
+}
+
+func (p *kqueuePoller) rearm(fd int) error {
+	return p.ctl(fd)
+}
+
+func (p *kqueuePoller) wait(ready func(fd int)) error {
+	n, err := syscall.Kevent(p.kq, nil, p.events[:], nil)
+	if err != nil {
+		if err == syscall.EINTR {
+			return nil
+		}
+		return err
+	}
+	for i := 0; i < n; i++ {
+		fd := int(p.events[i].Ident)
+		if fd == p.wake[0] {
+			p.mutex.Lock()
+			p.release()
+			p.mutex.Unlock()
+			return errPollerClosed
+		}
+		ready(fd)
+	}
+	return nil
+}
+
+// close wakes wait up, which releases the descriptors.
+func (p *kqueuePoller) close() error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if p.closed {
+		return nil
+	}
+	p.closed = true
+	_, err := syscall.Write(p.wake[1], []byte{0})
+	return err
+}
+
+func (p *kqueuePoller) release() {
+	syscall.Close(p.kq)
+	syscall.Close(p.wake[0])
+	syscall.Close(p.wake[1])
+}
@@ -0,0 +1,92 @@
+//go:build linux
+// +build linux
+
+package reactor
+
+import (
+	"sync"
+	"syscall"
+)
+
+type epoller struct {
+	epfd   int
+	wake   [2]int
+	mutex  sync.RWMutex
+	closed bool
+	events [256]syscall.EpollEvent
+}
+
+func newPoller() (poller, error) {
+	epfd, err := syscall.EpollCreate1(syscall.EPOLL_CLOEXEC)
+	if err != nil {
+		return nil, err
+	}
+	p := &epoller{epfd: epfd}
+	if err := syscall.Pipe2(p.wake[:], syscall.O_NONBLOCK|syscall.O_CLOEXEC); err != nil {
+		syscall.Close(epfd)
+		return nil, err
+	}
+	event := syscall.EpollEvent{Events: syscall.EPOLLIN, Fd: int32(p.wake[0])}
+	if err := syscall.EpollCtl(epfd, syscall.EPOLL_CTL_ADD, p.wake[0], &event); err != nil {
+		p.release()
+		return nil, err
+	}
+	return p, nil
+}
+
+func (p *epoller) ctl(op, fd int) error {
+	p.mutex.RLock()
+	defer p.mutex.RUnlock()
+	if p.closed {
+		return errPollerClosed
+	}
+	event := syscall.EpollEvent{Events: syscall.EPOLLIN | syscall.EPOLLRDHUP | syscall.EPOLLONESHOT, Fd: int32(fd)}
+	return syscall.EpollCtl(p.epfd, op, fd, &event)
+}
+
+func (p *epoller) add(fd int) error {
+	return p.ctl(syscall.EPOLL_CTL_ADD, fd)
+}
+
+func (p *epoller) rearm(fd int) error {
+	return p.ctl(syscall.EPOLL_CTL_MOD, fd)
+}
+
+func (p *epoller) wait(ready func(fd int)) error {
+	n, err := syscall.EpollWait(p.epfd, p.events[:], -1)
+	if err != nil {
+		if err == syscall.EINTR {
+			return nil
+		}
+		return err
+	}
+	for i := 0; i < n; i++ {
+		fd := int(p.events[i].Fd)
+		if fd == p.wake[0] {
+			p.mutex.Lock()
+			p.release()
+			p.mutex.Unlock()
+			return errPollerClosed
+		}
+		ready(fd)
+	}
+	return nil
+}
+
+// close wakes wait up, which releases the descriptors.
+func (p *epoller) close() error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if p.closed {
+		return nil
+	}
+	p.closed = true
+	_, err := syscall.Write(p.wake[1], []byte{0})
+	return err
+}
+
+func (p *epoller) release() {
+	syscall.Close(p.epfd)
+	syscall.Close(p.wake[0])
+	syscall.Close(p.wake[1])
+}
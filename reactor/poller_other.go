@@ -0,0 +1,8 @@
+//go:build !linux && !darwin && !freebsd
+// +build !linux,!darwin,!freebsd
+
+package reactor
+
+func newPoller() (poller, error) {
+	return nil, ErrUnsupported
+}
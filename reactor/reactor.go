@@ -0,0 +1,259 @@
+// Package reactor serves sessions without goroutines while they are idle.
+// The connections wait for data in one epoll or kqueue, and a pool of
+// workers receives and handles a message of each ready connection, so a
+// server holding many mostly idle connections takes the memory of the
+// connections and codecs only.
+//
+// The protocol must not read ahead of the message it receives, like Bufio,
+// since the bytes buffered by a codec wake no worker. The sessions have no
+// send channel, their messages are written by the goroutines sending them.
+package reactor
+
+import (
+	"errors"
+	"net"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/funny/link"
+)
+
+var ErrUnsupported = errors.New("Reactor Unsupported")
+var ErrNotSyscallConn = errors.New("Not Syscall Conn")
+var errPollerClosed = errors.New("Poller Closed")
+
+// Handler handles the messages of the sessions of a reactor server. The
+// messages of a session are handled one at a time in order, by any worker.
+type Handler interface {
+	HandleMessage(session *link.Session, msg interface{})
+}
+
+type HandlerFunc func(session *link.Session, msg interface{})
+
+func (f HandlerFunc) HandleMessage(session *link.Session, msg interface{}) {
+	f(session, msg)
+}
+
+// ConnectHandler is implemented by the handlers told of the new sessions, it
+// is called by a worker before the first message.
+type ConnectHandler interface {
+	HandleConnect(session *link.Session)
+}
+
+// poller waits for the connections to be readable, an added connection is
+// reported once until it is rearmed.
+type poller interface {
+	add(fd int) error
+	rearm(fd int) error
+	wait(ready func(fd int)) error
+	close() error
+}
+
+type conn struct {
+	fd      int
+	session *link.Session
+}
+
+type Server struct {
+	listener net.Listener
+	protocol link.Protocol
+	handler  Handler
+	manager  *link.Manager
+	poller   poller
+	jobs     chan func()
+
+	readTimeout time.Duration
+
+	connMutex sync.Mutex
+	conns     map[int]*conn
+
+	stopOnce sync.Once
+	stopChan chan struct{}
+}
+
+// NewServer creates a server handling the sessions of listener with workers
+// goroutines. The connections of listener must be syscall.Conn, like TCP and
+// Unix ones, not wrapped by a reading buffer. It fails with ErrUnsupported on
+// systems without epoll or kqueue.
+func NewServer(listener net.Listener, protocol link.Protocol, workers int, handler Handler) (*Server, error) {
+	poller, err := newPoller()
+	if err != nil {
+		return nil, err
+	}
+	if workers <= 0 {
+		workers = 1
+	}
+	server := &Server{
+		listener:    listener,
+		protocol:    protocol,
+		handler:     handler,
+		manager:     link.NewManager(),
+		poller:      poller,
+		jobs:        make(chan func(), workers),
+		readTimeout: 10 * time.Second,
+		conns:       make(map[int]*conn),
+		stopChan:    make(chan struct{}),
+	}
+	for i := 0; i < workers; i++ {
+		go server.work()
+	}
+	go server.poll()
+	return server, nil
+}
+
+func (server *Server) Listener() net.Listener {
+	return server.listener
+}
+
+func (server *Server) Manager() *link.Manager {
+	return server.manager
+}
+
+// SetReadTimeout limits the time of receiving a message once its first bytes
+// arrived, so a slow client can't hold a worker. It is 10 seconds by default,
+// zero means no limit. It applies to new sessions.
+func (server *Server) SetReadTimeout(timeout time.Duration) {
+	server.connMutex.Lock()
+	defer server.connMutex.Unlock()
+	server.readTimeout = timeout
+}
+
+// Serve accepts connections until the listener is closed.
+func (server *Server) Serve() error {
+	for {
+		c, err := server.listener.Accept()
+		if err != nil {
+			if ne, ok := err.(net.Error); ok && ne.Temporary() {
+				time.Sleep(5 * time.Millisecond)
+				continue
+			}
+			return err
+		}
+		server.submit(func() {
+			server.open(c)
+		})
+	}
+}
+
+func (server *Server) submit(job func()) bool {
+	select {
+	case server.jobs <- job:
+		return true
+	case <-server.stopChan:
+		return false
+	}
+}
+
+func (server *Server) work() {
+	for {
+		select {
+		case job := <-server.jobs:
+			job()
+		case <-server.stopChan:
+			return
+		}
+	}
+}
+
+func (server *Server) poll() {
+	for {
+		err := server.poller.wait(func(fd int) {
+			server.connMutex.Lock()
+			c := server.conns[fd]
+			server.connMutex.Unlock()
+			if c != nil {
+				server.submit(func() {
+					server.serve(c)
+				})
+			}
+		})
+		if err != nil {
+			return
+		}
+	}
+}
+
+func fileDescriptor(c net.Conn) (int, error) {
+	sc, ok := c.(syscall.Conn)
+	if !ok {
+		return -1, ErrNotSyscallConn
+	}
+	raw, err := sc.SyscallConn()
+	if err != nil {
+		return -1, err
+	}
+	fd := -1
+	if err := raw.Control(func(s uintptr) {
+		fd = int(s)
+	}); err != nil {
+		return -1, err
+	}
+	return fd, nil
+}
+
+func (server *Server) open(nc net.Conn) {
+	fd, err := fileDescriptor(nc)
+	if err != nil {
+		nc.Close()
+		return
+	}
+	codec, err := server.protocol.NewCodec(nc)
+	if err != nil {
+		nc.Close()
+		return
+	}
+	session := server.manager.NewConnSession(nc, codec, 0)
+	if session.IsClosed() {
+		return
+	}
+	c := &conn{fd, session}
+
+	server.connMutex.Lock()
+	session.SetReadTimeout(server.readTimeout)
+	server.conns[fd] = c
+	server.connMutex.Unlock()
+	// The fd may be taken by a new connection before the callback runs.
+	session.AddCloseCallback(server, nil, func() {
+		server.connMutex.Lock()
+		if server.conns[fd] == c {
+			delete(server.conns, fd)
+		}
+		server.connMutex.Unlock()
+	})
+
+	if connect, ok := server.handler.(ConnectHandler); ok {
+		connect.HandleConnect(session)
+	}
+	if session.IsClosed() {
+		return
+	}
+	if err := server.poller.add(fd); err != nil {
+		session.Close()
+	}
+}
+
+func (server *Server) serve(c *conn) {
+	msg, err := c.session.Receive()
+	if err != nil {
+		c.session.Close()
+		return
+	}
+	server.handler.HandleMessage(c.session, msg)
+	if c.session.IsClosed() {
+		return
+	}
+	if err := server.poller.rearm(c.fd); err != nil {
+		c.session.Close()
+	}
+}
+
+// Stop closes the listener and the sessions, and stops the workers.
+func (server *Server) Stop() {
+	server.stopOnce.Do(func() {
+		server.listener.Close()
+		server.poller.close()
+		close(server.stopChan)
+		server.manager.Dispose()
+	})
+}
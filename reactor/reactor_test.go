@@ -0,0 +1,102 @@
+//go:build linux || darwin || freebsd
+// +build linux darwin freebsd
+
+package reactor
+
+import (
+	"encoding/binary"
+	"net"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/funny/link"
+	"github.com/funny/link/codec"
+	"github.com/funny/utest"
+)
+
+type echoHandler struct {
+	connects int32
+}
+
+func (h *echoHandler) HandleConnect(session *link.Session) {
+	atomic.AddInt32(&h.connects, 1)
+}
+
+func (h *echoHandler) HandleMessage(session *link.Session, msg interface{}) {
+	if string(msg.(*codec.InBuffer).Bytes()) == "bye" {
+		session.Close()
+		return
+	}
+	session.Send(msg)
+}
+
+func Test_Reactor(t *testing.T) {
+	protocol := codec.FixLen(codec.Raw(), 2, binary.BigEndian, 64*1024, 64*1024)
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	utest.IsNilNow(t, err)
+	handler := &echoHandler{}
+	server, err := NewServer(listener, protocol, 4, handler)
+	utest.IsNilNow(t, err)
+	go server.Serve()
+	defer server.Stop()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			session, err := link.Dial("tcp", listener.Addr().String(), protocol, 0)
+			utest.IsNilNow(t, err)
+			defer session.Close()
+			for j := 0; j < 50; j++ {
+				msg := make([]byte, 1+j*100)
+				msg[0] = byte(j)
+				utest.IsNilNow(t, session.Send(msg))
+				reply, err := session.Receive()
+				utest.IsNilNow(t, err)
+				utest.EqualNow(t, reply.(*codec.InBuffer).Len(), len(msg))
+				utest.EqualNow(t, reply.(*codec.InBuffer).Bytes()[0], byte(j))
+			}
+		}()
+	}
+	wg.Wait()
+	utest.EqualNow(t, atomic.LoadInt32(&handler.connects), int32(20))
+
+	// Idle sessions take no goroutine.
+	goroutines := runtime.NumGoroutine()
+	var idle []*link.Session
+	for i := 0; i < 50; i++ {
+		session, err := link.Dial("tcp", listener.Addr().String(), protocol, 0)
+		utest.IsNilNow(t, err)
+		defer session.Close()
+		idle = append(idle, session)
+	}
+	for begin := time.Now(); server.Manager().Len() < 50 && time.Since(begin) < time.Second; {
+		time.Sleep(time.Millisecond)
+	}
+	utest.EqualNow(t, server.Manager().Len(), 50)
+	utest.Assert(t, runtime.NumGoroutine() < goroutines+10)
+
+	// A partial packet is finished by the worker.
+	idle[0].Conn().Write([]byte{0, 5, 'h', 'e'})
+	time.Sleep(10 * time.Millisecond)
+	idle[0].Conn().Write([]byte{'l', 'l', 'o'})
+	reply, err := idle[0].Receive()
+	utest.IsNilNow(t, err)
+	utest.EqualNow(t, string(reply.(*codec.InBuffer).Bytes()), "hello")
+
+	utest.IsNilNow(t, idle[1].Send([]byte("bye")))
+	_, err = idle[1].Receive()
+	utest.NotNilNow(t, err)
+	for begin := time.Now(); server.Manager().Len() != 49 && time.Since(begin) < time.Second; {
+		time.Sleep(time.Millisecond)
+	}
+	utest.EqualNow(t, server.Manager().Len(), 49)
+
+	server.Stop()
+	_, err = idle[2].Receive()
+	utest.NotNilNow(t, err)
+}
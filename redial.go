@@ -0,0 +1,226 @@
+package link
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+var ErrNotConnected = errors.New("Not Connected")
+
+// RedialState is reported to the state callback of a Redialer.
+type RedialState int
+
+const (
+	// RedialConnecting is reported before each dial.
+	RedialConnecting RedialState = iota
+	// RedialConnected is reported when the dial and the handshake passed.
+	RedialConnected
+	// RedialDisconnected is reported with the error of a failed dial or
+	// handshake, or with nil when a connected session ended.
+	RedialDisconnected
+	// RedialClosed is reported once when the Redialer is closed.
+	RedialClosed
+)
+
+// Redialer keeps a client session connected, it dials again with jittered
+// exponential backoff whenever the dial fails or the session ends.
+type Redialer struct {
+	dial      func() (*Session, error)
+	handler   Handler
+	handshake func(*Session) error
+	onState   func(RedialState, error)
+	minDelay  time.Duration
+	maxDelay  time.Duration
+	stable    time.Duration
+
+	mutex     sync.Mutex
+	session   *Session
+	connected chan struct{}
+	started   bool
+	closeOnce sync.Once
+	closeChan chan struct{}
+	doneChan  chan struct{}
+}
+
+// AutoRedial creates a Redialer creating sessions by dial, e.g. a closure of
+// Dial or DialTLS. handler is given each connected session like by a server,
+// the session is closed when it returns. Configure it, then Start it.
+func AutoRedial(dial func() (*Session, error), handler Handler) *Redialer {
+	return &Redialer{
+		dial:      dial,
+		handler:   handler,
+		minDelay:  100 * time.Millisecond,
+		maxDelay:  30 * time.Second,
+		stable:    10 * time.Second,
+		connected: make(chan struct{}),
+		closeChan: make(chan struct{}),
+		doneChan:  make(chan struct{}),
+	}
+}
+
+// SetBackoff sets the first and the largest delay between dials, 100ms and
+// 30s by default. The delay doubles by each failed dial, it is back to min
+// after a connection stayed up for the stable time.
+func (r *Redialer) SetBackoff(min, max time.Duration) {
+	r.minDelay = min
+	r.maxDelay = max
+}
+
+// SetStableTime sets how long a session must stay up for the backoff to
+// start over, 10s by default. A session ending sooner, like one closed by a
+// server failing right after the handshake, doubles the delay like a failed
+// dial, so the clients don't hammer it.
+func (r *Redialer) SetStableTime(stable time.Duration) {
+	r.stable = stable
+}
+
+// grow doubles delay within the backoff.
+func (r *Redialer) grow(delay time.Duration) time.Duration {
+	if delay *= 2; delay < r.minDelay {
+		return r.minDelay
+	} else if delay > r.maxDelay {
+		return r.maxDelay
+	}
+	return delay
+}
+
+// SetHandshake makes each new session run handshake before it is connected,
+// e.g. a closure of Handshake with ChallengeResponse. A failed one is closed
+// and dialed again.
+func (r *Redialer) SetHandshake(handshake func(*Session) error) {
+	r.handshake = handshake
+}
+
+// SetStateCallback makes the state changes be reported to callback, it is
+// called by the dialing goroutine.
+func (r *Redialer) SetStateCallback(callback func(state RedialState, err error)) {
+	r.onState = callback
+}
+
+// Start dials in background, it is called once.
+func (r *Redialer) Start() {
+	r.mutex.Lock()
+	r.started = true
+	r.mutex.Unlock()
+	go r.loop()
+}
+
+func (r *Redialer) state(state RedialState, err error) {
+	if r.onState != nil {
+		r.onState(state, err)
+	}
+}
+
+func (r *Redialer) loop() {
+	defer close(r.doneChan)
+	defer r.state(RedialClosed, nil)
+
+	var delay time.Duration
+	for {
+		r.state(RedialConnecting, nil)
+		session, err := r.dial()
+		if err == nil && r.handshake != nil {
+			if err = r.handshake(session); err != nil {
+				session.Close()
+			}
+		}
+
+		if err != nil {
+			r.state(RedialDisconnected, err)
+			delay = r.grow(delay)
+		} else {
+			r.mutex.Lock()
+			select {
+			case <-r.closeChan:
+				r.mutex.Unlock()
+				session.Close()
+				return
+			default:
+			}
+			r.session = session
+			close(r.connected)
+			r.mutex.Unlock()
+
+			r.state(RedialConnected, nil)
+			connectedAt := time.Now()
+			r.handler.HandleSession(session)
+			session.Close()
+
+			r.mutex.Lock()
+			r.session = nil
+			r.connected = make(chan struct{})
+			r.mutex.Unlock()
+			r.state(RedialDisconnected, nil)
+			if time.Since(connectedAt) >= r.stable {
+				delay = r.minDelay
+			} else {
+				delay = r.grow(delay)
+			}
+		}
+
+		// Jittered, so the clients of a restarted server don't come back at
+		// once.
+		wait := delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+		select {
+		case <-time.After(wait):
+		case <-r.closeChan:
+			return
+		}
+	}
+}
+
+// Session returns the connected session, or nil.
+func (r *Redialer) Session() *Session {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.session
+}
+
+// Wait returns the connected session, waiting for one until ctx is done.
+func (r *Redialer) Wait(ctx context.Context) (*Session, error) {
+	for {
+		r.mutex.Lock()
+		session, connected := r.session, r.connected
+		r.mutex.Unlock()
+		if session != nil {
+			return session, nil
+		}
+		select {
+		case <-connected:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-r.closeChan:
+			return nil, SessionClosedError
+		}
+	}
+}
+
+// Send sends msg by the connected session, it fails with ErrNotConnected
+// while there is none.
+func (r *Redialer) Send(msg interface{}) error {
+	session := r.Session()
+	if session == nil {
+		return ErrNotConnected
+	}
+	return session.Send(msg)
+}
+
+// Close stops dialing and closes the connected session, it returns when the
+// handler returned.
+func (r *Redialer) Close() {
+	r.closeOnce.Do(func() {
+		r.mutex.Lock()
+		close(r.closeChan)
+		session, started := r.session, r.started
+		r.mutex.Unlock()
+		if session != nil {
+			session.Close()
+		}
+		if started {
+			<-r.doneChan
+		}
+	})
+}
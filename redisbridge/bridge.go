@@ -0,0 +1,223 @@
+// Package redisbridge relays broadcasts of a link.Channel through Redis
+// pub/sub, so sessions connected to any server of a fleet receive them.
+package redisbridge
+
+import (
+	"bufio"
+	"errors"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/funny/link"
+)
+
+var ErrClosed = errors.New("Bridge Closed")
+
+// Bridge is the link.Relay of a channel on a Redis channel, it publishes the
+// broadcasts of the channel and re-broadcasts the messages from other servers
+// to the local sessions.
+//
+// Messages on Redis are the encoded message behind the ID of the publishing
+// bridge, so a bridge skips its own messages.
+type Bridge struct {
+	addr    string
+	topic   string
+	id      string
+	channel *link.Channel
+	encode  func(msg interface{}) ([]byte, error)
+	decode  func(data []byte) (interface{}, error)
+
+	pubMutex  sync.Mutex
+	pubConn   net.Conn
+	pubReader *bufio.Reader
+	pubWriter *bufio.Writer
+
+	subMutex  sync.Mutex
+	subConn   net.Conn
+	subReader *bufio.Reader
+
+	closeOnce sync.Once
+	closeChan chan struct{}
+}
+
+// New creates a bridge of channel on the Redis channel topic and sets it as
+// the relay of channel. encode and decode convert messages to and from bytes.
+func New(addr, topic string, channel *link.Channel, encode func(interface{}) ([]byte, error), decode func([]byte) (interface{}, error)) (*Bridge, error) {
+	bridge := &Bridge{
+		addr:      addr,
+		topic:     topic,
+		id:        strconv.FormatInt(time.Now().UnixNano(), 36),
+		channel:   channel,
+		encode:    encode,
+		decode:    decode,
+		closeChan: make(chan struct{}),
+	}
+	if err := bridge.subscribe(); err != nil {
+		return nil, err
+	}
+	go bridge.subscribeLoop()
+	channel.SetRelay(bridge)
+	return bridge, nil
+}
+
+// Broadcast sends msg to the local sessions and publishes it to other servers,
+// like Broadcast of the channel.
+func (bridge *Bridge) Broadcast(msg interface{}) error {
+	_, err := bridge.channel.Broadcast(msg)
+	return err
+}
+
+// Publish sends msg to other servers.
+func (bridge *Bridge) Publish(msg interface{}) error {
+	data, err := bridge.encode(msg)
+	if err != nil {
+		return err
+	}
+	payload := make([]byte, 1+len(bridge.id)+len(data))
+	payload[0] = byte(len(bridge.id))
+	copy(payload[1:], bridge.id)
+	copy(payload[1+len(bridge.id):], data)
+	return bridge.publish(payload)
+}
+
+func (bridge *Bridge) publish(payload []byte) error {
+	bridge.pubMutex.Lock()
+	defer bridge.pubMutex.Unlock()
+
+	select {
+	case <-bridge.closeChan:
+		return ErrClosed
+	default:
+	}
+	if bridge.pubConn == nil {
+		conn, err := net.DialTimeout("tcp", bridge.addr, 5*time.Second)
+		if err != nil {
+			return err
+		}
+		bridge.pubConn = conn
+		bridge.pubReader = bufio.NewReader(conn)
+		bridge.pubWriter = bufio.NewWriter(conn)
+	}
+	// A stuck redis must not block Broadcast and the publishers queued on
+	// pubMutex forever.
+	bridge.pubConn.SetDeadline(time.Now().Add(5 * time.Second))
+	err := writeCommand(bridge.pubWriter, []byte("PUBLISH"), []byte(bridge.topic), payload)
+	if err == nil {
+		_, err = readReply(bridge.pubReader)
+	}
+	if err != nil {
+		if _, ok := err.(redisError); !ok {
+			bridge.pubConn.Close()
+			bridge.pubConn = nil
+		}
+	}
+	return err
+}
+
+func (bridge *Bridge) subscribe() error {
+	conn, err := net.DialTimeout("tcp", bridge.addr, 5*time.Second)
+	if err != nil {
+		return err
+	}
+	reader := bufio.NewReader(conn)
+	err = writeCommand(bufio.NewWriter(conn), []byte("SUBSCRIBE"), []byte(bridge.topic))
+	if err == nil {
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		_, err = readReply(reader)
+		conn.SetReadDeadline(time.Time{})
+	}
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	bridge.subMutex.Lock()
+	defer bridge.subMutex.Unlock()
+	select {
+	case <-bridge.closeChan:
+		conn.Close()
+		return ErrClosed
+	default:
+	}
+	bridge.subConn = conn
+	bridge.subReader = reader
+	return nil
+}
+
+// subscribeLoop reads the subscription and reconnects when it is lost.
+func (bridge *Bridge) subscribeLoop() {
+	var delay time.Duration
+	for {
+		bridge.subMutex.Lock()
+		conn, reader := bridge.subConn, bridge.subReader
+		bridge.subMutex.Unlock()
+		if conn != nil {
+			bridge.receive(reader)
+			conn.Close()
+			delay = 0
+		}
+
+		if delay == 0 {
+			delay = 100 * time.Millisecond
+		} else if delay *= 2; delay > 5*time.Second {
+			delay = 5 * time.Second
+		}
+		select {
+		case <-bridge.closeChan:
+			return
+		case <-time.After(delay):
+		}
+		bridge.subMutex.Lock()
+		bridge.subConn = nil
+		bridge.subMutex.Unlock()
+		bridge.subscribe()
+	}
+}
+
+func (bridge *Bridge) receive(reader *bufio.Reader) {
+	for {
+		reply, err := readReply(reader)
+		if err != nil {
+			return
+		}
+		items, ok := reply.([]interface{})
+		if !ok || len(items) != 3 {
+			continue
+		}
+		if kind, _ := items[0].([]byte); string(kind) != "message" {
+			continue
+		}
+		payload, _ := items[2].([]byte)
+		if len(payload) < 1 || len(payload) < 1+int(payload[0]) {
+			continue
+		}
+		n := 1 + int(payload[0])
+		if string(payload[1:n]) == bridge.id {
+			continue
+		}
+		msg, err := bridge.decode(payload[n:])
+		if err != nil {
+			continue
+		}
+		bridge.channel.BroadcastLocal(msg)
+	}
+}
+
+func (bridge *Bridge) Close() {
+	bridge.closeOnce.Do(func() {
+		close(bridge.closeChan)
+		bridge.channel.SetRelay(nil)
+		bridge.subMutex.Lock()
+		if bridge.subConn != nil {
+			bridge.subConn.Close()
+		}
+		bridge.subMutex.Unlock()
+		bridge.pubMutex.Lock()
+		if bridge.pubConn != nil {
+			bridge.pubConn.Close()
+			bridge.pubConn = nil
+		}
+		bridge.pubMutex.Unlock()
+	})
+}
@@ -0,0 +1,123 @@
+package redisbridge
+
+import (
+	"bufio"
+	"net"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/funny/link"
+	"github.com/funny/utest"
+)
+
+// fakeRedis serves SUBSCRIBE and PUBLISH of one channel.
+type fakeRedis struct {
+	listener net.Listener
+	mutex    sync.Mutex
+	subs     []*bufio.Writer
+}
+
+func newFakeRedis(t *testing.T) *fakeRedis {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	utest.IsNilNow(t, err)
+	redis := &fakeRedis{listener: listener}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go redis.serve(conn)
+		}
+	}()
+	return redis
+}
+
+func (redis *fakeRedis) serve(conn net.Conn) {
+	defer conn.Close()
+	reader, writer := bufio.NewReader(conn), bufio.NewWriter(conn)
+	for {
+		reply, err := readReply(reader)
+		if err != nil {
+			return
+		}
+		args := reply.([]interface{})
+		redis.mutex.Lock()
+		switch string(args[0].([]byte)) {
+		case "SUBSCRIBE":
+			redis.subs = append(redis.subs, writer)
+			topic := args[1].([]byte)
+			writer.WriteString("*3\r\n$9\r\nsubscribe\r\n$" + strconv.Itoa(len(topic)) + "\r\n")
+			writer.Write(topic)
+			writer.WriteString("\r\n:1\r\n")
+			writer.Flush()
+		case "PUBLISH":
+			for _, sub := range redis.subs {
+				writeCommand(sub, []byte("message"), args[1].([]byte), args[2].([]byte))
+			}
+			writer.WriteString(":1\r\n")
+			writer.Flush()
+		}
+		redis.mutex.Unlock()
+	}
+}
+
+type recordCodec struct {
+	sent chan interface{}
+}
+
+func (c *recordCodec) Receive() (interface{}, error) { select {} }
+func (c *recordCodec) Send(msg interface{}) error    { c.sent <- msg; return nil }
+func (c *recordCodec) Close() error                  { return nil }
+
+func Test_Bridge(t *testing.T) {
+	redis := newFakeRedis(t)
+	defer redis.listener.Close()
+
+	encode := func(msg interface{}) ([]byte, error) { return []byte(msg.(string)), nil }
+	decode := func(data []byte) (interface{}, error) { return string(data), nil }
+
+	manager := link.NewManager()
+	var codecs [2]*recordCodec
+	var bridges [2]*Bridge
+	var channels [2]*link.Channel
+	for i := range bridges {
+		codecs[i] = &recordCodec{make(chan interface{}, 10)}
+		channel := link.NewChannel()
+		channels[i] = channel
+		channel.Put(i, manager.NewSession(codecs[i], 0))
+		bridge, err := New(redis.listener.Addr().String(), "room", channel, encode, decode)
+		utest.IsNilNow(t, err)
+		defer bridge.Close()
+		bridges[i] = bridge
+	}
+
+	utest.IsNilNow(t, bridges[0].Broadcast("hello"))
+	for i := range codecs {
+		select {
+		case msg := <-codecs[i].sent:
+			utest.EqualNow(t, msg, "hello")
+		case <-time.After(time.Second):
+			t.Fatal("broadcast not received")
+		}
+	}
+	select {
+	case msg := <-codecs[0].sent:
+		t.Fatalf("own message received again: %v", msg)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	// Broadcast of a channel goes through its bridge.
+	_, err := channels[1].Broadcast("world")
+	utest.IsNilNow(t, err)
+	for i := range codecs {
+		select {
+		case msg := <-codecs[i].sent:
+			utest.EqualNow(t, msg, "world")
+		case <-time.After(time.Second):
+			t.Fatal("channel broadcast not received")
+		}
+	}
+}
@@ -0,0 +1,83 @@
+package redisbridge
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"strconv"
+)
+
+var ErrBadReply = errors.New("Bad Redis Reply")
+
+type redisError string
+
+func (e redisError) Error() string {
+	return string(e)
+}
+
+func writeCommand(w *bufio.Writer, args ...[]byte) error {
+	w.WriteByte('*')
+	w.WriteString(strconv.Itoa(len(args)))
+	w.WriteString("\r\n")
+	for _, arg := range args {
+		w.WriteByte('$')
+		w.WriteString(strconv.Itoa(len(arg)))
+		w.WriteString("\r\n")
+		w.Write(arg)
+		w.WriteString("\r\n")
+	}
+	return w.Flush()
+}
+
+// readReply returns a reply as string, int64, []byte, []interface{} or nil.
+// Error replies are returned as error.
+func readReply(r *bufio.Reader) (interface{}, error) {
+	line, err := r.ReadSlice('\n')
+	if err != nil {
+		return nil, err
+	}
+	if len(line) < 3 || line[len(line)-2] != '\r' {
+		return nil, ErrBadReply
+	}
+	body := string(line[1 : len(line)-2])
+	switch line[0] {
+	case '+':
+		return body, nil
+	case '-':
+		return nil, redisError(body)
+	case ':':
+		return strconv.ParseInt(body, 10, 64)
+	case '$':
+		n, err := strconv.Atoi(body)
+		if err != nil {
+			return nil, ErrBadReply
+		}
+		if n < 0 {
+			return nil, nil
+		}
+		buf := make([]byte, n+2)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return nil, err
+		}
+		return buf[:n], nil
+	case '*':
+		n, err := strconv.Atoi(body)
+		if err != nil {
+			return nil, ErrBadReply
+		}
+		if n < 0 {
+			return nil, nil
+		}
+		items := make([]interface{}, n)
+		for i := range items {
+			if items[i], err = readReply(r); err != nil {
+				if _, ok := err.(redisError); !ok {
+					return nil, err
+				}
+				items[i] = err
+			}
+		}
+		return items, nil
+	}
+	return nil, ErrBadReply
+}
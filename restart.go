@@ -0,0 +1,168 @@
+package link
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+var ErrReusePortUnsupported = errors.New("SO_REUSEPORT Unsupported")
+var ErrNotFileListener = errors.New("Listener Has No File")
+
+// listenersEnv passes the listeners to the new process, a line of fd,
+// network and address for each.
+const listenersEnv = "LINK_LISTENERS"
+
+var inherit struct {
+	sync.Mutex
+	parsed    bool
+	files     map[string]*os.File
+	listeners []inheritedListener
+}
+
+type inheritedListener struct {
+	key      string
+	listener net.Listener
+}
+
+func listenerKey(network, address string) string {
+	return network + " " + address
+}
+
+func parseInheritedFiles() {
+	inherit.files = make(map[string]*os.File)
+	for _, line := range strings.Split(os.Getenv(listenersEnv), "\n") {
+		parts := strings.SplitN(line, " ", 3)
+		if len(parts) != 3 {
+			continue
+		}
+		fd, err := strconv.Atoi(parts[0])
+		if err != nil {
+			continue
+		}
+		key := listenerKey(parts[1], parts[2])
+		inherit.files[key] = os.NewFile(uintptr(fd), key)
+	}
+	os.Unsetenv(listenersEnv)
+}
+
+// InheritListener returns the listener of network and address handed over
+// by the parent process with StartUpgrade, or a new one, and remembers it
+// for the next StartUpgrade. The address must be the one the parent used.
+func InheritListener(network, address string) (net.Listener, error) {
+	inherit.Lock()
+	defer inherit.Unlock()
+	if !inherit.parsed {
+		inherit.parsed = true
+		parseInheritedFiles()
+	}
+	key := listenerKey(network, address)
+	var listener net.Listener
+	var err error
+	if file, ok := inherit.files[key]; ok {
+		delete(inherit.files, key)
+		listener, err = net.FileListener(file)
+		file.Close()
+	} else {
+		listener, err = net.Listen(network, address)
+	}
+	if err != nil {
+		return nil, err
+	}
+	inherit.listeners = append(inherit.listeners, inheritedListener{key, listener})
+	return listener, nil
+}
+
+// StartUpgrade starts the executable of the process again with its
+// arguments, handing over the listeners of InheritListener. The listeners
+// stay shared, the connections wait in their backlog until the new process
+// accepts them, so the old one can stop accepting and drain its sessions.
+func StartUpgrade() (*os.Process, error) {
+	inherit.Lock()
+	defer inherit.Unlock()
+	var files []*os.File
+	defer func() {
+		for _, file := range files {
+			file.Close()
+		}
+	}()
+	var env strings.Builder
+	for _, l := range inherit.listeners {
+		if unix, ok := l.listener.(*net.UnixListener); ok {
+			// The socket file is the new process's now.
+			unix.SetUnlinkOnClose(false)
+		}
+		filer, ok := l.listener.(interface{ File() (*os.File, error) })
+		if !ok {
+			return nil, ErrNotFileListener
+		}
+		file, err := filer.File()
+		if err != nil {
+			return nil, err
+		}
+		// ExtraFiles become the descriptors from 3 in the new process.
+		fmt.Fprintf(&env, "%d %s\n", 3+len(files), l.key)
+		files = append(files, file)
+	}
+
+	path, err := os.Executable()
+	if err != nil {
+		return nil, err
+	}
+	cmd := exec.Command(path, os.Args[1:]...)
+	cmd.Env = append(os.Environ(), listenersEnv+"="+env.String())
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	cmd.ExtraFiles = files
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	return cmd.Process, nil
+}
+
+// UpgradeOnSignal blocks until one of signals arrives, SIGHUP by default,
+// then starts the new process with StartUpgrade and calls GracefulStop. The
+// server keeps running and the error is returned if the new process fails to
+// start.
+func (server *Server) UpgradeOnSignal(drain time.Duration, msg interface{}, signals ...os.Signal) (*os.Process, error) {
+	if len(signals) == 0 {
+		signals = []os.Signal{syscall.SIGHUP}
+	}
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, signals...)
+	defer signal.Stop(c)
+
+	<-c
+	process, err := StartUpgrade()
+	if err != nil {
+		return nil, err
+	}
+	server.GracefulStop(drain, msg)
+	return process, nil
+}
+
+// ListenReusePort listens with SO_REUSEPORT, so several processes or
+// listeners on the same address share the connections, e.g. the old and the
+// new binary during an upgrade.
+func ListenReusePort(network, address string) (net.Listener, error) {
+	config := net.ListenConfig{
+		Control: func(network, address string, c syscall.RawConn) error {
+			var err error
+			if cerr := c.Control(func(fd uintptr) {
+				err = setReusePort(fd)
+			}); cerr != nil {
+				return cerr
+			}
+			return err
+		},
+	}
+	return config.Listen(context.Background(), network, address)
+}
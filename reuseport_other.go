@@ -0,0 +1,13 @@
+//go:build !darwin && !dragonfly && !freebsd && !netbsd && !openbsd && (!linux || mips || mipsle || mips64 || mips64le)
+// +build !darwin
+// +build !dragonfly
+// +build !freebsd
+// +build !netbsd
+// +build !openbsd
+// +build !linux mips mipsle mips64 mips64le
+
+package link
+
+func setReusePort(fd uintptr) error {
+	return ErrReusePortUnsupported
+}
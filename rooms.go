@@ -0,0 +1,90 @@
+package link
+
+import (
+	"sync"
+)
+
+// Rooms holds named channels that sessions join and leave, a channel is
+// created by its first join and removed when its last session leaves or is
+// closed.
+type Rooms struct {
+	mutex    sync.Mutex
+	channels map[string]*Channel
+	encode   func(interface{}) (interface{}, error)
+}
+
+type roomKey struct {
+	name string
+	key  KEY
+}
+
+// NewRooms creates rooms whose channels encode broadcast messages with
+// encode, see Channel.SetEncoder. It may be nil.
+func NewRooms(encode func(interface{}) (interface{}, error)) *Rooms {
+	return &Rooms{
+		channels: make(map[string]*Channel),
+		encode:   encode,
+	}
+}
+
+// Join puts the session with key into the named channel, it replaces the
+// session joined with the same key.
+func (rooms *Rooms) Join(name string, key KEY, session *Session) {
+	rooms.mutex.Lock()
+	defer rooms.mutex.Unlock()
+	channel, exists := rooms.channels[name]
+	if !exists {
+		channel = NewChannel()
+		channel.SetEncoder(rooms.encode)
+		rooms.channels[name] = channel
+	}
+	if old := channel.Get(key); old != nil {
+		old.RemoveCloseCallback(rooms, roomKey{name, key})
+	}
+	channel.Put(key, session)
+	session.AddCloseCallback(rooms, roomKey{name, key}, func() {
+		rooms.Leave(name, key)
+	})
+}
+
+// Leave removes the session of key from the named channel.
+func (rooms *Rooms) Leave(name string, key KEY) bool {
+	rooms.mutex.Lock()
+	defer rooms.mutex.Unlock()
+	channel, exists := rooms.channels[name]
+	if !exists {
+		return false
+	}
+	if session := channel.Get(key); session != nil {
+		session.RemoveCloseCallback(rooms, roomKey{name, key})
+	}
+	left := channel.Remove(key)
+	if channel.Len() == 0 {
+		delete(rooms.channels, name)
+	}
+	return left
+}
+
+// Channel returns the named channel, or nil if nobody joined it.
+func (rooms *Rooms) Channel(name string) *Channel {
+	rooms.mutex.Lock()
+	defer rooms.mutex.Unlock()
+	return rooms.channels[name]
+}
+
+// Broadcast sends msg to the sessions of the named channel, see
+// Channel.Broadcast.
+func (rooms *Rooms) Broadcast(name string, msg interface{}) (int, error) {
+	channel := rooms.Channel(name)
+	if channel == nil {
+		return 0, nil
+	}
+	return channel.Broadcast(msg)
+}
+
+// Len returns the number of channels.
+func (rooms *Rooms) Len() int {
+	rooms.mutex.Lock()
+	defer rooms.mutex.Unlock()
+	return len(rooms.channels)
+}
@@ -0,0 +1,151 @@
+package rpc
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/funny/link"
+)
+
+var ErrTimeout = errors.New("RPC Timeout")
+
+// RemoteError is an error returned by the handler of the server.
+type RemoteError string
+
+func (e RemoteError) Error() string {
+	return string(e)
+}
+
+// Client makes calls over a session, it owns the receiving of the session.
+type Client struct {
+	session *link.Session
+
+	mutex   sync.Mutex
+	seq     uint32
+	pending map[uint32]chan *Message
+	err     error
+}
+
+// NewClient starts receiving the responses of session.
+func NewClient(session *link.Session) *Client {
+	client := &Client{
+		session: session,
+		pending: make(map[uint32]chan *Message),
+	}
+	go client.recvLoop()
+	return client
+}
+
+func (client *Client) recvLoop() {
+	var err error
+	for {
+		var recv interface{}
+		if recv, err = client.session.Receive(); err != nil {
+			break
+		}
+		msg, ok := recv.(*Message)
+		if !ok || (msg.Kind != Response && msg.Kind != Error) {
+			err = ErrBadMessage
+			break
+		}
+		client.mutex.Lock()
+		call := client.pending[msg.Seq]
+		delete(client.pending, msg.Seq)
+		client.mutex.Unlock()
+		// The call timed out if it is not pending.
+		if call != nil {
+			call <- msg
+		}
+	}
+	client.session.Close()
+
+	client.mutex.Lock()
+	defer client.mutex.Unlock()
+	client.err = err
+	for seq, call := range client.pending {
+		delete(client.pending, seq)
+		close(call)
+	}
+}
+
+// Call sends req and waits for its response up to timeout, zero means no
+// limit. Calls can be made by many goroutines at once.
+func (client *Client) Call(req interface{}, timeout time.Duration) (interface{}, error) {
+	call := make(chan *Message, 1)
+	client.mutex.Lock()
+	if client.err != nil {
+		client.mutex.Unlock()
+		return nil, client.err
+	}
+	client.seq++
+	seq := client.seq
+	client.pending[seq] = call
+	client.mutex.Unlock()
+
+	if err := client.session.Send(&Message{Request, seq, req}); err != nil {
+		client.cancel(seq)
+		return nil, err
+	}
+
+	var expired <-chan time.Time
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		expired = timer.C
+	}
+	select {
+	case msg, ok := <-call:
+		if !ok {
+			client.mutex.Lock()
+			defer client.mutex.Unlock()
+			return nil, client.err
+		}
+		if msg.Kind == Error {
+			return nil, RemoteError(msg.Body.(string))
+		}
+		return msg.Body, nil
+	case <-expired:
+		client.cancel(seq)
+		return nil, ErrTimeout
+	}
+}
+
+func (client *Client) cancel(seq uint32) {
+	client.mutex.Lock()
+	defer client.mutex.Unlock()
+	delete(client.pending, seq)
+}
+
+// Notify sends req without waiting for a response.
+func (client *Client) Notify(req interface{}) error {
+	return client.session.Send(&Message{Notify, 0, req})
+}
+
+// Serve receives the requests of session until an error, and replies each
+// one with the result of handler, which is called in a new goroutine so
+// requests are served concurrently. Notifications are handled without reply.
+func Serve(session *link.Session, handler func(req interface{}) (interface{}, error)) error {
+	for {
+		recv, err := session.Receive()
+		if err != nil {
+			return err
+		}
+		msg, ok := recv.(*Message)
+		if !ok || (msg.Kind != Request && msg.Kind != Notify) {
+			session.Close()
+			return ErrBadMessage
+		}
+		go func() {
+			resp, err := handler(msg.Body)
+			if msg.Kind == Notify {
+				return
+			}
+			if err != nil {
+				session.Send(&Message{Error, msg.Seq, err.Error()})
+				return
+			}
+			session.Send(&Message{Response, msg.Seq, resp})
+		}()
+	}
+}
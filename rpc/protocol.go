@@ -0,0 +1,119 @@
+// Package rpc is a request/response layer over link sessions. Each packet
+// carries a kind and a sequence number, so a Client can have many calls in
+// flight on one session and match the responses to them:
+//
+//	protocol := codec.FixLen(rpc.Protocol(codec.Json()), 4, binary.BigEndian, 1<<20, 1<<20)
+//
+//	// server
+//	go rpc.Serve(session, func(req interface{}) (interface{}, error) { ... })
+//
+//	// client
+//	client := rpc.NewClient(session)
+//	resp, err := client.Call(req, time.Second)
+//
+// The base protocol must not reuse a received message for the next one, like
+// Raw does, since responses are handed to callers while receiving goes on.
+package rpc
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+
+	"github.com/funny/link"
+)
+
+var ErrBadMessage = errors.New("Bad RPC Message")
+
+// Kinds of messages.
+const (
+	Request  = 1
+	Response = 2
+	Error    = 3
+	Notify   = 4
+)
+
+const headSize = 5
+
+// Message is a packet of Protocol. The Body of an Error is the error text.
+type Message struct {
+	Kind byte
+	Seq  uint32
+	Body interface{}
+}
+
+type protocol struct {
+	base link.Protocol
+}
+
+// Protocol prefixes the bodies encoded by base with the kind and the sequence
+// number of messages. It reads each packet to the end, so it must be placed
+// under a framing protocol.
+func Protocol(base link.Protocol) link.Protocol {
+	return &protocol{base}
+}
+
+func (p *protocol) NewCodec(rw io.ReadWriter) (cc link.Codec, err error) {
+	codec := &rpcCodec{rw: rw}
+	codec.base, err = p.base.NewCodec(&codec.stream)
+	if err != nil {
+		return
+	}
+	cc = codec
+	return
+}
+
+type rpcCodec struct {
+	base   link.Codec
+	rw     io.ReadWriter
+	stream stream
+}
+
+func (c *rpcCodec) Receive() (interface{}, error) {
+	c.stream.recvData.Reset()
+	if _, err := c.stream.recvData.ReadFrom(c.rw); err != nil {
+		return nil, err
+	}
+	data := c.stream.recvData.Bytes()
+	if len(data) < headSize {
+		return nil, ErrBadMessage
+	}
+	msg := &Message{Kind: data[0], Seq: binary.BigEndian.Uint32(data[1:])}
+	switch msg.Kind {
+	case Request, Response, Notify:
+		c.stream.recvBuf.Reset(data[headSize:])
+		body, err := c.base.Receive()
+		if err != nil {
+			return nil, err
+		}
+		msg.Body = body
+	case Error:
+		msg.Body = string(data[headSize:])
+	default:
+		return nil, ErrBadMessage
+	}
+	return msg, nil
+}
+
+func (c *rpcCodec) Send(m interface{}) error {
+	msg, ok := m.(*Message)
+	if !ok {
+		return ErrBadMessage
+	}
+	c.stream.sendBuf.Reset()
+	var head [headSize]byte
+	head[0] = msg.Kind
+	binary.BigEndian.PutUint32(head[1:], msg.Seq)
+	c.stream.sendBuf.Write(head[:])
+	if msg.Kind == Error {
+		c.stream.sendBuf.WriteString(msg.Body.(string))
+	} else if err := c.base.Send(msg.Body); err != nil {
+		return err
+	}
+	_, err := c.rw.Write(c.stream.sendBuf.Bytes())
+	return err
+}
+
+func (c *rpcCodec) Close() error {
+	return c.base.Close()
+}
@@ -0,0 +1,90 @@
+package rpc
+
+import (
+	"encoding/binary"
+	"errors"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/funny/link"
+	"github.com/funny/link/codec"
+	"github.com/funny/utest"
+)
+
+type addRequest struct {
+	A, B  int
+	Sleep time.Duration
+}
+
+type addResponse struct {
+	Sum int
+}
+
+func newTestProtocol() link.Protocol {
+	json := codec.Json()
+	json.Register(addRequest{})
+	json.Register(addResponse{})
+	return codec.FixLen(Protocol(json), 4, binary.BigEndian, 1024, 1024)
+}
+
+func newTestClient(t *testing.T) (*Client, *link.Session) {
+	protocol := newTestProtocol()
+	conn1, conn2 := net.Pipe()
+	codec1, _ := protocol.NewCodec(conn1)
+	codec2, _ := protocol.NewCodec(conn2)
+	server := link.NewSession(codec2, 0)
+	go Serve(server, func(req interface{}) (interface{}, error) {
+		r := req.(*addRequest)
+		time.Sleep(r.Sleep)
+		if r.A < 0 {
+			return nil, errors.New("negative")
+		}
+		return &addResponse{r.A + r.B}, nil
+	})
+	return NewClient(link.NewSession(codec1, 0)), server
+}
+
+func Test_Call(t *testing.T) {
+	client, server := newTestClient(t)
+	defer server.Close()
+
+	// Concurrent calls get their own responses, out of order.
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			resp, err := client.Call(&addRequest{i, i, time.Duration(20-i) * time.Millisecond}, time.Second)
+			utest.IsNilNow(t, err)
+			utest.EqualNow(t, resp.(*addResponse).Sum, 2*i)
+		}(i)
+	}
+	wg.Wait()
+
+	_, err := client.Call(&addRequest{A: -1}, time.Second)
+	utest.EqualNow(t, err, RemoteError("negative"))
+
+	_, err = client.Call(&addRequest{Sleep: 100 * time.Millisecond}, 10*time.Millisecond)
+	utest.EqualNow(t, err, ErrTimeout)
+	utest.IsNilNow(t, client.Notify(&addRequest{}))
+	resp, err := client.Call(&addRequest{1, 2, 0}, time.Second)
+	utest.IsNilNow(t, err)
+	utest.EqualNow(t, resp.(*addResponse).Sum, 3)
+}
+
+func Test_CallClosed(t *testing.T) {
+	client, server := newTestClient(t)
+
+	done := make(chan error)
+	go func() {
+		_, err := client.Call(&addRequest{Sleep: time.Second}, 0)
+		done <- err
+	}()
+	time.Sleep(10 * time.Millisecond)
+	server.Close()
+	utest.NotNilNow(t, <-done)
+	_, err := client.Call(&addRequest{}, 0)
+	utest.NotNilNow(t, err)
+}
@@ -0,0 +1,20 @@
+package rpc
+
+import (
+	"bytes"
+)
+
+// stream is the io.ReadWriter of the base codec, holding one packet body.
+type stream struct {
+	recvData bytes.Buffer
+	recvBuf  bytes.Reader
+	sendBuf  bytes.Buffer
+}
+
+func (s *stream) Read(p []byte) (int, error) {
+	return s.recvBuf.Read(p)
+}
+
+func (s *stream) Write(p []byte) (int, error) {
+	return s.sendBuf.Write(p)
+}
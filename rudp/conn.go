@@ -0,0 +1,582 @@
+// Package rudp is a lightweight reliability layer over UDP. Datagrams carry
+// sequence numbers, are acknowledged one by one, and retransmitted after an
+// adaptive RTO, so link protocols and sessions can run over UDP where TCP
+// head-of-line blocking hurts but KCP or QUIC are too heavy.
+//
+// Conn and Listener implement net.Conn and net.Listener, and Transport is a
+// link.Transport:
+//
+//	listener, err := rudp.Listen("udp", "0.0.0.0:8000", rudp.Config{})
+//	server := link.NewServer(listener, protocol, 0, handler)
+//
+//	conn, err := rudp.Dial("udp", "127.0.0.1:8000", rudp.Config{})
+//	session := link.NewSession(codec, 0)
+package rudp
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+var (
+	ErrTooLargeMessage = errors.New("Too Large Message")
+	ErrPeerClosed      = errors.New("Peer Closed")
+	ErrPeerTimeout     = errors.New("Peer Timeout")
+)
+
+const (
+	typeData = 1
+	typeAck  = 2
+	typeFin  = 3
+
+	dataHeadSize = 5
+	ackSize      = 9
+)
+
+// Config of connections, the zero value is ordered delivery with defaults.
+type Config struct {
+	// Unordered delivers messages as they arrive. Each Write is then one
+	// message, it must fit in MSS and a message framing like FixLen has to
+	// write each packet in one Write.
+	Unordered bool
+
+	// MSS is the payload size of a datagram, default is 1200.
+	MSS int
+
+	// Window is the number of datagrams sent but not acknowledged, and of
+	// datagrams received but not read, default is 256.
+	Window int
+
+	// MinRTO and MaxRTO bound the retransmission timeout, default are 50ms
+	// and 5s.
+	MinRTO time.Duration
+	MaxRTO time.Duration
+
+	// MaxRetries is the retransmissions of a datagram before the peer is
+	// considered dead, default is 10.
+	MaxRetries int
+
+	// Linger is how long Close waits for the peer to acknowledge the data
+	// written and the FIN, default is 5s.
+	Linger time.Duration
+}
+
+func (config Config) withDefaults() Config {
+	if config.MSS <= 0 {
+		config.MSS = 1200
+	}
+	if config.Window <= 0 {
+		config.Window = 256
+	}
+	if config.MinRTO <= 0 {
+		config.MinRTO = 50 * time.Millisecond
+	}
+	if config.MaxRTO < config.MinRTO {
+		config.MaxRTO = 5 * time.Second
+	}
+	if config.MaxRetries <= 0 {
+		config.MaxRetries = 10
+	}
+	if config.Linger <= 0 {
+		config.Linger = 5 * time.Second
+	}
+	return config
+}
+
+type segment struct {
+	data     []byte
+	sent     time.Time
+	deadline time.Time
+	retries  int
+}
+
+// Conn is a reliable connection over a net.PacketConn.
+type Conn struct {
+	config  Config
+	pc      net.PacketConn
+	addr    net.Addr
+	onClose func()
+
+	mutex   sync.Mutex
+	nextSeq uint32
+	unacked map[uint32]*segment
+	srtt    time.Duration
+	rttvar  time.Duration
+	rto     time.Duration
+	timer   *time.Timer
+
+	una      uint32
+	received map[uint32][]byte
+	queue    [][]byte
+	current  []byte
+	finSeq   uint32
+	finSeen  bool
+	finRecv  bool
+	closing  bool
+	err      error
+
+	readDeadline  time.Time
+	writeDeadline time.Time
+	readChan      chan struct{}
+	writeChan     chan struct{}
+	closeOnce     sync.Once
+	closeChan     chan struct{}
+}
+
+func newConn(pc net.PacketConn, addr net.Addr, config Config, onClose func()) *Conn {
+	c := &Conn{
+		config:    config.withDefaults(),
+		pc:        pc,
+		addr:      addr,
+		onClose:   onClose,
+		unacked:   make(map[uint32]*segment),
+		received:  make(map[uint32][]byte),
+		readChan:  make(chan struct{}, 1),
+		writeChan: make(chan struct{}, 1),
+		closeChan: make(chan struct{}),
+	}
+	c.rto = 4 * c.config.MinRTO
+	return c
+}
+
+// Dial creates a connection to address from a new local UDP socket.
+func Dial(network, address string, config Config) (*Conn, error) {
+	addr, err := net.ResolveUDPAddr(network, address)
+	if err != nil {
+		return nil, err
+	}
+	pc, err := net.ListenUDP(network, nil)
+	if err != nil {
+		return nil, err
+	}
+	c := newConn(pc, addr, config, func() {
+		pc.Close()
+	})
+	go func() {
+		buf := make([]byte, 64*1024)
+		for {
+			n, from, err := pc.ReadFrom(buf)
+			if err != nil {
+				c.fail(err)
+				return
+			}
+			if from.String() == addr.String() {
+				c.input(buf[:n])
+			}
+		}
+	}()
+	return c, nil
+}
+
+func notify(ch chan struct{}) {
+	select {
+	case ch <- struct{}{}:
+	default:
+	}
+}
+
+// before tells whether sequence number a comes before b, with wrap around.
+func before(a, b uint32) bool {
+	return int32(a-b) < 0
+}
+
+func (c *Conn) input(b []byte) {
+	if len(b) == 0 {
+		return
+	}
+	switch b[0] {
+	case typeData:
+		if len(b) < dataHeadSize {
+			return
+		}
+		c.inputData(binary.BigEndian.Uint32(b[1:]), b[dataHeadSize:])
+	case typeAck:
+		if len(b) < ackSize {
+			return
+		}
+		c.inputAck(binary.BigEndian.Uint32(b[1:]), binary.BigEndian.Uint32(b[5:]))
+	case typeFin:
+		if len(b) < dataHeadSize {
+			// A peer without the connection any more, like a Listener
+			// after Close.
+			c.mutex.Lock()
+			c.finRecv = true
+			c.stopSending()
+			c.mutex.Unlock()
+			notify(c.readChan)
+			notify(c.writeChan)
+			return
+		}
+		c.inputFin(binary.BigEndian.Uint32(b[1:]))
+	}
+}
+
+func (c *Conn) inputData(seq uint32, payload []byte) {
+	c.mutex.Lock()
+	window := uint32(c.config.Window)
+	switch {
+	case before(seq, c.una):
+		// A retransmission of an acknowledged datagram, the ack was lost.
+	case seq-c.una >= window || len(c.queue) >= c.config.Window:
+		// Not acknowledged, the peer retransmits it when there is room.
+		c.mutex.Unlock()
+		return
+	default:
+		if _, exists := c.received[seq]; exists {
+			break
+		}
+		payload = append([]byte(nil), payload...)
+		if c.config.Unordered {
+			c.queue = append(c.queue, payload)
+			payload = nil
+		}
+		c.received[seq] = payload
+		c.advance()
+	}
+	ack := c.ack(seq)
+	c.mutex.Unlock()
+
+	notify(c.readChan)
+	notify(c.writeChan)
+	c.pc.WriteTo(ack[:], c.addr)
+}
+
+// inputFin takes the FIN of the peer like a datagram, the peer is closed
+// once the data before it arrived.
+func (c *Conn) inputFin(seq uint32) {
+	c.mutex.Lock()
+	switch {
+	case before(seq, c.una):
+		// A retransmission, the ack was lost.
+	case seq-c.una >= uint32(c.config.Window):
+		c.mutex.Unlock()
+		return
+	default:
+		c.finSeq, c.finSeen = seq, true
+		c.advance()
+	}
+	ack := c.ack(seq)
+	c.mutex.Unlock()
+
+	notify(c.readChan)
+	notify(c.writeChan)
+	c.pc.WriteTo(ack[:], c.addr)
+}
+
+// advance moves una over the datagrams received in order, and the FIN.
+func (c *Conn) advance() {
+	for {
+		if c.finSeen && c.una == c.finSeq {
+			c.finSeen = false
+			c.finRecv = true
+			c.una++
+			// The peer won't read what we send any more.
+			c.stopSending()
+			return
+		}
+		p, exists := c.received[c.una]
+		if !exists {
+			return
+		}
+		if !c.config.Unordered {
+			c.queue = append(c.queue, p)
+		}
+		delete(c.received, c.una)
+		c.una++
+	}
+}
+
+func (c *Conn) ack(seq uint32) [ackSize]byte {
+	var ack [ackSize]byte
+	ack[0] = typeAck
+	binary.BigEndian.PutUint32(ack[1:], c.una)
+	binary.BigEndian.PutUint32(ack[5:], seq)
+	return ack
+}
+
+func (c *Conn) inputAck(una, seq uint32) {
+	c.mutex.Lock()
+	now := time.Now()
+	for s, seg := range c.unacked {
+		if s != seq && !before(s, una) {
+			continue
+		}
+		// Karn's algorithm, a retransmitted datagram gives no RTT sample.
+		if s == seq && seg.retries == 0 {
+			c.updateRTO(now.Sub(seg.sent))
+		}
+		delete(c.unacked, s)
+	}
+	if len(c.unacked) == 0 && c.timer != nil {
+		c.timer.Stop()
+	}
+	c.mutex.Unlock()
+	notify(c.writeChan)
+}
+
+// updateRTO follows RFC 6298.
+func (c *Conn) updateRTO(rtt time.Duration) {
+	if c.srtt == 0 {
+		c.srtt = rtt
+		c.rttvar = rtt / 2
+	} else {
+		delta := c.srtt - rtt
+		if delta < 0 {
+			delta = -delta
+		}
+		c.rttvar = (3*c.rttvar + delta) / 4
+		c.srtt = (7*c.srtt + rtt) / 8
+	}
+	c.rto = c.srtt + 4*c.rttvar
+	if c.rto < c.config.MinRTO {
+		c.rto = c.config.MinRTO
+	}
+	if c.rto > c.config.MaxRTO {
+		c.rto = c.config.MaxRTO
+	}
+}
+
+func (c *Conn) backoff(retries int) time.Duration {
+	rto := c.rto << uint(retries)
+	if rto > c.config.MaxRTO || rto <= 0 {
+		rto = c.config.MaxRTO
+	}
+	return rto
+}
+
+func (c *Conn) onTimer() {
+	c.mutex.Lock()
+	now := time.Now()
+	var resend [][]byte
+	var next time.Time
+	for _, seg := range c.unacked {
+		if !now.Before(seg.deadline) {
+			if seg.retries++; seg.retries > c.config.MaxRetries {
+				c.mutex.Unlock()
+				c.fail(ErrPeerTimeout)
+				return
+			}
+			seg.deadline = now.Add(c.backoff(seg.retries))
+			resend = append(resend, seg.data)
+		}
+		if next.IsZero() || seg.deadline.Before(next) {
+			next = seg.deadline
+		}
+	}
+	if !next.IsZero() && c.timer != nil {
+		c.timer.Reset(next.Sub(now))
+	}
+	c.mutex.Unlock()
+
+	for _, data := range resend {
+		c.pc.WriteTo(data, c.addr)
+	}
+}
+
+// stopSending drops the datagrams in flight, the peer won't ack them.
+func (c *Conn) stopSending() {
+	c.unacked = make(map[uint32]*segment)
+	if c.timer != nil {
+		c.timer.Stop()
+	}
+}
+
+// wait blocks until ch is notified, the connection closed, or deadline.
+func (c *Conn) wait(ch chan struct{}, deadline time.Time) error {
+	if deadline.IsZero() {
+		select {
+		case <-ch:
+		case <-c.closeChan:
+		}
+		return nil
+	}
+	d := time.Until(deadline)
+	if d <= 0 {
+		return timeoutError{}
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ch:
+	case <-c.closeChan:
+	case <-timer.C:
+		return timeoutError{}
+	}
+	return nil
+}
+
+func (c *Conn) Read(b []byte) (int, error) {
+	for {
+		c.mutex.Lock()
+		if len(c.current) == 0 && len(c.queue) > 0 {
+			c.current = c.queue[0]
+			c.queue[0] = nil
+			c.queue = c.queue[1:]
+		}
+		if len(c.current) > 0 {
+			n := copy(b, c.current)
+			c.current = c.current[n:]
+			c.mutex.Unlock()
+			return n, nil
+		}
+		err := c.err
+		if err == nil && c.finRecv {
+			err = io.EOF
+		}
+		deadline := c.readDeadline
+		c.mutex.Unlock()
+		if err != nil {
+			return 0, c.opError("read", err)
+		}
+		if err := c.wait(c.readChan, deadline); err != nil {
+			return 0, c.opError("read", err)
+		}
+	}
+}
+
+func (c *Conn) Write(b []byte) (int, error) {
+	if c.config.Unordered && len(b) > c.config.MSS {
+		return 0, c.opError("write", ErrTooLargeMessage)
+	}
+	written := 0
+	for written < len(b) {
+		c.mutex.Lock()
+		err := c.err
+		if err == nil && c.closing {
+			err = net.ErrClosed
+		}
+		if err == nil && c.finRecv {
+			err = ErrPeerClosed
+		}
+		if err != nil {
+			c.mutex.Unlock()
+			return written, c.opError("write", err)
+		}
+		if len(c.unacked) >= c.config.Window {
+			deadline := c.writeDeadline
+			c.mutex.Unlock()
+			if err := c.wait(c.writeChan, deadline); err != nil {
+				return written, c.opError("write", err)
+			}
+			continue
+		}
+
+		n := len(b) - written
+		if n > c.config.MSS {
+			n = c.config.MSS
+		}
+		data := make([]byte, dataHeadSize+n)
+		data[0] = typeData
+		copy(data[dataHeadSize:], b[written:written+n])
+		c.push(data)
+		c.mutex.Unlock()
+
+		if _, err := c.pc.WriteTo(data, c.addr); err != nil {
+			return written, c.opError("write", err)
+		}
+		written += n
+	}
+	return written, nil
+}
+
+// push numbers data and keeps it for retransmission until it is acked.
+func (c *Conn) push(data []byte) {
+	binary.BigEndian.PutUint32(data[1:], c.nextSeq)
+	now := time.Now()
+	c.unacked[c.nextSeq] = &segment{data: data, sent: now, deadline: now.Add(c.rto)}
+	c.nextSeq++
+	if c.timer == nil {
+		c.timer = time.AfterFunc(c.rto, c.onTimer)
+	} else if len(c.unacked) == 1 {
+		c.timer.Reset(c.rto)
+	}
+}
+
+func (c *Conn) fail(err error) {
+	c.mutex.Lock()
+	if c.err == nil {
+		c.err = err
+	}
+	c.stopSending()
+	c.mutex.Unlock()
+	c.closeOnce.Do(func() {
+		close(c.closeChan)
+		c.onClose()
+	})
+}
+
+// Close sends a FIN after the data written, and waits up to Linger for the
+// peer to acknowledge them, retransmitting them like the data of Write.
+func (c *Conn) Close() error {
+	c.mutex.Lock()
+	if c.err != nil || c.closing {
+		c.mutex.Unlock()
+		return c.opError("close", net.ErrClosed)
+	}
+	c.closing = true
+	var fin []byte
+	if !c.finRecv {
+		fin = make([]byte, dataHeadSize)
+		fin[0] = typeFin
+		c.push(fin)
+	}
+	c.mutex.Unlock()
+
+	if fin != nil {
+		c.pc.WriteTo(fin, c.addr)
+		deadline := time.Now().Add(c.config.Linger)
+		for {
+			c.mutex.Lock()
+			done := len(c.unacked) == 0 || c.err != nil
+			c.mutex.Unlock()
+			if done || c.wait(c.writeChan, deadline) != nil {
+				break
+			}
+		}
+	}
+	c.fail(net.ErrClosed)
+	return nil
+}
+
+func (c *Conn) opError(op string, err error) error {
+	return &net.OpError{Op: op, Net: c.addr.Network(), Source: c.pc.LocalAddr(), Addr: c.addr, Err: err}
+}
+
+func (c *Conn) LocalAddr() net.Addr {
+	return c.pc.LocalAddr()
+}
+
+func (c *Conn) RemoteAddr() net.Addr {
+	return c.addr
+}
+
+func (c *Conn) SetDeadline(t time.Time) error {
+	c.SetReadDeadline(t)
+	return c.SetWriteDeadline(t)
+}
+
+func (c *Conn) SetReadDeadline(t time.Time) error {
+	c.mutex.Lock()
+	c.readDeadline = t
+	c.mutex.Unlock()
+	notify(c.readChan)
+	return nil
+}
+
+func (c *Conn) SetWriteDeadline(t time.Time) error {
+	c.mutex.Lock()
+	c.writeDeadline = t
+	c.mutex.Unlock()
+	notify(c.writeChan)
+	return nil
+}
+
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
@@ -0,0 +1,127 @@
+package rudp
+
+import (
+	"encoding/binary"
+	"net"
+	"sync"
+)
+
+// Listener accepts the connections of the peers sending to a PacketConn.
+type Listener struct {
+	pc     net.PacketConn
+	config Config
+
+	mutex  sync.Mutex
+	conns  map[string]*Conn
+	err    error
+	accept chan *Conn
+
+	closeOnce sync.Once
+	closeChan chan struct{}
+}
+
+func Listen(network, address string, config Config) (*Listener, error) {
+	pc, err := net.ListenPacket(network, address)
+	if err != nil {
+		return nil, err
+	}
+	return NewListener(pc, config), nil
+}
+
+// NewListener serves the peers of pc, which is closed by Close.
+func NewListener(pc net.PacketConn, config Config) *Listener {
+	l := &Listener{
+		pc:        pc,
+		config:    config,
+		conns:     make(map[string]*Conn),
+		accept:    make(chan *Conn, 128),
+		closeChan: make(chan struct{}),
+	}
+	go l.readLoop()
+	return l
+}
+
+func (l *Listener) readLoop() {
+	buf := make([]byte, 64*1024)
+	for {
+		n, addr, err := l.pc.ReadFrom(buf)
+		if err != nil {
+			l.close(err)
+			return
+		}
+		if c := l.conn(buf[:n], addr); c != nil {
+			c.input(buf[:n])
+		}
+	}
+}
+
+// conn finds the connection of addr. A new one starts with the first
+// datagram, a peer of a closed connection still sending data or its FIN is
+// told so.
+func (l *Listener) conn(b []byte, addr net.Addr) *Conn {
+	key := addr.String()
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if c := l.conns[key]; c != nil {
+		return c
+	}
+	if len(b) < dataHeadSize || l.err != nil {
+		return nil
+	}
+	if b[0] == typeFin || b[0] == typeData && binary.BigEndian.Uint32(b[1:]) != 0 {
+		l.pc.WriteTo([]byte{typeFin}, addr)
+		return nil
+	}
+	if b[0] != typeData {
+		return nil
+	}
+	c := newConn(l.pc, addr, l.config, func() {
+		l.mutex.Lock()
+		defer l.mutex.Unlock()
+		delete(l.conns, key)
+	})
+	select {
+	case l.accept <- c:
+	default:
+		// The backlog is full, the peer retries.
+		return nil
+	}
+	l.conns[key] = c
+	return c
+}
+
+func (l *Listener) Accept() (net.Conn, error) {
+	select {
+	case c := <-l.accept:
+		return c, nil
+	case <-l.closeChan:
+		l.mutex.Lock()
+		defer l.mutex.Unlock()
+		return nil, &net.OpError{Op: "accept", Net: l.pc.LocalAddr().Network(), Addr: l.pc.LocalAddr(), Err: l.err}
+	}
+}
+
+func (l *Listener) close(err error) {
+	l.closeOnce.Do(func() {
+		l.mutex.Lock()
+		l.err = err
+		conns := l.conns
+		l.conns = make(map[string]*Conn)
+		l.mutex.Unlock()
+		close(l.closeChan)
+		l.pc.Close()
+		for _, c := range conns {
+			c.fail(err)
+		}
+	})
+}
+
+// Close closes the PacketConn, so the accepted connections too.
+func (l *Listener) Close() error {
+	l.close(net.ErrClosed)
+	return nil
+}
+
+func (l *Listener) Addr() net.Addr {
+	return l.pc.LocalAddr()
+}
@@ -0,0 +1,182 @@
+package rudp
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/funny/link"
+	"github.com/funny/link/codec"
+	"github.com/funny/utest"
+)
+
+// lossyConn drops every nth datagram written.
+type lossyConn struct {
+	net.PacketConn
+	mutex sync.Mutex
+	n     int
+	count int
+}
+
+func (c *lossyConn) WriteTo(b []byte, addr net.Addr) (int, error) {
+	c.mutex.Lock()
+	c.count++
+	drop := c.count%c.n == 0
+	c.mutex.Unlock()
+	if drop {
+		return len(b), nil
+	}
+	return c.PacketConn.WriteTo(b, addr)
+}
+
+func newLossyListener(t *testing.T, config Config) *Listener {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	utest.IsNilNow(t, err)
+	return NewListener(&lossyConn{PacketConn: pc, n: 4}, config)
+}
+
+func Test_Session(t *testing.T) {
+	config := Config{MinRTO: 10 * time.Millisecond}
+	listener := newLossyListener(t, config)
+	protocol := codec.FixLen(codec.Raw(), 2, binary.BigEndian, 64*1024, 64*1024)
+	server := link.NewServer(listener, protocol, 0, link.HandlerFunc(func(session *link.Session) {
+		for {
+			msg, err := session.Receive()
+			if err != nil {
+				return
+			}
+			session.Send(msg)
+		}
+	}))
+	go server.Serve()
+	defer server.Stop()
+
+	conn, err := Dial("udp", listener.Addr().String(), config)
+	utest.IsNilNow(t, err)
+	c, _ := protocol.NewCodec(conn)
+	session := link.NewSession(c, 0)
+	defer session.Close()
+
+	msg := make([]byte, 3000)
+	for i := 0; i < 50; i++ {
+		msg[i] = byte(i)
+		utest.IsNilNow(t, session.Send(msg))
+		recv, err := session.Receive()
+		utest.IsNilNow(t, err)
+		utest.EqualNow(t, recv.(*codec.InBuffer).Bytes(), msg)
+	}
+}
+
+func Test_Unordered(t *testing.T) {
+	config := Config{Unordered: true, MSS: 100, MinRTO: 10 * time.Millisecond}
+	listener := newLossyListener(t, config)
+	defer listener.Close()
+
+	conn, err := Dial("udp", listener.Addr().String(), config)
+	utest.IsNilNow(t, err)
+	defer conn.Close()
+	_, err = conn.Write(make([]byte, 101))
+	utest.Assert(t, errors.Is(err, ErrTooLargeMessage))
+	for i := 0; i < 100; i++ {
+		_, err := conn.Write([]byte{byte(i)})
+		utest.IsNilNow(t, err)
+	}
+
+	server, err := listener.Accept()
+	utest.IsNilNow(t, err)
+	server.SetReadDeadline(time.Now().Add(5 * time.Second))
+	received := make(map[byte]bool)
+	var b [100]byte
+	for len(received) < 100 {
+		n, err := server.Read(b[:])
+		utest.IsNilNow(t, err)
+		utest.EqualNow(t, n, 1)
+		received[b[0]] = true
+	}
+
+	// The peer is told about Close.
+	server.Close()
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	_, err = conn.Read(b[:])
+	utest.Assert(t, errors.Is(err, io.EOF))
+}
+
+func Test_CloseLinger(t *testing.T) {
+	config := Config{MSS: 100, MinRTO: 10 * time.Millisecond}
+	listener := newLossyListener(t, config)
+	defer listener.Close()
+
+	conn, err := Dial("udp", listener.Addr().String(), config)
+	utest.IsNilNow(t, err)
+	defer conn.Close()
+	_, err = conn.Write([]byte("hello"))
+	utest.IsNilNow(t, err)
+	server, err := listener.Accept()
+	utest.IsNilNow(t, err)
+
+	// The datagrams lost are retransmitted after Close, and the FIN too.
+	data := make([]byte, 2000)
+	for i := range data {
+		data[i] = byte(i)
+	}
+	_, err = server.Write(data)
+	utest.IsNilNow(t, err)
+	utest.IsNilNow(t, server.Close())
+	_, err = server.Write(data)
+	utest.Assert(t, errors.Is(err, net.ErrClosed))
+
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	var recv []byte
+	b := make([]byte, 512)
+	for {
+		n, err := conn.Read(b)
+		recv = append(recv, b[:n]...)
+		if err != nil {
+			utest.Assert(t, errors.Is(err, io.EOF))
+			break
+		}
+	}
+	utest.EqualNow(t, recv, data)
+}
+
+func Test_PeerTimeout(t *testing.T) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	utest.IsNilNow(t, err)
+	defer pc.Close()
+
+	conn, err := Dial("udp", pc.LocalAddr().String(), Config{MinRTO: 5 * time.Millisecond, MaxRTO: 20 * time.Millisecond, MaxRetries: 3})
+	utest.IsNilNow(t, err)
+	defer conn.Close()
+	_, err = conn.Write([]byte("hello"))
+	utest.IsNilNow(t, err)
+
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	_, err = conn.Read(make([]byte, 8))
+	utest.Assert(t, errors.Is(err, ErrPeerTimeout))
+}
+
+func Test_Transport(t *testing.T) {
+	var transport link.Transport = Transport{Config: Config{MinRTO: 10 * time.Millisecond}}
+	protocol := codec.FixLen(codec.Raw(), 2, binary.BigEndian, 64*1024, 64*1024)
+	server, err := link.ListenTransport(transport, "127.0.0.1:0", protocol, 0, link.HandlerFunc(func(session *link.Session) {
+		msg, err := session.Receive()
+		if err == nil {
+			session.Send(msg)
+		}
+	}))
+	utest.IsNilNow(t, err)
+	go server.Serve()
+	defer server.Stop()
+
+	session, err := link.DialTransport(transport, server.Listener().Addr().String(), time.Second, protocol, 0)
+	utest.IsNilNow(t, err)
+	defer session.Close()
+	utest.IsNilNow(t, session.Send([]byte("hello")))
+	recv, err := session.Receive()
+	utest.IsNilNow(t, err)
+	utest.EqualNow(t, string(recv.(*codec.InBuffer).Bytes()), "hello")
+}
@@ -0,0 +1,36 @@
+package rudp
+
+import (
+	"net"
+	"time"
+)
+
+// Transport is a link.Transport over rudp, Network is "udp" by default.
+type Transport struct {
+	Network string
+	Config  Config
+}
+
+func (t Transport) network() string {
+	if t.Network == "" {
+		return "udp"
+	}
+	return t.Network
+}
+
+func (t Transport) Listen(address string) (net.Listener, error) {
+	listener, err := Listen(t.network(), address, t.Config)
+	if err != nil {
+		return nil, err
+	}
+	return listener, nil
+}
+
+// Dial ignores timeout, nothing is sent before the first Write.
+func (t Transport) Dial(address string, timeout time.Duration) (net.Conn, error) {
+	conn, err := Dial(t.network(), address, t.Config)
+	if err != nil {
+		return nil, err
+	}
+	return conn, nil
+}
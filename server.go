@@ -1,13 +1,78 @@
 package link
 
-import "net"
+import (
+	"math/rand"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+type ApplyPolicy int
+
+const (
+	// ApplyToNew changes the setting only for sessions created after the call.
+	ApplyToNew ApplyPolicy = iota
+	// ApplyToAll changes the setting for live sessions too.
+	ApplyToAll
+)
 
 type Server struct {
-	manager      *Manager
-	listener     net.Listener
+	manager  *Manager
+	listener net.Listener
+	handler  Handler
+	stats    acceptStats
+
+	configMutex  sync.RWMutex
 	protocol     Protocol
-	handler      Handler
 	sendChanSize int
+	readTimeout  time.Duration
+	writeTimeout time.Duration
+
+	banList        *BanList
+	filter         AcceptFilter
+	maintenance    bool
+	maintenanceMsg interface{}
+
+	maxLifetime   time.Duration
+	lifetimeGrace time.Duration
+	lifetimeMsg   interface{}
+
+	acceptBucket   *TokenBucket
+	acceptRetryMsg func(retryAfter time.Duration) interface{}
+
+	auth        Authenticator
+	authTimeout time.Duration
+
+	heartbeat *Heartbeat
+	hooks     *SessionHooks
+	frames    *CloseFrames
+	metrics   Metrics
+	budget    *MemoryBudget
+
+	idleTimeout time.Duration
+	onIdle      func(*Session)
+	reaping     bool
+	stopOnce    sync.Once
+	stopChan    chan struct{}
+
+	recvMiddlewares []Middleware
+	sendMiddlewares []Middleware
+
+	recvLimit *recvLimit
+
+	maxConns      int
+	maxConnsPerIP int
+	connLimitMsg  interface{}
+	connMutex     sync.Mutex
+	conns         int
+	ipConns       map[string]int
+}
+
+type recvLimit struct {
+	rate, burst int
+	cost        func(interface{}) int
+	policy      LimitPolicy
 }
 
 type Handler interface {
@@ -29,6 +94,9 @@ func NewServer(listener net.Listener, protocol Protocol, sendChanSize int, handl
 		protocol:     protocol,
 		handler:      handler,
 		sendChanSize: sendChanSize,
+		banList:      NewBanList(),
+		ipConns:      make(map[string]int),
+		stopChan:     make(chan struct{}),
 	}
 }
 
@@ -36,30 +104,449 @@ func (server *Server) Listener() net.Listener {
 	return server.listener
 }
 
+func (server *Server) Manager() *Manager {
+	return server.manager
+}
+
+// BanList returns the deny list checked for each new connection.
+func (server *Server) BanList() *BanList {
+	server.configMutex.RLock()
+	defer server.configMutex.RUnlock()
+	return server.banList
+}
+
+// SetBanList replaces the deny list, e.g. with one loaded by LoadBanList.
+func (server *Server) SetBanList(banList *BanList) {
+	server.configMutex.Lock()
+	defer server.configMutex.Unlock()
+	server.banList = banList
+}
+
+// AcceptStats returns the counters of the accept stage.
+func (server *Server) AcceptStats() AcceptStats {
+	return server.stats.get()
+}
+
+// SetProtocol changes the protocol used by new connections.
+func (server *Server) SetProtocol(protocol Protocol) {
+	server.configMutex.Lock()
+	defer server.configMutex.Unlock()
+	server.protocol = protocol
+}
+
+// SetSendChanSize changes the send channel size of new sessions,
+// the channels of live sessions can't be resized.
+func (server *Server) SetSendChanSize(sendChanSize int) {
+	server.configMutex.Lock()
+	defer server.configMutex.Unlock()
+	server.sendChanSize = sendChanSize
+}
+
+// SetMaintenance toggles maintenance mode, in which new connections are
+// sent msg, e.g. a maintenance notice or a redirect to another host, and
+// closed, while live sessions continue. A nil msg just closes them.
+func (server *Server) SetMaintenance(enabled bool, msg interface{}) {
+	server.configMutex.Lock()
+	defer server.configMutex.Unlock()
+	server.maintenance = enabled
+	server.maintenanceMsg = msg
+}
+
+// SetMaxLifetime caps the lifetime of new sessions. When a session reaches
+// lifetime, plus a random jitter up to 10%, msg is sent to ask the client to
+// reconnect if it is not nil, and the session is closed after grace.
+func (server *Server) SetMaxLifetime(lifetime, grace time.Duration, msg interface{}) {
+	server.configMutex.Lock()
+	defer server.configMutex.Unlock()
+	server.maxLifetime = lifetime
+	server.lifetimeGrace = grace
+	server.lifetimeMsg = msg
+}
+
+// SetAcceptRate limits new connections to rate per second with burst, so a
+// reconnect storm can't stampede the handshake or auth backend. Excess
+// connections wait in the listen backlog when retryMsg is nil, otherwise
+// they are sent the message returned by retryMsg and closed. A rate of zero
+// means no limit.
+func (server *Server) SetAcceptRate(rate, burst int, retryMsg func(retryAfter time.Duration) interface{}) {
+	server.configMutex.Lock()
+	defer server.configMutex.Unlock()
+	if rate <= 0 {
+		server.acceptBucket = nil
+	} else if server.acceptBucket == nil {
+		server.acceptBucket = NewTokenBucket(rate, burst)
+	} else {
+		server.acceptBucket.SetRate(rate, burst)
+	}
+	server.acceptRetryMsg = retryMsg
+}
+
+// SetAuthenticator makes new sessions run auth before they are added to the
+// manager and given to the handler, a failed one is closed. The handshake is
+// limited to timeout if it is not zero. A nil auth disables it.
+func (server *Server) SetAuthenticator(auth Authenticator, timeout time.Duration) {
+	server.configMutex.Lock()
+	defer server.configMutex.Unlock()
+	server.auth = auth
+	server.authTimeout = timeout
+}
+
+// SetHeartbeat makes new sessions start heartbeat before they are given to
+// the handler, nil disables it.
+func (server *Server) SetHeartbeat(heartbeat *Heartbeat) {
+	server.configMutex.Lock()
+	defer server.configMutex.Unlock()
+	server.heartbeat = heartbeat
+}
+
+// UseRecv adds middlewares to the received messages of new sessions, see
+// Session.UseRecv.
+func (server *Server) UseRecv(middlewares ...Middleware) {
+	server.configMutex.Lock()
+	defer server.configMutex.Unlock()
+	server.recvMiddlewares = append(server.recvMiddlewares, middlewares...)
+}
+
+// UseSend adds middlewares to the messages sent by new sessions, see
+// Session.UseSend.
+func (server *Server) UseSend(middlewares ...Middleware) {
+	server.configMutex.Lock()
+	defer server.configMutex.Unlock()
+	server.sendMiddlewares = append(server.sendMiddlewares, middlewares...)
+}
+
+// SetRecvRateLimit limits the messages received by each new session to rate
+// tokens per second up to burst, see RateLimit. A rate of zero disables it.
+// For a limit of all sessions together use UseRecv with a shared bucket.
+func (server *Server) SetRecvRateLimit(rate, burst int, cost func(msg interface{}) int, policy LimitPolicy) {
+	server.configMutex.Lock()
+	defer server.configMutex.Unlock()
+	server.recvLimit = nil
+	if rate > 0 {
+		server.recvLimit = &recvLimit{rate, burst, cost, policy}
+	}
+}
+
+// SetAcceptFilter makes the server refuse the new connections filter fails,
+// nil disables it.
+func (server *Server) SetAcceptFilter(filter AcceptFilter) {
+	server.configMutex.Lock()
+	defer server.configMutex.Unlock()
+	server.filter = filter
+}
+
+// SetMaxConns limits the connections of the server to max, and the ones of
+// each IP to perIP, zero means no limit. A connection over a limit is refused
+// with msg sent if it is not nil. The connections in handshake count too,
+// the ones without IP, like unix ones, have no per IP limit.
+func (server *Server) SetMaxConns(max, perIP int, msg interface{}) {
+	server.configMutex.Lock()
+	defer server.configMutex.Unlock()
+	server.maxConns = max
+	server.maxConnsPerIP = perIP
+	server.connLimitMsg = msg
+}
+
+// Conns returns the number of connections, in handshake or sessions.
+func (server *Server) Conns() int {
+	server.connMutex.Lock()
+	defer server.connMutex.Unlock()
+	return server.conns
+}
+
+// ConnsOf returns the number of connections from ip.
+func (server *Server) ConnsOf(ip net.IP) int {
+	server.connMutex.Lock()
+	defer server.connMutex.Unlock()
+	return server.ipConns[ip.String()]
+}
+
+// acquireConn counts a connection from ip if it is within the limits, the
+// returned func gives it back.
+func (server *Server) acquireConn(ip net.IP) (func(), bool) {
+	server.configMutex.RLock()
+	max, perIP := server.maxConns, server.maxConnsPerIP
+	server.configMutex.RUnlock()
+
+	key := ip.String()
+	server.connMutex.Lock()
+	defer server.connMutex.Unlock()
+	if ip == nil {
+		perIP = 0
+	}
+	if (max > 0 && server.conns >= max) || (perIP > 0 && server.ipConns[key] >= perIP) {
+		return nil, false
+	}
+	server.conns++
+	server.ipConns[key]++
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			server.connMutex.Lock()
+			defer server.connMutex.Unlock()
+			server.conns--
+			if server.ipConns[key]--; server.ipConns[key] == 0 {
+				delete(server.ipConns, key)
+			}
+		})
+	}, true
+}
+
+func (server *Server) limitLifetime(session *Session, lifetime, grace time.Duration, msg interface{}) {
+	lifetime += time.Duration(rand.Int63n(int64(lifetime)/10 + 1))
+	var timer *time.Timer
+	var mutex sync.Mutex
+	timer = time.AfterFunc(lifetime, func() {
+		if msg != nil {
+			session.Send(msg)
+		}
+		mutex.Lock()
+		timer = time.AfterFunc(grace, func() {
+			session.Close()
+		})
+		mutex.Unlock()
+	})
+	session.AddCloseCallback(server, "lifetime", func() {
+		mutex.Lock()
+		timer.Stop()
+		mutex.Unlock()
+	})
+}
+
+// SetReadTimeout changes the time limit of waiting for a message, a session
+// idle longer than that is closed. Zero means no limit.
+func (server *Server) SetReadTimeout(timeout time.Duration, policy ApplyPolicy) {
+	server.configMutex.Lock()
+	server.readTimeout = timeout
+	server.configMutex.Unlock()
+	if policy == ApplyToAll {
+		server.manager.Fetch(func(session *Session) {
+			session.SetReadTimeout(timeout)
+		})
+	}
+}
+
+// SetWriteTimeout changes the time limit of sending a message. Zero means no limit.
+func (server *Server) SetWriteTimeout(timeout time.Duration, policy ApplyPolicy) {
+	server.configMutex.Lock()
+	server.writeTimeout = timeout
+	server.configMutex.Unlock()
+	if policy == ApplyToAll {
+		server.manager.Fetch(func(session *Session) {
+			session.SetWriteTimeout(timeout)
+		})
+	}
+}
+
 func (server *Server) Serve() error {
+	onTempError := func() {
+		atomic.AddUint64(&server.stats.acceptErrors, 1)
+	}
 	for {
-		conn, err := Accept(server.listener)
+		waitTime := time.Now()
+		conn, err := accept(server.listener, onTempError)
 		if err != nil {
 			return err
 		}
+		acceptTime := time.Now()
+		server.stats.wait(acceptTime.Sub(waitTime))
+		server.stats.begin()
+
+		if server.BanList().Banned(AddrIP(conn.RemoteAddr())) {
+			server.refuse(conn, nil, RejectBanned, nil)
+			continue
+		}
+
+		server.configMutex.RLock()
+		filter := server.filter
+		server.configMutex.RUnlock()
+		if filter != nil && filter(conn) != nil {
+			server.refuse(conn, nil, RejectFiltered, nil)
+			continue
+		}
+
+		server.configMutex.RLock()
+		bucket, retryMsg := server.acceptBucket, server.acceptRetryMsg
+		server.configMutex.RUnlock()
+		if bucket != nil {
+			if retryMsg == nil {
+				bucket.Wait(1)
+			} else if !bucket.Allow(1) {
+				server.configMutex.RLock()
+				protocol := server.protocol
+				server.configMutex.RUnlock()
+				go server.refuse(conn, protocol, RejectLimit, retryMsg(bucket.Delay(1)))
+				continue
+			}
+		}
+
+		release, ok := server.acquireConn(AddrIP(conn.RemoteAddr()))
+		if !ok {
+			server.configMutex.RLock()
+			protocol, msg := server.protocol, server.connLimitMsg
+			server.configMutex.RUnlock()
+			go server.refuse(conn, protocol, RejectLimit, msg)
+			continue
+		}
 
 		go func() {
-			codec, err := server.protocol.NewCodec(conn)
+			server.configMutex.RLock()
+			protocol := server.protocol
+			maintenance := server.maintenance
+			maintenanceMsg := server.maintenanceMsg
+			metrics := server.metrics
+			server.configMutex.RUnlock()
+
+			if maintenance {
+				server.refuse(conn, protocol, RejectMaintenance, maintenanceMsg)
+				release()
+				return
+			}
+
+			var rw net.Conn = conn
+			var counter *countConn
+			if metrics != nil {
+				counter = &countConn{Conn: conn, metrics: metrics}
+				rw = counter
+			}
+			codec, err := protocol.NewCodec(rw)
 			if err != nil {
-				conn.Close()
+				server.refuse(conn, nil, RejectHandshake, nil)
+				release()
 				return
 			}
-			session := server.manager.NewSession(codec, server.sendChanSize)
+
+			server.configMutex.RLock()
+			session := newConnSession(server.manager, conn, codec, server.sendChanSize)
+			auth, authTimeout := server.auth, server.authTimeout
+			budget := server.budget
+			server.configMutex.RUnlock()
+			if budget != nil {
+				session.budget = budget
+				budget.add(session)
+				session.AddCloseCallback(server, "budget", func() {
+					budget.remove(session)
+				})
+			}
+			session.AddCloseCallback(server, "conns", release)
+			if metrics != nil {
+				session.counter = counter
+				session.metrics = metrics
+				metrics.SessionOpened()
+				session.AddCloseCallback(server, "metrics", metrics.SessionClosed)
+			}
+
+			if auth != nil && handshake(session, auth, authTimeout) != nil {
+				server.stats.reject(RejectAuth)
+				server.stats.done(time.Time{})
+				session.Close()
+				return
+			}
+
+			// Hold the config lock until the session is visible to the
+			// manager, so an ApplyToAll change can't miss it.
+			server.configMutex.RLock()
+			session.SetReadTimeout(server.readTimeout)
+			session.SetWriteTimeout(server.writeTimeout)
+			if server.maxLifetime > 0 {
+				server.limitLifetime(session, server.maxLifetime, server.lifetimeGrace, server.lifetimeMsg)
+			}
+			if len(server.recvMiddlewares) > 0 {
+				session.UseRecv(server.recvMiddlewares...)
+			}
+			if limit := server.recvLimit; limit != nil {
+				session.UseRecv(RateLimit(NewTokenBucket(limit.rate, limit.burst), limit.cost, limit.policy))
+			}
+			if len(server.sendMiddlewares) > 0 {
+				session.UseSend(server.sendMiddlewares...)
+			}
+			if server.heartbeat != nil {
+				session.StartHeartbeat(server.heartbeat)
+			}
+			hooks := server.hooks
+			if hooks != nil {
+				session.SetHooks(hooks)
+			}
+			if server.frames != nil {
+				session.SetCloseFrames(server.frames)
+			}
+			server.manager.putSession(session)
+			server.configMutex.RUnlock()
+			server.stats.done(acceptTime)
+
+			if hooks != nil && hooks.OnConnect != nil {
+				hooks.OnConnect(session)
+			}
 			server.handler.HandleSession(session)
 		}()
 	}
 }
 
+// refuse closes a new connection, after sending msg if it is not nil.
+func (server *Server) refuse(conn net.Conn, protocol Protocol, reason RejectReason, msg interface{}) {
+	server.stats.reject(reason)
+	server.stats.done(time.Time{})
+	if msg != nil {
+		if codec, err := protocol.NewCodec(conn); err == nil {
+			codec.Send(msg)
+			codec.Close()
+			return
+		}
+	}
+	conn.Close()
+}
+
 func (server *Server) GetSession(sessionID uint64) *Session {
 	return server.manager.GetSession(sessionID)
 }
 
+// Kill closes the session, it returns false if the session is not found.
+func (server *Server) Kill(sessionID uint64) bool {
+	session := server.manager.GetSession(sessionID)
+	if session == nil {
+		return false
+	}
+	session.Close()
+	return true
+}
+
+// KillAddr closes all sessions from a CIDR or an IP, and returns the number of them.
+func (server *Server) KillAddr(cidr string) (int, error) {
+	ipnet, err := ParseCIDR(cidr)
+	if err != nil {
+		return 0, err
+	}
+	return server.killNet(ipnet), nil
+}
+
+// Ban denies new connections from a CIDR or an IP for ttl, and closes the
+// live sessions from it. A zero ttl means forever.
+func (server *Server) Ban(cidr string, ttl time.Duration) (int, error) {
+	ipnet, err := ParseCIDR(cidr)
+	if err != nil {
+		return 0, err
+	}
+	err = server.BanList().Ban(ipnet, ttl)
+	return server.killNet(ipnet), err
+}
+
+func (server *Server) killNet(ipnet *net.IPNet) int {
+	var sessions []*Session
+	server.manager.Fetch(func(session *Session) {
+		if ip := AddrIP(session.RemoteAddr()); ip != nil && ipnet.Contains(ip) {
+			sessions = append(sessions, session)
+		}
+	})
+	for _, session := range sessions {
+		session.Close()
+	}
+	return len(sessions)
+}
+
 func (server *Server) Stop() {
+	server.stopOnce.Do(func() {
+		close(server.stopChan)
+	})
 	server.listener.Close()
 	server.manager.Dispose()
 }
@@ -1,23 +1,91 @@
 package link
 
 import (
+	"context"
 	"errors"
+	"net"
 	"sync"
 	"sync/atomic"
+	"time"
 )
 
 var SessionClosedError = errors.New("Session Closed")
 var SessionBlockedError = errors.New("Session Blocked")
+var MessageDroppedError = errors.New("Message Dropped")
+
+// SendPolicy tells what Send does when the send channel is full.
+type SendPolicy int32
+
+const (
+	// CloseWhenFull closes the session and returns SessionBlockedError.
+	CloseWhenFull SendPolicy = iota
+	// BlockWhenFull waits for room, or until the session is closed.
+	BlockWhenFull
+	// DropNewest drops the message and returns MessageDroppedError.
+	DropNewest
+	// DropOldest drops the oldest queued message to make room, its future
+	// completes with MessageDroppedError if it was sent by SendAsync.
+	DropOldest
+)
 
 var globalSessionId uint64
 
 type Session struct {
-	id        uint64
+	// 64-bit atomic fields first for alignment on 32-bit platforms.
+	id           uint64
+	recvPackets  uint64
+	sendPackets  uint64
+	readTimeout  int64
+	writeTimeout int64
+	lastRecv     int64
+	pingSent     int64
+	lastActive   int64
+	sendPolicy   int32
+	queuedBytes  int64
+
 	codec     Codec
+	sendCodec Codec
+	conn      net.Conn
+	counter   *countConn
+	metrics   Metrics
+	budget    *MemoryBudget
 	manager   *Manager
 	sendChan  chan interface{}
 	recvMutex sync.Mutex
 	sendMutex sync.RWMutex
+	createdAt time.Time
+
+	// codecMutex guards codec for Close and Codec, Receive owns it with
+	// recvMutex, and senders own sendCodec.
+	codecMutex sync.Mutex
+
+	tagMutex sync.RWMutex
+	tags     map[string]string
+
+	valueMutex sync.RWMutex
+	values     map[interface{}]interface{}
+
+	handlerMutex sync.RWMutex
+	handlers     map[uint16]func(interface{})
+
+	quality     qualityEstimator
+	heartbeat   atomic.Value
+	hooks       atomic.Value
+	closeFrames atomic.Value
+	closeErr    atomic.Value
+
+	recvChain middlewareChain
+	sendChain middlewareChain
+	lanes     priorityLanes
+
+	// dropMutex orders the codec switches taken out by DropOldest before
+	// the messages the send loop receives after them.
+	dropMutex     sync.Mutex
+	droppedSwitch *codecSwitch
+
+	asyncMutex   sync.Mutex
+	asyncQueue   []*asyncSend
+	asyncRunning bool
 
 	closeFlag          int32
 	closeChan          chan int
@@ -33,14 +101,23 @@ func NewSession(codec Codec, sendChanSize int) *Session {
 }
 
 func newSession(manager *Manager, codec Codec, sendChanSize int) *Session {
+	return newConnSession(manager, nil, codec, sendChanSize)
+}
+
+func newConnSession(manager *Manager, conn net.Conn, codec Codec, sendChanSize int) *Session {
 	session := &Session{
 		codec:     codec,
+		sendCodec: codec,
+		conn:      conn,
 		manager:   manager,
 		closeChan: make(chan int),
+		createdAt: time.Now(),
 		id:        atomic.AddUint64(&globalSessionId, 1),
 	}
+	session.lastActive = session.createdAt.UnixNano()
 	if sendChanSize > 0 {
 		session.sendChan = make(chan interface{}, sendChanSize)
+		session.lanes.notify = make(chan struct{}, 1)
 		go session.sendLoop()
 	}
 	return session
@@ -55,22 +132,56 @@ func (session *Session) IsClosed() bool {
 }
 
 func (session *Session) Close() error {
+	return session.closeWith(nil)
+}
+
+// closeWith closes the session for reason, nil when it is closed by Close.
+func (session *Session) closeWith(reason error) error {
 	if atomic.CompareAndSwapInt32(&session.closeFlag, 0, 1) {
+		if reason != nil {
+			session.closeErr.Store(closeError{reason})
+		}
 		close(session.closeChan)
 
 		if session.sendChan != nil {
 			session.sendMutex.Lock()
 			close(session.sendChan)
-			if clear, ok := session.codec.(ClearSendChan); ok {
-				clear.ClearSendChan(session.sendChan)
+			lanes := session.drainLanes()
+			pending := make(chan interface{}, len(session.sendChan)+len(lanes))
+			for _, msg := range lanes {
+				session.released(msg)
+				pending <- msg
+			}
+			for msg := range session.sendChan {
+				session.released(msg)
+				if async, ok := msg.(*asyncSend); ok {
+					async.future.complete(SessionClosedError)
+					msg = async.msg
+				}
+				if timed, ok := msg.(*timedSend); ok {
+					msg = timed.msg
+				}
+				switch msg.(type) {
+				case *codecSwitch, flushSend:
+				default:
+					pending <- msg
+				}
+			}
+			close(pending)
+			if clear, ok := session.Codec().(ClearSendChan); ok {
+				clear.ClearSendChan(pending)
 			}
 			session.sendMutex.Unlock()
 		}
 
-		err := session.codec.Close()
+		err := session.Codec().Close()
 
 		go func() {
 			session.invokeCloseCallbacks()
+			if hooks := session.loadHooks(); hooks != nil && hooks.OnClose != nil {
+				hooks.OnClose(session, reason)
+			}
+			session.clearValues()
 
 			if session.manager != nil {
 				session.manager.delSession(session)
@@ -82,26 +193,219 @@ func (session *Session) Close() error {
 }
 
 func (session *Session) Codec() Codec {
+	session.codecMutex.Lock()
+	defer session.codecMutex.Unlock()
 	return session.codec
 }
 
+// Conn returns the connection of the session, it is nil when the session is
+// created by NewSession.
+func (session *Session) Conn() net.Conn {
+	return session.conn
+}
+
+// RemoteAddr returns the peer address, it is nil when the session has no connection.
+func (session *Session) RemoteAddr() net.Addr {
+	if session.conn == nil {
+		return nil
+	}
+	return session.conn.RemoteAddr()
+}
+
+func (session *Session) CreatedAt() time.Time {
+	return session.createdAt
+}
+
+// RecvPackets returns the number of messages received.
+func (session *Session) RecvPackets() uint64 {
+	return atomic.LoadUint64(&session.recvPackets)
+}
+
+// SendPackets returns the number of messages sent.
+func (session *Session) SendPackets() uint64 {
+	return atomic.LoadUint64(&session.sendPackets)
+}
+
+// SendChanLen returns the number of messages waiting in the send channel.
+func (session *Session) SendChanLen() int {
+	return len(session.sendChan)
+}
+
+// AddRTTSample feeds a measured round trip time, e.g. from a heartbeat,
+// into the quality estimation.
+func (session *Session) AddRTTSample(rtt time.Duration) {
+	session.quality.addRTT(rtt)
+}
+
+// AddRetransmits reports that n messages had to be sent again.
+func (session *Session) AddRetransmits(n uint64) {
+	session.quality.addRetransmits(n)
+}
+
+// Quality returns the connection quality estimation. The throughput is
+// measured between calls at least one second apart.
+func (session *Session) Quality() Quality {
+	return session.quality.get(session.SendPackets(), time.Now())
+}
+
+// SetTag attaches a label to the session for introspection, an empty value removes it.
+func (session *Session) SetTag(key, value string) {
+	session.tagMutex.Lock()
+	defer session.tagMutex.Unlock()
+	if value == "" {
+		delete(session.tags, key)
+		return
+	}
+	if session.tags == nil {
+		session.tags = make(map[string]string)
+	}
+	session.tags[key] = value
+}
+
+// Tags returns a copy of the labels of the session.
+func (session *Session) Tags() map[string]string {
+	session.tagMutex.RLock()
+	defer session.tagMutex.RUnlock()
+	tags := make(map[string]string, len(session.tags))
+	for k, v := range session.tags {
+		tags[k] = v
+	}
+	return tags
+}
+
+func deadline(timeout time.Duration) time.Time {
+	if timeout > 0 {
+		return time.Now().Add(timeout)
+	}
+	return time.Time{}
+}
+
+// SetReadTimeout limits how long Receive waits for a message, zero means no
+// limit. A Receive in progress is limited from now on.
+func (session *Session) SetReadTimeout(timeout time.Duration) {
+	atomic.StoreInt64(&session.readTimeout, int64(timeout))
+	if session.conn != nil {
+		session.conn.SetReadDeadline(deadline(timeout))
+	}
+}
+
+// SetWriteTimeout limits how long sending a message can take, zero means no
+// limit. A send in progress is limited from now on.
+func (session *Session) SetWriteTimeout(timeout time.Duration) {
+	atomic.StoreInt64(&session.writeTimeout, int64(timeout))
+	if session.conn != nil {
+		session.conn.SetWriteDeadline(deadline(timeout))
+	}
+}
+
+// SetSendPolicy sets what Send and SendAsync do when the send channel is
+// full, CloseWhenFull by default. It does not apply to sessions without send
+// channel.
+func (session *Session) SetSendPolicy(policy SendPolicy) {
+	atomic.StoreInt32(&session.sendPolicy, int32(policy))
+}
+
 func (session *Session) Receive() (interface{}, error) {
+	return session.receive(0, false)
+}
+
+// ReceiveTimeout is Receive limited to timeout instead of the read timeout of
+// the session.
+func (session *Session) ReceiveTimeout(timeout time.Duration) (interface{}, error) {
+	return session.receive(timeout, true)
+}
+
+func (session *Session) receive(timeout time.Duration, once bool) (interface{}, error) {
 	session.recvMutex.Lock()
 	defer session.recvMutex.Unlock()
 
-	msg, err := session.codec.Receive()
-	if err != nil {
-		session.Close()
+	for {
+		if !once {
+			timeout = time.Duration(atomic.LoadInt64(&session.readTimeout))
+		}
+		if (timeout > 0 || once) && session.conn != nil {
+			session.conn.SetReadDeadline(deadline(timeout))
+		}
+
+		msg, err := session.codec.Receive()
+		if err != nil {
+			session.fail(err)
+			return msg, err
+		}
+		atomic.AddUint64(&session.recvPackets, 1)
+		session.touch()
+		if session.metrics != nil {
+			session.metrics.PacketReceived(MessageSize(msg))
+		}
+		if session.heartbeatSkip(msg) {
+			continue
+		}
+		if frame, ok := session.peerClose(msg); ok {
+			session.closeWith(frame)
+			return nil, frame
+		}
+		if msg, err = session.recvChain.run(msg); err != nil {
+			session.fail(err)
+			return nil, err
+		}
+		if msg != nil {
+			if once && session.conn != nil {
+				session.conn.SetReadDeadline(deadline(time.Duration(atomic.LoadInt64(&session.readTimeout))))
+			}
+			return msg, err
+		}
 	}
-	return msg, err
 }
 
 func (session *Session) sendLoop() {
 	defer session.Close()
 	for {
+		if msg, ok := session.popLane(); ok {
+			session.released(msg)
+			if err := session.send(msg); err != nil {
+				session.fail(err)
+				return
+			}
+			continue
+		}
 		select {
+		case <-session.lanes.notify:
 		case msg, ok := <-session.sendChan:
-			if !ok || session.codec.Send(msg) != nil {
+			if !ok {
+				return
+			}
+			session.released(msg)
+			if SendPolicy(atomic.LoadInt32(&session.sendPolicy)) == DropOldest {
+				session.dropMutex.Lock()
+				var err error
+				if session.droppedSwitch != nil {
+					err = session.switchSendCodec(session.droppedSwitch.codec)
+					session.droppedSwitch = nil
+				}
+				session.dropMutex.Unlock()
+				if err != nil {
+					session.fail(err)
+					return
+				}
+			}
+			if switched, isSwitch := msg.(*codecSwitch); isSwitch {
+				if err := session.switchSendCodec(switched.codec); err != nil {
+					session.fail(err)
+					return
+				}
+			} else if async, isAsync := msg.(*asyncSend); isAsync {
+				if async.cancelled() {
+					continue
+				}
+				done := session.interruptWrite(async.ctx)
+				err := done(session.send(async.msg))
+				async.future.complete(err)
+				if err != nil {
+					session.fail(err)
+					return
+				}
+			} else if err := session.send(msg); err != nil {
+				session.fail(err)
 				return
 			}
 		case <-session.closeChan:
@@ -110,7 +414,71 @@ func (session *Session) sendLoop() {
 	}
 }
 
+// timedSend is a message of SendTimeout.
+type timedSend struct {
+	msg     interface{}
+	timeout time.Duration
+}
+
+// send must be called by the send loop or with sendMutex locked.
+func (session *Session) send(msg interface{}) error {
+	timeout := time.Duration(atomic.LoadInt64(&session.writeTimeout))
+	timed, once := msg.(*timedSend)
+	if once {
+		msg, timeout = timed.msg, timed.timeout
+	}
+	if (timeout > 0 || once) && session.conn != nil {
+		session.conn.SetWriteDeadline(deadline(timeout))
+	}
+	if _, isFlush := msg.(flushSend); isFlush {
+		return flushCodec(session.sendCodec)
+	}
+	if err := session.sendCodec.Send(msg); err != nil {
+		return err
+	}
+	if once && session.conn != nil {
+		session.conn.SetWriteDeadline(deadline(time.Duration(atomic.LoadInt64(&session.writeTimeout))))
+	}
+	atomic.AddUint64(&session.sendPackets, 1)
+	session.touch()
+	if session.metrics != nil {
+		session.metrics.PacketSent(MessageSize(msg))
+	}
+	return nil
+}
+
+// SendTimeout is Send limiting the write of msg to timeout instead of the
+// write timeout of the session. With a send channel the limit of the write
+// starts when msg is taken from the channel, and waiting for room in a full
+// channel is limited to timeout too, msg is dropped then.
+func (session *Session) SendTimeout(msg interface{}, timeout time.Duration) error {
+	msg, err := session.sendChain.run(msg)
+	if err != nil || msg == nil {
+		return err
+	}
+	if session.sendChan == nil || timeout <= 0 {
+		return session.sendMsg(&timedSend{msg, timeout})
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	return session.sendMsgUntil(&timedSend{msg, timeout}, ctx.Done())
+}
+
 func (session *Session) Send(msg interface{}) error {
+	msg, err := session.sendChain.run(msg)
+	if err != nil || msg == nil {
+		return err
+	}
+	return session.sendMsg(msg)
+}
+
+func (session *Session) sendMsg(msg interface{}) error {
+	return session.sendMsgUntil(msg, nil)
+}
+
+// sendMsgUntil drops msg when the send channel has no room before expire is
+// closed.
+func (session *Session) sendMsgUntil(msg interface{}, expire <-chan struct{}) error {
 	if session.sendChan == nil {
 		if session.IsClosed() {
 			return SessionClosedError
@@ -119,27 +487,217 @@ func (session *Session) Send(msg interface{}) error {
 		session.sendMutex.Lock()
 		defer session.sendMutex.Unlock()
 
-		err := session.codec.Send(msg)
+		err := session.send(msg)
 		if err != nil {
-			session.Close()
+			session.fail(err)
 		}
 		return err
 	}
 
+	size, err := session.charge(msg)
+	if err != nil {
+		return err
+	}
 	session.sendMutex.RLock()
 	if session.IsClosed() {
 		session.sendMutex.RUnlock()
+		session.uncharge(size)
 		return SessionClosedError
 	}
 
+	err = session.enqueue(msg, expire)
+	session.sendMutex.RUnlock()
+	if err != nil {
+		session.uncharge(size)
+	}
+	if err == SessionBlockedError {
+		session.closeWith(err)
+	}
+	return err
+}
+
+// enqueue puts msg into the send channel following the send policy, it must
+// be called with sendMutex read locked. Waiting for room stops with
+// MessageDroppedError when expire is closed.
+func (session *Session) enqueue(msg interface{}, expire <-chan struct{}) error {
+	policy := SendPolicy(atomic.LoadInt32(&session.sendPolicy))
+	for {
+		select {
+		case session.sendChan <- msg:
+			return nil
+		default:
+		}
+		switch policy {
+		case BlockWhenFull:
+			select {
+			case session.sendChan <- msg:
+				return nil
+			case <-session.closeChan:
+				return SessionClosedError
+			case <-expire:
+				return MessageDroppedError
+			}
+		case DropNewest:
+			return MessageDroppedError
+		case DropOldest:
+			session.dropOldest()
+		default:
+			return SessionBlockedError
+		}
+	}
+}
+
+// dropOldest takes the oldest message out of the send channel. A codec switch
+// is kept for the send loop, and a dropped message is given to the codec to
+// clear like at Close.
+func (session *Session) dropOldest() {
+	session.dropMutex.Lock()
+	defer session.dropMutex.Unlock()
 	select {
-	case session.sendChan <- msg:
-		session.sendMutex.RUnlock()
-		return nil
+	case msg := <-session.sendChan:
+		session.released(msg)
+		if switched, isSwitch := msg.(*codecSwitch); isSwitch {
+			session.droppedSwitch = switched
+			return
+		}
+		if async, ok := msg.(*asyncSend); ok {
+			async.future.complete(MessageDroppedError)
+			msg = async.msg
+		}
+		if timed, ok := msg.(*timedSend); ok {
+			msg = timed.msg
+		}
+		if _, isFlush := msg.(flushSend); isFlush {
+			return
+		}
+		session.clearDropped(msg)
 	default:
+	}
+}
+
+// clearDropped gives a dropped message to the codec to clear.
+func (session *Session) clearDropped(msg interface{}) {
+	if clear, ok := session.Codec().(ClearSendChan); ok {
+		dropped := make(chan interface{}, 1)
+		dropped <- msg
+		close(dropped)
+		clear.ClearSendChan(dropped)
+	}
+}
+
+// SendFuture is the result of SendAsync.
+type SendFuture struct {
+	done chan struct{}
+	err  error
+}
+
+type asyncSend struct {
+	msg    interface{}
+	future *SendFuture
+	ctx    context.Context
+}
+
+// cancelled completes the future when the context of SendContext is done
+// before the message is written.
+func (async *asyncSend) cancelled() bool {
+	if async.ctx != nil && async.ctx.Err() != nil {
+		async.future.complete(async.ctx.Err())
+		return true
+	}
+	return false
+}
+
+func (future *SendFuture) complete(err error) {
+	future.err = err
+	close(future.done)
+}
+
+// Done is closed when the message has been written or failed.
+func (future *SendFuture) Done() <-chan struct{} {
+	return future.done
+}
+
+// Err returns the result after Done closed.
+func (future *SendFuture) Err() error {
+	<-future.done
+	return future.err
+}
+
+// SendAsync queues msg without blocking, the future completes when msg has
+// been written to the codec, or failed, or dropped because the send channel
+// is full. A session without send channel sends the messages in order in
+// a goroutine.
+func (session *Session) SendAsync(msg interface{}) *SendFuture {
+	return session.sendAsync(nil, msg)
+}
+
+func (session *Session) sendAsync(ctx context.Context, msg interface{}) *SendFuture {
+	future := &SendFuture{done: make(chan struct{})}
+	msg, err := session.sendChain.run(msg)
+	if err != nil || msg == nil {
+		future.complete(err)
+		return future
+	}
+	if session.sendChan == nil {
+		session.asyncMutex.Lock()
+		session.asyncQueue = append(session.asyncQueue, &asyncSend{msg, future, ctx})
+		if !session.asyncRunning {
+			session.asyncRunning = true
+			go session.asyncLoop()
+		}
+		session.asyncMutex.Unlock()
+		return future
+	}
+
+	async := &asyncSend{msg, future, ctx}
+	size, err := session.charge(async)
+	if err != nil {
+		future.complete(err)
+		return future
+	}
+	session.sendMutex.RLock()
+	if session.IsClosed() {
 		session.sendMutex.RUnlock()
-		session.Close()
-		return SessionBlockedError
+		session.uncharge(size)
+		future.complete(SessionClosedError)
+		return future
+	}
+
+	var expire <-chan struct{}
+	if ctx != nil {
+		expire = ctx.Done()
+	}
+	err = session.enqueue(async, expire)
+	session.sendMutex.RUnlock()
+	if err != nil {
+		session.uncharge(size)
+		if err == SessionBlockedError {
+			session.closeWith(err)
+		}
+		if err == MessageDroppedError && ctx != nil && ctx.Err() != nil {
+			err = ctx.Err()
+		}
+		future.complete(err)
+	}
+	return future
+}
+
+func (session *Session) asyncLoop() {
+	for {
+		session.asyncMutex.Lock()
+		if len(session.asyncQueue) == 0 {
+			session.asyncRunning = false
+			session.asyncMutex.Unlock()
+			return
+		}
+		async := session.asyncQueue[0]
+		session.asyncQueue[0] = nil
+		session.asyncQueue = session.asyncQueue[1:]
+		session.asyncMutex.Unlock()
+		if !async.cancelled() {
+			done := session.interruptWrite(async.ctx)
+			async.future.complete(done(session.sendMsg(async.msg)))
+		}
 	}
 }
 
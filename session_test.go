@@ -2,10 +2,23 @@ package link
 
 import (
 	"bytes"
+	"compress/flate"
+	"context"
+	"crypto/sha256"
 	"encoding/binary"
+	"errors"
 	"io"
+	"io/ioutil"
 	"math/rand"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -255,3 +268,2235 @@ func Benchmark_InterfaceToPointer(b *testing.B) {
 	}
 	_ = a
 }
+
+func Test_RuntimeTimeout(t *testing.T) {
+	closed := make(chan struct{})
+	server, err := Listen("tcp", "0.0.0.0:0", ProtocolFunc(NewTestCodec), 0, HandlerFunc(func(session *Session) {
+		defer close(closed)
+		for {
+			if _, err := session.Receive(); err != nil {
+				return
+			}
+		}
+	}))
+	utest.IsNilNow(t, err)
+	go server.Serve()
+	defer server.Stop()
+
+	session, err := Dial("tcp", server.Listener().Addr().String(), ProtocolFunc(NewTestCodec), 0)
+	utest.IsNilNow(t, err)
+	defer session.Close()
+
+	utest.IsNilNow(t, session.Send([]byte("hello")))
+	time.Sleep(50 * time.Millisecond)
+
+	server.SetReadTimeout(50*time.Millisecond, ApplyToAll)
+	select {
+	case <-closed:
+	case <-time.After(time.Second):
+		t.Fatal("idle session not closed")
+	}
+}
+
+func Test_Ban(t *testing.T) {
+	server, err := Listen("tcp", "127.0.0.1:0", ProtocolFunc(NewTestCodec), 0, HandlerFunc(func(session *Session) {
+		for {
+			if _, err := session.Receive(); err != nil {
+				return
+			}
+		}
+	}))
+	utest.IsNilNow(t, err)
+	go server.Serve()
+	defer server.Stop()
+	addr := server.Listener().Addr().String()
+
+	session, err := Dial("tcp", addr, ProtocolFunc(NewTestCodec), 0)
+	utest.IsNilNow(t, err)
+	defer session.Close()
+	utest.IsNilNow(t, session.Send([]byte("hello")))
+	time.Sleep(50 * time.Millisecond)
+
+	n, err := server.Ban("127.0.0.0/8", 200*time.Millisecond)
+	utest.IsNilNow(t, err)
+	utest.EqualNow(t, n, 1)
+	_, err = session.Receive()
+	utest.NotNilNow(t, err)
+
+	session, err = Dial("tcp", addr, ProtocolFunc(NewTestCodec), 0)
+	utest.IsNilNow(t, err)
+	_, err = session.Receive()
+	utest.NotNilNow(t, err)
+
+	time.Sleep(300 * time.Millisecond)
+	utest.Assert(t, !server.BanList().Banned(AddrIP(session.RemoteAddr())))
+}
+
+func Test_TokenBucket(t *testing.T) {
+	bucket := NewTokenBucket(100, 10)
+	utest.Assert(t, bucket.Allow(10))
+	utest.Assert(t, !bucket.Allow(1))
+	d := bucket.Take(10)
+	utest.Assert(t, d > 50*time.Millisecond && d <= 100*time.Millisecond, d)
+
+	bucket.SetRate(0, 0)
+	utest.Assert(t, bucket.Allow(1000))
+}
+
+func Test_Quality(t *testing.T) {
+	var q qualityEstimator
+	begin := time.Now()
+	q.get(0, begin)
+
+	for i := 0; i < 10; i++ {
+		q.addRTT(50 * time.Millisecond)
+	}
+	good := q.get(100, begin.Add(time.Second))
+	utest.EqualNow(t, good.RTT, 50*time.Millisecond)
+	utest.Assert(t, good.Jitter < 10*time.Millisecond, good.Jitter)
+	utest.EqualNow(t, good.Throughput, float64(100))
+
+	for i := 0; i < 10; i++ {
+		q.addRTT(time.Duration(i%2) * 300 * time.Millisecond)
+	}
+	q.addRetransmits(20)
+	bad := q.get(200, begin.Add(2*time.Second))
+	utest.Assert(t, bad.Jitter > good.Jitter, bad.Jitter)
+	utest.Assert(t, bad.Score < good.Score, bad.Score)
+	utest.EqualNow(t, bad.Retransmits, uint64(20))
+}
+
+func Test_Maintenance(t *testing.T) {
+	server, err := Listen("tcp", "127.0.0.1:0", ProtocolFunc(NewTestCodec), 0, HandlerFunc(func(session *Session) {
+		for {
+			msg, err := session.Receive()
+			if err != nil {
+				return
+			}
+			session.Send(msg)
+		}
+	}))
+	utest.IsNilNow(t, err)
+	go server.Serve()
+	defer server.Stop()
+	addr := server.Listener().Addr().String()
+
+	live, err := Dial("tcp", addr, ProtocolFunc(NewTestCodec), 0)
+	utest.IsNilNow(t, err)
+	defer live.Close()
+	utest.IsNilNow(t, live.Send([]byte("ping")))
+	_, err = live.Receive()
+	utest.IsNilNow(t, err)
+
+	server.SetMaintenance(true, []byte("maintenance"))
+
+	session, err := Dial("tcp", addr, ProtocolFunc(NewTestCodec), 0)
+	utest.IsNilNow(t, err)
+	msg, err := session.Receive()
+	utest.IsNilNow(t, err)
+	utest.EqualNow(t, string(msg.([]byte)), "maintenance")
+	_, err = session.Receive()
+	utest.NotNilNow(t, err)
+
+	utest.IsNilNow(t, live.Send([]byte("ping")))
+	msg, err = live.Receive()
+	utest.IsNilNow(t, err)
+	utest.EqualNow(t, string(msg.([]byte)), "ping")
+
+	server.SetMaintenance(false, nil)
+	session, err = Dial("tcp", addr, ProtocolFunc(NewTestCodec), 0)
+	utest.IsNilNow(t, err)
+	defer session.Close()
+	utest.IsNilNow(t, session.Send([]byte("ping")))
+	_, err = session.Receive()
+	utest.IsNilNow(t, err)
+}
+
+func Test_MaxLifetime(t *testing.T) {
+	server, err := Listen("tcp", "127.0.0.1:0", ProtocolFunc(NewTestCodec), 0, HandlerFunc(func(session *Session) {
+		for {
+			if _, err := session.Receive(); err != nil {
+				return
+			}
+		}
+	}))
+	utest.IsNilNow(t, err)
+	go server.Serve()
+	defer server.Stop()
+
+	server.SetMaxLifetime(100*time.Millisecond, 100*time.Millisecond, []byte("reconnect"))
+
+	begin := time.Now()
+	session, err := Dial("tcp", server.Listener().Addr().String(), ProtocolFunc(NewTestCodec), 0)
+	utest.IsNilNow(t, err)
+	defer session.Close()
+
+	msg, err := session.Receive()
+	utest.IsNilNow(t, err)
+	utest.EqualNow(t, string(msg.([]byte)), "reconnect")
+	_, err = session.Receive()
+	utest.NotNilNow(t, err)
+	utest.Assert(t, time.Since(begin) >= 200*time.Millisecond)
+}
+
+func Test_PersistentBanList(t *testing.T) {
+	dir, err := ioutil.TempDir("", "link")
+	utest.IsNilNow(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "bans.json")
+
+	list, err := LoadBanList(NewFileBanStore(path))
+	utest.IsNilNow(t, err)
+	ipnet, _ := ParseCIDR("10.0.0.0/8")
+	utest.IsNilNow(t, list.Ban(ipnet, 0))
+	utest.IsNilNow(t, list.BanKey("user1", time.Hour))
+	utest.IsNilNow(t, list.BanKey("user2", time.Millisecond))
+
+	list, err = LoadBanList(NewFileBanStore(path))
+	utest.IsNilNow(t, err)
+	utest.Assert(t, list.Banned(net.ParseIP("10.1.2.3")))
+	utest.Assert(t, !list.Banned(net.ParseIP("11.1.2.3")))
+	utest.Assert(t, list.KeyBanned("user1"))
+	time.Sleep(10 * time.Millisecond)
+	utest.Assert(t, !list.KeyBanned("user2"))
+
+	utest.IsNilNow(t, list.UnbanKey("user1"))
+	list, err = LoadBanList(NewFileBanStore(path))
+	utest.IsNilNow(t, err)
+	utest.Assert(t, !list.KeyBanned("user1"))
+	utest.EqualNow(t, len(list.Entries()), 1)
+}
+
+func Test_AcceptStats(t *testing.T) {
+	server, err := Listen("tcp", "127.0.0.1:0", ProtocolFunc(NewTestCodec), 0, HandlerFunc(func(session *Session) {
+		for {
+			msg, err := session.Receive()
+			if err != nil {
+				return
+			}
+			session.Send(msg)
+		}
+	}))
+	utest.IsNilNow(t, err)
+	go server.Serve()
+	defer server.Stop()
+	addr := server.Listener().Addr().String()
+
+	session, err := Dial("tcp", addr, ProtocolFunc(NewTestCodec), 0)
+	utest.IsNilNow(t, err)
+	defer session.Close()
+	utest.IsNilNow(t, session.Send([]byte("ping")))
+	_, err = session.Receive()
+	utest.IsNilNow(t, err)
+
+	server.SetMaintenance(true, nil)
+	conn, err := net.Dial("tcp", addr)
+	utest.IsNilNow(t, err)
+	_, err = conn.Read(make([]byte, 1))
+	utest.NotNilNow(t, err)
+	conn.Close()
+	server.SetMaintenance(false, nil)
+
+	server.Ban("127.0.0.1", 0)
+	conn, err = net.Dial("tcp", addr)
+	utest.IsNilNow(t, err)
+	_, err = conn.Read(make([]byte, 1))
+	utest.NotNilNow(t, err)
+	conn.Close()
+
+	stats := server.AcceptStats()
+	utest.EqualNow(t, stats.Accepted, uint64(1))
+	utest.EqualNow(t, stats.Rejected["maintenance"], uint64(1))
+	utest.EqualNow(t, stats.Rejected["banned"], uint64(1))
+	utest.EqualNow(t, stats.Pending, int64(0))
+	utest.Assert(t, stats.PeakPending >= 1)
+	utest.Assert(t, stats.AcceptLatency > 0)
+}
+
+func Test_VersionMux(t *testing.T) {
+	echo := func(version string) Handler {
+		return HandlerFunc(func(session *Session) {
+			for {
+				msg, err := session.Receive()
+				if err != nil {
+					return
+				}
+				session.Send(append([]byte(version+":"), msg.([]byte)...))
+			}
+		})
+	}
+	v2 := ProtocolFunc(func(rw io.ReadWriter) (Codec, error) {
+		var magic [2]byte
+		if _, err := io.ReadFull(rw, magic[:]); err != nil {
+			return nil, err
+		}
+		return NewTestCodec(rw)
+	})
+
+	mux := NewVersionMux()
+	mux.Handle("v1", nil, ProtocolFunc(NewTestCodec), echo("v1"))
+	mux.Handle("v2", []byte("V2"), v2, echo("v2"))
+	server, err := Listen("tcp", "127.0.0.1:0", mux, 0, mux)
+	utest.IsNilNow(t, err)
+	go server.Serve()
+	defer server.Stop()
+	addr := server.Listener().Addr().String()
+
+	client1, err := Dial("tcp", addr, ProtocolFunc(NewTestCodec), 0)
+	utest.IsNilNow(t, err)
+	defer client1.Close()
+	client2, err := Dial("tcp", addr, ProtocolFunc(func(rw io.ReadWriter) (Codec, error) {
+		if _, err := rw.Write([]byte("V2")); err != nil {
+			return nil, err
+		}
+		return NewTestCodec(rw)
+	}), 0)
+	utest.IsNilNow(t, err)
+	defer client2.Close()
+
+	utest.IsNilNow(t, client2.Send([]byte("hello")))
+	msg, err := client2.Receive()
+	utest.IsNilNow(t, err)
+	utest.EqualNow(t, string(msg.([]byte)), "v2:hello")
+
+	utest.IsNilNow(t, client1.Send([]byte("hello")))
+	msg, err = client1.Receive()
+	utest.IsNilNow(t, err)
+	utest.EqualNow(t, string(msg.([]byte)), "v1:hello")
+
+	// A session of another codec is closed instead of panicking.
+	session := NewSession(newBlockTestCodec(), 0)
+	mux.HandleSession(session)
+	utest.Assert(t, session.IsClosed())
+	utest.EqualNow(t, session.CloseError(), ErrUnknownVersion)
+}
+
+type frameTestCodec struct {
+	*TestCodec
+}
+
+func newFrameTestCodec(rw io.ReadWriter) (Codec, error) {
+	codec, _ := NewTestCodec(rw)
+	return frameTestCodec{codec.(*TestCodec)}, nil
+}
+
+func (c frameTestCodec) Send(msg interface{}) error {
+	frame := msg.(*GatewayFrame)
+	buf := make([]byte, 9)
+	binary.LittleEndian.PutUint64(buf, frame.Session)
+	if frame.Close {
+		buf[8] = 1
+	} else {
+		buf = append(buf, frame.Msg.([]byte)...)
+	}
+	return c.TestCodec.Send(buf)
+}
+
+func (c frameTestCodec) Receive() (interface{}, error) {
+	msg, err := c.TestCodec.Receive()
+	if err != nil {
+		return nil, err
+	}
+	buf := msg.([]byte)
+	return &GatewayFrame{
+		Session: binary.LittleEndian.Uint64(buf),
+		Msg:     buf[9:],
+		Close:   buf[8] == 1,
+	}, nil
+}
+
+func Test_Gateway(t *testing.T) {
+	backends := map[string]string{}
+	for _, name := range []string{"a", "b"} {
+		name := name
+		backend, err := Listen("tcp", "127.0.0.1:0", ProtocolFunc(newFrameTestCodec), 0, HandlerFunc(func(session *Session) {
+			for {
+				msg, err := session.Receive()
+				if err != nil {
+					return
+				}
+				frame := msg.(*GatewayFrame)
+				if frame.Close {
+					continue
+				}
+				if string(frame.Msg.([]byte)) == name+"quit" {
+					session.Send(&GatewayFrame{Session: frame.Session, Close: true})
+					continue
+				}
+				frame.Msg = append([]byte(name+":"), frame.Msg.([]byte)...)
+				session.Send(frame)
+			}
+		}))
+		utest.IsNilNow(t, err)
+		go backend.Serve()
+		defer backend.Stop()
+		backends[name] = backend.Listener().Addr().String()
+	}
+
+	gateway := NewGateway(ProtocolFunc(newFrameTestCodec), 2, func(msg interface{}) (string, error) {
+		return string(msg.([]byte)[:1]), nil
+	}, func(key string) (string, error) {
+		return backends[key], nil
+	})
+	defer gateway.Close()
+	server, err := Listen("tcp", "127.0.0.1:0", ProtocolFunc(NewTestCodec), 0, gateway)
+	utest.IsNilNow(t, err)
+	go server.Serve()
+	defer server.Stop()
+
+	client, err := Dial("tcp", server.Listener().Addr().String(), ProtocolFunc(NewTestCodec), 0)
+	utest.IsNilNow(t, err)
+	defer client.Close()
+	for _, text := range []string{"a1", "b2", "a3"} {
+		utest.IsNilNow(t, client.Send([]byte(text)))
+		msg, err := client.Receive()
+		utest.IsNilNow(t, err)
+		utest.EqualNow(t, string(msg.([]byte)), text[:1]+":"+text)
+	}
+
+	utest.IsNilNow(t, client.Send([]byte("bquit")))
+	_, err = client.Receive()
+	utest.NotNilNow(t, err)
+}
+
+func Test_Sniffer(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	utest.IsNilNow(t, err)
+	sniffer := NewSniffer(listener, time.Second)
+	httpListener := sniffer.Match(SniffHTTP...)
+	server := NewServer(sniffer.Match(), ProtocolFunc(NewTestCodec), 0, HandlerFunc(func(session *Session) {
+		for {
+			msg, err := session.Receive()
+			if err != nil {
+				return
+			}
+			session.Send(msg)
+		}
+	}))
+	go sniffer.Serve()
+	go server.Serve()
+	go http.Serve(httpListener, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	defer sniffer.Close()
+	addr := listener.Addr().String()
+
+	resp, err := http.Get("http://" + addr + "/health")
+	utest.IsNilNow(t, err)
+	body, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	utest.EqualNow(t, string(body), "ok")
+
+	session, err := Dial("tcp", addr, ProtocolFunc(NewTestCodec), 0)
+	utest.IsNilNow(t, err)
+	defer session.Close()
+	utest.IsNilNow(t, session.Send([]byte("GETX")))
+	msg, err := session.Receive()
+	utest.IsNilNow(t, err)
+	utest.EqualNow(t, string(msg.([]byte)), "GETX")
+
+	sniffer.Close()
+	utest.EqualNow(t, server.Serve(), io.EOF)
+}
+
+func Test_TenantServer(t *testing.T) {
+	echo := HandlerFunc(func(session *Session) {
+		for {
+			msg, err := session.Receive()
+			if err != nil {
+				return
+			}
+			session.Send(msg)
+		}
+	})
+	listener1, err := net.Listen("tcp", "127.0.0.1:0")
+	utest.IsNilNow(t, err)
+	listener2, err := net.Listen("tcp", "127.0.0.1:0")
+	utest.IsNilNow(t, err)
+	port := func(l net.Listener) int { return l.Addr().(*net.TCPAddr).Port }
+
+	server := NewTenantServer(ClassifyByPort(map[int]string{
+		port(listener1): "a",
+		port(listener2): "b",
+	}))
+	server.AddTenant(&Tenant{Name: "a", Protocol: ProtocolFunc(NewTestCodec), Handler: echo, MaxConns: 1})
+	server.AddTenant(&Tenant{Name: "b", Protocol: ProtocolFunc(NewTestCodec), Handler: echo, PacketRate: 100, PacketBurst: 1})
+	go server.Serve(listener1)
+	go server.Serve(listener2)
+	defer server.Stop()
+
+	a1, err := Dial("tcp", listener1.Addr().String(), ProtocolFunc(NewTestCodec), 0)
+	utest.IsNilNow(t, err)
+	defer a1.Close()
+	utest.IsNilNow(t, a1.Send([]byte("ping")))
+	_, err = a1.Receive()
+	utest.IsNilNow(t, err)
+
+	a2, err := Dial("tcp", listener1.Addr().String(), ProtocolFunc(NewTestCodec), 0)
+	utest.IsNilNow(t, err)
+	a2.Send([]byte("ping"))
+	_, err = a2.Receive()
+	utest.NotNilNow(t, err)
+	stats := server.Tenant("a").AcceptStats()
+	utest.EqualNow(t, stats.Accepted, uint64(1))
+	utest.EqualNow(t, stats.Rejected["limit"], uint64(1))
+
+	b, err := Dial("tcp", listener2.Addr().String(), ProtocolFunc(NewTestCodec), 0)
+	utest.IsNilNow(t, err)
+	defer b.Close()
+	begin := time.Now()
+	for i := 0; i < 5; i++ {
+		utest.IsNilNow(t, b.Send([]byte("ping")))
+		_, err = b.Receive()
+		utest.IsNilNow(t, err)
+	}
+	utest.Assert(t, time.Since(begin) >= 35*time.Millisecond)
+	utest.EqualNow(t, server.Tenant("b").Manager().Len(), 1)
+	utest.EqualNow(t, server.AcceptStats().Accepted, uint64(3))
+}
+
+func Test_TenantServerReplace(t *testing.T) {
+	echo := HandlerFunc(func(session *Session) {
+		for {
+			msg, err := session.Receive()
+			if err != nil {
+				return
+			}
+			session.Send(msg)
+		}
+	})
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	utest.IsNilNow(t, err)
+
+	var refuse int32
+	server := NewTenantServer(func(conn net.Conn) (string, net.Conn, error) {
+		if atomic.LoadInt32(&refuse) == 1 {
+			return "", nil, io.ErrUnexpectedEOF
+		}
+		return "a", conn, nil
+	})
+	server.AddTenant(&Tenant{Name: "a", Protocol: ProtocolFunc(NewTestCodec), Handler: echo})
+	go server.Serve(listener)
+
+	old, err := Dial("tcp", listener.Addr().String(), ProtocolFunc(NewTestCodec), 0)
+	utest.IsNilNow(t, err)
+	defer old.Close()
+	utest.IsNilNow(t, old.Send([]byte("ping")))
+	_, err = old.Receive()
+	utest.IsNilNow(t, err)
+	server.AddTenant(&Tenant{Name: "a", Protocol: ProtocolFunc(NewTestCodec), Handler: echo})
+
+	atomic.StoreInt32(&refuse, 1)
+	refused, err := Dial("tcp", listener.Addr().String(), ProtocolFunc(NewTestCodec), 0)
+	utest.IsNilNow(t, err)
+	defer refused.Close()
+	_, err = refused.Receive()
+	utest.NotNilNow(t, err)
+
+	server.Stop()
+	_, err = old.Receive()
+	utest.NotNilNow(t, err)
+}
+
+func Test_Rooms(t *testing.T) {
+	encodes := 0
+	rooms := NewRooms(func(msg interface{}) (interface{}, error) {
+		encodes++
+		return []byte(msg.(string)), nil
+	})
+	var codecs []*blockTestCodec
+	var sessions []*Session
+	for i := 0; i < 3; i++ {
+		codec := newBlockTestCodec()
+		close(codec.unblock)
+		codecs = append(codecs, codec)
+		sessions = append(sessions, NewSession(codec, 0))
+	}
+	rooms.Join("a", 0, sessions[0])
+	rooms.Join("a", 1, sessions[1])
+	rooms.Join("b", 2, sessions[2])
+	utest.EqualNow(t, rooms.Len(), 2)
+
+	n, err := rooms.Broadcast("a", "hello")
+	utest.IsNilNow(t, err)
+	utest.EqualNow(t, n, 2)
+	utest.EqualNow(t, encodes, 1)
+	utest.EqualNow(t, codecs[0].sent, []interface{}{[]byte("hello")})
+	utest.EqualNow(t, codecs[1].sent, []interface{}{[]byte("hello")})
+	utest.EqualNow(t, len(codecs[2].sent), 0)
+
+	utest.Assert(t, rooms.Leave("b", 2))
+	utest.Assert(t, !rooms.Leave("b", 2))
+	utest.Assert(t, rooms.Channel("b") == nil)
+
+	// Closed sessions leave, and the empty channel is removed.
+	sessions[0].Close()
+	sessions[1].Close()
+	for rooms.Len() > 0 {
+		time.Sleep(time.Millisecond)
+	}
+	n, err = rooms.Broadcast("a", "hello")
+	utest.IsNilNow(t, err)
+	utest.EqualNow(t, n, 0)
+}
+
+type relayTest []interface{}
+
+func (relay *relayTest) Publish(msg interface{}) error {
+	*relay = append(*relay, msg)
+	return nil
+}
+
+func Test_ChannelRelay(t *testing.T) {
+	codec := newBlockTestCodec()
+	close(codec.unblock)
+	channel := NewChannel()
+	channel.Put(1, NewSession(codec, 0))
+	channel.SetEncoder(func(msg interface{}) (interface{}, error) {
+		return []byte(msg.(string)), nil
+	})
+	var relay relayTest
+	channel.SetRelay(&relay)
+
+	n, err := channel.Broadcast("hello")
+	utest.IsNilNow(t, err)
+	utest.EqualNow(t, n, 1)
+	n, err = channel.BroadcastLocal("world")
+	utest.IsNilNow(t, err)
+	utest.EqualNow(t, n, 1)
+
+	// The relay gets the message before encoding.
+	utest.EqualNow(t, []interface{}(relay), []interface{}{"hello"})
+	utest.EqualNow(t, codec.sent, []interface{}{[]byte("hello"), []byte("world")})
+}
+
+func Test_ManagerRange(t *testing.T) {
+	manager := NewManager()
+	defer manager.Dispose()
+	var sessions []*Session
+	for i := 0; i < 10; i++ {
+		sessions = append(sessions, manager.NewSession(newBlockTestCodec(), 0))
+	}
+	utest.EqualNow(t, manager.Len(), 10)
+	utest.Assert(t, manager.GetSession(sessions[3].ID()) == sessions[3])
+	utest.Assert(t, sessions[3].ID() < sessions[4].ID())
+
+	// The callback can close and create sessions.
+	visited := 0
+	manager.Range(func(session *Session) bool {
+		visited++
+		session.Close()
+		manager.NewSession(newBlockTestCodec(), 0).Close()
+		return true
+	})
+	utest.Assert(t, visited >= 10)
+
+	for manager.Len() > 0 {
+		time.Sleep(time.Millisecond)
+	}
+	utest.Assert(t, manager.GetSession(sessions[3].ID()) == nil)
+
+	manager.NewSession(newBlockTestCodec(), 0)
+	manager.NewSession(newBlockTestCodec(), 0)
+	visited = 0
+	manager.Range(func(session *Session) bool {
+		visited++
+		return false
+	})
+	utest.EqualNow(t, visited, 1)
+}
+
+func Test_ManagerPutAfterDispose(t *testing.T) {
+	manager := NewManager()
+	manager.Dispose()
+
+	// The session is closed by putSession and never added, deleting it must
+	// not touch the dispose counter.
+	session := manager.NewSession(newBlockTestCodec(), 0)
+	utest.Assert(t, session.IsClosed())
+	time.Sleep(50 * time.Millisecond)
+	utest.EqualNow(t, manager.Len(), 0)
+
+	session = NewManager().NewSession(newBlockTestCodec(), 0)
+	session.Close()
+	session.Close()
+	time.Sleep(50 * time.Millisecond)
+	utest.EqualNow(t, session.manager.Len(), 0)
+	session.manager.Dispose()
+}
+
+func Test_MaxConns(t *testing.T) {
+	server, err := Listen("tcp", "127.0.0.1:0", ProtocolFunc(NewTestCodec), 0, HandlerFunc(func(session *Session) {
+		session.Receive()
+	}))
+	utest.IsNilNow(t, err)
+	go server.Serve()
+	defer server.Stop()
+	addr := server.Listener().Addr().String()
+	localhost := net.ParseIP("127.0.0.1")
+
+	server.SetMaxConns(0, 1, []byte("full"))
+	first, err := Dial("tcp", addr, ProtocolFunc(NewTestCodec), 0)
+	utest.IsNilNow(t, err)
+	for server.Manager().Len() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	utest.EqualNow(t, server.Conns(), 1)
+	utest.EqualNow(t, server.ConnsOf(localhost), 1)
+
+	second, err := Dial("tcp", addr, ProtocolFunc(NewTestCodec), 0)
+	utest.IsNilNow(t, err)
+	msg, err := second.Receive()
+	utest.IsNilNow(t, err)
+	utest.EqualNow(t, string(msg.([]byte)), "full")
+	second.Close()
+
+	// Closed sessions give their connections back.
+	first.Close()
+	for server.Conns() > 0 {
+		time.Sleep(time.Millisecond)
+	}
+	utest.EqualNow(t, server.ConnsOf(localhost), 0)
+
+	server.SetMaxConns(1, 0, nil)
+	first, err = Dial("tcp", addr, ProtocolFunc(NewTestCodec), 0)
+	utest.IsNilNow(t, err)
+	defer first.Close()
+	second, err = Dial("tcp", addr, ProtocolFunc(NewTestCodec), 0)
+	utest.IsNilNow(t, err)
+	_, err = second.Receive()
+	utest.NotNilNow(t, err)
+	utest.EqualNow(t, server.AcceptStats().Rejected["limit"], uint64(2))
+}
+
+func Test_AcceptFilter(t *testing.T) {
+	_, err := CIDRFilter([]string{"bad"}, nil)
+	utest.NotNilNow(t, err)
+
+	server, err := Listen("tcp", "127.0.0.1:0", ProtocolFunc(NewTestCodec), 0, HandlerFunc(func(session *Session) {
+		session.Send([]byte("welcome"))
+		session.Receive()
+	}))
+	utest.IsNilNow(t, err)
+	go server.Serve()
+	defer server.Stop()
+	addr := server.Listener().Addr().String()
+
+	for _, c := range []struct {
+		allow, deny []string
+		accepted    bool
+	}{
+		{nil, nil, true},
+		{[]string{"127.0.0.0/8"}, nil, true},
+		{[]string{"10.0.0.0/8"}, nil, false},
+		{nil, []string{"127.0.0.1"}, false},
+		{[]string{"127.0.0.0/8"}, []string{"127.0.0.1"}, false},
+	} {
+		filter, err := CIDRFilter(c.allow, c.deny)
+		utest.IsNilNow(t, err)
+		server.SetAcceptFilter(filter)
+		session, err := Dial("tcp", addr, ProtocolFunc(NewTestCodec), 0)
+		utest.IsNilNow(t, err)
+		_, err = session.Receive()
+		utest.EqualNow(t, err == nil, c.accepted)
+		session.Close()
+	}
+	utest.EqualNow(t, server.AcceptStats().Rejected["filtered"], uint64(3))
+}
+
+func Test_AcceptRate(t *testing.T) {
+	server, err := Listen("tcp", "127.0.0.1:0", ProtocolFunc(NewTestCodec), 0, HandlerFunc(func(session *Session) {
+		for {
+			if _, err := session.Receive(); err != nil {
+				return
+			}
+		}
+	}))
+	utest.IsNilNow(t, err)
+	go server.Serve()
+	defer server.Stop()
+	addr := server.Listener().Addr().String()
+
+	server.SetAcceptRate(10, 2, func(retryAfter time.Duration) interface{} {
+		return []byte("retry after " + retryAfter.Round(10*time.Millisecond).String())
+	})
+	var sessions []*Session
+	for i := 0; i < 4; i++ {
+		session, err := Dial("tcp", addr, ProtocolFunc(NewTestCodec), 0)
+		utest.IsNilNow(t, err)
+		sessions = append(sessions, session)
+	}
+	retries := 0
+	for _, session := range sessions {
+		session.SetReadTimeout(100 * time.Millisecond)
+		if msg, err := session.Receive(); err == nil {
+			utest.EqualNow(t, string(msg.([]byte)[:11]), "retry after")
+			retries++
+		}
+		session.Close()
+	}
+	utest.EqualNow(t, retries, 2)
+	utest.EqualNow(t, server.AcceptStats().Rejected["limit"], uint64(2))
+
+	server.SetAcceptRate(20, 1, nil)
+	begin := time.Now()
+	for i := 0; i < 3; i++ {
+		session, err := Dial("tcp", addr, ProtocolFunc(NewTestCodec), 0)
+		utest.IsNilNow(t, err)
+		session.Close()
+	}
+	for server.AcceptStats().Accepted < 4 {
+		time.Sleep(time.Millisecond)
+	}
+	utest.Assert(t, time.Since(begin) >= 50*time.Millisecond)
+}
+
+type blockTestCodec struct {
+	started chan struct{}
+	unblock chan struct{}
+	mutex   sync.Mutex
+	sent    []interface{}
+}
+
+func newBlockTestCodec() *blockTestCodec {
+	return &blockTestCodec{
+		started: make(chan struct{}, 100),
+		unblock: make(chan struct{}),
+	}
+}
+
+func (c *blockTestCodec) Receive() (interface{}, error) { select {} }
+func (c *blockTestCodec) Close() error                  { return nil }
+func (c *blockTestCodec) Send(msg interface{}) error {
+	c.started <- struct{}{}
+	<-c.unblock
+	c.mutex.Lock()
+	c.sent = append(c.sent, msg)
+	c.mutex.Unlock()
+	return nil
+}
+
+func Test_SendAsync(t *testing.T) {
+	codec := newBlockTestCodec()
+	session := NewSession(codec, 2)
+	first := session.SendAsync(1)
+	<-codec.started
+
+	// 1 is being written, 2 and 3 fill the channel, 4 overflows.
+	second := session.SendAsync(2)
+	third := session.SendAsync(3)
+	select {
+	case <-first.Done():
+		t.Fatal("completed before written")
+	default:
+	}
+	overflow := session.SendAsync(4)
+	utest.EqualNow(t, overflow.Err(), SessionBlockedError)
+	utest.EqualNow(t, second.Err(), SessionClosedError)
+	utest.EqualNow(t, third.Err(), SessionClosedError)
+
+	close(codec.unblock)
+	utest.IsNilNow(t, first.Err())
+	utest.EqualNow(t, session.SendAsync(5).Err(), SessionClosedError)
+}
+
+func Test_SendAsyncOrder(t *testing.T) {
+	codec := newBlockTestCodec()
+	close(codec.unblock)
+	session := NewSession(codec, 0)
+	var futures []*SendFuture
+	for i := 0; i < 100; i++ {
+		futures = append(futures, session.SendAsync(i))
+	}
+	for _, future := range futures {
+		utest.IsNilNow(t, future.Err())
+	}
+	for i, msg := range codec.sent {
+		utest.EqualNow(t, msg, i)
+	}
+	utest.EqualNow(t, len(codec.sent), 100)
+}
+
+func Test_SendPolicy(t *testing.T) {
+	// 1 is being written and 2, 3 fill the channel when 4 is sent.
+	fill := func(policy SendPolicy) (*Session, *blockTestCodec, *SendFuture) {
+		codec := newBlockTestCodec()
+		session := NewSession(codec, 2)
+		session.SetSendPolicy(policy)
+		utest.IsNilNow(t, session.Send(1))
+		<-codec.started
+		second := session.SendAsync(2)
+		utest.IsNilNow(t, session.Send(3))
+		return session, codec, second
+	}
+	sent := func(session *Session, codec *blockTestCodec, n uint64) []interface{} {
+		for session.SendPackets() < n {
+			time.Sleep(time.Millisecond)
+		}
+		codec.mutex.Lock()
+		defer codec.mutex.Unlock()
+		return codec.sent
+	}
+
+	session, codec, _ := fill(DropNewest)
+	utest.EqualNow(t, session.Send(4), MessageDroppedError)
+	utest.Assert(t, !session.IsClosed())
+	close(codec.unblock)
+	utest.EqualNow(t, sent(session, codec, 3), []interface{}{1, 2, 3})
+	session.Close()
+
+	session, codec, second := fill(DropOldest)
+	utest.IsNilNow(t, session.Send(4))
+	utest.EqualNow(t, second.Err(), MessageDroppedError)
+	close(codec.unblock)
+	utest.EqualNow(t, sent(session, codec, 3), []interface{}{1, 3, 4})
+	session.Close()
+
+	session, codec, _ = fill(BlockWhenFull)
+	done := make(chan error, 1)
+	go func() { done <- session.Send(4) }()
+	select {
+	case <-done:
+		t.Fatal("returned before room")
+	case <-time.After(20 * time.Millisecond):
+	}
+	close(codec.unblock)
+	utest.IsNilNow(t, <-done)
+	utest.EqualNow(t, sent(session, codec, 4), []interface{}{1, 2, 3, 4})
+	session.Close()
+}
+
+func Test_Heartbeat(t *testing.T) {
+	isMsg := func(text string) func(interface{}) bool {
+		return func(msg interface{}) bool { return string(msg.([]byte)) == text }
+	}
+	var idles int32
+	timeouts := make(chan *Session, 2)
+	server, err := Listen("tcp", "127.0.0.1:0", ProtocolFunc(NewTestCodec), 0, HandlerFunc(func(session *Session) {
+		for {
+			if _, err := session.Receive(); err != nil {
+				return
+			}
+		}
+	}))
+	utest.IsNilNow(t, err)
+	server.SetHeartbeat(&Heartbeat{
+		Idle:      20 * time.Millisecond,
+		MaxMissed: 2,
+		Ping:      []byte("ping"),
+		IsPong:    isMsg("pong"),
+		OnIdle:    func(*Session) { atomic.AddInt32(&idles, 1) },
+		OnTimeout: func(session *Session) { timeouts <- session },
+	})
+	go server.Serve()
+	defer server.Stop()
+	addr := server.Listener().Addr().String()
+
+	// A client answering the pings stays, and never receives them.
+	client, err := Dial("tcp", addr, ProtocolFunc(NewTestCodec), 0)
+	utest.IsNilNow(t, err)
+	client.StartHeartbeat(&Heartbeat{Idle: time.Hour, IsPing: isMsg("ping"), Pong: []byte("pong")})
+	received := make(chan interface{}, 1)
+	go func() {
+		msg, _ := client.Receive()
+		received <- msg
+	}()
+	time.Sleep(150 * time.Millisecond)
+	utest.Assert(t, atomic.LoadInt32(&idles) > 0)
+	utest.EqualNow(t, server.Manager().Len(), 1)
+	// The pongs are round trip samples.
+	server.Manager().Fetch(func(session *Session) {
+		utest.Assert(t, session.Quality().RTT > 0)
+	})
+	select {
+	case msg := <-received:
+		t.Fatalf("ping received: %v", msg)
+	case <-timeouts:
+		t.Fatal("answering client timed out")
+	default:
+	}
+	client.Close()
+
+	// A client not answering is closed.
+	client, err = Dial("tcp", addr, ProtocolFunc(NewTestCodec), 0)
+	utest.IsNilNow(t, err)
+	defer client.Close()
+	select {
+	case session := <-timeouts:
+		for !session.IsClosed() {
+			time.Sleep(time.Millisecond)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("silent client not timed out")
+	}
+}
+
+func Test_PacketTimeout(t *testing.T) {
+	conn1, conn2 := net.Pipe()
+	codec1, _ := NewTestCodec(conn1)
+	codec2, _ := NewTestCodec(conn2)
+	session1 := newConnSession(nil, conn1, codec1, 0)
+	session2 := newConnSession(nil, conn2, codec2, 0)
+	defer session2.Close()
+
+	go session2.Send([]byte("hello"))
+	msg, err := session1.ReceiveTimeout(time.Second)
+	utest.IsNilNow(t, err)
+	utest.EqualNow(t, string(msg.([]byte)), "hello")
+
+	// The limit was given back to no limit.
+	time.Sleep(30 * time.Millisecond)
+	go session2.Send([]byte("world"))
+	msg, err = session1.Receive()
+	utest.IsNilNow(t, err)
+	utest.EqualNow(t, string(msg.([]byte)), "world")
+
+	_, err = session1.ReceiveTimeout(20 * time.Millisecond)
+	ne, ok := err.(net.Error)
+	utest.Assert(t, ok && ne.Timeout())
+	utest.Assert(t, session1.IsClosed())
+
+	conn1, conn2 = net.Pipe()
+	defer conn2.Close()
+	codec1, _ = NewTestCodec(conn1)
+	session1 = newConnSession(nil, conn1, codec1, 10)
+	utest.IsNilNow(t, session1.SendTimeout([]byte("stuck"), 20*time.Millisecond))
+	for !session1.IsClosed() {
+		time.Sleep(time.Millisecond)
+	}
+	utest.EqualNow(t, session1.SendPackets(), uint64(0))
+}
+
+func Test_Context(t *testing.T) {
+	server, err := Listen("tcp", "127.0.0.1:0", ProtocolFunc(NewTestCodec), 0, HandlerFunc(func(session *Session) {
+		session.Receive()
+	}))
+	utest.IsNilNow(t, err)
+	addr := server.Listener().Addr().String()
+	ctx, cancel := context.WithCancel(context.Background())
+	served := make(chan error, 1)
+	go func() { served <- server.ServeContext(ctx) }()
+
+	session, err := DialContext(context.Background(), "tcp", addr, ProtocolFunc(NewTestCodec), 0)
+	utest.IsNilNow(t, err)
+	recvCtx, recvCancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer recvCancel()
+	_, err = session.ReceiveContext(recvCtx)
+	utest.EqualNow(t, err, context.DeadlineExceeded)
+	utest.Assert(t, session.IsClosed())
+
+	cancel()
+	utest.EqualNow(t, <-served, context.Canceled)
+	_, err = DialContext(ctx, "tcp", addr, ProtocolFunc(NewTestCodec), 0)
+	utest.NotNilNow(t, err)
+
+	// A message still queued when cancelled is not written.
+	codec := newBlockTestCodec()
+	session = NewSession(codec, 2)
+	utest.IsNilNow(t, session.Send(1))
+	<-codec.started
+	sendCtx, sendCancel := context.WithCancel(context.Background())
+	sent := make(chan error, 1)
+	go func() { sent <- session.SendContext(sendCtx, 2) }()
+	sendCancel()
+	utest.EqualNow(t, <-sent, context.Canceled)
+	third := session.SendAsync(3)
+	close(codec.unblock)
+	utest.IsNilNow(t, third.Err())
+	codec.mutex.Lock()
+	utest.EqualNow(t, codec.sent, []interface{}{1, 3})
+	codec.mutex.Unlock()
+	session.Close()
+}
+
+func Test_SendCancel(t *testing.T) {
+	// Waiting for room in a full send channel is limited.
+	codec := newBlockTestCodec()
+	session := NewSession(codec, 1)
+	session.SetSendPolicy(BlockWhenFull)
+	utest.IsNilNow(t, session.Send(1))
+	<-codec.started
+	utest.IsNilNow(t, session.Send(2))
+	utest.EqualNow(t, session.SendTimeout(3, 20*time.Millisecond), MessageDroppedError)
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	utest.EqualNow(t, session.SendContext(ctx, 4), context.DeadlineExceeded)
+	cancel()
+	close(codec.unblock)
+	utest.IsNilNow(t, session.SendAsync(5).Err())
+	codec.mutex.Lock()
+	utest.EqualNow(t, codec.sent, []interface{}{1, 2, 5})
+	codec.mutex.Unlock()
+	session.Close()
+
+	// A write to a peer not reading is interrupted.
+	for _, sendChanSize := range []int{0, 10} {
+		conn1, conn2 := net.Pipe()
+		codec1, _ := NewTestCodec(conn1)
+		session = newConnSession(nil, conn1, codec1, sendChanSize)
+		ctx, cancel = context.WithTimeout(context.Background(), 20*time.Millisecond)
+		utest.EqualNow(t, session.SendContext(ctx, []byte("stuck")), context.DeadlineExceeded)
+		cancel()
+		for !session.IsClosed() {
+			time.Sleep(time.Millisecond)
+		}
+		utest.EqualNow(t, session.SendPackets(), uint64(0))
+		conn2.Close()
+	}
+}
+
+func Test_Redialer(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	utest.IsNilNow(t, err)
+	addr := listener.Addr().String()
+	listener.Close()
+
+	var stateMutex sync.Mutex
+	var states []RedialState
+	var handshakes int32
+	received := make(chan string, 10)
+	redialer := AutoRedial(func() (*Session, error) {
+		return DialTimeout("tcp", addr, time.Second, ProtocolFunc(NewTestCodec), 0)
+	}, HandlerFunc(func(session *Session) {
+		for {
+			msg, err := session.Receive()
+			if err != nil {
+				return
+			}
+			received <- string(msg.([]byte))
+		}
+	}))
+	redialer.SetBackoff(5*time.Millisecond, 20*time.Millisecond)
+	redialer.SetHandshake(func(session *Session) error {
+		atomic.AddInt32(&handshakes, 1)
+		return nil
+	})
+	redialer.SetStateCallback(func(state RedialState, err error) {
+		stateMutex.Lock()
+		states = append(states, state)
+		stateMutex.Unlock()
+	})
+	utest.EqualNow(t, redialer.Send([]byte("early")), ErrNotConnected)
+	redialer.Start()
+
+	// The server comes up late and closes each session after a message.
+	time.Sleep(30 * time.Millisecond)
+	server, err := Listen("tcp", addr, ProtocolFunc(NewTestCodec), 0, HandlerFunc(func(session *Session) {
+		session.Send([]byte("hi"))
+		session.Close()
+	}))
+	utest.IsNilNow(t, err)
+	go server.Serve()
+	defer server.Stop()
+
+	for i := 0; i < 3; i++ {
+		select {
+		case msg := <-received:
+			utest.EqualNow(t, msg, "hi")
+		case <-time.After(time.Second):
+			t.Fatal("not redialed")
+		}
+	}
+	redialer.Close()
+	utest.Assert(t, atomic.LoadInt32(&handshakes) >= 3)
+	_, err = redialer.Wait(context.Background())
+	utest.EqualNow(t, err, SessionClosedError)
+
+	stateMutex.Lock()
+	defer stateMutex.Unlock()
+	utest.EqualNow(t, states[0], RedialConnecting)
+	utest.EqualNow(t, states[1], RedialDisconnected)
+	utest.EqualNow(t, states[len(states)-1], RedialClosed)
+}
+
+func Test_RedialerFlapping(t *testing.T) {
+	server, err := Listen("tcp", "127.0.0.1:0", ProtocolFunc(NewTestCodec), 0, HandlerFunc(func(session *Session) {
+		session.Close()
+	}))
+	utest.IsNilNow(t, err)
+	go server.Serve()
+	defer server.Stop()
+	addr := server.Listener().Addr().String()
+
+	connects := make(chan time.Time, 100)
+	redialer := AutoRedial(func() (*Session, error) {
+		return DialTimeout("tcp", addr, time.Second, ProtocolFunc(NewTestCodec), 0)
+	}, HandlerFunc(func(session *Session) {
+		session.Receive()
+	}))
+	redialer.SetBackoff(5*time.Millisecond, 100*time.Millisecond)
+	redialer.SetStateCallback(func(state RedialState, err error) {
+		if state == RedialConnected {
+			connects <- time.Now()
+		}
+	})
+	redialer.Start()
+	defer redialer.Close()
+
+	// The sessions closed at once don't reset the backoff.
+	var last, gap time.Duration
+	begin := time.Now()
+	for i := 0; i < 8; i++ {
+		select {
+		case at := <-connects:
+			gap, last = at.Sub(begin)-last, at.Sub(begin)
+		case <-time.After(time.Second):
+			t.Fatal("not redialed")
+		}
+	}
+	utest.Assert(t, gap >= 50*time.Millisecond, gap)
+}
+
+func Test_Pool(t *testing.T) {
+	dials := 0
+	pool := NewPool(func() (*Session, error) {
+		dials++
+		return NewSession(newBlockTestCodec(), 0), nil
+	}, 2)
+	ctx := context.Background()
+
+	a, err := pool.Get(ctx)
+	utest.IsNilNow(t, err)
+	b, err := pool.Get(ctx)
+	utest.IsNilNow(t, err)
+	timeout, cancel := context.WithTimeout(ctx, 20*time.Millisecond)
+	defer cancel()
+	_, err = pool.Get(timeout)
+	utest.EqualNow(t, err, context.DeadlineExceeded)
+
+	pool.Put(a)
+	reused, err := pool.Get(ctx)
+	utest.IsNilNow(t, err)
+	utest.Assert(t, reused == a)
+	utest.EqualNow(t, dials, 2)
+
+	// Closed and unhealthy sessions are replaced.
+	b.Close()
+	pool.Put(b)
+	pool.Put(a)
+	utest.EqualNow(t, pool.Idle(), 1)
+	pool.SetHealthCheck(func(*Session) error { return io.EOF })
+	fresh, err := pool.Get(ctx)
+	utest.IsNilNow(t, err)
+	utest.Assert(t, fresh != a && a.IsClosed())
+	utest.EqualNow(t, dials, 3)
+	pool.Put(fresh)
+	pool.SetHealthCheck(nil)
+
+	utest.EqualNow(t, pool.Do(ctx, func(session *Session) error { return io.EOF }), io.EOF)
+	utest.Assert(t, fresh.IsClosed())
+	utest.EqualNow(t, pool.Idle(), 0)
+
+	pool.Close()
+	_, err = pool.Get(ctx)
+	utest.EqualNow(t, err, ErrPoolClosed)
+}
+
+func Test_Middleware(t *testing.T) {
+	var order []string
+	tag := func(name string) Middleware {
+		return func(next MessageFunc) MessageFunc {
+			return func(msg interface{}) (interface{}, error) {
+				order = append(order, name)
+				return next(msg)
+			}
+		}
+	}
+	upper := func(next MessageFunc) MessageFunc {
+		return func(msg interface{}) (interface{}, error) {
+			return next(bytes.ToUpper(msg.([]byte)))
+		}
+	}
+	dropEmpty := func(next MessageFunc) MessageFunc {
+		return func(msg interface{}) (interface{}, error) {
+			if len(msg.([]byte)) == 0 {
+				return nil, nil
+			}
+			return next(msg)
+		}
+	}
+	rejectBad := func(next MessageFunc) MessageFunc {
+		return func(msg interface{}) (interface{}, error) {
+			if string(msg.([]byte)) == "bad" {
+				return nil, io.ErrUnexpectedEOF
+			}
+			return next(msg)
+		}
+	}
+
+	server, err := Listen("tcp", "127.0.0.1:0", ProtocolFunc(NewTestCodec), 0, HandlerFunc(func(session *Session) {
+		for {
+			msg, err := session.Receive()
+			if err != nil {
+				return
+			}
+			session.Send(msg)
+		}
+	}))
+	utest.IsNilNow(t, err)
+	server.UseRecv(dropEmpty, upper)
+	go server.Serve()
+	defer server.Stop()
+
+	session, err := Dial("tcp", server.Listener().Addr().String(), ProtocolFunc(NewTestCodec), 0)
+	utest.IsNilNow(t, err)
+	defer session.Close()
+	session.UseSend(tag("a"), rejectBad)
+	session.UseSend(tag("b"))
+	session.UseRecv(tag("recv"))
+
+	utest.EqualNow(t, session.Send([]byte("bad")), io.ErrUnexpectedEOF)
+	utest.Assert(t, !session.IsClosed())
+	utest.IsNilNow(t, session.Send([]byte{}))
+	utest.IsNilNow(t, session.Send([]byte("hello")))
+	msg, err := session.Receive()
+	utest.IsNilNow(t, err)
+	utest.EqualNow(t, string(msg.([]byte)), "HELLO")
+	utest.EqualNow(t, order, []string{"a", "a", "b", "a", "b", "recv"})
+}
+
+func Test_RateLimit(t *testing.T) {
+	received := make(chan string, 10)
+	server, err := Listen("tcp", "127.0.0.1:0", ProtocolFunc(NewTestCodec), 0, HandlerFunc(func(session *Session) {
+		for {
+			msg, err := session.Receive()
+			if err != nil {
+				received <- err.Error()
+				return
+			}
+			received <- string(msg.([]byte))
+		}
+	}))
+	utest.IsNilNow(t, err)
+	server.SetRecvRateLimit(1, 2, nil, LimitClose)
+	go server.Serve()
+	defer server.Stop()
+
+	session, err := Dial("tcp", server.Listener().Addr().String(), ProtocolFunc(NewTestCodec), 0)
+	utest.IsNilNow(t, err)
+	defer session.Close()
+	for _, msg := range []string{"1", "2", "3"} {
+		session.Send([]byte(msg))
+	}
+	utest.EqualNow(t, <-received, "1")
+	utest.EqualNow(t, <-received, "2")
+	utest.EqualNow(t, <-received, ErrRateLimited.Error())
+
+	// Dropped over the limit of bytes, the bucket is shared.
+	bucket := NewTokenBucket(1, 10)
+	size := func(msg interface{}) int { return len(msg.([]byte)) }
+	var sent [][]byte
+	for i := 0; i < 2; i++ {
+		codec := newBlockTestCodec()
+		close(codec.unblock)
+		s := NewSession(codec, 0)
+		s.UseSend(RateLimit(bucket, size, LimitDrop))
+		utest.IsNilNow(t, s.Send([]byte("123456")))
+		for _, msg := range codec.sent {
+			sent = append(sent, msg.([]byte))
+		}
+	}
+	utest.EqualNow(t, len(sent), 1)
+
+	begin := time.Now()
+	delay := RateLimit(NewTokenBucket(100, 1), nil, LimitDelay)(func(msg interface{}) (interface{}, error) {
+		return msg, nil
+	})
+	for i := 0; i < 3; i++ {
+		delay(i)
+	}
+	utest.Assert(t, time.Since(begin) >= 15*time.Millisecond)
+}
+
+func Test_Authenticator(t *testing.T) {
+	key := []byte("shared key")
+	var handled int32
+	server, err := Listen("tcp", "127.0.0.1:0", ProtocolFunc(NewTestCodec), 0, HandlerFunc(func(session *Session) {
+		atomic.AddInt32(&handled, 1)
+		session.Send([]byte("welcome"))
+		session.Close()
+	}))
+	utest.IsNilNow(t, err)
+	server.SetAuthenticator(ChallengeAuth(sha256.New, key), time.Second)
+	go server.Serve()
+	defer server.Stop()
+	addr := server.Listener().Addr().String()
+
+	session, err := Dial("tcp", addr, ProtocolFunc(NewTestCodec), 0)
+	utest.IsNilNow(t, err)
+	utest.IsNilNow(t, Handshake(session, ChallengeResponse(sha256.New, key), time.Second))
+	msg, err := session.Receive()
+	utest.IsNilNow(t, err)
+	utest.EqualNow(t, string(msg.([]byte)), "welcome")
+	session.Close()
+
+	// A wrong key, and a client not answering in time, are refused.
+	session, err = Dial("tcp", addr, ProtocolFunc(NewTestCodec), 0)
+	utest.IsNilNow(t, err)
+	utest.IsNilNow(t, Handshake(session, ChallengeResponse(sha256.New, []byte("wrong key")), time.Second))
+	_, err = session.Receive()
+	utest.NotNilNow(t, err)
+
+	server.SetAuthenticator(TokenAuth(func(session *Session, msg interface{}) error {
+		return nil
+	}), 50*time.Millisecond)
+	session, err = Dial("tcp", addr, ProtocolFunc(NewTestCodec), 0)
+	utest.IsNilNow(t, err)
+	_, err = session.Receive()
+	utest.NotNilNow(t, err)
+
+	// Receive closes the session on the timeout before it is counted.
+	for i := 0; i < 100 && server.AcceptStats().Rejected["auth"] < 2; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	utest.EqualNow(t, atomic.LoadInt32(&handled), int32(1))
+	utest.EqualNow(t, server.AcceptStats().Rejected["auth"], uint64(2))
+}
+
+// xorTestCodec is TestCodec with the bytes of messages flipped.
+type xorTestCodec struct {
+	Codec
+}
+
+func newXorTestCodec(rw io.ReadWriter) (Codec, error) {
+	codec, _ := NewTestCodec(rw)
+	return xorTestCodec{codec}, nil
+}
+
+func xorBytes(b []byte) []byte {
+	x := make([]byte, len(b))
+	for i := range b {
+		x[i] = b[i] ^ 0xff
+	}
+	return x
+}
+
+func (c xorTestCodec) Send(msg interface{}) error {
+	return c.Codec.Send(xorBytes(msg.([]byte)))
+}
+
+func (c xorTestCodec) Receive() (interface{}, error) {
+	msg, err := c.Codec.Receive()
+	if err != nil {
+		return nil, err
+	}
+	return xorBytes(msg.([]byte)), nil
+}
+
+// releaseTestCodec counts the releases of a codec replaced by SetProtocol.
+type releaseTestCodec struct {
+	Codec
+	released *int32
+}
+
+func (c releaseTestCodec) Release() {
+	atomic.AddInt32(c.released, 1)
+}
+
+func Test_SetProtocol(t *testing.T) {
+	for _, sendChanSize := range []int{0, 10} {
+		server, err := Listen("tcp", "127.0.0.1:0", ProtocolFunc(NewTestCodec), sendChanSize, HandlerFunc(func(session *Session) {
+			for {
+				msg, err := session.Receive()
+				if err != nil {
+					return
+				}
+				session.Send(msg)
+				if string(msg.([]byte)) == "upgrade" {
+					session.SetProtocol(ProtocolFunc(newXorTestCodec))
+				}
+			}
+		}))
+		utest.IsNilNow(t, err)
+		go server.Serve()
+
+		var released int32
+		session, err := Dial("tcp", server.Listener().Addr().String(), ProtocolFunc(func(rw io.ReadWriter) (Codec, error) {
+			codec, err := NewTestCodec(rw)
+			return releaseTestCodec{codec, &released}, err
+		}), sendChanSize)
+		utest.IsNilNow(t, err)
+		utest.IsNilNow(t, session.Send([]byte("upgrade")))
+		msg, err := session.Receive()
+		utest.IsNilNow(t, err)
+		utest.EqualNow(t, string(msg.([]byte)), "upgrade")
+		utest.IsNilNow(t, session.SetProtocol(ProtocolFunc(newXorTestCodec)))
+
+		utest.IsNilNow(t, session.Send([]byte("hello")))
+		msg, err = session.Receive()
+		utest.IsNilNow(t, err)
+		utest.EqualNow(t, string(msg.([]byte)), "hello")
+		utest.Assert(t, session.Codec().(xorTestCodec).Codec != nil)
+		utest.EqualNow(t, atomic.LoadInt32(&released), int32(1))
+		session.Close()
+		server.Stop()
+	}
+
+	utest.EqualNow(t, NewSession(newBlockTestCodec(), 0).SetProtocol(ProtocolFunc(NewTestCodec)), ErrNoConn)
+}
+
+func Test_ProxyProtocol(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	utest.IsNilNow(t, err)
+	server := NewServer(ProxyListener(listener, 100*time.Millisecond), ProtocolFunc(NewTestCodec), 0, HandlerFunc(func(session *Session) {
+		session.Send([]byte(session.RemoteAddr().String()))
+		session.Receive()
+	}))
+	go server.Serve()
+	defer server.Stop()
+	addr := listener.Addr().String()
+
+	v2 := append([]byte(nil), proxyV2Signature...)
+	v2 = append(v2, 0x21, 0x11, 0, 12, 10, 1, 2, 3, 127, 0, 0, 1, 0x30, 0x39, 0x1f, 0x90)
+	v2Local := append([]byte(nil), proxyV2Signature...)
+	v2Local = append(v2Local, 0x20, 0, 0, 0)
+
+	for _, c := range []struct {
+		header string
+		remote string
+	}{
+		{"PROXY TCP4 10.1.2.3 127.0.0.1 12345 8080\r\n", "10.1.2.3:12345"},
+		{"PROXY TCP6 2001:db8::1 ::1 12345 8080\r\n", "[2001:db8::1]:12345"},
+		{string(v2), "10.1.2.3:12345"},
+		{"PROXY UNKNOWN\r\n", ""},
+		{string(v2Local), ""},
+		{"PROXY TCP4 bad 127.0.0.1 1 2\r\n", "closed"},
+		{"GET / HTTP/1.1\r\n", "closed"},
+		{"", "closed"},
+	} {
+		conn, err := net.Dial("tcp", addr)
+		utest.IsNilNow(t, err)
+		_, err = conn.Write([]byte(c.header))
+		utest.IsNilNow(t, err)
+		if c.remote == "" {
+			c.remote = conn.LocalAddr().String()
+		}
+		codec, _ := NewTestCodec(conn)
+		session := NewSession(codec, 0)
+		msg, err := session.Receive()
+		if c.remote == "closed" {
+			utest.NotNilNow(t, err)
+		} else {
+			utest.IsNilNow(t, err)
+			utest.EqualNow(t, string(msg.([]byte)), c.remote)
+		}
+		session.Close()
+	}
+}
+
+func Test_ProxyTransport(t *testing.T) {
+	_, err := ProxyTransport("ftp://127.0.0.1:21")
+	utest.EqualNow(t, err, ErrUnknownProxy)
+
+	server, err := Listen("tcp", "127.0.0.1:0", ProtocolFunc(NewTestCodec), 0, HandlerFunc(func(session *Session) {
+		session.Send([]byte("welcome"))
+		for {
+			msg, err := session.Receive()
+			if err != nil {
+				return
+			}
+			session.Send(msg)
+		}
+	}))
+	utest.IsNilNow(t, err)
+	go server.Serve()
+	defer server.Stop()
+	addr := server.Listener().Addr().String()
+
+	tunnel := func(client net.Conn, address string) {
+		backend, err := net.Dial("tcp", address)
+		if err != nil {
+			client.Close()
+			return
+		}
+		go func() {
+			io.Copy(backend, client)
+			backend.Close()
+		}()
+		io.Copy(client, backend)
+		client.Close()
+	}
+
+	// A SOCKS5 proxy of IPv4 addresses, with the user "link" and "secret".
+	socks, err := net.Listen("tcp", "127.0.0.1:0")
+	utest.IsNilNow(t, err)
+	defer socks.Close()
+	go func() {
+		for {
+			conn, err := socks.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				var b [10]byte
+				io.ReadFull(conn, b[:3])
+				if b[2] != 2 {
+					conn.Write([]byte{5, 0xff})
+					conn.Close()
+					return
+				}
+				conn.Write([]byte{5, 2})
+				io.ReadFull(conn, b[:2])
+				user := make([]byte, b[1])
+				io.ReadFull(conn, user)
+				io.ReadFull(conn, b[:1])
+				password := make([]byte, b[0])
+				io.ReadFull(conn, password)
+				if string(user) != "link" || string(password) != "secret" {
+					conn.Write([]byte{1, 1})
+					conn.Close()
+					return
+				}
+				conn.Write([]byte{1, 0})
+				io.ReadFull(conn, b[:10])
+				conn.Write([]byte{5, 0, 0, 1, 0, 0, 0, 0, 0, 0})
+				ip := net.IP(b[4:8])
+				tunnel(conn, net.JoinHostPort(ip.String(), strconv.Itoa(int(binary.BigEndian.Uint16(b[8:])))))
+			}()
+		}
+	}()
+
+	connect := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "CONNECT" || r.Header.Get("Proxy-Authorization") != "Basic bGluazpzZWNyZXQ=" {
+			w.WriteHeader(http.StatusProxyAuthRequired)
+			return
+		}
+		conn, rw, _ := w.(http.Hijacker).Hijack()
+		rw.WriteString("HTTP/1.1 200 Connection Established\r\n\r\n")
+		rw.Flush()
+		tunnel(conn, r.Host)
+	})}
+	connectListener, err := net.Listen("tcp", "127.0.0.1:0")
+	utest.IsNilNow(t, err)
+	go connect.Serve(connectListener)
+	defer connect.Close()
+
+	for _, c := range []struct {
+		url string
+		ok  bool
+	}{
+		{"socks5://link:secret@" + socks.Addr().String(), true},
+		{"socks5://link:wrong@" + socks.Addr().String(), false},
+		{"socks5://" + socks.Addr().String(), false},
+		{"http://link:secret@" + connectListener.Addr().String(), true},
+		{"http://" + connectListener.Addr().String(), false},
+	} {
+		transport, err := ProxyTransport(c.url)
+		utest.IsNilNow(t, err)
+		session, err := DialTransport(transport, addr, time.Second, ProtocolFunc(NewTestCodec), 0)
+		if !c.ok {
+			utest.EqualNow(t, err, ErrProxyRefused)
+			continue
+		}
+		utest.IsNilNow(t, err)
+		msg, err := session.Receive()
+		utest.IsNilNow(t, err)
+		utest.EqualNow(t, string(msg.([]byte)), "welcome")
+		utest.IsNilNow(t, session.Send([]byte("hello")))
+		msg, err = session.Receive()
+		utest.IsNilNow(t, err)
+		utest.EqualNow(t, string(msg.([]byte)), "hello")
+		session.Close()
+	}
+}
+
+func Test_SessionHooks(t *testing.T) {
+	errBad := errors.New("bad message")
+	server, err := Listen("tcp", "127.0.0.1:0", ProtocolFunc(NewTestCodec), 0, HandlerFunc(func(session *Session) {
+		for {
+			msg, err := session.Receive()
+			if err != nil {
+				return
+			}
+			if string(msg.([]byte)) == "bye" {
+				session.Close()
+				return
+			}
+		}
+	}))
+	utest.IsNilNow(t, err)
+	go server.Serve()
+	defer server.Stop()
+	addr := server.Listener().Addr().String()
+
+	server.UseRecv(func(next MessageFunc) MessageFunc {
+		return func(msg interface{}) (interface{}, error) {
+			if string(msg.([]byte)) == "bad" {
+				return nil, errBad
+			}
+			return next(msg)
+		}
+	})
+	var connects int32
+	errs := make(chan error, 10)
+	closes := make(chan error, 10)
+	server.SetHooks(&SessionHooks{
+		OnConnect: func(session *Session) {
+			atomic.AddInt32(&connects, 1)
+		},
+		OnClose: func(session *Session, reason error) {
+			utest.Equal(t, session.CloseError(), reason)
+			closes <- reason
+		},
+		OnError: func(session *Session, err error) {
+			errs <- err
+		},
+	})
+
+	for _, c := range []struct {
+		msg    string
+		reason error
+	}{
+		{"bad", errBad},
+		{"bye", nil},
+		{"", io.EOF},
+	} {
+		session, err := Dial("tcp", addr, ProtocolFunc(NewTestCodec), 0)
+		utest.IsNilNow(t, err)
+		if c.msg != "" {
+			utest.IsNilNow(t, session.Send([]byte(c.msg)))
+		}
+		session.Close()
+		select {
+		case reason := <-closes:
+			utest.EqualNow(t, reason, c.reason)
+		case <-time.After(time.Second):
+			t.Fatal("session not closed")
+		}
+	}
+	utest.EqualNow(t, len(errs), 1)
+	utest.EqualNow(t, <-errs, errBad)
+	utest.EqualNow(t, atomic.LoadInt32(&connects), int32(3))
+
+	// A session closed by Close has no close error.
+	session := NewSession(newBlockTestCodec(), 0)
+	session.Close()
+	utest.IsNilNow(t, session.CloseError())
+}
+
+func Test_Pipe(t *testing.T) {
+	session1, session2, err := Pipe(ProtocolFunc(NewTestCodec), 1)
+	utest.IsNilNow(t, err)
+	defer session1.Close()
+
+	utest.IsNilNow(t, session1.Send([]byte("ping")))
+	utest.IsNilNow(t, session2.Send([]byte("pong")))
+	msg, err := session2.Receive()
+	utest.IsNilNow(t, err)
+	utest.EqualNow(t, string(msg.([]byte)), "ping")
+	msg, err = session1.Receive()
+	utest.IsNilNow(t, err)
+	utest.EqualNow(t, string(msg.([]byte)), "pong")
+	utest.EqualNow(t, session1.RemoteAddr().Network(), "pipe")
+
+	_, err = session1.ReceiveTimeout(10 * time.Millisecond)
+	utest.NotNilNow(t, err)
+	_, err = session2.Receive()
+	utest.NotNilNow(t, err)
+}
+
+type flushTestCodec struct {
+	*blockTestCodec
+}
+
+func (c flushTestCodec) Flush() error {
+	c.mutex.Lock()
+	c.sent = append(c.sent, "flush")
+	c.mutex.Unlock()
+	return nil
+}
+
+func Test_SessionFlush(t *testing.T) {
+	for _, sendChanSize := range []int{0, 10} {
+		codec := flushTestCodec{newBlockTestCodec()}
+		close(codec.unblock)
+		session := NewSession(codec, sendChanSize)
+		utest.IsNilNow(t, session.Send(1))
+		utest.IsNilNow(t, session.Send(2))
+		utest.IsNilNow(t, session.Flush())
+		codec.mutex.Lock()
+		utest.EqualNow(t, len(codec.sent), 3)
+		utest.EqualNow(t, codec.sent[2], "flush")
+		codec.mutex.Unlock()
+		utest.EqualNow(t, session.SendPackets(), uint64(2))
+		session.Close()
+		utest.EqualNow(t, session.Flush(), SessionClosedError)
+	}
+
+	// Codecs not Flusher have nothing to flush.
+	codec := newBlockTestCodec()
+	session := NewSession(codec, 1)
+	defer session.Close()
+	utest.IsNilNow(t, session.Flush())
+	utest.EqualNow(t, len(codec.sent), 0)
+}
+
+func Test_SendPriority(t *testing.T) {
+	codec := newBlockTestCodec()
+	session := NewSession(codec, 2)
+	utest.IsNilNow(t, session.Send(1))
+	<-codec.started
+
+	// 1 is being written, the lanes pass 2 and 3.
+	utest.IsNilNow(t, session.Send(2))
+	utest.IsNilNow(t, session.Send(3))
+	utest.IsNilNow(t, session.SendPriority("realtime", PriorityRealtime))
+	utest.IsNilNow(t, session.SendPriority("control", PriorityControl))
+	utest.IsNilNow(t, session.SendPriority("control2", PriorityControl))
+	session.SetSendPolicy(DropNewest)
+	utest.EqualNow(t, session.SendPriority("control3", PriorityControl), MessageDroppedError)
+
+	close(codec.unblock)
+	for i := 0; i < 5; i++ {
+		<-codec.started
+	}
+	for session.SendPackets() < 6 {
+		time.Sleep(time.Millisecond)
+	}
+	session.Close()
+	codec.mutex.Lock()
+	defer codec.mutex.Unlock()
+	utest.EqualNow(t, len(codec.sent), 6)
+	for i, expected := range []interface{}{1, "control", "control2", "realtime", 2, 3} {
+		utest.EqualNow(t, codec.sent[i], expected)
+	}
+}
+
+func newBudgetTestSession(budget *MemoryBudget) (*Session, *blockTestCodec) {
+	codec := newBlockTestCodec()
+	session := NewSession(codec, 10)
+	session.budget = budget
+	budget.add(session)
+	session.Send([]byte("x"))
+	<-codec.started
+	return session, codec
+}
+
+func Test_MemoryBudget(t *testing.T) {
+	// The first message of each session is being written, not queued.
+	budget := NewMemoryBudget(10, BudgetReject)
+	session, codec := newBudgetTestSession(budget)
+	utest.IsNilNow(t, session.Send([]byte("123456")))
+	utest.EqualNow(t, session.Send([]byte("12345")), ErrMemoryBudget)
+	utest.EqualNow(t, session.SendPriority([]byte("12345"), PriorityControl), ErrMemoryBudget)
+	utest.IsNilNow(t, session.SendPriority([]byte("1234"), PriorityControl))
+	utest.EqualNow(t, budget.Used(), 10)
+	utest.EqualNow(t, session.QueuedBytes(), 10)
+	close(codec.unblock)
+	for session.SendPackets() < 3 {
+		time.Sleep(time.Millisecond)
+	}
+	utest.EqualNow(t, budget.Used(), 0)
+	session.Close()
+
+	budget = NewMemoryBudget(10, BudgetShed)
+	session1, codec1 := newBudgetTestSession(budget)
+	session2, codec2 := newBudgetTestSession(budget)
+	defer close(codec1.unblock)
+	defer close(codec2.unblock)
+	utest.IsNilNow(t, session1.Send([]byte("12345678")))
+	utest.IsNilNow(t, session2.Send([]byte("12")))
+	utest.IsNilNow(t, session2.Send([]byte("12345")))
+	utest.Assert(t, session1.IsClosed())
+	utest.EqualNow(t, session1.CloseError(), ErrMemoryBudget)
+	utest.EqualNow(t, budget.Used(), 7)
+	utest.EqualNow(t, session2.Send([]byte("12345678")), ErrMemoryBudget)
+	utest.Assert(t, session2.IsClosed())
+	utest.EqualNow(t, budget.Used(), 0)
+
+	budget = NewMemoryBudget(10, BudgetBlock)
+	session, codec = newBudgetTestSession(budget)
+	defer session.Close()
+	utest.IsNilNow(t, session.Send([]byte("12345678")))
+	sent := make(chan error)
+	go func() {
+		sent <- session.Send([]byte("12345"))
+	}()
+	select {
+	case <-sent:
+		t.Fatal("sent over the budget")
+	case <-time.After(20 * time.Millisecond):
+	}
+	close(codec.unblock)
+	utest.IsNilNow(t, <-sent)
+	utest.EqualNow(t, session.Send(make([]byte, 11)), ErrMemoryBudget)
+}
+
+func Test_IdleTimeout(t *testing.T) {
+	idles := make(chan *Session, 2)
+	server, err := Listen("tcp", "127.0.0.1:0", ProtocolFunc(NewTestCodec), 0, HandlerFunc(func(session *Session) {
+		for {
+			if _, err := session.Receive(); err != nil {
+				return
+			}
+		}
+	}))
+	utest.IsNilNow(t, err)
+	server.SetIdleTimeout(50*time.Millisecond, func(session *Session) {
+		idles <- session
+	})
+	go server.Serve()
+	defer server.Stop()
+
+	addr := server.Listener().Addr().String()
+	active, err := Dial("tcp", addr, ProtocolFunc(NewTestCodec), 0)
+	utest.IsNilNow(t, err)
+	defer active.Close()
+	idle, err := Dial("tcp", addr, ProtocolFunc(NewTestCodec), 0)
+	utest.IsNilNow(t, err)
+	defer idle.Close()
+
+	var closed *Session
+	for begin := time.Now(); closed == nil && time.Since(begin) < time.Second; {
+		utest.IsNilNow(t, active.Send([]byte("ping")))
+		select {
+		case closed = <-idles:
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+	utest.NotNilNow(t, closed)
+	utest.EqualNow(t, closed.RemoteAddr().String(), idle.Conn().LocalAddr().String())
+	utest.EqualNow(t, closed.CloseError(), SessionIdleError)
+	utest.Assert(t, time.Since(closed.LastActive()) >= 50*time.Millisecond)
+	_, err = idle.Receive()
+	utest.NotNilNow(t, err)
+	for begin := time.Now(); server.Manager().Len() != 1 && time.Since(begin) < time.Second; {
+		time.Sleep(time.Millisecond)
+	}
+	utest.EqualNow(t, server.Manager().Len(), 1)
+}
+
+func Test_UnixSocket(t *testing.T) {
+	dir, err := ioutil.TempDir("", "link")
+	utest.IsNilNow(t, err)
+	defer os.RemoveAll(dir)
+	addresses := []string{filepath.Join(dir, "link.sock")}
+	if runtime.GOOS == "linux" {
+		addresses = append(addresses, "@link-test-"+strconv.Itoa(os.Getpid()))
+	}
+
+	for _, address := range addresses {
+		server, err := Listen("unix", address, ProtocolFunc(NewTestCodec), 0, HandlerFunc(func(session *Session) {
+			for {
+				msg, err := session.Receive()
+				if err != nil {
+					return
+				}
+				session.Send(msg)
+			}
+		}))
+		utest.IsNilNow(t, err)
+		server.SetMaxConns(0, 1, nil)
+		go server.Serve()
+
+		// Unix connections have no IP to be limited by.
+		for i := 0; i < 2; i++ {
+			session, err := Dial("unix", address, ProtocolFunc(NewTestCodec), 0)
+			utest.IsNilNow(t, err)
+			defer session.Close()
+			utest.IsNilNow(t, session.Send([]byte("hello")))
+			msg, err := session.Receive()
+			utest.IsNilNow(t, err)
+			utest.EqualNow(t, string(msg.([]byte)), "hello")
+		}
+		server.Stop()
+	}
+
+	// The socket file of a dead process is replaced.
+	listener, err := net.Listen("unix", addresses[0])
+	utest.IsNilNow(t, err)
+	listener.(*net.UnixListener).SetUnlinkOnClose(false)
+	listener.Close()
+	_, err = os.Lstat(addresses[0])
+	utest.IsNilNow(t, err)
+	server, err := Listen("unix", addresses[0], ProtocolFunc(NewTestCodec), 0, HandlerFunc(func(session *Session) {}))
+	utest.IsNilNow(t, err)
+	server.Stop()
+
+	// The one of a live listener is not.
+	listener, err = net.Listen("unix", addresses[0])
+	utest.IsNilNow(t, err)
+	defer listener.Close()
+	_, err = Listen("unix", addresses[0], ProtocolFunc(NewTestCodec), 0, HandlerFunc(func(session *Session) {}))
+	utest.NotNilNow(t, err)
+}
+
+func Test_UpgradeListener(t *testing.T) {
+	upgrader := NewUpgradeListener("link", nil)
+	server := NewServer(upgrader, ProtocolFunc(NewTestCodec), 0, HandlerFunc(func(session *Session) {
+		for {
+			msg, err := session.Receive()
+			if err != nil {
+				return
+			}
+			session.Send(msg)
+		}
+	}))
+	go server.Serve()
+	mux := http.NewServeMux()
+	mux.Handle("/link", upgrader)
+	mux.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	})
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	utest.IsNilNow(t, err)
+	defer listener.Close()
+	go http.Serve(listener, mux)
+	addr := listener.Addr().String()
+
+	resp, err := http.Get("http://" + addr + "/health")
+	utest.IsNilNow(t, err)
+	body, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	utest.EqualNow(t, string(body), "ok")
+
+	resp, err = http.Get("http://" + addr + "/link")
+	utest.IsNilNow(t, err)
+	resp.Body.Close()
+	utest.EqualNow(t, resp.StatusCode, http.StatusUpgradeRequired)
+
+	_, err = DialTransport(UpgradeTransport(NetTransport("tcp"), "/health", "link"), addr, time.Second, ProtocolFunc(NewTestCodec), 0)
+	utest.EqualNow(t, err, ErrUpgradeRefused)
+
+	session, err := DialTransport(UpgradeTransport(NetTransport("tcp"), "/link", "link"), addr, time.Second, ProtocolFunc(NewTestCodec), 0)
+	utest.IsNilNow(t, err)
+	defer session.Close()
+	utest.IsNilNow(t, session.Send([]byte("hello")))
+	msg, err := session.Receive()
+	utest.IsNilNow(t, err)
+	utest.EqualNow(t, string(msg.([]byte)), "hello")
+
+	upgrader.Close()
+	utest.EqualNow(t, server.Serve(), io.EOF)
+}
+
+func Test_Negotiate(t *testing.T) {
+	const featureXor = 1
+	newProtocol := func(agreement Agreement) (Protocol, error) {
+		if agreement.Features&featureXor != 0 {
+			return ProtocolFunc(newXorTestCodec), nil
+		}
+		return nil, nil
+	}
+	server, err := Listen("tcp", "127.0.0.1:0", ProtocolFunc(NewTestCodec), 0, HandlerFunc(func(session *Session) {
+		agreement, _ := Negotiated(session)
+		for {
+			msg, err := session.Receive()
+			if err != nil {
+				return
+			}
+			session.Send(append(msg.([]byte), " "+strconv.Itoa(int(agreement.Version))...))
+		}
+	}))
+	utest.IsNilNow(t, err)
+	server.SetAuthenticator(Negotiate(Offer{Versions: []uint16{1, 2, 3}, Features: featureXor | 2}, newProtocol), time.Second)
+	go server.Serve()
+	defer server.Stop()
+	addr := server.Listener().Addr().String()
+
+	for _, offer := range []Offer{{[]uint16{2, 1}, featureXor}, {[]uint16{2, 4}, 2}} {
+		session, err := Dial("tcp", addr, ProtocolFunc(NewTestCodec), 0)
+		utest.IsNilNow(t, err)
+		utest.IsNilNow(t, Handshake(session, NegotiateClient(offer, newProtocol), time.Second))
+		agreement, ok := Negotiated(session)
+		utest.Assert(t, ok)
+		utest.EqualNow(t, agreement, Agreement{2, offer.Features})
+		_, xor := session.Codec().(xorTestCodec)
+		utest.EqualNow(t, xor, offer.Features&featureXor != 0)
+
+		utest.IsNilNow(t, session.Send([]byte("hello")))
+		msg, err := session.Receive()
+		utest.IsNilNow(t, err)
+		utest.EqualNow(t, string(msg.([]byte)), "hello 2")
+		session.Close()
+	}
+
+	session, err := Dial("tcp", addr, ProtocolFunc(NewTestCodec), 0)
+	utest.IsNilNow(t, err)
+	utest.EqualNow(t, Handshake(session, NegotiateClient(Offer{Versions: []uint16{4}}, newProtocol), time.Second), ErrNoCommonVersion)
+}
+
+func Test_WorkerPool(t *testing.T) {
+	const sessions, messages = 4, 100
+	var mutex sync.Mutex
+	received := make(map[*Session][]int)
+	var running sync.Map
+	var concurrent int32
+	var handled sync.WaitGroup
+	handled.Add(sessions * messages)
+	pool := NewWorkerPool(3, 4, MessageHandlerFunc(func(session *Session, msg interface{}) {
+		// The messages of a session are never handled at the same time.
+		value, _ := running.LoadOrStore(session, new(int32))
+		if atomic.AddInt32(value.(*int32), 1) != 1 {
+			atomic.StoreInt32(&concurrent, 1)
+		}
+		n, _ := strconv.Atoi(string(msg.([]byte)))
+		mutex.Lock()
+		received[session] = append(received[session], n)
+		mutex.Unlock()
+		time.Sleep(10 * time.Microsecond)
+		atomic.AddInt32(value.(*int32), -1)
+		handled.Done()
+	}))
+	server, err := Listen("tcp", "127.0.0.1:0", ProtocolFunc(NewTestCodec), 0, pool)
+	utest.IsNilNow(t, err)
+	go server.Serve()
+	defer server.Stop()
+
+	for i := 0; i < sessions; i++ {
+		session, err := Dial("tcp", server.Listener().Addr().String(), ProtocolFunc(NewTestCodec), 0)
+		utest.IsNilNow(t, err)
+		defer session.Close()
+		go func() {
+			for j := 0; j < messages; j++ {
+				session.Send([]byte(strconv.Itoa(j)))
+			}
+		}()
+	}
+	handled.Wait()
+	utest.EqualNow(t, atomic.LoadInt32(&concurrent), int32(0))
+	mutex.Lock()
+	utest.EqualNow(t, len(received), sessions)
+	for _, list := range received {
+		for j, n := range list {
+			utest.EqualNow(t, n, j)
+		}
+	}
+	mutex.Unlock()
+
+	pool.Stop()
+}
+
+type byteCountConn struct {
+	net.Conn
+	read, written int64
+}
+
+func (c *byteCountConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	atomic.AddInt64(&c.read, int64(n))
+	return n, err
+}
+
+func (c *byteCountConn) Write(p []byte) (int, error) {
+	n, err := c.Conn.Write(p)
+	atomic.AddInt64(&c.written, int64(n))
+	return n, err
+}
+
+func Test_CompressNegotiated(t *testing.T) {
+	protocol := ProtocolFunc(NewTestCodec)
+	server, err := Listen("tcp", "127.0.0.1:0", protocol, 0, HandlerFunc(func(session *Session) {
+		for {
+			msg, err := session.Receive()
+			if err != nil {
+				return
+			}
+			session.Send(msg)
+		}
+	}))
+	utest.IsNilNow(t, err)
+	server.SetAuthenticator(Negotiate(Offer{[]uint16{1}, FeatureCompressUp | FeatureCompressDown}, CompressNegotiated(protocol, flate.BestSpeed, false)), time.Second)
+	go server.Serve()
+	defer server.Stop()
+
+	for _, features := range []uint32{FeatureCompressDown, FeatureCompressUp, 0} {
+		conn, err := net.Dial("tcp", server.Listener().Addr().String())
+		utest.IsNilNow(t, err)
+		counter := &byteCountConn{Conn: conn}
+		codec, _ := protocol.NewCodec(counter)
+		session := newConnSession(nil, counter, codec, 0)
+		utest.IsNilNow(t, Handshake(session, NegotiateClient(Offer{[]uint16{1}, features}, CompressNegotiated(protocol, flate.BestSpeed, true)), time.Second))
+
+		read, written := atomic.LoadInt64(&counter.read), atomic.LoadInt64(&counter.written)
+		text := bytes.Repeat([]byte("compress me "), 1000)
+		for i := 0; i < 3; i++ {
+			utest.IsNilNow(t, session.Send(text))
+			msg, err := session.Receive()
+			utest.IsNilNow(t, err)
+			utest.EqualNow(t, string(msg.([]byte)), string(text))
+		}
+		read = atomic.LoadInt64(&counter.read) - read
+		written = atomic.LoadInt64(&counter.written) - written
+		utest.EqualNow(t, read < int64(len(text)), features&FeatureCompressDown != 0)
+		utest.EqualNow(t, written < int64(len(text)), features&FeatureCompressUp != 0)
+		session.Close()
+	}
+}
+
+func Test_CloseWith(t *testing.T) {
+	frames := &CloseFrames{
+		New: func(code uint16, reason string) interface{} {
+			return []byte("close " + strconv.Itoa(int(code)) + " " + reason)
+		},
+		Parse: func(msg interface{}) (uint16, string, bool) {
+			parts := strings.SplitN(string(msg.([]byte)), " ", 3)
+			if len(parts) != 3 || parts[0] != "close" {
+				return 0, "", false
+			}
+			code, _ := strconv.Atoi(parts[1])
+			return uint16(code), parts[2], true
+		},
+	}
+	closed := make(chan error, 1)
+	server, err := Listen("tcp", "127.0.0.1:0", ProtocolFunc(NewTestCodec), 10, HandlerFunc(func(session *Session) {
+		session.Receive()
+		session.Send([]byte("a"))
+		session.Send([]byte("b"))
+		session.CloseWith(4001, "auth failed")
+		closed <- session.CloseError()
+	}))
+	utest.IsNilNow(t, err)
+	server.SetCloseFrames(frames)
+	server.SetWriteTimeout(time.Second, ApplyToNew)
+	go server.Serve()
+	defer server.Stop()
+
+	session, err := Dial("tcp", server.Listener().Addr().String(), ProtocolFunc(NewTestCodec), 0)
+	utest.IsNilNow(t, err)
+	session.SetCloseFrames(frames)
+	utest.IsNilNow(t, session.Send([]byte("login")))
+	for _, text := range []string{"a", "b"} {
+		msg, err := session.Receive()
+		utest.IsNilNow(t, err)
+		utest.EqualNow(t, string(msg.([]byte)), text)
+	}
+	_, err = session.Receive()
+	frame, ok := err.(*CloseFrame)
+	utest.Assert(t, ok)
+	utest.EqualNow(t, *frame, CloseFrame{4001, "auth failed"})
+	utest.Assert(t, session.IsClosed())
+	utest.EqualNow(t, session.CloseError(), error(frame))
+	utest.EqualNow(t, (<-closed).Error(), "Session Closed With 4001: auth failed")
+	utest.EqualNow(t, session.CloseWith(1000, ""), SessionClosedError)
+}
+
+type byteMetrics struct {
+	written int64
+}
+
+func (m *byteMetrics) SessionOpened()     {}
+func (m *byteMetrics) SessionClosed()     {}
+func (m *byteMetrics) BytesRead(n int)    {}
+func (m *byteMetrics) BytesWritten(n int) { atomic.AddInt64(&m.written, int64(n)) }
+func (m *byteMetrics) PacketReceived(int) {}
+func (m *byteMetrics) PacketSent(int)     {}
+
+func Test_CountConnWrites(t *testing.T) {
+	conn1, conn2 := net.Pipe()
+	defer conn1.Close()
+	defer conn2.Close()
+	go io.Copy(ioutil.Discard, conn2)
+
+	metrics := &byteMetrics{}
+	counter := &countConn{Conn: conn1, metrics: metrics}
+	n, err := counter.ReadFrom(strings.NewReader("hello"))
+	utest.IsNilNow(t, err)
+	utest.EqualNow(t, n, int64(5))
+	buffers := net.Buffers{[]byte("a"), []byte("bc")}
+	n, err = counter.WriteBuffers(&buffers)
+	utest.IsNilNow(t, err)
+	utest.EqualNow(t, n, int64(3))
+	utest.EqualNow(t, atomic.LoadUint64(&counter.bytesOut), uint64(8))
+	utest.EqualNow(t, atomic.LoadInt64(&metrics.written), int64(8))
+	utest.Assert(t, counter.NetConn() == conn1)
+}
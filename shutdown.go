@@ -0,0 +1,64 @@
+package link
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+// GracefulStop is Shutdown waiting up to drain.
+func (server *Server) GracefulStop(drain time.Duration, msg interface{}) {
+	ctx, cancel := context.WithTimeout(context.Background(), drain)
+	defer cancel()
+	server.Shutdown(ctx, msg)
+}
+
+// Shutdown stops accepting new connections, sends msg to the live sessions if
+// it is not nil, and waits for them to close until ctx is done, then closes
+// the rest and returns the error of ctx. The sends are asynchronous, a
+// session still writing msg when ctx is done is closed like the others.
+func (server *Server) Shutdown(ctx context.Context, msg interface{}) error {
+	server.listener.Close()
+
+	if msg != nil {
+		// Send outside of Fetch, a slow session must not hold the session map
+		// lock, and must not delay the others past ctx.
+		var sessions []*Session
+		server.manager.Fetch(func(session *Session) {
+			sessions = append(sessions, session)
+		})
+		for _, session := range sessions {
+			session.SendAsync(msg)
+		}
+	}
+
+	ticker := time.NewTicker(10 * time.Millisecond)
+	defer ticker.Stop()
+	for server.manager.Len() > 0 {
+		select {
+		case <-ctx.Done():
+			server.Stop()
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+	server.Stop()
+	return nil
+}
+
+// StopOnSignal blocks until one of signals arrives, SIGINT and SIGTERM by
+// default, then calls GracefulStop. It returns the signal received.
+func (server *Server) StopOnSignal(drain time.Duration, msg interface{}, signals ...os.Signal) os.Signal {
+	if len(signals) == 0 {
+		signals = []os.Signal{os.Interrupt, syscall.SIGTERM}
+	}
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, signals...)
+	defer signal.Stop(c)
+
+	sig := <-c
+	server.GracefulStop(drain, msg)
+	return sig
+}
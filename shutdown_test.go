@@ -0,0 +1,193 @@
+//go:build !windows
+// +build !windows
+
+package link
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/funny/utest"
+)
+
+func Test_StopOnSignal(t *testing.T) {
+	server, err := Listen("tcp", "127.0.0.1:0", ProtocolFunc(NewTestCodec), 0, HandlerFunc(func(session *Session) {
+		for {
+			if _, err := session.Receive(); err != nil {
+				return
+			}
+		}
+	}))
+	utest.IsNilNow(t, err)
+	go server.Serve()
+
+	session, err := Dial("tcp", server.Listener().Addr().String(), ProtocolFunc(NewTestCodec), 0)
+	utest.IsNilNow(t, err)
+	defer session.Close()
+	utest.IsNilNow(t, session.Send([]byte("hello")))
+	time.Sleep(50 * time.Millisecond)
+
+	stopped := make(chan os.Signal)
+	go func() {
+		stopped <- server.StopOnSignal(100*time.Millisecond, []byte("bye"), syscall.SIGUSR1)
+	}()
+	time.Sleep(50 * time.Millisecond)
+	syscall.Kill(os.Getpid(), syscall.SIGUSR1)
+
+	msg, err := session.Receive()
+	utest.IsNilNow(t, err)
+	utest.EqualNow(t, string(msg.([]byte)), "bye")
+
+	select {
+	case sig := <-stopped:
+		utest.EqualNow(t, sig, syscall.SIGUSR1)
+	case <-time.After(time.Second):
+		t.Fatal("server not stopped")
+	}
+	_, err = session.Receive()
+	utest.NotNilNow(t, err)
+	utest.EqualNow(t, server.Manager().Len(), 0)
+}
+
+func Test_GracefulStopBlockedSend(t *testing.T) {
+	codec := newBlockTestCodec()
+	defer close(codec.unblock)
+	server, err := Listen("tcp", "127.0.0.1:0", ProtocolFunc(func(rw io.ReadWriter) (Codec, error) {
+		return codec, nil
+	}), 0, HandlerFunc(func(session *Session) {
+		<-session.closeChan
+	}))
+	utest.IsNilNow(t, err)
+	go server.Serve()
+
+	conn, err := net.Dial("tcp", server.Listener().Addr().String())
+	utest.IsNilNow(t, err)
+	defer conn.Close()
+	for server.Manager().Len() == 0 {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	stopped := make(chan struct{})
+	go func() {
+		server.GracefulStop(50*time.Millisecond, []byte("bye"))
+		close(stopped)
+	}()
+	<-codec.started
+	select {
+	case <-stopped:
+	case <-time.After(time.Second):
+		t.Fatal("blocked by a session send")
+	}
+	utest.EqualNow(t, server.Manager().Len(), 0)
+}
+
+func Test_Shutdown(t *testing.T) {
+	server, err := Listen("tcp", "127.0.0.1:0", ProtocolFunc(NewTestCodec), 0, HandlerFunc(func(session *Session) {
+		session.Receive()
+	}))
+	utest.IsNilNow(t, err)
+	go server.Serve()
+	addr := server.Listener().Addr().String()
+
+	// The client closes when it gets the going away message.
+	obedient, err := Dial("tcp", addr, ProtocolFunc(NewTestCodec), 0)
+	utest.IsNilNow(t, err)
+	go func() {
+		obedient.Receive()
+		obedient.Close()
+	}()
+	for server.Manager().Len() == 0 {
+		time.Sleep(10 * time.Millisecond)
+	}
+	utest.IsNilNow(t, server.Shutdown(context.Background(), []byte("bye")))
+
+	server, err = Listen("tcp", "127.0.0.1:0", ProtocolFunc(NewTestCodec), 0, HandlerFunc(func(session *Session) {
+		session.Receive()
+	}))
+	utest.IsNilNow(t, err)
+	go server.Serve()
+	stubborn, err := Dial("tcp", server.Listener().Addr().String(), ProtocolFunc(NewTestCodec), 0)
+	utest.IsNilNow(t, err)
+	defer stubborn.Close()
+	for server.Manager().Len() == 0 {
+		time.Sleep(10 * time.Millisecond)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Millisecond)
+	defer cancel()
+	utest.EqualNow(t, server.Shutdown(ctx, nil), context.DeadlineExceeded)
+	utest.EqualNow(t, server.Manager().Len(), 0)
+}
+
+func Test_InheritListener(t *testing.T) {
+	parent, err := net.Listen("tcp", "127.0.0.1:0")
+	utest.IsNilNow(t, err)
+	defer parent.Close()
+	file, err := parent.(*net.TCPListener).File()
+	utest.IsNilNow(t, err)
+	os.Setenv(listenersEnv, fmt.Sprintf("%d tcp upgrade\n", file.Fd()))
+	inherit.Lock()
+	inherit.parsed, inherit.listeners = false, nil
+	inherit.Unlock()
+
+	listener, err := InheritListener("tcp", "upgrade")
+	utest.IsNilNow(t, err)
+	utest.EqualNow(t, os.Getenv(listenersEnv), "")
+	utest.EqualNow(t, listener.Addr().String(), parent.Addr().String())
+	parent.Close()
+
+	// The new process accepts the connections of the old listener.
+	os.Setenv("LINK_TEST_UPGRADE_CHILD", "1")
+	defer os.Unsetenv("LINK_TEST_UPGRADE_CHILD")
+	args := os.Args
+	os.Args = []string{args[0], "-test.run=^Test_UpgradeChild$"}
+	process, err := StartUpgrade()
+	os.Args = args
+	utest.IsNilNow(t, err)
+	listener.Close()
+
+	conn, err := net.Dial("tcp", parent.Addr().String())
+	utest.IsNilNow(t, err)
+	defer conn.Close()
+	reply, err := io.ReadAll(conn)
+	utest.IsNilNow(t, err)
+	utest.EqualNow(t, string(reply), "child")
+	state, err := process.Wait()
+	utest.IsNilNow(t, err)
+	utest.Assert(t, state.Success())
+}
+
+func Test_UpgradeChild(t *testing.T) {
+	if os.Getenv("LINK_TEST_UPGRADE_CHILD") == "" {
+		t.Skip("run by Test_InheritListener")
+	}
+	listener, err := InheritListener("tcp", "upgrade")
+	utest.IsNilNow(t, err)
+	conn, err := listener.Accept()
+	utest.IsNilNow(t, err)
+	conn.Write([]byte("child"))
+	conn.Close()
+}
+
+func Test_ListenReusePort(t *testing.T) {
+	listener1, err := ListenReusePort("tcp", "127.0.0.1:0")
+	utest.IsNilNow(t, err)
+	defer listener1.Close()
+	listener2, err := ListenReusePort("tcp", listener1.Addr().String())
+	utest.IsNilNow(t, err)
+	defer listener2.Close()
+
+	// Either listener accepts the connections.
+	listener1.Close()
+	conn, err := net.Dial("tcp", listener2.Addr().String())
+	utest.IsNilNow(t, err)
+	defer conn.Close()
+	accepted, err := listener2.Accept()
+	utest.IsNilNow(t, err)
+	accepted.Close()
+}
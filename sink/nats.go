@@ -0,0 +1,98 @@
+package sink
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/funny/link"
+	"github.com/funny/link/codec"
+)
+
+// NatsSink publishes records to NATS, each record is a message.
+type NatsSink struct {
+	addr    string
+	subject func(Record) string
+	encode  func(Record) ([]byte, error)
+
+	mutex   sync.Mutex
+	session *link.Session
+}
+
+// NewNats publishes records to the subject returned by subject, as JSON if
+// encode is nil.
+func NewNats(addr string, subject func(Record) string, encode func(Record) ([]byte, error)) *NatsSink {
+	if encode == nil {
+		encode = func(record Record) ([]byte, error) {
+			return json.Marshal(record)
+		}
+	}
+	return &NatsSink{
+		addr:    addr,
+		subject: subject,
+		encode:  encode,
+	}
+}
+
+func (sink *NatsSink) connect() (*link.Session, error) {
+	if sink.session != nil && !sink.session.IsClosed() {
+		return sink.session, nil
+	}
+	session, err := link.DialTimeout("tcp", sink.addr, 5*time.Second, codec.Nats(64*1024, 64*1024*1024), 0)
+	if err != nil {
+		return nil, err
+	}
+	err = session.Send(&codec.NatsMsg{Op: "CONNECT", Args: []string{`{"verbose":false,"pedantic":false}`}})
+	if err != nil {
+		session.Close()
+		return nil, err
+	}
+	go func() {
+		for {
+			msg, err := session.Receive()
+			if err != nil {
+				session.Close()
+				return
+			}
+			if msg.(*codec.NatsMsg).Op == "PING" {
+				session.Send(&codec.NatsMsg{Op: "PONG"})
+			}
+		}
+	}()
+	sink.session = session
+	return session, nil
+}
+
+func (sink *NatsSink) Write(records []Record) error {
+	sink.mutex.Lock()
+	defer sink.mutex.Unlock()
+	session, err := sink.connect()
+	if err != nil {
+		return err
+	}
+	for _, record := range records {
+		payload, err := sink.encode(record)
+		if err != nil {
+			return err
+		}
+		err = session.Send(&codec.NatsMsg{
+			Op:      "PUB",
+			Args:    []string{sink.subject(record)},
+			Payload: payload,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (sink *NatsSink) Close() error {
+	sink.mutex.Lock()
+	defer sink.mutex.Unlock()
+	if sink.session != nil {
+		sink.session.Close()
+		sink.session = nil
+	}
+	return nil
+}
@@ -0,0 +1,53 @@
+package sink
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/funny/link"
+	"github.com/funny/link/codec"
+	"github.com/funny/utest"
+)
+
+func Test_NatsSink(t *testing.T) {
+	received := make(chan *codec.NatsMsg, 10)
+	server, err := link.Listen("tcp", "127.0.0.1:0", codec.Nats(4096, 4096), 0, link.HandlerFunc(func(session *link.Session) {
+		session.Send(&codec.NatsMsg{Op: "INFO", Args: []string{"{}"}})
+		session.Send(&codec.NatsMsg{Op: "PING"})
+		for {
+			msg, err := session.Receive()
+			if err != nil {
+				return
+			}
+			received <- msg.(*codec.NatsMsg)
+		}
+	}))
+	utest.IsNilNow(t, err)
+	go server.Serve()
+	defer server.Stop()
+
+	sink := NewNats(server.Listener().Addr().String(), func(record Record) string {
+		return "audit." + record.Tags["type"]
+	}, nil)
+	defer sink.Close()
+	err = sink.Write([]Record{{SessionID: 1, Tags: map[string]string{"type": "login"}, Msg: "alice"}})
+	utest.IsNilNow(t, err)
+
+	ops := map[string]*codec.NatsMsg{}
+	for len(ops) < 3 {
+		select {
+		case msg := <-received:
+			ops[msg.Op] = msg
+		case <-time.After(time.Second):
+			t.Fatalf("messages not received: %v", ops)
+		}
+	}
+	utest.NotNilNow(t, ops["CONNECT"])
+	utest.NotNilNow(t, ops["PONG"])
+	pub := ops["PUB"]
+	utest.EqualNow(t, pub.Args[0], "audit.login")
+	var record Record
+	utest.IsNilNow(t, json.Unmarshal(pub.Payload, &record))
+	utest.EqualNow(t, record.Msg, "alice")
+}
@@ -0,0 +1,156 @@
+// Package sink forwards selected inbound messages, with the metadata of
+// their sessions, to a message bus for analytics and audit pipelines.
+//
+//	async := sink.NewAsync(natsSink, 10000, 100, time.Second)
+//	for {
+//		msg, err := session.Receive()
+//		if err != nil {
+//			return
+//		}
+//		if isAudited(msg) {
+//			async.Put(session, msg)
+//		}
+//		...
+//	}
+package sink
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/funny/link"
+)
+
+// Record is a message received by a session.
+type Record struct {
+	SessionID  uint64            `json:"session_id"`
+	RemoteAddr string            `json:"remote_addr,omitempty"`
+	Tags       map[string]string `json:"tags,omitempty"`
+	Time       time.Time         `json:"time"`
+	Msg        interface{}       `json:"msg"`
+}
+
+// NewRecord creates a record of msg received by session. A msg with a
+// Bytes method, like a codec.InBuffer, is copied into a []byte because its
+// buffer is reused by the next Receive.
+func NewRecord(session *link.Session, msg interface{}) Record {
+	if b, ok := msg.(interface {
+		Bytes() []byte
+	}); ok {
+		msg = append([]byte(nil), b.Bytes()...)
+	}
+	record := Record{
+		SessionID: session.ID(),
+		Tags:      session.Tags(),
+		Time:      time.Now(),
+		Msg:       msg,
+	}
+	if addr := session.RemoteAddr(); addr != nil {
+		record.RemoteAddr = addr.String()
+	}
+	return record
+}
+
+// Sink writes batches of records to somewhere, like a Kafka or NATS topic.
+type Sink interface {
+	Write(records []Record) error
+	Close() error
+}
+
+// Async batches records in background, so sessions never wait for a sink.
+// Records are dropped when the queue is full or the sink fails.
+type Async struct {
+	sink      Sink
+	batchSize int
+	interval  time.Duration
+	queue     chan Record
+	dropped   uint64
+	failed    uint64
+	closeOnce sync.Once
+	closeWait sync.WaitGroup
+}
+
+// NewAsync writes to sink when batchSize records are queued or interval passed.
+func NewAsync(sink Sink, queueSize, batchSize int, interval time.Duration) *Async {
+	if batchSize < 1 {
+		batchSize = 1
+	}
+	async := &Async{
+		sink:      sink,
+		batchSize: batchSize,
+		interval:  interval,
+		queue:     make(chan Record, queueSize),
+	}
+	async.closeWait.Add(1)
+	go async.loop()
+	return async
+}
+
+// Put queues the message received by session, it returns false if dropped.
+func (async *Async) Put(session *link.Session, msg interface{}) bool {
+	return async.PutRecord(NewRecord(session, msg))
+}
+
+func (async *Async) PutRecord(record Record) bool {
+	select {
+	case async.queue <- record:
+		return true
+	default:
+		atomic.AddUint64(&async.dropped, 1)
+		return false
+	}
+}
+
+// Dropped returns the number of records dropped for a full queue.
+func (async *Async) Dropped() uint64 {
+	return atomic.LoadUint64(&async.dropped)
+}
+
+// Failed returns the number of records lost in failed writes.
+func (async *Async) Failed() uint64 {
+	return atomic.LoadUint64(&async.failed)
+}
+
+func (async *Async) loop() {
+	defer async.closeWait.Done()
+	ticker := time.NewTicker(async.interval)
+	defer ticker.Stop()
+	batch := make([]Record, 0, async.batchSize)
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+		if async.sink.Write(batch) != nil {
+			atomic.AddUint64(&async.failed, uint64(len(batch)))
+		}
+		batch = make([]Record, 0, async.batchSize)
+	}
+	for {
+		select {
+		case record, ok := <-async.queue:
+			if !ok {
+				flush()
+				return
+			}
+			batch = append(batch, record)
+			if len(batch) >= async.batchSize {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+		}
+	}
+}
+
+// Close writes the queued records and closes the sink, Put must not be
+// called after.
+func (async *Async) Close() error {
+	var err error
+	async.closeOnce.Do(func() {
+		close(async.queue)
+		async.closeWait.Wait()
+		err = async.sink.Close()
+	})
+	return err
+}
@@ -0,0 +1,93 @@
+package sink
+
+import (
+	"bytes"
+	"encoding/binary"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/funny/link"
+	"github.com/funny/link/codec"
+	"github.com/funny/utest"
+)
+
+type memorySink struct {
+	mutex   sync.Mutex
+	batches [][]Record
+}
+
+func (sink *memorySink) Write(records []Record) error {
+	sink.mutex.Lock()
+	defer sink.mutex.Unlock()
+	sink.batches = append(sink.batches, records)
+	return nil
+}
+
+func (sink *memorySink) Close() error {
+	return nil
+}
+
+type nopCodec struct{}
+
+func (nopCodec) Receive() (interface{}, error) { select {} }
+func (nopCodec) Send(interface{}) error        { return nil }
+func (nopCodec) Close() error                  { return nil }
+
+func Test_Async(t *testing.T) {
+	memory := &memorySink{}
+	async := NewAsync(memory, 100, 3, time.Hour)
+	session := link.NewManager().NewSession(nopCodec{}, 0)
+	session.SetTag("user", "alice")
+
+	for i := 0; i < 7; i++ {
+		utest.Assert(t, async.Put(session, i))
+	}
+	utest.IsNilNow(t, async.Close())
+
+	utest.EqualNow(t, len(memory.batches), 3)
+	utest.EqualNow(t, len(memory.batches[0]), 3)
+	utest.EqualNow(t, len(memory.batches[2]), 1)
+	record := memory.batches[2][0]
+	utest.EqualNow(t, record.Msg, 6)
+	utest.EqualNow(t, record.SessionID, session.ID())
+	utest.EqualNow(t, record.Tags["user"], "alice")
+}
+
+func Test_AsyncDrop(t *testing.T) {
+	block := make(chan struct{})
+	memory := &memorySink{}
+	memory.mutex.Lock()
+	go func() {
+		<-block
+		memory.mutex.Unlock()
+	}()
+	async := NewAsync(memory, 1, 1, time.Hour)
+	session := link.NewManager().NewSession(nopCodec{}, 0)
+	dropped := 0
+	for i := 0; i < 10; i++ {
+		if !async.Put(session, i) {
+			dropped++
+		}
+	}
+	close(block)
+	async.Close()
+	utest.Assert(t, dropped > 0)
+	utest.EqualNow(t, async.Dropped(), uint64(dropped))
+}
+
+func Test_RecordCopiesBuffer(t *testing.T) {
+	var stream bytes.Buffer
+	protocol := codec.FixLen(codec.Raw(), 2, binary.BigEndian, 1024, 1024)
+	c, _ := protocol.NewCodec(&stream)
+	session := link.NewSession(nopCodec{}, 0)
+
+	c.Send([]byte("first"))
+	c.Send([]byte("other"))
+	msg, err := c.Receive()
+	utest.IsNilNow(t, err)
+	record := NewRecord(session, msg)
+	_, err = c.Receive()
+	utest.IsNilNow(t, err)
+	utest.EqualNow(t, string(record.Msg.([]byte)), "first")
+}
@@ -0,0 +1,184 @@
+package link
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+var errClosedListener = errors.New("use of closed network connection")
+
+// Prefixes of common protocols for Sniffer.Match.
+var (
+	SniffTLS  = [][]byte{{0x16, 0x03}}
+	SniffHTTP = [][]byte{
+		[]byte("GET "), []byte("HEAD "), []byte("POST "), []byte("PUT "),
+		[]byte("DELETE "), []byte("OPTIONS "), []byte("PATCH "),
+	}
+)
+
+// sniff reads from r until the bytes read start with one of prefixes, or
+// none of them can match. It returns the index of the prefix matched or -1,
+// and the bytes read.
+func sniff(r io.Reader, prefixes [][]byte) (int, []byte, error) {
+	var head []byte
+	var buf [64]byte
+	for {
+		more := 0
+		for i, prefix := range prefixes {
+			if bytes.HasPrefix(head, prefix) {
+				return i, head, nil
+			}
+			if bytes.HasPrefix(prefix, head) && len(prefix)-len(head) > more {
+				more = len(prefix) - len(head)
+			}
+		}
+		if more == 0 {
+			return -1, head, nil
+		}
+		if more > len(buf) {
+			more = len(buf)
+		}
+		n, err := r.Read(buf[:more])
+		if n == 0 && err != nil {
+			return -1, head, err
+		}
+		head = append(head, buf[:n]...)
+	}
+}
+
+// Sniffer splits a listener into several by the first bytes sent by
+// clients, so one port can serve e.g. TLS, plain link protocol and HTTP
+// health checks. Protocols the server speaks first can't be sniffed.
+type Sniffer struct {
+	listener net.Listener
+	timeout  time.Duration
+	routes   []*sniffListener
+	prefixes [][]byte
+	indexes  []int
+	fallback *sniffListener
+
+	closeOnce sync.Once
+	closeChan chan struct{}
+}
+
+// NewSniffer creates a sniffer. A connection not sending enough bytes in
+// timeout is closed, zero means no limit.
+func NewSniffer(listener net.Listener, timeout time.Duration) *Sniffer {
+	return &Sniffer{
+		listener:  listener,
+		timeout:   timeout,
+		closeChan: make(chan struct{}),
+	}
+}
+
+// Match returns a listener of connections starting with any of prefixes,
+// the first bytes are still readable. Without prefixes it returns the
+// listener of connections match nothing else. It must be called before Serve.
+func (sniffer *Sniffer) Match(prefixes ...[]byte) net.Listener {
+	l := &sniffListener{
+		sniffer: sniffer,
+		conns:   make(chan net.Conn),
+	}
+	if len(prefixes) == 0 {
+		sniffer.fallback = l
+		return l
+	}
+	for _, prefix := range prefixes {
+		sniffer.prefixes = append(sniffer.prefixes, prefix)
+		sniffer.indexes = append(sniffer.indexes, len(sniffer.routes))
+	}
+	sniffer.routes = append(sniffer.routes, l)
+	return l
+}
+
+// Serve accepts connections and dispatches them until the listener closed.
+func (sniffer *Sniffer) Serve() error {
+	defer sniffer.Close()
+	for {
+		conn, err := Accept(sniffer.listener)
+		if err != nil {
+			return err
+		}
+		go sniffer.dispatch(conn)
+	}
+}
+
+func (sniffer *Sniffer) dispatch(conn net.Conn) {
+	if sniffer.timeout > 0 {
+		conn.SetReadDeadline(time.Now().Add(sniffer.timeout))
+	}
+	i, head, err := sniff(conn, sniffer.prefixes)
+	if err != nil {
+		conn.Close()
+		return
+	}
+	if sniffer.timeout > 0 {
+		conn.SetReadDeadline(time.Time{})
+	}
+	l := sniffer.fallback
+	if i >= 0 {
+		l = sniffer.routes[sniffer.indexes[i]]
+	}
+	if l == nil {
+		conn.Close()
+		return
+	}
+	select {
+	case l.conns <- &sniffConn{conn, bytes.NewReader(head)}:
+	case <-sniffer.closeChan:
+		conn.Close()
+	}
+}
+
+// Close closes the listener and all the listeners split from it.
+func (sniffer *Sniffer) Close() error {
+	var err error
+	sniffer.closeOnce.Do(func() {
+		close(sniffer.closeChan)
+		err = sniffer.listener.Close()
+	})
+	return err
+}
+
+type sniffListener struct {
+	sniffer *Sniffer
+	conns   chan net.Conn
+}
+
+// Accept returns the same error of use of closed network connection like
+// net.Listener, which link.Accept reports as io.EOF.
+func (l *sniffListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.conns:
+		return conn, nil
+	case <-l.sniffer.closeChan:
+		return nil, &net.OpError{Op: "accept", Net: "sniff", Err: errClosedListener}
+	}
+}
+
+// Close closes the sniffer, because connections to a closed listener
+// would be left unanswered.
+func (l *sniffListener) Close() error {
+	return l.sniffer.Close()
+}
+
+func (l *sniffListener) Addr() net.Addr {
+	return l.sniffer.listener.Addr()
+}
+
+// sniffConn gives back the bytes read to choose the listener.
+type sniffConn struct {
+	net.Conn
+	head *bytes.Reader
+}
+
+func (c *sniffConn) Read(p []byte) (int, error) {
+	if c.head.Len() > 0 {
+		return c.head.Read(p)
+	}
+	return c.Conn.Read(p)
+}
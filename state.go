@@ -0,0 +1,37 @@
+package link
+
+// Set attaches value to the session under key, e.g. the user ID once
+// authenticated. The values are kept until the session is closed, they are
+// dropped after the close callbacks and the OnClose hook ran.
+func (session *Session) Set(key, value interface{}) {
+	session.valueMutex.Lock()
+	defer session.valueMutex.Unlock()
+	if session.IsClosed() {
+		return
+	}
+	if session.values == nil {
+		session.values = make(map[interface{}]interface{})
+	}
+	session.values[key] = value
+}
+
+// Get returns the value attached under key.
+func (session *Session) Get(key interface{}) (interface{}, bool) {
+	session.valueMutex.RLock()
+	defer session.valueMutex.RUnlock()
+	value, ok := session.values[key]
+	return value, ok
+}
+
+// Delete removes the value attached under key.
+func (session *Session) Delete(key interface{}) {
+	session.valueMutex.Lock()
+	defer session.valueMutex.Unlock()
+	delete(session.values, key)
+}
+
+func (session *Session) clearValues() {
+	session.valueMutex.Lock()
+	defer session.valueMutex.Unlock()
+	session.values = nil
+}
@@ -0,0 +1,118 @@
+package link
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// RejectReason is why a accepted connection didn't become a session.
+type RejectReason int
+
+const (
+	RejectBanned      RejectReason = iota // denied by the ban list
+	RejectMaintenance                     // server in maintenance mode
+	RejectHandshake                       // Protocol.NewCodec failed
+	RejectLimit                           // over a connection or rate limit
+	RejectAuth                            // the Authenticator failed
+	RejectFiltered                        // refused by the AcceptFilter
+	numRejectReasons
+)
+
+var rejectReasonNames = [numRejectReasons]string{
+	"banned",
+	"maintenance",
+	"handshake",
+	"limit",
+	"auth",
+	"filtered",
+}
+
+func (reason RejectReason) String() string {
+	if reason < 0 || reason >= numRejectReasons {
+		return "unknown"
+	}
+	return rejectReasonNames[reason]
+}
+
+// queuedAcceptTime is how fast Accept returns when connections are
+// already waiting in the listen backlog.
+const queuedAcceptTime = 100 * time.Microsecond
+
+// AcceptStats shows the pressure on the accept stage of a server.
+type AcceptStats struct {
+	Accepted      uint64            `json:"accepted"`       // connections became sessions
+	Rejected      map[string]uint64 `json:"rejected"`       // connections closed by reason
+	Pending       int64             `json:"pending"`        // connections in handshake
+	PeakPending   int64             `json:"peak_pending"`   // max Pending seen
+	QueuedAccepts uint64            `json:"queued_accepts"` // accepts not waited, a sign of a non-empty backlog
+	AcceptErrors  uint64            `json:"accept_errors"`  // temporary errors, like running out of file descriptors
+	AcceptLatency time.Duration     `json:"accept_latency"` // smoothed time from accept to session ready
+}
+
+type acceptStats struct {
+	accepted      uint64
+	queuedAccepts uint64
+	acceptErrors  uint64
+	pending       int64
+	peakPending   int64
+	rejected      [numRejectReasons]uint64
+
+	latencyMutex sync.Mutex
+	latency      time.Duration
+}
+
+// wait counts an accept returned after waited.
+func (s *acceptStats) wait(waited time.Duration) {
+	if waited < queuedAcceptTime {
+		atomic.AddUint64(&s.queuedAccepts, 1)
+	}
+}
+
+func (s *acceptStats) begin() {
+	pending := atomic.AddInt64(&s.pending, 1)
+	for {
+		peak := atomic.LoadInt64(&s.peakPending)
+		if pending <= peak || atomic.CompareAndSwapInt64(&s.peakPending, peak, pending) {
+			break
+		}
+	}
+}
+
+func (s *acceptStats) reject(reason RejectReason) {
+	atomic.AddUint64(&s.rejected[reason], 1)
+}
+
+func (s *acceptStats) done(acceptTime time.Time) {
+	atomic.AddInt64(&s.pending, -1)
+	if acceptTime.IsZero() {
+		return
+	}
+	atomic.AddUint64(&s.accepted, 1)
+	latency := time.Since(acceptTime)
+	s.latencyMutex.Lock()
+	if s.latency == 0 {
+		s.latency = latency
+	} else {
+		s.latency = (7*s.latency + latency) / 8
+	}
+	s.latencyMutex.Unlock()
+}
+
+func (s *acceptStats) get() AcceptStats {
+	stats := AcceptStats{
+		Accepted:      atomic.LoadUint64(&s.accepted),
+		Rejected:      make(map[string]uint64, numRejectReasons),
+		Pending:       atomic.LoadInt64(&s.pending),
+		PeakPending:   atomic.LoadInt64(&s.peakPending),
+		QueuedAccepts: atomic.LoadUint64(&s.queuedAccepts),
+		AcceptErrors:  atomic.LoadUint64(&s.acceptErrors),
+	}
+	for reason := RejectReason(0); reason < numRejectReasons; reason++ {
+		stats.Rejected[reason.String()] = atomic.LoadUint64(&s.rejected[reason])
+	}
+	s.latencyMutex.Lock()
+	stats.AcceptLatency = s.latency
+	s.latencyMutex.Unlock()
+	return stats
+}
@@ -0,0 +1,239 @@
+package link
+
+import (
+	"crypto/tls"
+	"errors"
+	"net"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var ErrUnknownTenant = errors.New("Unknown Tenant")
+
+// Tenant is a partition of a TenantServer with its own configuration,
+// sessions and stats.
+type Tenant struct {
+	Name         string
+	Protocol     Protocol
+	Handler      Handler
+	SendChanSize int
+	MaxConns     int // zero means no limit
+	PacketRate   int // packets received per second per session, zero means no limit
+	PacketBurst  int
+
+	conns   int64
+	manager *Manager
+	stats   acceptStats
+}
+
+func (tenant *Tenant) Manager() *Manager {
+	return tenant.manager
+}
+
+func (tenant *Tenant) AcceptStats() AcceptStats {
+	return tenant.stats.get()
+}
+
+// TenantServer serves many tenants on shared listeners. Each new connection
+// is classified into a tenant, e.g. by SNI, local port or a handshake token.
+type TenantServer struct {
+	classify func(net.Conn) (string, net.Conn, error)
+	stats    acceptStats
+
+	mutex     sync.RWMutex
+	tenants   map[string]*Tenant
+	replaced  []*Tenant
+	listeners []net.Listener
+}
+
+// NewTenantServer creates a server. classify returns the tenant name of a
+// connection, and the connection to use, which may wrap the former like a
+// TLS connection does.
+func NewTenantServer(classify func(net.Conn) (string, net.Conn, error)) *TenantServer {
+	return &TenantServer{
+		classify: classify,
+		tenants:  make(map[string]*Tenant),
+	}
+}
+
+// AddTenant adds or replaces a tenant, the sessions of the replaced one are
+// not affected until Stop.
+func (server *TenantServer) AddTenant(tenant *Tenant) {
+	tenant.manager = NewManager()
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+	if old, exists := server.tenants[tenant.Name]; exists {
+		server.replaced = append(server.replaced, old)
+	}
+	server.tenants[tenant.Name] = tenant
+}
+
+func (server *TenantServer) Tenant(name string) *Tenant {
+	server.mutex.RLock()
+	defer server.mutex.RUnlock()
+	return server.tenants[name]
+}
+
+// AcceptStats returns the stats of classifying connections into tenants.
+func (server *TenantServer) AcceptStats() AcceptStats {
+	return server.stats.get()
+}
+
+// Serve accepts connections of listener, it can be called with more
+// listeners in other goroutines.
+func (server *TenantServer) Serve(listener net.Listener) error {
+	server.mutex.Lock()
+	server.listeners = append(server.listeners, listener)
+	server.mutex.Unlock()
+	onTempError := func() {
+		atomic.AddUint64(&server.stats.acceptErrors, 1)
+	}
+	for {
+		waitTime := time.Now()
+		conn, err := accept(listener, onTempError)
+		if err != nil {
+			return err
+		}
+		acceptTime := time.Now()
+		server.stats.wait(acceptTime.Sub(waitTime))
+		server.stats.begin()
+		go server.serveConn(conn, acceptTime)
+	}
+}
+
+func (server *TenantServer) serveConn(conn net.Conn, acceptTime time.Time) {
+	name, classified, err := server.classify(conn)
+	if err != nil {
+		server.stats.reject(RejectHandshake)
+		server.stats.done(time.Time{})
+		conn.Close()
+		return
+	}
+	conn = classified
+	tenant := server.Tenant(name)
+	if tenant == nil {
+		server.stats.reject(RejectHandshake)
+		server.stats.done(time.Time{})
+		conn.Close()
+		return
+	}
+	server.stats.done(acceptTime)
+
+	tenant.stats.begin()
+	if !tenant.acquire() {
+		tenant.stats.reject(RejectLimit)
+		tenant.stats.done(time.Time{})
+		conn.Close()
+		return
+	}
+	codec, err := tenant.Protocol.NewCodec(conn)
+	if err != nil {
+		tenant.release()
+		tenant.stats.reject(RejectHandshake)
+		tenant.stats.done(time.Time{})
+		conn.Close()
+		return
+	}
+	if tenant.PacketRate > 0 {
+		codec = &tenantCodec{codec, NewTokenBucket(tenant.PacketRate, tenant.PacketBurst)}
+	}
+	session := newConnSession(tenant.manager, conn, codec, tenant.SendChanSize)
+	session.AddCloseCallback(tenant, "conns", tenant.release)
+	tenant.manager.putSession(session)
+	tenant.stats.done(acceptTime)
+
+	tenant.Handler.HandleSession(session)
+}
+
+func (tenant *Tenant) acquire() bool {
+	if tenant.MaxConns <= 0 {
+		atomic.AddInt64(&tenant.conns, 1)
+		return true
+	}
+	for {
+		conns := atomic.LoadInt64(&tenant.conns)
+		if conns >= int64(tenant.MaxConns) {
+			return false
+		}
+		if atomic.CompareAndSwapInt64(&tenant.conns, conns, conns+1) {
+			return true
+		}
+	}
+}
+
+func (tenant *Tenant) release() {
+	atomic.AddInt64(&tenant.conns, -1)
+}
+
+// Stop closes the listeners and the sessions of all tenants.
+func (server *TenantServer) Stop() {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+	for _, listener := range server.listeners {
+		listener.Close()
+	}
+	for _, tenant := range server.tenants {
+		tenant.manager.Dispose()
+	}
+	for _, tenant := range server.replaced {
+		tenant.manager.Dispose()
+	}
+}
+
+// tenantCodec waits for tokens before receiving each packet, so a client
+// sending too fast is slowed down by TCP flow control.
+type tenantCodec struct {
+	Codec
+	bucket *TokenBucket
+}
+
+func (c *tenantCodec) Receive() (interface{}, error) {
+	c.bucket.Wait(1)
+	return c.Codec.Receive()
+}
+
+func (c *tenantCodec) ClearSendChan(sendChan <-chan interface{}) {
+	if clear, ok := c.Codec.(ClearSendChan); ok {
+		clear.ClearSendChan(sendChan)
+	}
+}
+
+// ClassifyByPort classifies connections by the local port.
+func ClassifyByPort(tenants map[int]string) func(net.Conn) (string, net.Conn, error) {
+	return func(conn net.Conn) (string, net.Conn, error) {
+		_, port, err := net.SplitHostPort(conn.LocalAddr().String())
+		if err != nil {
+			return "", conn, err
+		}
+		n, err := strconv.Atoi(port)
+		if err != nil {
+			return "", conn, err
+		}
+		name, exists := tenants[n]
+		if !exists {
+			return "", conn, ErrUnknownTenant
+		}
+		return name, conn, nil
+	}
+}
+
+// ClassifyBySNI completes the TLS handshake with config, and takes the server
+// name sent by client as tenant name. A handshake takes longer than timeout
+// fails, zero means no limit.
+func ClassifyBySNI(config *tls.Config, timeout time.Duration) func(net.Conn) (string, net.Conn, error) {
+	return func(conn net.Conn) (string, net.Conn, error) {
+		tlsConn := tls.Server(conn, config)
+		if timeout > 0 {
+			tlsConn.SetDeadline(time.Now().Add(timeout))
+		}
+		if err := tlsConn.Handshake(); err != nil {
+			return "", conn, err
+		}
+		if timeout > 0 {
+			tlsConn.SetDeadline(time.Time{})
+		}
+		return tlsConn.ConnectionState().ServerName, tlsConn, nil
+	}
+}
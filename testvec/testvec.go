@@ -0,0 +1,108 @@
+// Package testvec generates wire format test vectors of a link.Protocol,
+// so clients in other languages can be checked against the exact framing.
+package testvec
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/funny/link"
+)
+
+var ErrNotRoundTrip = errors.New("Message Not Round Trip")
+
+// Case is a message to encode.
+type Case struct {
+	Name    string
+	Message interface{}
+}
+
+// Vector is the encoding of a case. Message is the JSON of the message,
+// Hex is the bytes on wire.
+type Vector struct {
+	Name    string          `json:"name"`
+	Message json.RawMessage `json:"message"`
+	Size    int             `json:"size"`
+	Hex     string          `json:"hex"`
+
+	bytes []byte
+}
+
+// Bytes returns the bytes on wire.
+func (v *Vector) Bytes() []byte {
+	return v.bytes
+}
+
+// Generate encodes each case with a new codec of protocol, and checks the
+// bytes decode back to the same message, compared as JSON.
+func Generate(protocol link.Protocol, cases []Case) ([]Vector, error) {
+	vectors := make([]Vector, 0, len(cases))
+	for _, c := range cases {
+		v, err := generate(protocol, c)
+		if err != nil {
+			return nil, errors.New(c.Name + ": " + err.Error())
+		}
+		vectors = append(vectors, v)
+	}
+	return vectors, nil
+}
+
+func generate(protocol link.Protocol, c Case) (Vector, error) {
+	var stream bytes.Buffer
+	codec, err := protocol.NewCodec(&stream)
+	if err != nil {
+		return Vector{}, err
+	}
+	if err := codec.Send(c.Message); err != nil {
+		return Vector{}, err
+	}
+	wire := append([]byte(nil), stream.Bytes()...)
+
+	message, err := json.Marshal(c.Message)
+	if err != nil {
+		return Vector{}, err
+	}
+	codec, err = protocol.NewCodec(&stream)
+	if err != nil {
+		return Vector{}, err
+	}
+	decoded, err := codec.Receive()
+	if err != nil {
+		return Vector{}, err
+	}
+	if data, err := json.Marshal(decoded); err != nil || !bytes.Equal(data, message) {
+		return Vector{}, ErrNotRoundTrip
+	}
+
+	return Vector{
+		Name:    c.Name,
+		Message: message,
+		Size:    len(wire),
+		Hex:     hex.EncodeToString(wire),
+		bytes:   wire,
+	}, nil
+}
+
+// WriteFiles writes each vector to dir as <name>.bin, and all of them
+// to dir/vectors.json.
+func WriteFiles(dir string, vectors []Vector) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	for i := range vectors {
+		path := filepath.Join(dir, vectors[i].Name+".bin")
+		if err := ioutil.WriteFile(path, vectors[i].bytes, 0644); err != nil {
+			return err
+		}
+	}
+	data, err := json.MarshalIndent(vectors, "", "\t")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filepath.Join(dir, "vectors.json"), data, 0644)
+}
@@ -0,0 +1,60 @@
+package testvec
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/funny/link/codec"
+	"github.com/funny/utest"
+)
+
+type Login struct {
+	User string
+	Seq  int
+}
+
+func Test_Generate(t *testing.T) {
+	jsonProtocol := codec.Json()
+	jsonProtocol.Register(Login{})
+	protocol := codec.FixLen(jsonProtocol, 2, binary.BigEndian, 1024, 1024)
+
+	vectors, err := Generate(protocol, []Case{
+		{"login", &Login{"alice", 1}},
+		{"empty", &Login{}},
+	})
+	utest.IsNilNow(t, err)
+	utest.EqualNow(t, len(vectors), 2)
+	wire := vectors[0].Bytes()
+	utest.EqualNow(t, int(binary.BigEndian.Uint16(wire)), len(wire)-2)
+	utest.EqualNow(t, vectors[0].Size, len(wire))
+
+	_, err = Generate(protocol, []Case{{"too_large", &Login{User: strings.Repeat("x", 2000)}}})
+	utest.NotNilNow(t, err)
+}
+
+func Test_WriteFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "testvec")
+	utest.IsNilNow(t, err)
+	defer os.RemoveAll(dir)
+
+	protocol := codec.FixLen(codec.Json(), 4, binary.LittleEndian, 1024, 1024)
+	vectors, err := Generate(protocol, []Case{{"map", map[string]int{"a": 1}}})
+	utest.IsNilNow(t, err)
+	utest.IsNilNow(t, WriteFiles(dir, vectors))
+
+	data, err := ioutil.ReadFile(filepath.Join(dir, "map.bin"))
+	utest.IsNilNow(t, err)
+	utest.Assert(t, bytes.Equal(data, vectors[0].Bytes()))
+
+	var index []Vector
+	data, err = ioutil.ReadFile(filepath.Join(dir, "vectors.json"))
+	utest.IsNilNow(t, err)
+	utest.IsNilNow(t, json.Unmarshal(data, &index))
+	utest.EqualNow(t, index[0].Hex, vectors[0].Hex)
+}
@@ -0,0 +1,150 @@
+package link
+
+import (
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"net"
+	"sync"
+	"time"
+)
+
+var ErrNotTLS = errors.New("Not TLS")
+
+// ListenTLS is Listen over TLS. Resumed sessions skip the full handshake, see
+// RotateTicketKeys for the server side and tls.Config.ClientSessionCache for
+// the client side.
+func ListenTLS(network, address string, config *tls.Config, protocol Protocol, sendChanSize int, handler Handler) (*Server, error) {
+	listener, err := tls.Listen(network, address, config)
+	if err != nil {
+		return nil, err
+	}
+	return NewServer(listener, protocol, sendChanSize, handler), nil
+}
+
+// DialTLS is Dial over TLS, it completes the handshake before returning. Set
+// config.ClientSessionCache, e.g. to tls.NewLRUClientSessionCache(0), so
+// mobile clients reconnecting often resume their TLS sessions.
+func DialTLS(network, address string, config *tls.Config, protocol Protocol, sendChanSize int) (*Session, error) {
+	return DialTLSTimeout(network, address, 0, config, protocol, sendChanSize)
+}
+
+func DialTLSTimeout(network, address string, timeout time.Duration, config *tls.Config, protocol Protocol, sendChanSize int) (*Session, error) {
+	conn, err := tls.DialWithDialer(&net.Dialer{Timeout: timeout}, network, address, config)
+	if err != nil {
+		return nil, err
+	}
+	codec, err := protocol.NewCodec(conn)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return newConnSession(nil, conn, codec, sendChanSize), nil
+}
+
+// TLSResumed tells whether the TLS connection of session resumed a previous
+// one. It is false before the handshake and for sessions not over TLS.
+func TLSResumed(session *Session) bool {
+	conn, ok := session.Conn().(*tls.Conn)
+	return ok && conn.ConnectionState().DidResume
+}
+
+// TLSState completes the handshake of the TLS connection of session if not
+// yet done, and returns its state, e.g. NegotiatedProtocol chosen by ALPN from
+// config.NextProtos or PeerCertificates. Servers may call it first in the
+// handler to check the peer before any message.
+func TLSState(session *Session) (tls.ConnectionState, error) {
+	conn, ok := session.Conn().(*tls.Conn)
+	if !ok {
+		return tls.ConnectionState{}, ErrNotTLS
+	}
+	if err := conn.Handshake(); err != nil {
+		session.Close()
+		return tls.ConnectionState{}, err
+	}
+	return conn.ConnectionState(), nil
+}
+
+// RequireClientCert makes a server config require client certificates signed
+// by one of roots, verify is called with the verified client certificate to
+// accept it or not, e.g. by its Subject. It may be nil.
+func RequireClientCert(config *tls.Config, roots *x509.CertPool, verify func(cert *x509.Certificate) error) {
+	config.ClientCAs = roots
+	config.ClientAuth = tls.RequireAndVerifyClientCert
+	if verify == nil {
+		config.VerifyConnection = nil
+		return
+	}
+	config.VerifyConnection = func(state tls.ConnectionState) error {
+		return verify(state.PeerCertificates[0])
+	}
+}
+
+// TicketKeys rotates the session ticket keys of a server TLS config. The
+// newest key encrypts new tickets, and the kept older ones still decrypt, so
+// a ticket stays valid for up to interval * keep.
+type TicketKeys struct {
+	mutex     sync.Mutex
+	config    *tls.Config
+	keep      int
+	keys      [][32]byte
+	closeOnce sync.Once
+	closeChan chan struct{}
+}
+
+// RotateTicketKeys sets a random ticket key to config now, and a new one every
+// interval if it is not zero.
+func RotateTicketKeys(config *tls.Config, interval time.Duration, keep int) (*TicketKeys, error) {
+	if keep < 1 {
+		keep = 1
+	}
+	keys := &TicketKeys{
+		config:    config,
+		keep:      keep,
+		closeChan: make(chan struct{}),
+	}
+	if err := keys.Rotate(); err != nil {
+		return nil, err
+	}
+	if interval > 0 {
+		go keys.loop(interval)
+	}
+	return keys, nil
+}
+
+func (keys *TicketKeys) loop(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			keys.Rotate()
+		case <-keys.closeChan:
+			return
+		}
+	}
+}
+
+// Rotate adds a new random key and drops the ones beyond keep.
+func (keys *TicketKeys) Rotate() error {
+	var key [32]byte
+	if _, err := rand.Read(key[:]); err != nil {
+		return err
+	}
+	keys.mutex.Lock()
+	defer keys.mutex.Unlock()
+	keys.keys = append([][32]byte{key}, keys.keys...)
+	if len(keys.keys) > keys.keep {
+		keys.keys = keys.keys[:keys.keep]
+	}
+	keys.config.SetSessionTicketKeys(keys.keys)
+	return nil
+}
+
+// Stop stops rotating, the current keys stay in use.
+func (keys *TicketKeys) Stop() {
+	keys.closeOnce.Do(func() {
+		close(keys.closeChan)
+	})
+}
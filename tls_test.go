@@ -0,0 +1,138 @@
+package link
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"math/big"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/funny/utest"
+)
+
+func newTestCertificate(t *testing.T) tls.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	utest.IsNilNow(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		DNSNames:     []string{"localhost"},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	utest.IsNilNow(t, err)
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+func Test_TLSResumption(t *testing.T) {
+	serverConfig := &tls.Config{Certificates: []tls.Certificate{newTestCertificate(t)}}
+	keys, err := RotateTicketKeys(serverConfig, 0, 1)
+	utest.IsNilNow(t, err)
+	defer keys.Stop()
+
+	server, err := ListenTLS("tcp", "127.0.0.1:0", serverConfig, ProtocolFunc(NewTestCodec), 0, HandlerFunc(func(session *Session) {
+		for {
+			msg, err := session.Receive()
+			if err != nil {
+				return
+			}
+			session.Send(msg)
+		}
+	}))
+	utest.IsNilNow(t, err)
+	go server.Serve()
+	defer server.Stop()
+
+	clientConfig := &tls.Config{
+		InsecureSkipVerify: true,
+		ClientSessionCache: tls.NewLRUClientSessionCache(0),
+	}
+	roundTrip := func() bool {
+		session, err := DialTLSTimeout("tcp", server.Listener().Addr().String(), time.Second, clientConfig, ProtocolFunc(NewTestCodec), 0)
+		utest.IsNilNow(t, err)
+		defer session.Close()
+		utest.IsNilNow(t, session.Send([]byte("hello")))
+		// The ticket of TLS 1.3 arrives after the handshake, with the reply.
+		msg, err := session.Receive()
+		utest.IsNilNow(t, err)
+		utest.EqualNow(t, string(msg.([]byte)), "hello")
+		return TLSResumed(session)
+	}
+
+	utest.Assert(t, !roundTrip())
+	utest.Assert(t, roundTrip())
+
+	// The only key is replaced, so the cached tickets are no longer valid.
+	utest.IsNilNow(t, keys.Rotate())
+	utest.Assert(t, !roundTrip())
+	utest.Assert(t, !TLSResumed(NewSession(newBlockTestCodec(), 0)))
+}
+
+func Test_TLSClientCert(t *testing.T) {
+	serverCert := newTestCertificate(t)
+	clientCert := newTestCertificate(t)
+	leaf, err := x509.ParseCertificate(clientCert.Certificate[0])
+	utest.IsNilNow(t, err)
+	roots := x509.NewCertPool()
+	roots.AddCert(leaf)
+
+	serverConfig := &tls.Config{
+		Certificates: []tls.Certificate{serverCert},
+		NextProtos:   []string{"game/2", "game/1"},
+	}
+	var allow int32 = 1
+	RequireClientCert(serverConfig, roots, func(cert *x509.Certificate) error {
+		if atomic.LoadInt32(&allow) == 0 {
+			return errors.New("denied")
+		}
+		return nil
+	})
+
+	protocols := make(chan string, 2)
+	server, err := ListenTLS("tcp", "127.0.0.1:0", serverConfig, ProtocolFunc(NewTestCodec), 0, HandlerFunc(func(session *Session) {
+		state, err := TLSState(session)
+		if err != nil {
+			protocols <- ""
+			return
+		}
+		protocols <- state.NegotiatedProtocol
+		session.Send([]byte("hello"))
+	}))
+	utest.IsNilNow(t, err)
+	go server.Serve()
+	defer server.Stop()
+
+	clientConfig := &tls.Config{
+		InsecureSkipVerify: true,
+		Certificates:       []tls.Certificate{clientCert},
+		NextProtos:         []string{"game/1"},
+	}
+	session, err := DialTLS("tcp", server.Listener().Addr().String(), clientConfig, ProtocolFunc(NewTestCodec), 0)
+	utest.IsNilNow(t, err)
+	defer session.Close()
+	state, err := TLSState(session)
+	utest.IsNilNow(t, err)
+	utest.EqualNow(t, state.NegotiatedProtocol, "game/1")
+	utest.EqualNow(t, <-protocols, "game/1")
+	msg, err := session.Receive()
+	utest.IsNilNow(t, err)
+	utest.EqualNow(t, string(msg.([]byte)), "hello")
+
+	// A client refused by verify gets no message.
+	atomic.StoreInt32(&allow, 0)
+	session, err = DialTLS("tcp", server.Listener().Addr().String(), clientConfig, ProtocolFunc(NewTestCodec), 0)
+	if err == nil {
+		_, err = session.Receive()
+		session.Close()
+	}
+	utest.NotNilNow(t, err)
+	utest.EqualNow(t, <-protocols, "")
+
+	_, err = TLSState(NewSession(newBlockTestCodec(), 0))
+	utest.EqualNow(t, err, ErrNotTLS)
+}
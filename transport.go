@@ -0,0 +1,102 @@
+package link
+
+import (
+	"errors"
+	"net"
+	"os"
+	"syscall"
+	"time"
+)
+
+// Transport creates the connections of sessions, so protocols and sessions
+// run the same over TCP, rudp, or streams of kcp-go or quic-go wrapped into
+// net.Conn.
+type Transport interface {
+	Listen(address string) (net.Listener, error)
+	Dial(address string, timeout time.Duration) (net.Conn, error)
+}
+
+// NetTransport is the transport of the net package for network, like "tcp"
+// or "unix". A unix address starting with @ is an abstract name on Linux,
+// which has no socket file.
+type NetTransport string
+
+// Listen removes the socket file of a unix address left by a dead process,
+// one nothing listens on, before listening.
+func (network NetTransport) Listen(address string) (net.Listener, error) {
+	if (network == "unix" || network == "unixpacket") && len(address) > 0 && address[0] != '@' {
+		removeStaleSocket(string(network), address)
+	}
+	return net.Listen(string(network), address)
+}
+
+func removeStaleSocket(network, address string) {
+	info, err := os.Lstat(address)
+	if err != nil || info.Mode()&os.ModeSocket == 0 {
+		return
+	}
+	conn, err := net.Dial(network, address)
+	if err == nil {
+		conn.Close()
+		return
+	}
+	if errors.Is(err, syscall.ECONNREFUSED) {
+		os.Remove(address)
+	}
+}
+
+func (network NetTransport) Dial(address string, timeout time.Duration) (net.Conn, error) {
+	return net.DialTimeout(string(network), address, timeout)
+}
+
+// ListenTransport is Listen over transport.
+func ListenTransport(transport Transport, address string, protocol Protocol, sendChanSize int, handler Handler) (*Server, error) {
+	listener, err := transport.Listen(address)
+	if err != nil {
+		return nil, err
+	}
+	return NewServer(listener, protocol, sendChanSize, handler), nil
+}
+
+// DialTransport is DialTimeout over transport, a zero timeout means none.
+func DialTransport(transport Transport, address string, timeout time.Duration, protocol Protocol, sendChanSize int) (*Session, error) {
+	conn, err := transport.Dial(address, timeout)
+	if err != nil {
+		return nil, err
+	}
+	codec, err := protocol.NewCodec(conn)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return newConnSession(nil, conn, codec, sendChanSize), nil
+}
+
+// Pipe returns two sessions connected in memory by net.Pipe, for tests
+// without sockets. The codecs are created at the same time, so protocols may
+// handshake. A write on a pipe waits for the read of the other end, so give
+// the sessions a send channel when both send before receiving.
+func Pipe(protocol Protocol, sendChanSize int) (*Session, *Session, error) {
+	conn1, conn2 := net.Pipe()
+	var codec2 Codec
+	var err2 error
+	done := make(chan struct{})
+	go func() {
+		codec2, err2 = protocol.NewCodec(conn2)
+		close(done)
+	}()
+	codec1, err1 := protocol.NewCodec(conn1)
+	if err1 != nil {
+		conn1.Close()
+	}
+	<-done
+	if err1 != nil || err2 != nil {
+		conn1.Close()
+		conn2.Close()
+		if err1 != nil {
+			return nil, nil, err1
+		}
+		return nil, nil, err2
+	}
+	return newConnSession(nil, conn1, codec1, sendChanSize), newConnSession(nil, conn2, codec2, sendChanSize), nil
+}
@@ -0,0 +1,100 @@
+//go:build go1.18
+// +build go1.18
+
+package link
+
+import (
+	"sync"
+)
+
+// TypedChannel broadcasts messages of type T to the subscribed sessions.
+type TypedChannel[T any] struct {
+	mutex  sync.RWMutex
+	encode func(T) (interface{}, error)
+	subs   map[KEY]*subscriber[T]
+}
+
+type subscriber[T any] struct {
+	session *Session
+	filter  func(T) bool
+}
+
+// NewTypedChannel creates a channel that converts each message with encode
+// once before fan out, e.g. into the []byte sent as is by the codecs of the
+// sessions. The encoded message is shared by all sessions so it must not be
+// changed after. A nil encode sends messages as they are.
+func NewTypedChannel[T any](encode func(T) (interface{}, error)) *TypedChannel[T] {
+	return &TypedChannel[T]{
+		encode: encode,
+		subs:   make(map[KEY]*subscriber[T]),
+	}
+}
+
+func (channel *TypedChannel[T]) Len() int {
+	channel.mutex.RLock()
+	defer channel.mutex.RUnlock()
+	return len(channel.subs)
+}
+
+// Subscribe adds the session with key, a nil filter means all messages.
+// The session is removed when closed.
+func (channel *TypedChannel[T]) Subscribe(key KEY, session *Session, filter func(T) bool) {
+	channel.mutex.Lock()
+	defer channel.mutex.Unlock()
+	if sub, exists := channel.subs[key]; exists {
+		sub.session.RemoveCloseCallback(channel, key)
+	}
+	session.AddCloseCallback(channel, key, func() {
+		channel.Unsubscribe(key)
+	})
+	channel.subs[key] = &subscriber[T]{session, filter}
+}
+
+func (channel *TypedChannel[T]) Unsubscribe(key KEY) bool {
+	channel.mutex.Lock()
+	defer channel.mutex.Unlock()
+	sub, exists := channel.subs[key]
+	if exists {
+		sub.session.RemoveCloseCallback(channel, key)
+		delete(channel.subs, key)
+	}
+	return exists
+}
+
+// Publish sends msg to the sessions its filters accept, and returns the
+// number of them. A session fails to send is closed and unsubscribed.
+func (channel *TypedChannel[T]) Publish(msg T) (int, error) {
+	var encoded interface{} = msg
+	if channel.encode != nil {
+		var err error
+		if encoded, err = channel.encode(msg); err != nil {
+			return 0, err
+		}
+	}
+
+	channel.mutex.RLock()
+	sessions := make([]*Session, 0, len(channel.subs))
+	for _, sub := range channel.subs {
+		if sub.filter == nil || sub.filter(msg) {
+			sessions = append(sessions, sub.session)
+		}
+	}
+	channel.mutex.RUnlock()
+
+	n := 0
+	for _, session := range sessions {
+		if session.Send(encoded) == nil {
+			n++
+		}
+	}
+	return n, nil
+}
+
+func (channel *TypedChannel[T]) Close() {
+	channel.mutex.Lock()
+	defer channel.mutex.Unlock()
+	for key, sub := range channel.subs {
+		sub.session.RemoveCloseCallback(channel, key)
+		delete(channel.subs, key)
+	}
+}
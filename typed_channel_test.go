@@ -0,0 +1,49 @@
+//go:build go1.18
+// +build go1.18
+
+package link
+
+import (
+	"strconv"
+	"testing"
+
+	"github.com/funny/utest"
+)
+
+type recordCodec struct {
+	sent []interface{}
+}
+
+func (c *recordCodec) Receive() (interface{}, error) { select {} }
+func (c *recordCodec) Send(msg interface{}) error    { c.sent = append(c.sent, msg); return nil }
+func (c *recordCodec) Close() error                  { return nil }
+
+func Test_TypedChannel(t *testing.T) {
+	encodes := 0
+	channel := NewTypedChannel(func(n int) (interface{}, error) {
+		encodes++
+		return []byte(strconv.Itoa(n)), nil
+	})
+	manager := NewManager()
+	c1, c2 := &recordCodec{}, &recordCodec{}
+	s1 := manager.NewSession(c1, 0)
+	s2 := manager.NewSession(c2, 0)
+	channel.Subscribe(1, s1, nil)
+	channel.Subscribe(2, s2, func(n int) bool { return n%2 == 0 })
+
+	for i := 1; i <= 4; i++ {
+		channel.Publish(i)
+	}
+	utest.EqualNow(t, encodes, 4)
+	utest.EqualNow(t, len(c1.sent), 4)
+	utest.EqualNow(t, len(c2.sent), 2)
+	utest.EqualNow(t, string(c2.sent[1].([]byte)), "4")
+
+	s1.Close()
+	n, err := channel.Publish(6)
+	utest.IsNilNow(t, err)
+	utest.EqualNow(t, n, 1)
+
+	utest.Assert(t, channel.Unsubscribe(2))
+	utest.Assert(t, !channel.Unsubscribe(2))
+}
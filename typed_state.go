@@ -0,0 +1,40 @@
+//go:build go1.18
+// +build go1.18
+
+package link
+
+// StateKey is a key of values of type T attached to sessions, so the values
+// need no type assertions. Keys are distinct even with the same name.
+type StateKey[T any] struct {
+	name string
+}
+
+// NewStateKey creates a key, name is only for debugging.
+func NewStateKey[T any](name string) *StateKey[T] {
+	return &StateKey[T]{name}
+}
+
+func (key *StateKey[T]) String() string {
+	return key.name
+}
+
+// Set attaches value to session under the key.
+func (key *StateKey[T]) Set(session *Session, value T) {
+	session.Set(key, value)
+}
+
+// Get returns the value attached to session under the key, the zero value
+// and false if there is none.
+func (key *StateKey[T]) Get(session *Session) (T, bool) {
+	value, ok := session.Get(key)
+	if !ok {
+		var zero T
+		return zero, false
+	}
+	return value.(T), true
+}
+
+// Delete removes the value attached to session under the key.
+func (key *StateKey[T]) Delete(session *Session) {
+	session.Delete(key)
+}
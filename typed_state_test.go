@@ -0,0 +1,54 @@
+//go:build go1.18
+// +build go1.18
+
+package link
+
+import (
+	"testing"
+	"time"
+
+	"github.com/funny/utest"
+)
+
+func Test_SessionState(t *testing.T) {
+	session := NewSession(&recordCodec{}, 0)
+
+	userID := NewStateKey[uint64]("user id")
+	otherID := NewStateKey[uint64]("user id")
+	_, ok := userID.Get(session)
+	utest.Assert(t, !ok)
+
+	userID.Set(session, 42)
+	id, ok := userID.Get(session)
+	utest.Assert(t, ok)
+	utest.EqualNow(t, id, uint64(42))
+	_, ok = otherID.Get(session)
+	utest.Assert(t, !ok)
+
+	session.Set("auth", true)
+	auth, ok := session.Get("auth")
+	utest.Assert(t, ok)
+	utest.EqualNow(t, auth, true)
+	session.Delete("auth")
+	_, ok = session.Get("auth")
+	utest.Assert(t, !ok)
+
+	// The values are still there for the close callbacks, then dropped.
+	closed := make(chan uint64, 1)
+	session.AddCloseCallback(nil, nil, func() {
+		id, _ := userID.Get(session)
+		closed <- id
+	})
+	session.Close()
+	utest.EqualNow(t, <-closed, uint64(42))
+	for i := 0; i < 100; i++ {
+		if _, ok = userID.Get(session); !ok {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	utest.Assert(t, !ok)
+	userID.Set(session, 1)
+	_, ok = userID.Get(session)
+	utest.Assert(t, !ok)
+}
@@ -0,0 +1,194 @@
+// Package udp runs link sessions over plain UDP, each datagram is one packet.
+// Use it with a protocol writing each packet in one Write and reading it in
+// one Read, like codec.Datagram, no delivery or order is guaranteed.
+//
+// The Listener demultiplexes the datagrams of a socket into one Conn per
+// remote address, closed after an idle time:
+//
+//	listener, err := udp.Listen("udp", "0.0.0.0:8000", time.Minute)
+//	server := link.NewServer(listener, codec.Datagram(base, 1400), 0, handler)
+//
+// Clients can use a connected socket from net.Dial("udp", address).
+package udp
+
+import (
+	"errors"
+	"net"
+	"sync"
+	"time"
+)
+
+var ErrIdleTimeout = errors.New("Idle Timeout")
+
+// Conn is the datagrams of a remote address received by a Listener.
+type Conn struct {
+	pc      net.PacketConn
+	addr    net.Addr
+	onClose func()
+
+	mutex    sync.Mutex
+	queue    [][]byte
+	lastRecv time.Time
+	err      error
+
+	readDeadline time.Time
+	readChan     chan struct{}
+	closeOnce    sync.Once
+	closeChan    chan struct{}
+}
+
+func newConn(pc net.PacketConn, addr net.Addr, onClose func()) *Conn {
+	return &Conn{
+		pc:        pc,
+		addr:      addr,
+		onClose:   onClose,
+		lastRecv:  time.Now(),
+		readChan:  make(chan struct{}, 1),
+		closeChan: make(chan struct{}),
+	}
+}
+
+func notify(ch chan struct{}) {
+	select {
+	case ch <- struct{}{}:
+	default:
+	}
+}
+
+// input queues a copy of b, it is dropped when backlog datagrams are unread.
+func (c *Conn) input(b []byte, backlog int) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.lastRecv = time.Now()
+	if c.err != nil || len(c.queue) >= backlog {
+		return
+	}
+	c.queue = append(c.queue, append([]byte(nil), b...))
+	notify(c.readChan)
+}
+
+func (c *Conn) idle(now time.Time, timeout time.Duration) bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return now.Sub(c.lastRecv) >= timeout
+}
+
+// wait blocks until a datagram is queued, the connection closed, or deadline.
+func (c *Conn) wait(deadline time.Time) error {
+	if deadline.IsZero() {
+		select {
+		case <-c.readChan:
+		case <-c.closeChan:
+		}
+		return nil
+	}
+	d := time.Until(deadline)
+	if d <= 0 {
+		return timeoutError{}
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-c.readChan:
+	case <-c.closeChan:
+	case <-timer.C:
+		return timeoutError{}
+	}
+	return nil
+}
+
+// Read reads one datagram, the bytes beyond len(b) are discarded.
+func (c *Conn) Read(b []byte) (int, error) {
+	for {
+		c.mutex.Lock()
+		if len(c.queue) > 0 {
+			n := copy(b, c.queue[0])
+			c.queue[0] = nil
+			c.queue = c.queue[1:]
+			c.mutex.Unlock()
+			return n, nil
+		}
+		err := c.err
+		deadline := c.readDeadline
+		c.mutex.Unlock()
+		if err != nil {
+			return 0, c.opError("read", err)
+		}
+		if err := c.wait(deadline); err != nil {
+			return 0, c.opError("read", err)
+		}
+	}
+}
+
+// Write sends b as one datagram.
+func (c *Conn) Write(b []byte) (int, error) {
+	c.mutex.Lock()
+	err := c.err
+	c.mutex.Unlock()
+	if err != nil {
+		return 0, c.opError("write", err)
+	}
+	n, err := c.pc.WriteTo(b, c.addr)
+	if err != nil {
+		return n, c.opError("write", err)
+	}
+	return n, nil
+}
+
+func (c *Conn) fail(err error) {
+	c.mutex.Lock()
+	if c.err == nil {
+		c.err = err
+	}
+	c.mutex.Unlock()
+	c.closeOnce.Do(func() {
+		close(c.closeChan)
+		c.onClose()
+	})
+}
+
+func (c *Conn) Close() error {
+	c.mutex.Lock()
+	closed := c.err != nil
+	c.mutex.Unlock()
+	if closed {
+		return c.opError("close", net.ErrClosed)
+	}
+	c.fail(net.ErrClosed)
+	return nil
+}
+
+func (c *Conn) opError(op string, err error) error {
+	return &net.OpError{Op: op, Net: c.addr.Network(), Source: c.pc.LocalAddr(), Addr: c.addr, Err: err}
+}
+
+func (c *Conn) LocalAddr() net.Addr {
+	return c.pc.LocalAddr()
+}
+
+func (c *Conn) RemoteAddr() net.Addr {
+	return c.addr
+}
+
+func (c *Conn) SetDeadline(t time.Time) error {
+	return c.SetReadDeadline(t)
+}
+
+func (c *Conn) SetReadDeadline(t time.Time) error {
+	c.mutex.Lock()
+	c.readDeadline = t
+	c.mutex.Unlock()
+	notify(c.readChan)
+	return nil
+}
+
+// SetWriteDeadline does nothing, a datagram is written without waiting.
+func (c *Conn) SetWriteDeadline(t time.Time) error {
+	return nil
+}
+
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
@@ -0,0 +1,153 @@
+package udp
+
+import (
+	"net"
+	"sync"
+	"time"
+)
+
+// Backlog is the datagrams of a Conn received but not read before new ones
+// are dropped.
+const Backlog = 256
+
+// Listener accepts a Conn for each remote address sending to a PacketConn.
+type Listener struct {
+	pc          net.PacketConn
+	idleTimeout time.Duration
+
+	mutex  sync.Mutex
+	conns  map[string]*Conn
+	err    error
+	accept chan *Conn
+
+	closeOnce sync.Once
+	closeChan chan struct{}
+}
+
+func Listen(network, address string, idleTimeout time.Duration) (*Listener, error) {
+	pc, err := net.ListenPacket(network, address)
+	if err != nil {
+		return nil, err
+	}
+	return NewListener(pc, idleTimeout), nil
+}
+
+// NewListener serves the remote addresses of pc, which is closed by Close. A
+// Conn receiving nothing for idleTimeout fails with ErrIdleTimeout, zero
+// means never.
+func NewListener(pc net.PacketConn, idleTimeout time.Duration) *Listener {
+	l := &Listener{
+		pc:          pc,
+		idleTimeout: idleTimeout,
+		conns:       make(map[string]*Conn),
+		accept:      make(chan *Conn, 128),
+		closeChan:   make(chan struct{}),
+	}
+	go l.readLoop()
+	if idleTimeout > 0 {
+		go l.expireLoop()
+	}
+	return l
+}
+
+func (l *Listener) readLoop() {
+	buf := make([]byte, 64*1024)
+	for {
+		n, addr, err := l.pc.ReadFrom(buf)
+		if err != nil {
+			l.close(err)
+			return
+		}
+		if c := l.conn(addr); c != nil {
+			c.input(buf[:n], Backlog)
+		}
+	}
+}
+
+// conn finds the connection of addr, a new one starts with its first datagram.
+func (l *Listener) conn(addr net.Addr) *Conn {
+	key := addr.String()
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if c := l.conns[key]; c != nil {
+		return c
+	}
+	if l.err != nil {
+		return nil
+	}
+	var c *Conn
+	c = newConn(l.pc, addr, func() {
+		l.mutex.Lock()
+		defer l.mutex.Unlock()
+		if l.conns[key] == c {
+			delete(l.conns, key)
+		}
+	})
+	select {
+	case l.accept <- c:
+	default:
+		// The backlog is full, the datagram is dropped.
+		return nil
+	}
+	l.conns[key] = c
+	return c
+}
+
+func (l *Listener) expireLoop() {
+	ticker := time.NewTicker(l.idleTimeout / 2)
+	defer ticker.Stop()
+	for {
+		select {
+		case now := <-ticker.C:
+			var expired []*Conn
+			l.mutex.Lock()
+			for _, c := range l.conns {
+				if c.idle(now, l.idleTimeout) {
+					expired = append(expired, c)
+				}
+			}
+			l.mutex.Unlock()
+			for _, c := range expired {
+				c.fail(ErrIdleTimeout)
+			}
+		case <-l.closeChan:
+			return
+		}
+	}
+}
+
+func (l *Listener) Accept() (net.Conn, error) {
+	select {
+	case c := <-l.accept:
+		return c, nil
+	case <-l.closeChan:
+		l.mutex.Lock()
+		defer l.mutex.Unlock()
+		return nil, &net.OpError{Op: "accept", Net: l.pc.LocalAddr().Network(), Addr: l.pc.LocalAddr(), Err: l.err}
+	}
+}
+
+func (l *Listener) close(err error) {
+	l.closeOnce.Do(func() {
+		l.mutex.Lock()
+		l.err = err
+		conns := l.conns
+		l.conns = make(map[string]*Conn)
+		l.mutex.Unlock()
+		close(l.closeChan)
+		l.pc.Close()
+		for _, c := range conns {
+			c.fail(err)
+		}
+	})
+}
+
+// Close closes the PacketConn, so the accepted connections too.
+func (l *Listener) Close() error {
+	l.close(net.ErrClosed)
+	return nil
+}
+
+func (l *Listener) Addr() net.Addr {
+	return l.pc.LocalAddr()
+}
@@ -0,0 +1,86 @@
+package udp
+
+import (
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/funny/link"
+	"github.com/funny/link/codec"
+	"github.com/funny/utest"
+)
+
+func Test_Session(t *testing.T) {
+	listener, err := Listen("udp", "127.0.0.1:0", time.Minute)
+	utest.IsNilNow(t, err)
+	protocol := codec.Datagram(codec.Raw(), 1400)
+	server := link.NewServer(listener, protocol, 0, link.HandlerFunc(func(session *link.Session) {
+		for {
+			msg, err := session.Receive()
+			if err != nil {
+				return
+			}
+			session.Send(msg)
+		}
+	}))
+	go server.Serve()
+	defer server.Stop()
+
+	dial := func() *link.Session {
+		conn, err := net.Dial("udp", listener.Addr().String())
+		utest.IsNilNow(t, err)
+		conn.SetDeadline(time.Now().Add(5 * time.Second))
+		c, _ := protocol.NewCodec(conn)
+		return link.NewSession(c, 0)
+	}
+	session1, session2 := dial(), dial()
+	defer session1.Close()
+	defer session2.Close()
+
+	for i := 0; i < 10; i++ {
+		msg := make([]byte, 1000+i)
+		msg[i] = byte(i)
+		utest.IsNilNow(t, session1.Send(msg))
+		recv, err := session1.Receive()
+		utest.IsNilNow(t, err)
+		utest.EqualNow(t, recv.(*codec.InBuffer).Bytes(), msg)
+
+		utest.IsNilNow(t, session2.Send([]byte{byte(i)}))
+		recv, err = session2.Receive()
+		utest.IsNilNow(t, err)
+		utest.EqualNow(t, recv.(*codec.InBuffer).Bytes(), []byte{byte(i)})
+	}
+	utest.EqualNow(t, server.Manager().Len(), 2)
+	utest.EqualNow(t, session1.Send(make([]byte, 1401)), codec.ErrTooLargePacket)
+}
+
+func Test_IdleTimeout(t *testing.T) {
+	listener, err := Listen("udp", "127.0.0.1:0", 50*time.Millisecond)
+	utest.IsNilNow(t, err)
+	defer listener.Close()
+
+	conn, err := net.Dial("udp", listener.Addr().String())
+	utest.IsNilNow(t, err)
+	defer conn.Close()
+	conn.Write([]byte("hello"))
+
+	server, err := listener.Accept()
+	utest.IsNilNow(t, err)
+	b := make([]byte, 3)
+	n, err := server.Read(b)
+	utest.IsNilNow(t, err)
+	utest.EqualNow(t, string(b[:n]), "hel")
+
+	_, err = server.Read(b)
+	utest.Assert(t, errors.Is(err, ErrIdleTimeout))
+
+	// The address starts a new connection.
+	conn.Write([]byte("again"))
+	server, err = listener.Accept()
+	utest.IsNilNow(t, err)
+	n, err = server.Read(b)
+	utest.IsNilNow(t, err)
+	utest.EqualNow(t, string(b[:n]), "aga")
+	server.Close()
+}
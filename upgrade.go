@@ -0,0 +1,73 @@
+package link
+
+import (
+	"errors"
+	"io"
+)
+
+var ErrNoConn = errors.New("No Connection")
+
+// Releaser is a codec with goroutines or timers of its own, like
+// codec.Batch. The codec replaced by SetProtocol is not closed, it shares the
+// connection, Release must stop them instead.
+type Releaser interface {
+	Release()
+}
+
+// codecSwitch makes the send loop use codec for the messages queued after it.
+type codecSwitch struct {
+	codec Codec
+}
+
+// SetProtocol switches the session to protocol between two messages, e.g. to
+// a compressed or encrypted one after a plaintext handshake. The messages
+// received after it are decoded by the new codec, and the messages sent
+// before it are still encoded by the old one. It must be called by the
+// goroutine receiving messages, and the old codec must not have read ahead
+// the bytes of the new one, like Bufio may. The old codec is flushed and, if
+// it is a Releaser, released once the messages before the switch are sent.
+// The session needs a connection, e.g. created by a Server or Dial.
+func (session *Session) SetProtocol(protocol Protocol) error {
+	if session.conn == nil {
+		return ErrNoConn
+	}
+	var rw io.ReadWriter = session.conn
+	if session.counter != nil {
+		rw = session.counter
+	}
+	codec, err := protocol.NewCodec(rw)
+	if err != nil {
+		return err
+	}
+
+	session.recvMutex.Lock()
+	session.codecMutex.Lock()
+	session.codec = codec
+	session.codecMutex.Unlock()
+	session.recvMutex.Unlock()
+
+	if session.sendChan == nil {
+		session.sendMutex.Lock()
+		err = session.switchSendCodec(codec)
+		session.sendMutex.Unlock()
+		if err != nil {
+			session.fail(err)
+		}
+		return err
+	}
+
+	session.sendMutex.RLock()
+	if session.IsClosed() {
+		session.sendMutex.RUnlock()
+		return SessionClosedError
+	}
+	// The switch must not be dropped, a full channel closes the session
+	// unless the send policy waits or makes room.
+	err = session.enqueue(&codecSwitch{codec}, nil)
+	session.sendMutex.RUnlock()
+	if err == SessionBlockedError || err == MessageDroppedError {
+		session.closeWith(SessionBlockedError)
+		return SessionBlockedError
+	}
+	return err
+}
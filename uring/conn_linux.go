@@ -0,0 +1,208 @@
+//go:build linux && uring
+// +build linux,uring
+
+package uring
+
+import (
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// Conn is a connection reading and writing through a Ring.
+type Conn struct {
+	ring          *Ring
+	fd            int
+	localAddr     net.Addr
+	remoteAddr    net.Addr
+	readMutex     sync.Mutex
+	writeMutex    sync.Mutex
+	readOp        op
+	writeOp       op
+	readDeadline  int64
+	writeDeadline int64
+	closeFlag     int32
+}
+
+// Wrap moves conn, a TCP or Unix connection, onto the ring. conn is closed
+// and must not be used any more.
+func (ring *Ring) Wrap(conn net.Conn) (*Conn, error) {
+	sc, ok := conn.(syscall.Conn)
+	if !ok {
+		conn.Close()
+		return nil, syscall.EINVAL
+	}
+	raw, err := sc.SyscallConn()
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	fd := -1
+	err2 := raw.Control(func(s uintptr) {
+		fd, err = syscall.Dup(int(s))
+	})
+	localAddr, remoteAddr := conn.LocalAddr(), conn.RemoteAddr()
+	conn.Close()
+	if err2 != nil {
+		return nil, err2
+	}
+	if err != nil {
+		return nil, err
+	}
+	// The ring waits for readiness itself, the blocking mode doesn't matter
+	// to it but has to be the same on old kernels.
+	if err := syscall.SetNonblock(fd, false); err != nil {
+		syscall.Close(fd)
+		return nil, err
+	}
+	c := &Conn{
+		ring:       ring,
+		fd:         fd,
+		localAddr:  localAddr,
+		remoteAddr: remoteAddr,
+	}
+	c.readOp = op{opcode: opRecv, fd: int32(fd), done: make(chan int32, 1)}
+	c.writeOp = op{opcode: opSend, fd: int32(fd), flags: syscall.MSG_NOSIGNAL, done: make(chan int32, 1)}
+	return c, nil
+}
+
+func (c *Conn) Read(b []byte) (int, error) {
+	c.readMutex.Lock()
+	defer c.readMutex.Unlock()
+	if atomic.LoadInt32(&c.closeFlag) == 1 {
+		return 0, c.opError("read", net.ErrClosed)
+	}
+	if len(b) == 0 {
+		return 0, nil
+	}
+	c.readOp.buf = b
+	n, err := c.ring.do(&c.readOp, atomic.LoadInt64(&c.readDeadline))
+	c.readOp.buf = nil
+	if atomic.LoadInt32(&c.closeFlag) == 1 {
+		return 0, c.opError("read", net.ErrClosed)
+	}
+	if err != nil {
+		return 0, c.opError("read", err)
+	}
+	if n == 0 {
+		return 0, io.EOF
+	}
+	return n, nil
+}
+
+func (c *Conn) Write(b []byte) (int, error) {
+	c.writeMutex.Lock()
+	defer c.writeMutex.Unlock()
+	written := 0
+	for written < len(b) {
+		if atomic.LoadInt32(&c.closeFlag) == 1 {
+			return written, c.opError("write", net.ErrClosed)
+		}
+		c.writeOp.buf = b[written:]
+		n, err := c.ring.do(&c.writeOp, atomic.LoadInt64(&c.writeDeadline))
+		c.writeOp.buf = nil
+		if err != nil {
+			return written, c.opError("write", err)
+		}
+		written += n
+	}
+	return written, nil
+}
+
+func (c *Conn) opError(op string, err error) error {
+	return &net.OpError{Op: op, Net: c.localAddr.Network(), Source: c.localAddr, Addr: c.remoteAddr, Err: err}
+}
+
+// Close shuts the connection down, so the calls in flight return, then
+// closes it once they did.
+func (c *Conn) Close() error {
+	if !atomic.CompareAndSwapInt32(&c.closeFlag, 0, 1) {
+		return c.opError("close", net.ErrClosed)
+	}
+	syscall.Shutdown(c.fd, syscall.SHUT_RDWR)
+	// The fd can't be reused by another connection while a call still
+	// refers to it.
+	c.readMutex.Lock()
+	c.writeMutex.Lock()
+	defer c.readMutex.Unlock()
+	defer c.writeMutex.Unlock()
+	return syscall.Close(c.fd)
+}
+
+func (c *Conn) LocalAddr() net.Addr {
+	return c.localAddr
+}
+
+func (c *Conn) RemoteAddr() net.Addr {
+	return c.remoteAddr
+}
+
+func (c *Conn) SetDeadline(t time.Time) error {
+	c.SetReadDeadline(t)
+	return c.SetWriteDeadline(t)
+}
+
+// SetReadDeadline sets the deadline of the next reads. A read in flight is
+// only affected by a deadline in the past, which cancels it.
+func (c *Conn) SetReadDeadline(t time.Time) error {
+	c.setDeadline(&c.readDeadline, &c.readOp, t)
+	return nil
+}
+
+// SetWriteDeadline sets the deadline of the next writes. A write in flight is
+// only affected by a deadline in the past, which cancels it.
+func (c *Conn) SetWriteDeadline(t time.Time) error {
+	c.setDeadline(&c.writeDeadline, &c.writeOp, t)
+	return nil
+}
+
+func (c *Conn) setDeadline(deadline *int64, o *op, t time.Time) {
+	if t.IsZero() {
+		atomic.StoreInt64(deadline, 0)
+		return
+	}
+	atomic.StoreInt64(deadline, t.UnixNano())
+	if !t.After(time.Now()) {
+		if id := atomic.LoadUint64(&o.id); id != 0 {
+			c.ring.cancel(id)
+		}
+	}
+}
+
+type listener struct {
+	net.Listener
+	ring *Ring
+}
+
+// Listen announces on the local network address, the accepted connections
+// are moved onto the ring.
+func (ring *Ring) Listen(network, address string) (net.Listener, error) {
+	l, err := net.Listen(network, address)
+	if err != nil {
+		return nil, err
+	}
+	return &listener{l, ring}, nil
+}
+
+func (l *listener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	c, err := l.ring.Wrap(conn)
+	if err != nil {
+		// Other connections can still be accepted.
+		return nil, &net.OpError{Op: "accept", Net: l.Addr().Network(), Addr: l.Addr(), Err: temporaryError{err}}
+	}
+	return c, nil
+}
+
+type temporaryError struct {
+	error
+}
+
+func (temporaryError) Temporary() bool { return true }
+func (temporaryError) Timeout() bool   { return false }
@@ -0,0 +1,13 @@
+// Package uring is an experimental Linux transport submitting the reads and
+// writes of many connections to one io_uring, so a server with hundreds of
+// thousands of busy connections makes one io_uring_enter for a batch of
+// calls instead of a syscall per call.
+//
+// It is built only with the uring tag on Linux 5.6 or later:
+//
+//	go build -tags uring
+//
+//	ring, err := uring.NewRing(4096)
+//	listener, err := ring.Listen("tcp", "0.0.0.0:8000")
+//	server := link.NewServer(listener, protocol, 0, handler)
+package uring
@@ -0,0 +1,432 @@
+//go:build linux && uring
+// +build linux,uring
+
+package uring
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+var ErrRingClosed = errors.New("Ring Closed")
+
+const (
+	sysSetup = 425
+	sysEnter = 426
+
+	opNop         = 0
+	opAsyncCancel = 14
+	opLinkTimeout = 15
+	opSend        = 26
+	opRecv        = 27
+
+	sqeIOLink        = 1 << 2
+	enterGetEvents   = 1 << 0
+	featSingleMmap   = 1 << 0
+	offSQRing        = 0
+	offCQRing        = 0x8000000
+	offSQEs          = 0x10000000
+	closeUserData    = ^uint64(0)
+	ignoredUserData  = 0
+	submitQueueDepth = 4096
+)
+
+type params struct {
+	sqEntries    uint32
+	cqEntries    uint32
+	flags        uint32
+	sqThreadCPU  uint32
+	sqThreadIdle uint32
+	features     uint32
+	wqFd         uint32
+	resv         [3]uint32
+	sqOff        struct {
+		head, tail, ringMask, ringEntries, flags, dropped, array, resv1 uint32
+		userAddr                                                        uint64
+	}
+	cqOff struct {
+		head, tail, ringMask, ringEntries, overflow, cqes, flags, resv1 uint32
+		userAddr                                                        uint64
+	}
+}
+
+type sqe struct {
+	opcode      uint8
+	flags       uint8
+	ioprio      uint16
+	fd          int32
+	off         uint64
+	addr        uint64
+	len         uint32
+	opFlags     uint32
+	userData    uint64
+	bufIndex    uint16
+	personality uint16
+	spliceFdIn  int32
+	addr3       uint64
+	pad         uint64
+}
+
+type cqe struct {
+	userData uint64
+	res      int32
+	flags    uint32
+}
+
+type timespec struct {
+	sec  int64
+	nsec int64
+}
+
+// op is a call waiting for its completion, the memory it points the kernel
+// to is kept alive by the ops map until then.
+type op struct {
+	id      uint64
+	opcode  uint8
+	fd      int32
+	buf     []byte
+	flags   uint32
+	target  uint64
+	timeout bool
+	ts      timespec
+	done    chan int32
+}
+
+// Ring is an io_uring shared by many connections. One goroutine submits the
+// queued calls in batches and another one reaps the completions.
+type Ring struct {
+	fd      int
+	sqMem   []byte
+	cqMem   []byte
+	sqeMem  []byte
+	sqHead  *uint32
+	sqTail  *uint32
+	sqMask  uint32
+	sqSize  uint32
+	sqes    []sqe
+	cqHead  *uint32
+	cqTail  *uint32
+	cqMask  uint32
+	cqes    []cqe
+	pending uint32
+
+	mutex   sync.Mutex
+	ops     map[uint64]*op
+	nextID  uint64
+	err     error
+	submits chan *op
+
+	closeOnce sync.Once
+	closeChan chan struct{}
+	closeWait sync.WaitGroup
+}
+
+// NewRing sets up an io_uring with entries submission slots, a power of 2.
+// The number of calls in flight is not limited by it.
+func NewRing(entries uint32) (*Ring, error) {
+	var p params
+	fd, _, errno := syscall.Syscall(sysSetup, uintptr(entries), uintptr(unsafe.Pointer(&p)), 0)
+	if errno != 0 {
+		return nil, errno
+	}
+	ring := &Ring{
+		fd:        int(fd),
+		ops:       make(map[uint64]*op),
+		submits:   make(chan *op, submitQueueDepth),
+		closeChan: make(chan struct{}),
+	}
+	if err := ring.mmap(&p); err != nil {
+		ring.unmap()
+		syscall.Close(ring.fd)
+		return nil, err
+	}
+	ring.closeWait.Add(2)
+	go ring.submitLoop()
+	go ring.completeLoop()
+	return ring, nil
+}
+
+func (ring *Ring) mmap(p *params) error {
+	sqSize := int(p.sqOff.array + p.sqEntries*4)
+	cqSize := int(p.cqOff.cqes + p.cqEntries*uint32(unsafe.Sizeof(cqe{})))
+	flags := syscall.MAP_SHARED | syscall.MAP_POPULATE
+	prot := syscall.PROT_READ | syscall.PROT_WRITE
+
+	single := p.features&featSingleMmap != 0
+	if single && cqSize > sqSize {
+		sqSize = cqSize
+	}
+	var err error
+	if ring.sqMem, err = syscall.Mmap(ring.fd, offSQRing, sqSize, prot, flags); err != nil {
+		return err
+	}
+	ring.cqMem = ring.sqMem
+	if !single {
+		if ring.cqMem, err = syscall.Mmap(ring.fd, offCQRing, cqSize, prot, flags); err != nil {
+			return err
+		}
+	}
+	sqeSize := int(p.sqEntries) * int(unsafe.Sizeof(sqe{}))
+	if ring.sqeMem, err = syscall.Mmap(ring.fd, offSQEs, sqeSize, prot, flags); err != nil {
+		return err
+	}
+
+	ring.sqHead = (*uint32)(unsafe.Pointer(&ring.sqMem[p.sqOff.head]))
+	ring.sqTail = (*uint32)(unsafe.Pointer(&ring.sqMem[p.sqOff.tail]))
+	ring.sqMask = *(*uint32)(unsafe.Pointer(&ring.sqMem[p.sqOff.ringMask]))
+	ring.sqSize = p.sqEntries
+	ring.sqes = unsafe.Slice((*sqe)(unsafe.Pointer(&ring.sqeMem[0])), p.sqEntries)
+	// Slot i of the submission queue always holds entry i.
+	array := unsafe.Slice((*uint32)(unsafe.Pointer(&ring.sqMem[p.sqOff.array])), p.sqEntries)
+	for i := range array {
+		array[i] = uint32(i)
+	}
+
+	ring.cqHead = (*uint32)(unsafe.Pointer(&ring.cqMem[p.cqOff.head]))
+	ring.cqTail = (*uint32)(unsafe.Pointer(&ring.cqMem[p.cqOff.tail]))
+	ring.cqMask = *(*uint32)(unsafe.Pointer(&ring.cqMem[p.cqOff.ringMask]))
+	ring.cqes = unsafe.Slice((*cqe)(unsafe.Pointer(&ring.cqMem[p.cqOff.cqes])), p.cqEntries)
+	return nil
+}
+
+func (ring *Ring) unmap() {
+	if ring.sqeMem != nil {
+		syscall.Munmap(ring.sqeMem)
+	}
+	if ring.cqMem != nil && &ring.cqMem[0] != &ring.sqMem[0] {
+		syscall.Munmap(ring.cqMem)
+	}
+	if ring.sqMem != nil {
+		syscall.Munmap(ring.sqMem)
+	}
+}
+
+func (ring *Ring) enter(toSubmit, minComplete, flags uint32) (int, error) {
+	for {
+		n, _, errno := syscall.Syscall6(sysEnter, uintptr(ring.fd), uintptr(toSubmit), uintptr(minComplete), uintptr(flags), 0, 0)
+		switch errno {
+		case 0:
+			return int(n), nil
+		case syscall.EINTR:
+			continue
+		case syscall.EAGAIN, syscall.EBUSY:
+			// The completion queue is full, wait for it to be reaped.
+			time.Sleep(time.Millisecond)
+			continue
+		}
+		return 0, errno
+	}
+}
+
+// do submits a call and waits for its result. A deadline in the past fails
+// it at once, a later one is linked to it as a timeout.
+func (ring *Ring) do(o *op, deadline int64) (int, error) {
+	o.timeout = false
+	if deadline > 0 {
+		d := time.Until(time.Unix(0, deadline))
+		if d <= 0 {
+			return 0, errTimeout
+		}
+		o.timeout = true
+		o.ts = timespec{int64(d / time.Second), int64(d % time.Second)}
+	}
+
+	ring.mutex.Lock()
+	if ring.err != nil {
+		ring.mutex.Unlock()
+		return 0, ring.err
+	}
+	ring.nextID++
+	atomic.StoreUint64(&o.id, ring.nextID)
+	ring.mutex.Unlock()
+
+	select {
+	case ring.submits <- o:
+	case <-ring.closeChan:
+		atomic.StoreUint64(&o.id, 0)
+		return 0, ErrRingClosed
+	}
+	res := <-o.done
+	atomic.StoreUint64(&o.id, 0)
+	switch {
+	case res == -int32(syscall.ECANCELED):
+		return 0, errTimeout
+	case res < 0:
+		return 0, syscall.Errno(-res)
+	}
+	return int(res), nil
+}
+
+// cancel asks the kernel to cancel the call with the given id, the call then
+// fails with a timeout.
+func (ring *Ring) cancel(id uint64) {
+	select {
+	case ring.submits <- &op{opcode: opAsyncCancel, target: id}:
+	case <-ring.closeChan:
+	}
+}
+
+func (ring *Ring) submitLoop() {
+	defer ring.closeWait.Done()
+	for {
+		select {
+		case o := <-ring.submits:
+			ring.push(o)
+			// Take whatever else is queued into the same batch.
+			for more := true; more; {
+				select {
+				case o := <-ring.submits:
+					ring.push(o)
+				default:
+					more = false
+				}
+			}
+			ring.flush()
+		case <-ring.closeChan:
+			ring.push(&op{opcode: opNop, id: closeUserData})
+			ring.flush()
+			return
+		}
+	}
+}
+
+func (ring *Ring) push(o *op) {
+	ring.mutex.Lock()
+	err := ring.err
+	ring.mutex.Unlock()
+	if err != nil {
+		if o.done != nil {
+			o.done <- -int32(syscall.EIO)
+		}
+		return
+	}
+
+	need := uint32(1)
+	if o.timeout {
+		need = 2
+	}
+	tail := *ring.sqTail
+	if ring.sqSize-(tail-atomic.LoadUint32(ring.sqHead)) < need {
+		ring.flush()
+	}
+
+	s := &ring.sqes[tail&ring.sqMask]
+	*s = sqe{
+		opcode:   o.opcode,
+		fd:       o.fd,
+		opFlags:  o.flags,
+		userData: o.id,
+	}
+	if len(o.buf) > 0 {
+		s.addr = uint64(uintptr(unsafe.Pointer(&o.buf[0])))
+		s.len = uint32(len(o.buf))
+	}
+	if o.opcode == opAsyncCancel {
+		s.addr = o.target
+		s.userData = ignoredUserData
+	}
+	if o.timeout {
+		s.flags |= sqeIOLink
+		tail++
+		ring.sqes[tail&ring.sqMask] = sqe{
+			opcode:   opLinkTimeout,
+			fd:       -1,
+			addr:     uint64(uintptr(unsafe.Pointer(&o.ts))),
+			len:      1,
+			userData: ignoredUserData,
+		}
+	}
+	// Added after the entry is written, so the completion taking it from the
+	// map sees the op as it was submitted.
+	if s.userData != ignoredUserData && s.userData != closeUserData {
+		ring.mutex.Lock()
+		ring.ops[o.id] = o
+		ring.mutex.Unlock()
+	}
+	atomic.StoreUint32(ring.sqTail, tail+1)
+	ring.pending += need
+}
+
+func (ring *Ring) flush() {
+	for ring.pending > 0 {
+		n, err := ring.enter(ring.pending, 0, 0)
+		if err != nil {
+			ring.pending = 0
+			ring.fail(err)
+			return
+		}
+		ring.pending -= uint32(n)
+	}
+}
+
+// fail completes all calls in flight with err once the ring is broken.
+func (ring *Ring) fail(err error) {
+	ring.mutex.Lock()
+	defer ring.mutex.Unlock()
+	ring.err = err
+	for id, o := range ring.ops {
+		delete(ring.ops, id)
+		o.done <- -int32(syscall.EIO)
+	}
+}
+
+func (ring *Ring) completeLoop() {
+	defer ring.closeWait.Done()
+	for {
+		head := *ring.cqHead
+		tail := atomic.LoadUint32(ring.cqTail)
+		if head == tail {
+			if _, err := ring.enter(0, 1, enterGetEvents); err != nil {
+				ring.fail(err)
+				return
+			}
+			continue
+		}
+		closed := false
+		for ; head != tail; head++ {
+			c := ring.cqes[head&ring.cqMask]
+			switch c.userData {
+			case ignoredUserData:
+			case closeUserData:
+				closed = true
+			default:
+				ring.mutex.Lock()
+				o := ring.ops[c.userData]
+				delete(ring.ops, c.userData)
+				ring.mutex.Unlock()
+				if o != nil {
+					o.done <- c.res
+				}
+			}
+		}
+		atomic.StoreUint32(ring.cqHead, head)
+		if closed {
+			return
+		}
+	}
+}
+
+// Close stops the ring, the connections using it must be closed before.
+func (ring *Ring) Close() error {
+	var err error
+	ring.closeOnce.Do(func() {
+		close(ring.closeChan)
+		ring.closeWait.Wait()
+		ring.fail(ErrRingClosed)
+		ring.unmap()
+		err = syscall.Close(ring.fd)
+	})
+	return err
+}
+
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+var errTimeout error = timeoutError{}
@@ -0,0 +1,124 @@
+//go:build linux && uring
+// +build linux,uring
+
+package uring
+
+import (
+	"encoding/binary"
+	"net"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/funny/link"
+	"github.com/funny/link/codec"
+	"github.com/funny/utest"
+)
+
+func newTestRing(t *testing.T) *Ring {
+	ring, err := NewRing(64)
+	if err == syscall.ENOSYS || err == syscall.EPERM {
+		t.Skip("io_uring not available:", err)
+	}
+	utest.IsNilNow(t, err)
+	return ring
+}
+
+func Test_Echo(t *testing.T) {
+	ring := newTestRing(t)
+	defer ring.Close()
+
+	listener, err := ring.Listen("tcp", "127.0.0.1:0")
+	utest.IsNilNow(t, err)
+	protocol := codec.FixLen(codec.Raw(), 2, binary.BigEndian, 64*1024, 64*1024)
+	server := link.NewServer(listener, protocol, 0, link.HandlerFunc(func(session *link.Session) {
+		for {
+			msg, err := session.Receive()
+			if err != nil {
+				return
+			}
+			if session.Send(msg) != nil {
+				return
+			}
+		}
+	}))
+	go server.Serve()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			session, err := link.Dial("tcp", listener.Addr().String(), protocol, 0)
+			utest.IsNilNow(t, err)
+			defer session.Close()
+			msg := make([]byte, 40*1024)
+			for j := 0; j < 20; j++ {
+				msg[j] = byte(j)
+				utest.IsNilNow(t, session.Send(msg))
+				recv, err := session.Receive()
+				utest.IsNilNow(t, err)
+				utest.EqualNow(t, recv.(*codec.InBuffer).Bytes(), msg)
+			}
+		}()
+	}
+	wg.Wait()
+	server.Stop()
+}
+
+func acceptTestConn(t *testing.T, ring *Ring) (net.Conn, net.Conn) {
+	listener, err := ring.Listen("tcp", "127.0.0.1:0")
+	utest.IsNilNow(t, err)
+	defer listener.Close()
+	client, err := net.Dial("tcp", listener.Addr().String())
+	utest.IsNilNow(t, err)
+	conn, err := listener.Accept()
+	utest.IsNilNow(t, err)
+	return client, conn
+}
+
+func Test_Deadline(t *testing.T) {
+	ring := newTestRing(t)
+	defer ring.Close()
+	client, conn := acceptTestConn(t, ring)
+	defer client.Close()
+
+	var b [8]byte
+	conn.SetReadDeadline(time.Now().Add(50 * time.Millisecond))
+	begin := time.Now()
+	_, err := conn.Read(b[:])
+	ne, ok := err.(net.Error)
+	utest.Assert(t, ok && ne.Timeout())
+	utest.Assert(t, time.Since(begin) >= 50*time.Millisecond)
+
+	// A deadline in the past cancels a read in flight.
+	conn.SetReadDeadline(time.Time{})
+	done := make(chan error, 1)
+	go func() {
+		_, err := conn.Read(b[:])
+		done <- err
+	}()
+	time.Sleep(50 * time.Millisecond)
+	conn.SetReadDeadline(time.Now())
+	select {
+	case err := <-done:
+		ne, ok := err.(net.Error)
+		utest.Assert(t, ok && ne.Timeout())
+	case <-time.After(time.Second):
+		t.Fatal("read not canceled")
+	}
+
+	conn.SetReadDeadline(time.Time{})
+	client.Write([]byte("hello"))
+	n, err := conn.Read(b[:])
+	utest.IsNilNow(t, err)
+	utest.EqualNow(t, string(b[:n]), "hello")
+
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		conn.Close()
+	}()
+	_, err = conn.Read(b[:])
+	utest.NotNilNow(t, err)
+}
@@ -0,0 +1,111 @@
+package link
+
+import (
+	"bytes"
+	"errors"
+	"io"
+)
+
+var ErrUnknownVersion = errors.New("Unknown Protocol Version")
+
+// VersionMux dispatches new connections to one of several Protocol and
+// Handler stacks by the first bytes sent by clients, so clients of different
+// versions can be served on one listener. Use it as both the protocol and
+// the handler of a server. The first bytes are not consumed, the protocol of
+// a version reads them again.
+type VersionMux struct {
+	versions []*version
+	fallback *version
+}
+
+type version struct {
+	name     string
+	prefix   []byte
+	protocol Protocol
+	handler  Handler
+}
+
+// VersionCodec is the codec of sessions created by a VersionMux.
+type VersionCodec struct {
+	Codec
+	Version string
+	handler Handler
+}
+
+func (c *VersionCodec) ClearSendChan(sendChan <-chan interface{}) {
+	if clear, ok := c.Codec.(ClearSendChan); ok {
+		clear.ClearSendChan(sendChan)
+	}
+}
+
+func NewVersionMux() *VersionMux {
+	return &VersionMux{}
+}
+
+// Handle registers a version for connections starting with prefix. A nil
+// prefix makes it the fallback for connections match no other prefix.
+func (mux *VersionMux) Handle(name string, prefix []byte, protocol Protocol, handler Handler) {
+	v := &version{name, prefix, protocol, handler}
+	if prefix == nil {
+		mux.fallback = v
+		return
+	}
+	mux.versions = append(mux.versions, v)
+}
+
+func (mux *VersionMux) NewCodec(rw io.ReadWriter) (Codec, error) {
+	prefixes := make([][]byte, len(mux.versions))
+	for i, v := range mux.versions {
+		prefixes[i] = v.prefix
+	}
+	i, head, err := sniff(rw, prefixes)
+	if err != nil {
+		return nil, err
+	}
+	v := mux.fallback
+	if i >= 0 {
+		v = mux.versions[i]
+	}
+	if v == nil {
+		return nil, ErrUnknownVersion
+	}
+	codec, err := v.protocol.NewCodec(&versionReadWriter{
+		ReadWriter: rw,
+		head:       bytes.NewReader(head),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &VersionCodec{codec, v.name, v.handler}, nil
+}
+
+// HandleSession closes the sessions not created by the mux, e.g. of a
+// server using it as the handler only, with ErrUnknownVersion.
+func (mux *VersionMux) HandleSession(session *Session) {
+	codec, ok := session.Codec().(*VersionCodec)
+	if !ok {
+		session.closeWith(ErrUnknownVersion)
+		return
+	}
+	codec.handler.HandleSession(session)
+}
+
+// versionReadWriter gives back the bytes read to choose the version.
+type versionReadWriter struct {
+	io.ReadWriter
+	head *bytes.Reader
+}
+
+func (rw *versionReadWriter) Read(p []byte) (int, error) {
+	if rw.head.Len() > 0 {
+		return rw.head.Read(p)
+	}
+	return rw.ReadWriter.Read(p)
+}
+
+func (rw *versionReadWriter) Close() error {
+	if closer, ok := rw.ReadWriter.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
@@ -0,0 +1,154 @@
+package link
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+)
+
+var ErrPoolStopped = errors.New("Worker Pool Stopped")
+
+// MessageHandler handles the received messages of sessions.
+type MessageHandler interface {
+	HandleMessage(session *Session, msg interface{})
+}
+
+type MessageHandlerFunc func(session *Session, msg interface{})
+
+func (f MessageHandlerFunc) HandleMessage(session *Session, msg interface{}) {
+	f(session, msg)
+}
+
+// WorkerPool handles the messages of many sessions by a fixed number of
+// workers. The messages of a session are handled one at a time in order, by
+// any worker. A session with queueSize messages waiting is not read until a
+// worker takes one, so a slow handler pushes back on its clients instead of
+// taking memory. Use it as the handler of a server.
+type WorkerPool struct {
+	handler   MessageHandler
+	queueSize int
+
+	mutex    sync.Mutex
+	cond     *sync.Cond
+	ready    []*workerQueue
+	stopped  bool
+	stopOnce sync.Once
+	stopChan chan struct{}
+	workers  sync.WaitGroup
+}
+
+// workerQueue is the messages of a session waiting for a worker, it is in
+// the ready list or held by a worker at most once.
+type workerQueue struct {
+	session   *Session
+	msgs      chan interface{}
+	scheduled int32
+}
+
+func NewWorkerPool(workers, queueSize int, handler MessageHandler) *WorkerPool {
+	if workers <= 0 {
+		panic("WorkerPool: workers must be positive")
+	}
+	if queueSize <= 0 {
+		queueSize = 1
+	}
+	pool := &WorkerPool{
+		handler:   handler,
+		queueSize: queueSize,
+		stopChan:  make(chan struct{}),
+	}
+	pool.cond = sync.NewCond(&pool.mutex)
+	pool.workers.Add(workers)
+	for i := 0; i < workers; i++ {
+		go pool.work()
+	}
+	return pool
+}
+
+func (pool *WorkerPool) HandleSession(session *Session) {
+	pool.Serve(session)
+}
+
+// Serve receives the messages of session for the workers until an error, or
+// ErrPoolStopped. The messages received before are still handled.
+func (pool *WorkerPool) Serve(session *Session) error {
+	q := &workerQueue{
+		session: session,
+		msgs:    make(chan interface{}, pool.queueSize),
+	}
+	for {
+		msg, err := session.Receive()
+		if err != nil {
+			return err
+		}
+		select {
+		case q.msgs <- msg:
+		case <-pool.stopChan:
+			return ErrPoolStopped
+		}
+		pool.schedule(q)
+	}
+}
+
+func (pool *WorkerPool) schedule(q *workerQueue) {
+	if !atomic.CompareAndSwapInt32(&q.scheduled, 0, 1) {
+		return
+	}
+	pool.mutex.Lock()
+	pool.ready = append(pool.ready, q)
+	pool.mutex.Unlock()
+	pool.cond.Signal()
+}
+
+func (pool *WorkerPool) next() *workerQueue {
+	pool.mutex.Lock()
+	defer pool.mutex.Unlock()
+	for len(pool.ready) == 0 && !pool.stopped {
+		pool.cond.Wait()
+	}
+	if pool.stopped {
+		return nil
+	}
+	q := pool.ready[0]
+	pool.ready[0] = nil
+	pool.ready = pool.ready[1:]
+	return q
+}
+
+func (pool *WorkerPool) work() {
+	defer pool.workers.Done()
+	for {
+		q := pool.next()
+		if q == nil {
+			return
+		}
+		pool.run(q)
+	}
+}
+
+// run handles the messages of q waiting, at most queueSize of them so busy
+// sessions take turns.
+func (pool *WorkerPool) run(q *workerQueue) {
+	for i := 0; i < pool.queueSize && len(q.msgs) > 0; i++ {
+		pool.handler.HandleMessage(q.session, <-q.msgs)
+	}
+	atomic.StoreInt32(&q.scheduled, 0)
+	// A message queued after the last check found q still scheduled.
+	if len(q.msgs) > 0 {
+		pool.schedule(q)
+	}
+}
+
+// Stop makes Serve return and waits for the workers to finish the messages
+// they are handling, the messages waiting are dropped.
+func (pool *WorkerPool) Stop() {
+	pool.stopOnce.Do(func() {
+		close(pool.stopChan)
+		pool.mutex.Lock()
+		pool.stopped = true
+		pool.ready = nil
+		pool.mutex.Unlock()
+		pool.cond.Broadcast()
+	})
+	pool.workers.Wait()
+}
@@ -0,0 +1,104 @@
+// Package wsgate terminates WebSocket connections from browsers and bridges
+// their binary messages to backend link sessions.
+package wsgate
+
+import (
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/funny/link"
+)
+
+// Gateway is a http.Handler bridges each WebSocket connection to a backend
+// session. Messages from a browser are sent to the backend as []byte, so the
+// backend protocol should accept them, like codec.FixLen(codec.Raw(), ...).
+//
+// Sends are synchronous both ways, a slow backend stops the gateway reading
+// from the browser, and a browser not reading stops the gateway reading from
+// the backend until the write timeout.
+type Gateway struct {
+	manager    *link.Manager
+	dial       func(r *http.Request) (*link.Session, error)
+	maxMessage int
+
+	mutex        sync.RWMutex
+	writeTimeout time.Duration
+}
+
+// New creates a gateway. dial authenticates a request, e.g. by a token in the
+// query or a cookie, and returns the backend session of it. An error from
+// dial denies the request with 403.
+func New(dial func(r *http.Request) (*link.Session, error), maxMessage int) *Gateway {
+	return &Gateway{
+		manager:      link.NewManager(),
+		dial:         dial,
+		maxMessage:   maxMessage,
+		writeTimeout: 10 * time.Second,
+	}
+}
+
+// Manager returns the manager of browser sessions.
+func (gateway *Gateway) Manager() *link.Manager {
+	return gateway.manager
+}
+
+// SetWriteTimeout changes time limit of writing to a browser, default is
+// 10 seconds. Zero means no limit.
+func (gateway *Gateway) SetWriteTimeout(timeout time.Duration) {
+	gateway.mutex.Lock()
+	defer gateway.mutex.Unlock()
+	gateway.writeTimeout = timeout
+}
+
+func (gateway *Gateway) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !checkHandshake(r) {
+		http.Error(w, "bad websocket handshake", http.StatusBadRequest)
+		return
+	}
+	backend, err := gateway.dial(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+	codec, err := Upgrade(w, r, gateway.maxMessage)
+	if err != nil {
+		backend.Close()
+		return
+	}
+	gateway.mutex.RLock()
+	codec.(*Codec).SetWriteTimeout(gateway.writeTimeout)
+	gateway.mutex.RUnlock()
+
+	browser := gateway.manager.NewSession(codec, 0)
+	browser.SetTag("remote_addr", codec.(*Codec).Conn().RemoteAddr().String())
+	go func() {
+		defer backend.Close()
+		defer browser.Close()
+		for {
+			msg, err := browser.Receive()
+			if err != nil {
+				return
+			}
+			if backend.Send(msg) != nil {
+				return
+			}
+		}
+	}()
+	defer backend.Close()
+	defer browser.Close()
+	for {
+		msg, err := backend.Receive()
+		if err != nil {
+			return
+		}
+		if browser.Send(msg) != nil {
+			return
+		}
+	}
+}
+
+// Close closes all browser sessions.
+func (gateway *Gateway) Close() {
+	gateway.manager.Dispose()
+}
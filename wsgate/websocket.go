@@ -0,0 +1,250 @@
+package wsgate
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/funny/link"
+)
+
+var (
+	ErrBadHandshake  = errors.New("Bad WebSocket Handshake")
+	ErrBadFrame      = errors.New("Bad WebSocket Frame")
+	ErrTooLargeFrame = errors.New("Too Large WebSocket Message")
+	ErrNotHijackable = errors.New("ResponseWriter Not Hijackable")
+)
+
+const websocketGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+var (
+	closeProtocolError = []byte{0x03, 0xea} // 1002
+	closeTooLarge      = []byte{0x03, 0xf1} // 1009
+)
+
+const (
+	opContinuation = 0x0
+	opText         = 0x1
+	opBinary       = 0x2
+	opClose        = 0x8
+	opPing         = 0x9
+	opPong         = 0xa
+)
+
+func checkHandshake(r *http.Request) bool {
+	return r.Method == "GET" &&
+		strings.EqualFold(r.Header.Get("Upgrade"), "websocket") &&
+		headerContains(r.Header.Get("Connection"), "upgrade") &&
+		r.Header.Get("Sec-WebSocket-Version") == "13" &&
+		r.Header.Get("Sec-WebSocket-Key") != ""
+}
+
+func headerContains(value, token string) bool {
+	for _, s := range strings.Split(value, ",") {
+		if strings.EqualFold(strings.TrimSpace(s), token) {
+			return true
+		}
+	}
+	return false
+}
+
+func acceptKey(key string) string {
+	h := sha1.New()
+	h.Write([]byte(key + websocketGUID))
+	return base64.StdEncoding.EncodeToString(h.Sum(nil))
+}
+
+// Upgrade completes the server side handshake of RFC 6455, and returns the
+// connection as a link.Codec of binary messages. Messages received are
+// []byte, text messages too. maxMessage limits the size of a message.
+func Upgrade(w http.ResponseWriter, r *http.Request, maxMessage int) (link.Codec, error) {
+	if !checkHandshake(r) {
+		http.Error(w, "bad websocket handshake", http.StatusBadRequest)
+		return nil, ErrBadHandshake
+	}
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "websocket not supported", http.StatusInternalServerError)
+		return nil, ErrNotHijackable
+	}
+	conn, rw, err := hijacker.Hijack()
+	if err != nil {
+		return nil, err
+	}
+	rw.WriteString("HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\nSec-WebSocket-Accept: ")
+	rw.WriteString(acceptKey(r.Header.Get("Sec-WebSocket-Key")))
+	rw.WriteString("\r\n\r\n")
+	if err := rw.Flush(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return &Codec{
+		conn:       conn,
+		reader:     rw.Reader,
+		maxMessage: maxMessage,
+	}, nil
+}
+
+// Codec is the server side of a WebSocket connection.
+type Codec struct {
+	conn       net.Conn
+	reader     *bufio.Reader
+	maxMessage int
+	head       [14]byte
+	message    []byte
+
+	writeMutex   sync.Mutex
+	writeHead    [10]byte
+	writeTimeout time.Duration
+}
+
+func (c *Codec) Conn() net.Conn {
+	return c.conn
+}
+
+// SetWriteTimeout limits the time of writing a message, so a browser not
+// reading can't block the sender forever.
+func (c *Codec) SetWriteTimeout(timeout time.Duration) {
+	c.writeMutex.Lock()
+	defer c.writeMutex.Unlock()
+	c.writeTimeout = timeout
+}
+
+func (c *Codec) Receive() (interface{}, error) {
+	c.message = nil
+	for {
+		fin, op, payload, err := c.readFrame()
+		if err != nil {
+			return nil, err
+		}
+		switch op {
+		case opPing:
+			if err := c.writeFrame(opPong, payload); err != nil {
+				return nil, err
+			}
+			continue
+		case opPong:
+			continue
+		case opClose:
+			c.writeFrame(opClose, payload)
+			return nil, io.EOF
+		case opText, opBinary:
+			if c.message != nil {
+				return nil, c.fail(ErrBadFrame, closeProtocolError)
+			}
+			c.message = payload
+		case opContinuation:
+			if c.message == nil {
+				return nil, c.fail(ErrBadFrame, closeProtocolError)
+			}
+			if len(c.message)+len(payload) > c.maxMessage {
+				return nil, c.fail(ErrTooLargeFrame, closeTooLarge)
+			}
+			c.message = append(c.message, payload...)
+		default:
+			return nil, c.fail(ErrBadFrame, closeProtocolError)
+		}
+		if fin {
+			msg := c.message
+			c.message = nil
+			return msg, nil
+		}
+	}
+}
+
+func (c *Codec) fail(err error, code []byte) error {
+	c.writeFrame(opClose, code)
+	return err
+}
+
+func (c *Codec) readFrame() (bool, byte, []byte, error) {
+	head := c.head[:2]
+	if _, err := io.ReadFull(c.reader, head); err != nil {
+		return false, 0, nil, err
+	}
+	fin, op := head[0]&0x80 != 0, head[0]&0x0f
+	if head[0]&0x70 != 0 || head[1]&0x80 == 0 {
+		// No extension is negotiated, and clients must mask frames.
+		return false, 0, nil, c.fail(ErrBadFrame, closeProtocolError)
+	}
+	size := uint64(head[1] & 0x7f)
+	switch size {
+	case 126:
+		if _, err := io.ReadFull(c.reader, c.head[:2]); err != nil {
+			return false, 0, nil, err
+		}
+		size = uint64(binary.BigEndian.Uint16(c.head[:2]))
+	case 127:
+		if _, err := io.ReadFull(c.reader, c.head[:8]); err != nil {
+			return false, 0, nil, err
+		}
+		size = binary.BigEndian.Uint64(c.head[:8])
+	}
+	if op >= opClose && (size > 125 || !fin) {
+		return false, 0, nil, c.fail(ErrBadFrame, closeProtocolError)
+	}
+	if size > uint64(c.maxMessage) {
+		return false, 0, nil, c.fail(ErrTooLargeFrame, closeTooLarge)
+	}
+	mask := c.head[10:14]
+	if _, err := io.ReadFull(c.reader, mask); err != nil {
+		return false, 0, nil, err
+	}
+	payload := make([]byte, size)
+	if _, err := io.ReadFull(c.reader, payload); err != nil {
+		return false, 0, nil, err
+	}
+	for i := range payload {
+		payload[i] ^= mask[i&3]
+	}
+	return fin, op, payload, nil
+}
+
+func (c *Codec) writeFrame(op byte, payload []byte) error {
+	c.writeMutex.Lock()
+	defer c.writeMutex.Unlock()
+
+	head := c.writeHead[:2]
+	head[0] = 0x80 | op
+	switch n := len(payload); {
+	case n <= 125:
+		head[1] = byte(n)
+	case n <= 0xffff:
+		head = c.writeHead[:4]
+		head[1] = 126
+		binary.BigEndian.PutUint16(head[2:], uint16(n))
+	default:
+		head = c.writeHead[:10]
+		head[1] = 127
+		binary.BigEndian.PutUint64(head[2:], uint64(n))
+	}
+	if c.writeTimeout > 0 {
+		c.conn.SetWriteDeadline(time.Now().Add(c.writeTimeout))
+	}
+	buffers := net.Buffers{head, payload}
+	_, err := buffers.WriteTo(c.conn)
+	return err
+}
+
+// Send writes a []byte, or a value with a Bytes method like *codec.InBuffer,
+// as a binary message.
+func (c *Codec) Send(msg interface{}) error {
+	if b, ok := msg.(interface {
+		Bytes() []byte
+	}); ok {
+		return c.writeFrame(opBinary, b.Bytes())
+	}
+	return c.writeFrame(opBinary, msg.([]byte))
+}
+
+func (c *Codec) Close() error {
+	return c.conn.Close()
+}
@@ -0,0 +1,118 @@
+package wsgate
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/funny/link"
+	"github.com/funny/link/codec"
+	"github.com/funny/utest"
+)
+
+func dialWebSocket(t *testing.T, url, path string) (net.Conn, *bufio.Reader, string) {
+	conn, err := net.Dial("tcp", strings.TrimPrefix(url, "http://"))
+	utest.IsNilNow(t, err)
+	conn.Write([]byte("GET " + path + " HTTP/1.1\r\nHost: test\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n" +
+		"Sec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\nSec-WebSocket-Version: 13\r\n\r\n"))
+	reader := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(reader, nil)
+	utest.IsNilNow(t, err)
+	if resp.StatusCode == 101 {
+		utest.EqualNow(t, resp.Header.Get("Sec-WebSocket-Accept"), "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=")
+	}
+	return conn, reader, resp.Status
+}
+
+func writeClientFrame(conn net.Conn, op byte, fin bool, payload []byte) {
+	head := []byte{op, 0x80 | byte(len(payload))}
+	if fin {
+		head[0] |= 0x80
+	}
+	if len(payload) > 125 {
+		head[1] = 0x80 | 126
+		head = append(head, byte(len(payload)>>8), byte(len(payload)))
+	}
+	mask := []byte{1, 2, 3, 4}
+	head = append(head, mask...)
+	masked := make([]byte, len(payload))
+	for i := range payload {
+		masked[i] = payload[i] ^ mask[i&3]
+	}
+	conn.Write(append(head, masked...))
+}
+
+func readServerFrame(t *testing.T, reader *bufio.Reader) (byte, []byte) {
+	var head [2]byte
+	_, err := io.ReadFull(reader, head[:])
+	utest.IsNilNow(t, err)
+	size := int(head[1] & 0x7f)
+	if size == 126 {
+		var ext [2]byte
+		io.ReadFull(reader, ext[:])
+		size = int(binary.BigEndian.Uint16(ext[:]))
+	}
+	payload := make([]byte, size)
+	_, err = io.ReadFull(reader, payload)
+	utest.IsNilNow(t, err)
+	return head[0] & 0x0f, payload
+}
+
+func Test_Gateway(t *testing.T) {
+	protocol := codec.FixLen(codec.Raw(), 2, binary.BigEndian, 1024, 1024)
+	backend, err := link.Listen("tcp", "127.0.0.1:0", protocol, 0, link.HandlerFunc(func(session *link.Session) {
+		for {
+			msg, err := session.Receive()
+			if err != nil {
+				return
+			}
+			session.Send(append([]byte("echo:"), msg.(*codec.InBuffer).Bytes()...))
+		}
+	}))
+	utest.IsNilNow(t, err)
+	go backend.Serve()
+	defer backend.Stop()
+
+	gateway := New(func(r *http.Request) (*link.Session, error) {
+		if r.URL.Query().Get("token") != "secret" {
+			return nil, errors.New("bad token")
+		}
+		return link.Dial("tcp", backend.Listener().Addr().String(), protocol, 0)
+	}, 1000)
+	defer gateway.Close()
+	server := httptest.NewServer(gateway)
+	defer server.Close()
+
+	conn, _, status := dialWebSocket(t, server.URL, "/?token=bad")
+	conn.Close()
+	utest.Assert(t, strings.HasPrefix(status, "403"))
+
+	conn, reader, status := dialWebSocket(t, server.URL, "/?token=secret")
+	defer conn.Close()
+	utest.Assert(t, strings.HasPrefix(status, "101"))
+
+	writeClientFrame(conn, opBinary, false, []byte("hel"))
+	writeClientFrame(conn, opPing, true, []byte("p"))
+	writeClientFrame(conn, opContinuation, true, []byte("lo"))
+	op, payload := readServerFrame(t, reader)
+	utest.EqualNow(t, op, byte(opPong))
+	utest.EqualNow(t, string(payload), "p")
+	op, payload = readServerFrame(t, reader)
+	utest.EqualNow(t, op, byte(opBinary))
+	utest.EqualNow(t, string(payload), "echo:hello")
+
+	writeClientFrame(conn, opBinary, true, make([]byte, 200))
+	op, payload = readServerFrame(t, reader)
+	utest.EqualNow(t, op, byte(opBinary))
+	utest.EqualNow(t, len(payload), 205)
+
+	writeClientFrame(conn, opClose, true, []byte{0x03, 0xe8})
+	op, _ = readServerFrame(t, reader)
+	utest.EqualNow(t, op, byte(opClose))
+}